@@ -0,0 +1,24 @@
+package consistent_hash
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// 包不能依赖 cgo，保证 CGO_ENABLED=0 以及交叉编译时可以正常构建
+func Test_BuildWithoutCgo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip building in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	cmd := exec.Command(goBin, "build", "./...")
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build with CGO_ENABLED=0 failed, err: %v\n%s", err, output)
+	}
+}
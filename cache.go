@@ -0,0 +1,87 @@
+package consistent_hash
+
+import (
+	"container/list"
+	"sync"
+)
+
+// 进程内的 LRU 缓存，记录数据 key 上一次登记的真实节点
+// 当 GetNode 的结果与缓存一致，并且期间哈希环没有发生变更时，说明数据 key 已经登记过，可以跳过 AddNodeToDataKeys
+type dataKeyCache struct {
+	mu   sync.Mutex
+	size int
+	// 哈希环的代数，每次节点变更都会递增，缓存只在代数不变时有效
+	generation uint64
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type dataKeyCacheEntry struct {
+	dataKey string
+	nodeID  string
+}
+
+func newDataKeyCache(size int) *dataKeyCache {
+	return &dataKeyCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+// 查询数据 key 上一次登记的真实节点，同时返回当前的代数，不存在时 nodeID 为空
+func (d *dataKeyCache) lookup(dataKey string) (nodeID string, generation uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	elem, ok := d.items[dataKey]
+	if !ok {
+		return "", d.generation
+	}
+	d.ll.MoveToFront(elem)
+	return elem.Value.(*dataKeyCacheEntry).nodeID, d.generation
+}
+
+// 记录数据 key 登记的真实节点，倘若 lookup 之后哈希环已经发生变更，则放弃写入
+func (d *dataKeyCache) add(dataKey, nodeID string, generation uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if generation != d.generation {
+		return
+	}
+
+	if elem, ok := d.items[dataKey]; ok {
+		elem.Value.(*dataKeyCacheEntry).nodeID = nodeID
+		d.ll.MoveToFront(elem)
+		return
+	}
+
+	d.items[dataKey] = d.ll.PushFront(&dataKeyCacheEntry{dataKey: dataKey, nodeID: nodeID})
+	if d.ll.Len() > d.size {
+		oldest := d.ll.Back()
+		d.ll.Remove(oldest)
+		delete(d.items, oldest.Value.(*dataKeyCacheEntry).dataKey)
+	}
+}
+
+// 删除数据 key 的缓存
+func (d *dataKeyCache) remove(dataKey string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.items[dataKey]; ok {
+		d.ll.Remove(elem)
+		delete(d.items, dataKey)
+	}
+}
+
+// 哈希环发生变更时清空缓存并递增代数
+func (d *dataKeyCache) invalidate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.generation++
+	d.ll.Init()
+	d.items = make(map[string]*list.Element, d.size)
+}
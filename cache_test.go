@@ -0,0 +1,106 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func Test_dataKeyCache(t *testing.T) {
+	cache := newDataKeyCache(2)
+	_, generation := cache.lookup("data_a")
+	cache.add("data_a", "node_a", generation)
+	cache.add("data_b", "node_b", generation)
+	// 访问 data_a 后，容量超限时淘汰最久未使用的 data_b
+	if nodeID, _ := cache.lookup("data_a"); nodeID != "node_a" {
+		t.Errorf("got %q, want node_a", nodeID)
+	}
+	cache.add("data_c", "node_c", generation)
+	if nodeID, _ := cache.lookup("data_b"); nodeID != "" {
+		t.Errorf("data_b should be evicted, got %q", nodeID)
+	}
+
+	// 失效之后，携带旧代数的写入会被丢弃
+	cache.invalidate()
+	if nodeID, _ := cache.lookup("data_a"); nodeID != "" {
+		t.Errorf("data_a should be invalidated, got %q", nodeID)
+	}
+	cache.add("data_a", "node_a", generation)
+	if nodeID, _ := cache.lookup("data_a"); nodeID != "" {
+		t.Errorf("stale add should be ignored, got %q", nodeID)
+	}
+}
+
+func Test_WithDataKeyCache(t *testing.T) {
+	ctx := context.Background()
+	hashRing := &countingHashRing{HashRing: memory.NewHashRing()}
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithDataKeyCache(100))
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := consistentHash.GetNode(ctx, "data_a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hashRing.addNodeToDataKeys != 1 {
+		t.Errorf("got %d registrations, want 1", hashRing.addNodeToDataKeys)
+	}
+
+	// 节点变更后缓存失效，需要重新登记
+	if err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	registrations := hashRing.addNodeToDataKeys
+	nodeID, err := consistentHash.GetNode(ctx, "data_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashRing.addNodeToDataKeys != registrations+1 {
+		t.Errorf("got %d registrations after add node, want %d", hashRing.addNodeToDataKeys, registrations+1)
+	}
+	dataKeys, err := hashRing.DataKeys(ctx, nodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dataKeys["data_a"]; !ok {
+		t.Errorf("data_a should be registered under %s", nodeID)
+	}
+}
+
+// 对比启用缓存前后，重复检索同一批数据 key 时的数据 key 登记次数
+func benchmarkGetNodeRepeated(b *testing.B, opts ...ConsistentHashOption) {
+	ctx := context.Background()
+	hashRing := &countingHashRing{HashRing: memory.NewHashRing()}
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil, opts...)
+	for i := 0; i < 10; i++ {
+		if err := consistentHash.AddNode(ctx, fmt.Sprintf("node_%d", i), 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+	dataKeys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		dataKeys = append(dataKeys, fmt.Sprintf("data_%d", i))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, dataKey := range dataKeys {
+			if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(hashRing.addNodeToDataKeys)/float64(b.N), "registrations/op")
+}
+
+func Benchmark_GetNode_Repeated(b *testing.B) {
+	benchmarkGetNodeRepeated(b)
+}
+
+func Benchmark_GetNode_Repeated_DataKeyCache(b *testing.B) {
+	benchmarkGetNodeRepeated(b, WithDataKeyCache(1000))
+}
@@ -0,0 +1,21 @@
+package consistent_hash
+
+import "time"
+
+// 时钟，锁看门狗的续期间隔以及加锁重试的等待都基于该时钟计时，便于在测试中驱动时间而不必真实等待
+type Clock interface {
+	Now() time.Time
+	// 经过 d 之后向返回的 channel 写入当时的时间
+	After(d time.Duration) <-chan time.Time
+}
+
+// 基于系统时间的时钟，默认使用
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
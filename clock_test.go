@@ -0,0 +1,141 @@
+package consistent_hash
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+// 只有调用 Advance 时才会前进的时钟
+type fakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	clock.cond = sync.NewCond(&clock.mu)
+	return clock
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, fakeWaiter{deadline: f.now.Add(d), ch: ch})
+	f.cond.Broadcast()
+	return ch
+}
+
+// 时间前进 d，唤醒全部到期的等待者
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	waiters := f.waiters[:0]
+	for _, waiter := range f.waiters {
+		if waiter.deadline.After(f.now) {
+			waiters = append(waiters, waiter)
+			continue
+		}
+		waiter.ch <- f.now
+	}
+	f.waiters = waiters
+}
+
+// 丢弃全部等待者，用于清理已经退出的看门狗遗留的等待
+func (f *fakeClock) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waiters = nil
+}
+
+// 阻塞直到至少有 n 个等待者
+func (f *fakeClock) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+func Test_WithClock_LockExpiry(t *testing.T) {
+	ctx := context.Background()
+	runMigration := func(watchDog bool) (heldDuring bool) {
+		clock := newFakeClock()
+		hashRing := &leaseHashRing{HashRing: memory.NewHashRing(), clock: clock, renewed: make(chan struct{})}
+		opts := []ConsistentHashOption{WithClock(clock), WithLockExpireSeconds(3)}
+		if watchDog {
+			opts = append(opts, WithLockWatchDog())
+		}
+
+		var once sync.Once
+		migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+			once.Do(func() {
+				// 迁移耗时 6s，超过锁的过期时间 3s
+				for i := 0; i < 6; i++ {
+					if !watchDog {
+						clock.Advance(time.Second)
+						continue
+					}
+					// 看门狗每 1s 续期一次
+					clock.BlockUntil(1)
+					clock.Advance(time.Second)
+					<-hashRing.renewed
+				}
+				heldDuring = hashRing.held()
+			})
+			return nil
+		}
+		consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, opts...)
+		if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+			t.Fatal(err)
+		}
+		for _, dataKey := range []string{"data_a", "data_b", "data_c", "data_d", "data_e"} {
+			if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 之前每次释放锁时看门狗都已经退出，只有接下来 AddNode 的看门狗会等待时钟
+		clock.Reset()
+		if err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if watchDog {
+			hashRing.mu.Lock()
+			defer hashRing.mu.Unlock()
+			if hashRing.renewals != 6 {
+				t.Errorf("got %d renewals, want 6", hashRing.renewals)
+			}
+		}
+		return heldDuring
+	}
+
+	if !runMigration(true) {
+		t.Error("lock should be renewed by the watch dog")
+	}
+	if runMigration(false) {
+		t.Error("lock should expire without the watch dog")
+	}
+}
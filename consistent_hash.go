@@ -4,11 +4,39 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// 在 lockMaxWait 的等待窗口内没有获取到哈希环的锁
+var ErrRingLocked = errors.New("ring locked by others")
+
+// 通过 WithReplicas 配置的放大系数小于 1，此时无法为真实节点生成虚拟节点
+var ErrInvalidReplicas = errors.New("invalid replicas, must be at least 1")
+
+var (
+	// 添加的真实节点已经存在于哈希环中
+	ErrNodeExists = errors.New("node already exists")
+	// 操作的真实节点不存在于哈希环中
+	ErrNodeNotFound = errors.New("node not found")
+	// 哈希环中存在真实节点，但是无法为数据找到承接的真实节点，通常意味着哈希环的数据不一致
+	ErrNoNodeAvailable = errors.New("no node available")
+	// 哈希环中没有任何真实节点
+	ErrRingEmpty = errors.New("ring empty")
+)
+
+// 锁被其他持有者占用时，重试加锁的间隔
+const lockRetryInterval = 50 * time.Millisecond
+
+// ScanDataKeys 未指定 count 时单次返回的数据 key 个数，与 redis SSCAN 的默认值一致
+const defaultScanCount = 10
+
 type ConsistentHash struct {
 	// 哈希环，是核心存储模块，包括虚拟节点到真实节点的映射关系，真实节点对应的虚拟节点个数，以及哈希环上各个节点的位置
 	hashRing HashRing
@@ -18,6 +46,22 @@ type ConsistentHash struct {
 	encryptor Encryptor
 	// 用于自定义配置项
 	opts ConsistentHashOptions
+
+	// 停止当前锁的看门狗，哈希环的锁是互斥的，因此同一时刻至多只有一个看门狗在运行
+	watchDogMu   sync.Mutex
+	stopWatchDog func()
+
+	// 数据 key 登记缓存，未启用时为 nil
+	dataKeyCache *dataKeyCache
+
+	// ReconcileKeys 的进度，下一次从该真实节点中大于 reconcileDataKey 的数据 key 继续检查
+	reconcileMu      sync.Mutex
+	reconcileNodeID  string
+	reconcileDataKey string
+
+	// 本实例正在执行的加锁操作个数，以及加锁、解锁的累计次数，用于乐观检索感知本实例发起的变更
+	lockedOps atomic.Int64
+	lockSeq   atomic.Uint64
 }
 
 func NewConsistentHash(hashRing HashRing, encryptor Encryptor, migrator Migrator, opts ...ConsistentHashOption) *ConsistentHash {
@@ -32,52 +76,179 @@ func NewConsistentHash(hashRing HashRing, encryptor Encryptor, migrator Migrator
 	}
 
 	repair(&ch.opts)
+	if ch.opts.dataKeyEncryptor == nil {
+		ch.opts.dataKeyEncryptor = encryptor
+	}
+	// 未指定范围的散列器按照哈希环的长度均匀地映射，避免再次取模带来的偏差
+	if e, ok := ch.encryptor.(ringSizeEncryptor); ok {
+		ch.encryptor = e.withRingSize(ch.opts.ringSize)
+	}
+	if e, ok := ch.opts.dataKeyEncryptor.(ringSizeEncryptor); ok {
+		ch.opts.dataKeyEncryptor = e.withRingSize(ch.opts.ringSize)
+	}
+	if ch.opts.dataKeyCacheSize > 0 {
+		ch.dataKeyCache = newDataKeyCache(ch.opts.dataKeyCacheSize)
+	}
 	return &ch
 }
 
 // 添加节点触发数据迁移
 // 1加锁，  2 校验节点是否存在，  3 通过传入的权重值确定对应的虚拟节点个数（replicas） 4 添加虚拟节点 5 执行数据迁移
 func (c *ConsistentHash) AddNode(ctx context.Context, nodeID string, weight int) error {
-	// 加全局分布式锁
-	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
+	// 根据用户传入的节点的权重值weight以及配置项中配置好放大系数replicas 计算出这个真实节点对应的虚拟节点的个数
+	replicas, err := c.getReplicas(weight)
+	if err != nil {
 		return err
 	}
+	return c.AddNodeWithReplicas(ctx, nodeID, replicas)
+}
 
-	defer func() {
-		_ = c.hashRing.Unlock(ctx)
-	}()
+// 声明式地确保节点以指定的权重存在于哈希环中，适用于反复对齐期望状态的调用方
+// 节点不存在时等同于 AddNode，已经存在时等同于 UpdateNodeWeight，权重未变化时直接返回 nil
+func (c *ConsistentHash) EnsureNode(ctx context.Context, nodeID string, weight int) error {
+	exists, err := c.NodeExists(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		// 检查与加锁之间节点可能被其他调用方添加，此时按照权重调整处理
+		if err = c.AddNode(ctx, nodeID, weight); !errors.Is(err, ErrNodeExists) {
+			return err
+		}
+	}
+	return c.UpdateNodeWeight(ctx, nodeID, weight)
+}
 
-	// 如果节点已经存在，直接返回重复添加节点的错误
-	nodes, err := c.hashRing.Nodes(ctx)
+// 添加节点的同时为其登记元数据，需要哈希环实现 NodeMetaStore
+// 节点添加成功之后才会写入元数据，节点已经存在时返回 ErrNodeExists 且不会覆盖原有的元数据
+func (c *ConsistentHash) AddNodeWithMeta(ctx context.Context, nodeID string, weight int, meta map[string]string) error {
+	metaStore, ok := c.hashRing.(NodeMetaStore)
+	if !ok {
+		return errors.New("hash ring does not support node meta")
+	}
+	if err := c.AddNode(ctx, nodeID, weight); err != nil {
+		return err
+	}
+	return metaStore.SetNodeMeta(ctx, nodeID, meta)
+}
+
+// 查询真实节点的元数据，节点不存在时返回 ErrNodeNotFound
+func (c *ConsistentHash) NodeMeta(ctx context.Context, nodeID string) (map[string]string, error) {
+	metaStore, ok := c.hashRing.(NodeMetaStore)
+	if !ok {
+		return nil, errors.New("hash ring does not support node meta")
+	}
+	exists, err := c.NodeExists(ctx, nodeID)
 	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("invalid node id: %s, err: %w", nodeID, ErrNodeNotFound)
+	}
+	return metaStore.NodeMeta(ctx, nodeID)
+}
+
+// 删除真实节点与虚拟节点个数的映射，同时清理节点的元数据
+func (c *ConsistentHash) deleteNodeToReplica(ctx context.Context, nodeID string) error {
+	if err := c.hashRing.DeleteNodeToReplica(ctx, nodeID); err != nil {
 		return err
 	}
+	if metaStore, ok := c.hashRing.(NodeMetaStore); ok {
+		return metaStore.DeleteNodeMeta(ctx, nodeID)
+	}
+	return nil
+}
 
-	for node := range nodes {
-		if node == nodeID {
-			return errors.New("repeat node")
-		}
+// 添加节点，并直接指定该节点对应的虚拟节点个数
+// 与 AddNode 不同，replicas 不受权重取值范围 [1,10] 以及放大系数的限制，适用于需要精确控制节点负载比例的场景
+func (c *ConsistentHash) AddNodeWithReplicas(ctx context.Context, nodeID string, replicas int) (err error) {
+	defer c.observeLatency(OpAddNode, c.opts.clock.Now())
+	ctx, span := c.startSpan(ctx, OpAddNode, map[string]interface{}{AttrNodeID: nodeID})
+	defer func() { span.end(err, nil) }()
+
+	if replicas <= 0 {
+		return fmt.Errorf("replicas: %d, err: %w", replicas, ErrInvalidReplicas)
 	}
 
-	// 根据用户传入的节点的权重值weight以及配置项中配置好放大系数replicas 计算出这个真实节点对应的虚拟节点的个数
-	replicas := c.getValidWeight(weight) * c.opts.replicas
+	// 加全局分布式锁
+	if err := c.lock(ctx); err != nil {
+		return err
+	}
 
-	// 将计算得到的replicas个数与nodeID 的映射关系放到hash ring 中， 同时也能标识出当前nodeID已经存在
+	defer c.unlock(ctx)
+	c.invalidateDataKeyCache()
+
+	return c.addNodeLocked(ctx, nodeID, replicas)
+}
+
+// 在已经持有哈希环锁的前提下添加节点并执行数据迁移
+func (c *ConsistentHash) addNodeLocked(ctx context.Context, nodeID string, replicas int) error {
+	// 如果节点已经存在，直接返回重复添加节点的错误
+	exists, err := c.NodeExists(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("repeat node: %s, err: %w", nodeID, ErrNodeExists)
+	}
+
+	// 将replicas个数与nodeID 的映射关系放到hash ring 中， 同时也能标识出当前nodeID已经存在
 	if err = c.hashRing.AddNodeToReplica(ctx, nodeID, replicas); err != nil {
 		return err
 	}
 
+	// 关闭了数据 key 登记时，虚拟节点之间不存在先后依赖，倘若哈希环支持批量添加，则一次性添加全部虚拟节点
+	if batchAdder, ok := c.hashRing.(BatchAdder); ok && !c.needMigration() {
+		virtualNodes := make(map[int64][]string, replicas)
+		for i := 0; i < replicas; i++ {
+			nodeKey := c.getRawNodeKey(nodeID, i)
+			virtualScore, err := c.pickVirtualScore(ctx, nodeID, i, virtualNodes)
+			if err != nil {
+				return err
+			}
+			virtualNodes[virtualScore] = append(virtualNodes[virtualScore], nodeKey)
+		}
+		if err := batchAdder.BatchAdd(ctx, virtualNodes); err != nil {
+			return err
+		}
+		return c.topologyChanged(ctx, RingEvent{Type: RingEventNodeAdded, NodeID: nodeID})
+	}
+
 	// 按照虚拟节点的个数将虚拟节点添加到哈希环中
-	var migraeTasks []func()
+	var migraeTasks []migrateTask
 	for i := 0; i < replicas; i++ {
+		// 请求被取消时及时退出，分布式锁会在 defer 中释放
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
 		// 使用encryptor推算出对应的k个虚拟节点的数值
 		nodeKey := c.getRawNodeKey(nodeID, i)
-		virtualScore := c.encryptor.Encrypt(nodeKey)
+		virtualScore, err := c.pickVirtualScore(ctx, nodeID, i, nil)
+		if err != nil {
+			return err
+		}
 
-		// 将一个虚拟节点添加到hash ring当中
-		if err := c.hashRing.Add(ctx, virtualScore, nodeKey); err != nil {
+		// 将一个虚拟节点添加到hash ring当中，虚拟节点已经存在时拓扑没有变化，无需迁移
+		added, err := c.addVirtualNode(ctx, virtualScore, nodeKey)
+		if err != nil {
 			return err
 		}
+		if !added {
+			continue
+		}
+
+		// 开启冲突分散时同一区间的数据可能来自多个已有节点
+		if c.opts.spreadCollisions {
+			tasks, err := c.migrateInSpread(ctx, virtualScore, nodeID)
+			if err != nil {
+				return err
+			}
+			migraeTasks = append(migraeTasks, tasks...)
+			continue
+		}
 
 		// 调用migrateIn方法，获取需要执行的数据迁移任务信息
 		// from 数据迁移起点的节点id
@@ -89,30 +260,165 @@ func (c *ConsistentHash) AddNode(ctx context.Context, nodeID string, weight int)
 		}
 
 		// 倘若等待迁移的数据长度为0 ，直接跳过
-		if len(datas) == 0 {
+		// 后继虚拟节点同样属于 nodeID 时，数据已经在本次添加中迁入 nodeID，无需重复迁移
+		if len(datas) == 0 || from == to {
 			continue
 		}
 		// 数据迁移任务不是立即执行，只是追加到list中，最后会在batchExecuteMigrator方法中一起执行
-		migraeTasks = append(migraeTasks, func() {
-			_ = c.migrator(ctx, datas, from, to)
-		})
+		migraeTasks = append(migraeTasks, c.newMigrateTask(ctx, datas, from, to))
+	}
+
+	// 虚拟节点已经全部入环，拓扑发生了变更
+	if err = c.topologyChanged(ctx, RingEvent{Type: RingEventNodeAdded, NodeID: nodeID}); err != nil {
+		return err
 	}
 
 	// 批量执行数据迁移任务
-	c.batchExecuteMigrator(migraeTasks)
-	return nil
+	return c.batchExecuteMigrator(ctx, migraeTasks)
+}
+
+// 将真实节点追加到虚拟节点中，哈希环实现了 AddIfAbsenter 时原子地检查并追加，否则视为总是发生了追加
+func (c *ConsistentHash) addVirtualNode(ctx context.Context, score int64, rawNodeKey string) (bool, error) {
+	if adder, ok := c.hashRing.(AddIfAbsenter); ok {
+		return adder.AddIfAbsent(ctx, score, rawNodeKey)
+	}
+	return true, c.hashRing.Add(ctx, score, rawNodeKey)
+}
+
+// 在一次加锁中批量添加节点，nodes 为真实节点 id 到权重的映射，用于集群初始化等需要同时添加多个节点的场景
+// 与循环调用 AddNode 不同，全部虚拟节点入环后才会基于最终的拓扑统一计算一次数据迁移，
+// 每个数据 key 至多被迁移一次，不会在新加入的节点之间来回迁移
+func (c *ConsistentHash) AddNodes(ctx context.Context, nodes map[string]int) (err error) {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	nodeIDs := make([]string, 0, len(nodes))
+	replicas := make(map[string]int, len(nodes))
+	for nodeID, weight := range nodes {
+		if replicas[nodeID], err = c.getReplicas(weight); err != nil {
+			return err
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+
+	defer c.observeLatency(OpAddNode, c.opts.clock.Now())
+	ctx, span := c.startSpan(ctx, OpAddNode, map[string]interface{}{AttrNodeID: strings.Join(nodeIDs, ",")})
+	defer func() { span.end(err, nil) }()
+
+	if err := c.lock(ctx); err != nil {
+		return err
+	}
+
+	defer c.unlock(ctx)
+	c.invalidateDataKeyCache()
+
+	existNodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, nodeID := range nodeIDs {
+		if _, ok := existNodes[nodeID]; ok {
+			return fmt.Errorf("repeat node: %s, err: %w", nodeID, ErrNodeExists)
+		}
+	}
+
+	// 数据只会从已有节点迁移到新节点，因此在拓扑变更前记录下已有节点的数据 key
+	existDataKeys := make(map[string]map[string]struct{}, len(existNodes))
+	if c.needMigration() {
+		for nodeID := range existNodes {
+			if existDataKeys[nodeID], err = c.hashRing.DataKeys(ctx, nodeID); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 迁移基于最终的拓扑统一计算，因此虚拟节点之间不存在先后依赖，倘若哈希环支持批量添加，则一次性添加全部虚拟节点
+	batchAdder, canBatchAdd := c.hashRing.(BatchAdder)
+	virtualNodes := make(map[int64][]string)
+	for _, nodeID := range nodeIDs {
+		if err = c.hashRing.AddNodeToReplica(ctx, nodeID, replicas[nodeID]); err != nil {
+			return err
+		}
+
+		for i := 0; i < replicas[nodeID]; i++ {
+			nodeKey := c.getRawNodeKey(nodeID, i)
+			virtualScore, err := c.pickVirtualScore(ctx, nodeID, i, virtualNodes)
+			if err != nil {
+				return err
+			}
+			if canBatchAdd {
+				virtualNodes[virtualScore] = append(virtualNodes[virtualScore], nodeKey)
+				continue
+			}
+
+			// 请求被取消时及时退出，分布式锁会在 defer 中释放
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			if err = c.hashRing.Add(ctx, virtualScore, nodeKey); err != nil {
+				return err
+			}
+		}
+	}
+	if canBatchAdd {
+		if err = batchAdder.BatchAdd(ctx, virtualNodes); err != nil {
+			return err
+		}
+	}
+
+	events := make([]RingEvent, 0, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		events = append(events, RingEvent{Type: RingEventNodeAdded, NodeID: nodeID})
+	}
+	if err = c.topologyChanged(ctx, events...); err != nil {
+		return err
+	}
+
+	// 按照最终的拓扑重新计算已有节点上每个数据 key 的归属，按照 from、to 聚合为迁移任务
+	moves := make(map[[2]string]map[string]struct{})
+	for from, dataKeys := range existDataKeys {
+		for dataKey := range dataKeys {
+			to, err := c.getNode(ctx, dataKey)
+			if err != nil {
+				return err
+			}
+			if to == from {
+				continue
+			}
+			if moves[[2]string{from, to}] == nil {
+				moves[[2]string{from, to}] = make(map[string]struct{})
+			}
+			moves[[2]string{from, to}][dataKey] = struct{}{}
+		}
+	}
+
+	migrateTasks := make([]migrateTask, 0, len(moves))
+	for fromTo, datas := range moves {
+		if err = c.moveDataKeys(ctx, fromTo[0], fromTo[1], datas); err != nil {
+			return err
+		}
+		migrateTasks = append(migrateTasks, c.newMigrateTask(ctx, datas, fromTo[0], fromTo[1]))
+	}
+	return c.batchExecuteMigrator(ctx, migrateTasks)
 }
 
 // 删除节点 也会造成数据迁移
 // 1加锁，  2 检验哈希环是否存在， 3 获取对应虚拟节点的个数  4 一次删除虚拟节点  5 执行数据迁移
-func (c *ConsistentHash) RemoveNode(ctx context.Context, nodeID string) error {
-	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
+func (c *ConsistentHash) RemoveNode(ctx context.Context, nodeID string) (err error) {
+	defer c.observeLatency(OpRemoveNode, c.opts.clock.Now())
+	ctx, span := c.startSpan(ctx, OpRemoveNode, map[string]interface{}{AttrNodeID: nodeID})
+	defer func() { span.end(err, nil) }()
+
+	if err := c.lock(ctx); err != nil {
 		return err
 	}
 
-	defer func() {
-		_ = c.hashRing.Unlock(ctx)
-	}()
+	defer c.unlock(ctx)
+	c.invalidateDataKeyCache()
 
 	// 查询哈希环中所有存在的节点
 	nodes, err := c.hashRing.Nodes(ctx)
@@ -135,19 +441,35 @@ func (c *ConsistentHash) RemoveNode(ctx context.Context, nodeID string) error {
 
 	// 如果删除的节点不存在，直接返回
 	if !nodeExist {
-		return errors.New("invalid node id")
+		return fmt.Errorf("invalid node id: %s, err: %w", nodeID, ErrNodeNotFound)
+	}
+
+	// 待删除的节点是哈希环中最后一个真实节点，数据没有后继节点可以托付
+	// 未注入迁移函数时不存在需要托付的数据，直接清空其登记的数据 key
+	if len(nodes) == 1 && (c.opts.allowRemoveLastNode || c.migrator == nil) {
+		return c.removeLastNode(ctx, nodeID, replicas)
 	}
 
 	// 从哈希环中删除节点与虚拟节点个数的映射信息，这个操作背后的含义就是从哈希环中删除这个真实节点
-	if err = c.hashRing.DeleteNodeToReplica(ctx, nodeID); err != nil {
+	if err = c.deleteNodeToReplica(ctx, nodeID); err != nil {
 		return err
 	}
 
-	var migrateTasks []func()
+	var migrateTasks []migrateTask
 	// 根据真实节点对应的虚拟节点个数，开始执行对应虚拟节点的删除操作
 	for i := 0; i < replicas; i++ {
-		//使用encrptor，推算出对应的k个虚拟节点数值
-		virtualScore := c.encryptor.Encrypt(fmt.Sprintf("%d_%d", nodeID, i))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		//使用encrptor，推算出对应的k个虚拟节点数值，与 AddNode 使用同一套虚拟节点 key 的生成规则
+		nodeKey := c.getRawNodeKey(nodeID, i)
+		virtualScore, err := c.locateVirtualScore(ctx, nodeID, i)
+		if err != nil {
+			return err
+		}
 		// 调用migrateout方法，获取迁移任务明细
 		from, to, datas, err := c.migrateOut(ctx, virtualScore, nodeID)
 		if err != nil {
@@ -155,7 +477,6 @@ func (c *ConsistentHash) RemoveNode(ctx context.Context, nodeID string) error {
 		}
 
 		// 从哈希环对应虚拟节点数值virtualScore的位置删除这个真实节点nodeID
-		nodeKey := c.getRawNodeKey(nodeID, i)
 		if err = c.hashRing.Rem(ctx, virtualScore, nodeKey); err != nil {
 			return err
 		}
@@ -165,98 +486,1442 @@ func (c *ConsistentHash) RemoveNode(ctx context.Context, nodeID string) error {
 			continue
 		}
 
-		migrateTasks = append(migrateTasks, func() {
-			_ = c.migrator(ctx, datas, from, to)
-		})
+		migrateTasks = append(migrateTasks, c.newMigrateTask(ctx, datas, from, to))
 
 	}
-	c.batchExecuteMigrator(migrateTasks)
-	return nil
 
-}
+	// 有界负载模式下数据 key 可能登记在其所在区间之外的节点上，按照区间迁移之后残留的数据 key 需要交给其在哈希环上的归属节点
+	// 开启冲突分散时 migrateOut 不按照区间迁移，全部数据 key 都在这里交给各自的归属节点
+	if (c.boundedLoad() || c.opts.spreadCollisions) && c.needMigration() {
+		leftoverTasks, err := c.migrateLeftover(ctx, nodeID)
+		if err != nil {
+			return err
+		}
+		migrateTasks = append(migrateTasks, leftoverTasks...)
+	}
 
-func (c *ConsistentHash) batchExecuteMigrator(migrateTasks []func()) {
-	// 执行所有数据迁移任务
-	var wg sync.WaitGroup
-	for _, migrateTask := range migrateTasks {
-		migrateTask := migrateTask
-		wg.Add(1)
-		go func() {
-			defer func() {
-				if err := recover(); err != nil {
-					log.Fatal(err)
-				}
-				wg.Done()
-			}()
-			migrateTask()
-		}()
+	if err = c.topologyChanged(ctx, RingEvent{Type: RingEventNodeRemoved, NodeID: nodeID}); err != nil {
+		return err
 	}
-	wg.Wait()
+	return c.batchExecuteMigrator(ctx, migrateTasks)
 }
 
-// 执行一笔状态数据的读写请求时，需要通过一致性哈希模块，检索到数据所对应的真实节点
-// 1 加锁， 2 通过hash编码器，找到数据在哈希环上的位置  3 找到顺时针往下的第一个虚拟节点   4 找到虚拟节点对应的真实节点  5 建立真实节点与状态数据之间的映射关系
-func (c *ConsistentHash) GetNode(ctx context.Context, dataKey string) (string, error) {
-	if err := c.hashRing.Lock(ctx, c.opts.lockExpireSeconds); err != nil {
-		return "", err
+// 将节点 nodeID 仍然登记着的数据 key 迁移到各自在哈希环上的归属节点，仍然归属于 nodeID 的数据 key 保持不变
+func (c *ConsistentHash) migrateLeftover(ctx context.Context, nodeID string) ([]migrateTask, error) {
+	datas, err := c.hashRing.DataKeys(ctx, nodeID)
+	if err != nil {
+		return nil, err
 	}
 
-	defer func() {
-		_ = c.hashRing.Unlock(ctx)
-	}()
+	toDatas := make(map[string]map[string]struct{})
+	for data := range datas {
+		to, err := c.getNode(ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		if to == nodeID {
+			continue
+		}
+		if toDatas[to] == nil {
+			toDatas[to] = make(map[string]struct{})
+		}
+		toDatas[to][data] = struct{}{}
+	}
 
-	//输入一个数据的key 根据encryptor计算出其从属与哈希环的位置dataScore
-	dataScore := c.encryptor.Encrypt(dataKey)
-	// 执行ceiling 找到当前datakey对应dataScore的下一个虚拟节点数值ceilingScore
-	ceilingScore, err := c.hashRing.Ceiling(ctx, dataScore)
+	tasks := make([]migrateTask, 0, len(toDatas))
+	for to, _datas := range toDatas {
+		if err = c.moveDataKeys(ctx, nodeID, to, _datas); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, c.newMigrateTask(ctx, _datas, nodeID, to))
+	}
+	return tasks, nil
+}
+
+// 删除哈希环中最后一个真实节点，拆除其全部虚拟节点并清空其数据 key
+// 注入了迁移函数时，全部数据会通过一笔 to 为空的迁移任务交还给使用方处理
+func (c *ConsistentHash) removeLastNode(ctx context.Context, nodeID string, replicas int) error {
+	datas, err := c.hashRing.DataKeys(ctx, nodeID)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	// 倘若未找到目标，则说明没有可用的目标节点
-	if ceilingScore == -1 {
-		return "", errors.New("no node available")
+	if err = c.removeVirtualNodes(ctx, nodeID, replicas); err != nil {
+		return err
 	}
 
-	// 查询ceilingScore对应的真实节点列表
-	nodes, err := c.hashRing.Node(ctx, ceilingScore)
-	if err != nil {
-		return "", err
+	if err = c.topologyChanged(ctx, RingEvent{Type: RingEventNodeRemoved, NodeID: nodeID}); err != nil {
+		return err
 	}
 
-	// 倘若真实节点列表为空直接返回错误
-	if len(nodes) == 0 {
-		return "", errors.New("no node available with empty score")
+	if len(datas) == 0 {
+		return nil
 	}
 
-	// 为datakey选中真实节点后， 需要将datakey添加到真实节点的状态数据key列表中
-	if err = c.hashRing.AddNodeToDataKeys(ctx, c.getNodeID(nodes[0]), map[string]struct{}{
-		dataKey: {},
-	}); err != nil {
-		return "", err
+	if err = c.hashRing.DeleteNodeToDataKeys(ctx, nodeID, datas); err != nil {
+		return err
 	}
 
-	//返回选中的目标节点
-	return nodes[0], nil
+	if c.migrator == nil {
+		return nil
+	}
+	return c.batchExecuteMigrator(ctx, []migrateTask{c.newMigrateTask(ctx, datas, nodeID, "")})
 }
 
-func (c *ConsistentHash) getValidWeight(weight int) int {
-	if weight <= 0 {
-		return 1
+// 删除真实节点与虚拟节点个数的映射，并从哈希环中删除其全部虚拟节点，不涉及数据迁移
+func (c *ConsistentHash) removeVirtualNodes(ctx context.Context, nodeID string, replicas int) error {
+	if err := c.deleteNodeToReplica(ctx, nodeID); err != nil {
+		return err
 	}
 
-	if weight >= 10 {
-		return 10
+	for i := 0; i < replicas; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		virtualScore, err := c.locateVirtualScore(ctx, nodeID, i)
+		if err != nil {
+			return err
+		}
+		if err = c.hashRing.Rem(ctx, virtualScore, c.getRawNodeKey(nodeID, i)); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return weight
+// 停放的数据 key 登记在该 id 下，与真实节点的数据 key 互不影响
+func parkedNodeID(nodeID string) string {
+	return "parked:" + nodeID
 }
 
-func (c *ConsistentHash) getRawNodeKey(nodeID string, index int) string {
-	return fmt.Sprintf("%d_%d", nodeID, index)
+// 从哈希环中删除节点，但不将其数据迁移到后继节点，用于下线异常节点后再由人工分配数据
+// 节点原有的数据 key 会作为返回值返回，同时转存到停放标记下，之后可以通过 ParkedDataKeys 再次查询
+// 与 RemoveNode 不同，该方法不会调用迁移函数，也允许删除哈希环中最后一个真实节点
+func (c *ConsistentHash) RemoveNodeParkData(ctx context.Context, nodeID string) (parkedKeys map[string]struct{}, err error) {
+	defer c.observeLatency(OpRemoveNode, c.opts.clock.Now())
+	ctx, span := c.startSpan(ctx, OpRemoveNode, map[string]interface{}{AttrNodeID: nodeID})
+	defer func() { span.end(err, nil) }()
+
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	defer c.unlock(ctx)
+	c.invalidateDataKeyCache()
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replicas, ok := nodes[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("invalid node id: %s, err: %w", nodeID, ErrNodeNotFound)
+	}
+
+	if parkedKeys, err = c.hashRing.DataKeys(ctx, nodeID); err != nil {
+		return nil, err
+	}
+
+	if err = c.removeVirtualNodes(ctx, nodeID, replicas); err != nil {
+		return nil, err
+	}
+
+	if err = c.topologyChanged(ctx, RingEvent{Type: RingEventNodeRemoved, NodeID: nodeID}); err != nil {
+		return nil, err
+	}
+
+	if len(parkedKeys) == 0 {
+		return parkedKeys, nil
+	}
+	if err = c.moveDataKeys(ctx, nodeID, parkedNodeID(nodeID), parkedKeys); err != nil {
+		return nil, err
+	}
+	return parkedKeys, nil
 }
 
-func (c *ConsistentHash) getNodeID(rawNodeKey string) string {
-	index := strings.LastIndex(rawNodeKey, "_")
-	return rawNodeKey[:index]
+// 查询通过 RemoveNodeParkData 停放的数据 key
+func (c *ConsistentHash) ParkedDataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	return c.hashRing.DataKeys(ctx, parkedNodeID(nodeID))
+}
+
+// 调整节点的权重，只增加或删除新旧权重之间相差的虚拟节点，并且只针对这部分虚拟节点执行数据迁移
+// 与先 RemoveNode 再 AddNode 相比，节点在整个过程中始终存在于哈希环中，其余虚拟节点上的数据也不会发生迁移
+func (c *ConsistentHash) UpdateNodeWeight(ctx context.Context, nodeID string, newWeight int) (err error) {
+	defer c.observeLatency(OpUpdateNodeWeight, c.opts.clock.Now())
+	ctx, span := c.startSpan(ctx, OpUpdateNodeWeight, map[string]interface{}{AttrNodeID: nodeID})
+	defer func() { span.end(err, nil) }()
+
+	newReplicas, err := c.getReplicas(newWeight)
+	if err != nil {
+		return err
+	}
+
+	if err = c.lock(ctx); err != nil {
+		return err
+	}
+
+	defer c.unlock(ctx)
+	c.invalidateDataKeyCache()
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	replicas, ok := nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("invalid node id: %s, err: %w", nodeID, ErrNodeNotFound)
+	}
+
+	if newReplicas == replicas {
+		return nil
+	}
+
+	if err = c.hashRing.AddNodeToReplica(ctx, nodeID, newReplicas); err != nil {
+		return err
+	}
+
+	var migrateTasks []migrateTask
+	// 权重增加时，追加序号为 [replicas, newReplicas) 的虚拟节点，流程与 AddNode 一致
+	for i := replicas; i < newReplicas; i++ {
+		// 请求被取消时及时退出，分布式锁会在 defer 中释放
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		nodeKey := c.getRawNodeKey(nodeID, i)
+		virtualScore, err := c.pickVirtualScore(ctx, nodeID, i, nil)
+		if err != nil {
+			return err
+		}
+		added, err := c.addVirtualNode(ctx, virtualScore, nodeKey)
+		if err != nil {
+			return err
+		}
+		if !added {
+			continue
+		}
+
+		if c.opts.spreadCollisions {
+			tasks, err := c.migrateInSpread(ctx, virtualScore, nodeID)
+			if err != nil {
+				return err
+			}
+			migrateTasks = append(migrateTasks, tasks...)
+			continue
+		}
+
+		from, to, datas, err := c.migrateIn(ctx, virtualScore, nodeID)
+		if err != nil {
+			return err
+		}
+
+		// 新虚拟节点的后继仍是当前节点自身时，数据无需迁移
+		if len(datas) == 0 || from == to {
+			continue
+		}
+		migrateTasks = append(migrateTasks, c.newMigrateTask(ctx, datas, from, to))
+	}
+
+	// 权重减少时，删除序号为 [newReplicas, replicas) 的虚拟节点，并将其区间内的数据交给新的归属节点
+	for i := newReplicas; i < replicas; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		nodeKey := c.getRawNodeKey(nodeID, i)
+		virtualScore, err := c.locateVirtualScore(ctx, nodeID, i)
+		if err != nil {
+			return err
+		}
+		if err = c.hashRing.Rem(ctx, virtualScore, nodeKey); err != nil {
+			return err
+		}
+		// 开启冲突分散时区间内的数据 key 可能归属于不同的节点，统一在删除之后由 migrateLeftover 迁移
+		if c.opts.spreadCollisions {
+			continue
+		}
+
+		from, to, datas, err := c.migrateShrink(ctx, virtualScore, nodeID)
+		if err != nil {
+			return err
+		}
+
+		if len(datas) == 0 {
+			continue
+		}
+		migrateTasks = append(migrateTasks, c.newMigrateTask(ctx, datas, from, to))
+	}
+
+	if c.opts.spreadCollisions && newReplicas < replicas && c.needMigration() {
+		leftoverTasks, err := c.migrateLeftover(ctx, nodeID)
+		if err != nil {
+			return err
+		}
+		migrateTasks = append(migrateTasks, leftoverTasks...)
+	}
+
+	if err = c.topologyChanged(ctx, RingEvent{Type: RingEventNodeUpdated, NodeID: nodeID}); err != nil {
+		return err
+	}
+	return c.batchExecuteMigrator(ctx, migrateTasks)
+}
+
+// 加哈希环的全局锁，通过 WithLocking(false) 关闭锁时直接返回
+func (c *ConsistentHash) lock(ctx context.Context) error {
+	if c.opts.disableLocking {
+		c.beginLocked()
+		return nil
+	}
+
+	start := c.opts.clock.Now()
+	if err := c.tryLock(ctx); err != nil {
+		c.opts.logger.Errorf("consistent hash lock failed, err: %v", err)
+		return err
+	}
+	spanFromContext(ctx).lockAcquired(c.opts.clock.Now().Sub(start))
+	c.beginLocked()
+	c.opts.logger.Debugf("consistent hash lock acquired, expire seconds: %d", c.opts.lockExpireSeconds)
+
+	if renewer, ok := c.hashRing.(LockRenewer); ok && c.opts.lockWatchDog {
+		c.runWatchDog(renewer)
+	}
+	return nil
+}
+
+// 获取哈希环的锁，配置了 lockMaxWait 时最多等待 lockMaxWait，超时返回 ErrRingLocked
+func (c *ConsistentHash) tryLock(ctx context.Context) error {
+	if c.opts.lockMaxWait <= 0 {
+		return c.hashRing.Lock(ctx, c.opts.lockExpireSeconds)
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, c.opts.lockMaxWait)
+	defer cancel()
+	checker, _ := c.hashRing.(LockBusyChecker)
+	for {
+		err := c.hashRing.Lock(lockCtx, c.opts.lockExpireSeconds)
+		if err == nil {
+			return nil
+		}
+		// 调用方的 ctx 结束时返回其自身的错误，仅等待窗口耗尽时才返回 ErrRingLocked
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if lockCtx.Err() != nil {
+			return ErrRingLocked
+		}
+		if checker == nil || !checker.IsLockBusy(err) {
+			return err
+		}
+
+		select {
+		case <-lockCtx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return ErrRingLocked
+		case <-c.opts.clock.After(lockRetryInterval):
+		}
+	}
+}
+
+// 启动看门狗，在后台定期为锁续期，直到 unlock 时停止
+func (c *ConsistentHash) runWatchDog(renewer LockRenewer) {
+	// 续期不能受调用方 ctx 的影响，只要锁没有释放就需要持续续期
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	interval := time.Duration(c.opts.lockExpireSeconds) * time.Second / 3
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.opts.clock.After(interval):
+				if err := renewer.RenewLock(ctx, c.opts.lockExpireSeconds); err != nil && ctx.Err() == nil {
+					c.opts.logger.Errorf("consistent hash renew lock failed, err: %v", err)
+				}
+			}
+		}
+	}()
+
+	c.watchDogMu.Lock()
+	c.stopWatchDog = func() {
+		cancel()
+		<-done
+	}
+	c.watchDogMu.Unlock()
+}
+
+func (c *ConsistentHash) unlock(ctx context.Context) {
+	c.endLocked()
+	if c.opts.disableLocking {
+		return
+	}
+
+	// 释放锁之前先停止看门狗，避免释放后再次续期
+	c.watchDogMu.Lock()
+	stopWatchDog := c.stopWatchDog
+	c.stopWatchDog = nil
+	c.watchDogMu.Unlock()
+	if stopWatchDog != nil {
+		stopWatchDog()
+	}
+
+	if err := c.hashRing.Unlock(ctx); err != nil {
+		c.opts.logger.Errorf("consistent hash unlock failed, err: %v", err)
+	}
+}
+
+// 查询哈希环的代数，每次成功添加、删除节点或调整节点权重后都会递增
+// 外部缓存可以据此判断哈希环的拓扑是否发生过变更，需要哈希环实现 GenerationCounter 接口
+func (c *ConsistentHash) Generation(ctx context.Context) (int64, error) {
+	counter, ok := c.hashRing.(GenerationCounter)
+	if !ok {
+		return 0, errors.New("hash ring does not support generation")
+	}
+	return counter.Generation(ctx)
+}
+
+// 哈希环的拓扑发生变更后递增代数、上报真实节点个数并发布变更事件，哈希环没有实现 GenerationCounter 时不递增代数
+func (c *ConsistentHash) topologyChanged(ctx context.Context, events ...RingEvent) error {
+	c.reportNodeCount(ctx)
+
+	var generation int64
+	if counter, ok := c.hashRing.(GenerationCounter); ok {
+		var err error
+		if generation, err = counter.IncrGeneration(ctx); err != nil {
+			return fmt.Errorf("incr ring generation failed, err: %w", err)
+		}
+	}
+
+	for _, event := range events {
+		event.Generation = generation
+		c.publish(ctx, event)
+	}
+	return nil
+}
+
+// 节点变更时是否需要重新分配数据 key 的登记关系，关闭了数据 key 登记时，节点上不存在需要迁移的数据
+// 未注入迁移函数时同样需要修正登记关系，否则登记的数据 key 会在拓扑变更后指向错误的节点，只是不再调用迁移函数
+func (c *ConsistentHash) needMigration() bool {
+	return !c.opts.disableDataKeyTracking
+}
+
+// 哈希环的拓扑即将发生变更，使数据 key 登记缓存失效
+func (c *ConsistentHash) invalidateDataKeyCache() {
+	if c.dataKeyCache != nil {
+		c.dataKeyCache.invalidate()
+	}
+}
+
+// 一笔数据迁移任务，ctx 在同批次的其他任务失败时会被取消
+type migrateTask func(ctx context.Context) error
+
+// 生成一笔数据迁移任务，任务执行失败时打印错误，并返回标识了迁移起点与终点的错误
+func (c *ConsistentHash) newMigrateTask(ctx context.Context, datas map[string]struct{}, from, to string) migrateTask {
+	spanFromContext(ctx).addMigratedKeys(len(datas))
+	return func(ctx context.Context) error {
+		// 同批次的任务已经失败，不再执行
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("migrate %d data keys from %s to %s canceled, err: %w", len(datas), from, to, err)
+		}
+
+		c.opts.logger.Infof("migrate %d data keys from %s to %s", len(datas), from, to)
+		if err := c.migrator(ctx, datas, from, to); err != nil {
+			c.opts.logger.Errorf("migrate %d data keys from %s to %s failed, err: %v", len(datas), from, to, err)
+			return fmt.Errorf("migrate %d data keys from %s to %s failed, err: %w", len(datas), from, to, err)
+		}
+		c.opts.metrics.AddMigratedKeys(len(datas))
+		return nil
+	}
+}
+
+// 数据迁移任务执行过程中产生的错误集合
+type MigrateErrors []error
+
+func (m MigrateErrors) Error() string {
+	errStrs := make([]string, 0, len(m))
+	for _, err := range m {
+		errStrs = append(errStrs, err.Error())
+	}
+	return fmt.Sprintf("migrate failed, errs: [%s]", strings.Join(errStrs, "; "))
+}
+
+func (m MigrateErrors) Unwrap() []error {
+	return m
+}
+
+// 并发执行全部数据迁移任务，同一时刻至多有 migrationConcurrency 个任务在执行，任务返回的错误以及 panic 都会被收集到 MigrateErrors 中
+// 任意一个任务失败后会取消传给其余任务的 ctx，尚未开始的任务不再执行，哈希环的拓扑变更在此之前已经完成，不会因迁移失败而回滚
+func (c *ConsistentHash) batchExecuteMigrator(ctx context.Context, migrateTasks []migrateTask) error {
+	// 未注入迁移函数时，数据 key 的登记关系已经修正，没有需要执行的数据迁移
+	if c.migrator == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 执行所有数据迁移任务
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs MigrateErrors
+		sem  = make(chan struct{}, c.opts.migrationConcurrency)
+	)
+	fail := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+		cancel()
+	}
+	for _, task := range migrateTasks {
+		task := task
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				// 迁移任务中的 panic 不能影响宿主进程，转换为错误后统一返回给调用方
+				if err := recover(); err != nil {
+					c.opts.logger.Errorf("migrate task panic: %v", err)
+					fail(fmt.Errorf("migrate task panic: %v", err))
+				}
+				wg.Done()
+			}()
+			if err := task(ctx); err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// 执行一笔状态数据的读写请求时，需要通过一致性哈希模块，检索到数据所对应的真实节点
+// 1 加锁， 2 通过hash编码器，找到数据在哈希环上的位置  3 找到顺时针往下的第一个虚拟节点   4 找到虚拟节点对应的真实节点  5 建立真实节点与状态数据之间的映射关系
+func (c *ConsistentHash) GetNode(ctx context.Context, dataKey string) (nodeID string, err error) {
+	defer c.observeLatency(OpGetNode, c.opts.clock.Now())
+	ctx, span := c.startSpan(ctx, OpGetNode, map[string]interface{}{AttrDataKey: dataKey})
+	defer func() { span.end(err, map[string]interface{}{AttrNodeID: nodeID}) }()
+	ctx = withLookup(ctx)
+
+	// 乐观检索期间拓扑发生变更时加锁重试，registered 为乐观检索时已经登记了该数据 key 的节点
+	var registered string
+	if c.opts.optimisticGetNode && !c.boundedLoad() {
+		var ok bool
+		if nodeID, registered, ok, err = c.getNodeOptimistic(ctx, dataKey); err != nil || ok {
+			return nodeID, err
+		}
+	}
+
+	if err := c.lock(ctx); err != nil {
+		return "", err
+	}
+
+	defer c.unlock(ctx)
+
+	var (
+		cachedNodeID string
+		generation   uint64
+	)
+	if c.dataKeyCache != nil {
+		cachedNodeID, generation = c.dataKeyCache.lookup(dataKey)
+	}
+
+	if c.boundedLoad() {
+		nodeID, err = c.getBoundedNode(ctx, dataKey)
+	} else {
+		nodeID, err = c.getNode(ctx, dataKey)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if c.opts.disableDataKeyTracking {
+		return nodeID, nil
+	}
+
+	// 乐观检索时登记的节点可能已经不是数据的归属节点，数据迁移也可能没有覆盖到这次登记
+	if registered != "" && registered != nodeID {
+		if err = c.hashRing.DeleteNodeToDataKeys(ctx, registered, map[string]struct{}{dataKey: {}}); err != nil {
+			return "", err
+		}
+	}
+
+	// 数据 key 已经登记在该节点下，无需重复登记
+	if cachedNodeID == nodeID {
+		return nodeID, nil
+	}
+
+	// 为datakey选中真实节点后， 需要将datakey添加到真实节点的状态数据key列表中
+	if err = c.hashRing.AddNodeToDataKeys(ctx, nodeID, map[string]struct{}{
+		dataKey: {},
+	}); err != nil {
+		return "", err
+	}
+	if c.dataKeyCache != nil {
+		c.dataKeyCache.add(dataKey, nodeID, generation)
+	}
+
+	//返回选中的目标节点
+	return nodeID, nil
+}
+
+// 不加锁检索数据对应的真实节点并登记数据 key，检索前后本实例的加锁次数或者哈希环的代数发生变化时 ok 为 false，
+// 此时检索结果以及登记关系都可能基于变更过程中的哈希环，需要加锁重试。registered 为已经登记了该数据 key 的节点
+func (c *ConsistentHash) getNodeOptimistic(ctx context.Context, dataKey string) (nodeID, registered string, ok bool, err error) {
+	// 本实例正在执行加锁的操作，直接加锁等待
+	if c.lockedOps.Load() > 0 {
+		return "", "", false, nil
+	}
+	seq := c.lockSeq.Load()
+	ringGeneration, err := c.ringGeneration(ctx)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	changed := func() (bool, error) {
+		if c.lockedOps.Load() > 0 || c.lockSeq.Load() != seq {
+			return true, nil
+		}
+		_ringGeneration, err := c.ringGeneration(ctx)
+		return _ringGeneration != ringGeneration, err
+	}
+
+	if nodeID, err = c.getNode(ctx, dataKey); err != nil {
+		// 拓扑变更过程中读取到的哈希环可能不完整
+		if _changed, _ := changed(); _changed {
+			return "", "", false, nil
+		}
+		return "", "", false, err
+	}
+
+	if !c.opts.disableDataKeyTracking {
+		var (
+			cachedNodeID string
+			generation   uint64
+		)
+		if c.dataKeyCache != nil {
+			cachedNodeID, generation = c.dataKeyCache.lookup(dataKey)
+		}
+		if cachedNodeID != nodeID {
+			if err = c.hashRing.AddNodeToDataKeys(ctx, nodeID, map[string]struct{}{dataKey: {}}); err != nil {
+				return "", "", false, err
+			}
+			if c.dataKeyCache != nil {
+				c.dataKeyCache.add(dataKey, nodeID, generation)
+			}
+		}
+		registered = nodeID
+	}
+
+	_changed, err := changed()
+	if err != nil {
+		return "", registered, false, err
+	}
+	return nodeID, registered, !_changed, nil
+}
+
+// 查询哈希环的代数，哈希环没有实现 GenerationCounter 时为 0
+func (c *ConsistentHash) ringGeneration(ctx context.Context) (int64, error) {
+	counter, ok := c.hashRing.(GenerationCounter)
+	if !ok {
+		return 0, nil
+	}
+	return counter.Generation(ctx)
+}
+
+// 记录本实例开始执行加锁的操作
+func (c *ConsistentHash) beginLocked() {
+	c.lockedOps.Add(1)
+	c.lockSeq.Add(1)
+}
+
+// 记录本实例结束执行加锁的操作
+func (c *ConsistentHash) endLocked() {
+	c.lockSeq.Add(1)
+	c.lockedOps.Add(-1)
+}
+
+// 检索数据对应的真实节点，并将数据 key 登记到该节点下，此后数据会参与节点变更时的数据迁移
+// 与 GetNode 的行为一致，用于在数据写入时显式登记
+func (c *ConsistentHash) AddDataKey(ctx context.Context, dataKey string) (string, error) {
+	return c.GetNode(ctx, dataKey)
+}
+
+// 将数据 key 从其当前归属的真实节点下删除，用于底层数据被删除后取消登记，此后数据不再参与数据迁移
+func (c *ConsistentHash) RemoveDataKey(ctx context.Context, dataKey string) error {
+	if err := c.lock(ctx); err != nil {
+		return err
+	}
+
+	defer c.unlock(ctx)
+
+	nodeID, err := c.getNode(ctx, dataKey)
+	if err != nil {
+		return err
+	}
+
+	if c.dataKeyCache != nil {
+		c.dataKeyCache.remove(dataKey)
+	}
+	return c.hashRing.DeleteNodeToDataKeys(ctx, nodeID, map[string]struct{}{
+		dataKey: {},
+	})
+}
+
+// 将一批数据 key 直接登记到真实节点下，不经过 GetNode 逐个检索，此后数据会参与节点变更时的数据迁移
+// 用于从备份恢复等节点本身已经持有数据的场景，需要在 AddNode 之后调用
+// 数据 key 不会按照哈希环重新分配，登记到非归属节点的数据 key 可以通过 ReconcileKeys 修正
+func (c *ConsistentHash) SeedNodeDataKeys(ctx context.Context, nodeID string, keys map[string]struct{}) error {
+	if c.opts.disableDataKeyTracking || len(keys) == 0 {
+		return nil
+	}
+
+	if err := c.lock(ctx); err != nil {
+		return err
+	}
+
+	defer c.unlock(ctx)
+
+	exists, err := c.NodeExists(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("invalid node id: %s, err: %w", nodeID, ErrNodeNotFound)
+	}
+
+	// 缓存中可能记录着这批数据 key 之前的登记节点
+	if c.dataKeyCache != nil {
+		for dataKey := range keys {
+			c.dataKeyCache.remove(dataKey)
+		}
+	}
+	return c.hashRing.AddNodeToDataKeys(ctx, nodeID, keys)
+}
+
+// 批量检索一批数据对应的真实节点，返回数据 key 到真实节点 id 的映射
+// 与循环调用 GetNode 相比，整个批次只会加锁一次，并且按照真实节点分组后批量登记数据 key
+func (c *ConsistentHash) BatchGetNode(ctx context.Context, dataKeys []string) (map[string]string, error) {
+	ctx = withLookup(ctx)
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	defer c.unlock(ctx)
+
+	res := make(map[string]string, len(dataKeys))
+	// 真实节点 id 到其需要登记的数据 key 集合的映射
+	nodeToDataKeys := make(map[string]map[string]struct{})
+	for _, dataKey := range dataKeys {
+		if _, ok := res[dataKey]; ok {
+			continue
+		}
+
+		nodeID, err := c.getNode(ctx, dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		res[dataKey] = nodeID
+		if _, ok := nodeToDataKeys[nodeID]; !ok {
+			nodeToDataKeys[nodeID] = make(map[string]struct{})
+		}
+		nodeToDataKeys[nodeID][dataKey] = struct{}{}
+	}
+
+	if c.opts.disableDataKeyTracking {
+		return res, nil
+	}
+
+	// 哈希环支持批量登记时，一次性完成全部真实节点的数据 key 登记
+	if batchAdder, ok := c.hashRing.(BatchDataKeysAdder); ok {
+		if err := batchAdder.BatchAddNodeToDataKeys(ctx, nodeToDataKeys); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
+	// 每个真实节点只需要执行一次数据 key 的登记
+	for nodeID, _dataKeys := range nodeToDataKeys {
+		if err := c.hashRing.AddNodeToDataKeys(ctx, nodeID, _dataKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// 查询真实节点是否已经存在于哈希环中，不加锁
+// 哈希环实现了 NodeChecker 时直接查询单个节点，否则退化为拉取全量真实节点后查找
+func (c *ConsistentHash) NodeExists(ctx context.Context, nodeID string) (bool, error) {
+	if checker, ok := c.hashRing.(NodeChecker); ok {
+		return checker.NodeExists(ctx, nodeID)
+	}
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, ok := nodes[nodeID]
+	return ok, nil
+}
+
+// 查询数据 key 是否登记在真实节点下
+// 哈希环实现了 DataKeyRecorder 时直接查询单个数据 key，否则退化为拉取真实节点全量的数据 key 后查找
+func (c *ConsistentHash) dataKeyRecorded(ctx context.Context, nodeID, dataKey string) (bool, error) {
+	if recorder, ok := c.hashRing.(DataKeyRecorder); ok {
+		return recorder.DataKeyRecorded(ctx, nodeID, dataKey)
+	}
+
+	dataKeys, err := c.hashRing.DataKeys(ctx, nodeID)
+	if err != nil {
+		return false, err
+	}
+	_, ok := dataKeys[dataKey]
+	return ok, nil
+}
+
+// 检查哈希环的存储是否可达，用于就绪探针与启动检查，哈希环未实现 Pinger 时视为始终可达
+func (c *ConsistentHash) Ping(ctx context.Context) error {
+	if pinger, ok := c.hashRing.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// 释放哈希环持有的资源，哈希环实现了 Closeable 时转交给哈希环处理，否则不做任何处理
+func (c *ConsistentHash) Close() error {
+	if closeable, ok := c.hashRing.(Closeable); ok {
+		return closeable.Close()
+	}
+	return nil
+}
+
+// 分页遍历真实节点的数据 key，首次调用时 cursor 传 0，之后传入上一次返回的 next，next 为 0 时遍历结束
+// 哈希环实现了 DataKeysScanner 时按游标分页读取，否则退化为拉取全量数据 key 后排序分页
+// 遍历期间数据 key 发生变更时，可能返回重复的 key 或者遗漏变更的 key
+func (c *ConsistentHash) ScanDataKeys(ctx context.Context, nodeID string, cursor uint64, count int) ([]string, uint64, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	if scanner, ok := c.hashRing.(DataKeysScanner); ok {
+		return scanner.ScanDataKeys(ctx, nodeID, cursor, count)
+	}
+
+	dataKeys, err := c.hashRing.DataKeys(ctx, nodeID)
+	if err != nil {
+		return nil, 0, err
+	}
+	keys := make([]string, 0, len(dataKeys))
+	for dataKey := range dataKeys {
+		keys = append(keys, dataKey)
+	}
+	sort.Strings(keys)
+
+	if cursor >= uint64(len(keys)) {
+		return nil, 0, nil
+	}
+	next := cursor + uint64(count)
+	if next >= uint64(len(keys)) {
+		return keys[cursor:], 0, nil
+	}
+	return keys[cursor:next], next, nil
+}
+
+// 不加锁、只读的 GetNode，适用于读多写少的场景
+// 该方法既不获取哈希环的分布式锁，也不会将 dataKey 登记到真实节点的状态数据 key 列表中，因此：
+// 1 与 AddNode/RemoveNode 并发执行时，可能返回拓扑变更前的节点
+// 2 通过该方法路由的数据不会参与后续节点变更时的数据迁移
+// 倘若需要依赖数据迁移能力，请使用 GetNode
+func (c *ConsistentHash) GetNodeReadOnly(ctx context.Context, dataKey string) (string, error) {
+	return c.getNode(withLookup(ctx), dataKey)
+}
+
+// 在 GetNodeReadOnly 的基础上额外返回数据 key 在哈希环上的位置 dataScore，以及选中的虚拟节点数值 ceilingScore
+// 两者相距越近，数据 key 越可能在下一次拓扑变更时被迁移，调用方可以据此实现自身的缓存或者分片亲和策略
+// 与 GetNodeReadOnly 一样不加锁也不登记数据 key
+func (c *ConsistentHash) GetNodeDetailed(ctx context.Context, dataKey string) (nodeID string, dataScore, ceilingScore int64, err error) {
+	return c.getNodeDetailed(withLookup(ctx), dataKey)
+}
+
+func (c *ConsistentHash) getNodeDetailed(ctx context.Context, dataKey string) (nodeID string, dataScore, ceilingScore int64, err error) {
+	dataScore = c.getScore(dataKey)
+	nodes, ceilingScore, err := c.ceilingNodes(ctx, dataScore)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return c.getNodeID(nodes[c.pickIndex(dataKey, nodes)]), dataScore, ceilingScore, nil
+}
+
+// 是否启用了有界负载模式，负载依赖数据 key 的登记，关闭登记时不生效
+func (c *ConsistentHash) boundedLoad() bool {
+	return c.opts.loadFactor > 0 && !c.opts.disableDataKeyTracking
+}
+
+// 有界负载模式下为数据选择真实节点，数据 key 已经登记过时直接返回其登记的节点
+// 否则从哈希环上的归属节点开始顺时针寻找登记的数据 key 个数低于上限的节点，上限为 loadFactor 乘以计入本次数据后的平均负载
+func (c *ConsistentHash) getBoundedNode(ctx context.Context, dataKey string) (string, error) {
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	loads := make(map[string]int, len(nodes))
+	var total int
+	for nodeID := range nodes {
+		recorded, load, err := c.nodeLoad(ctx, nodeID, dataKey)
+		if err != nil {
+			return "", err
+		}
+		if recorded {
+			return nodeID, nil
+		}
+		loads[nodeID] = load
+		total += load
+	}
+
+	var nodeID string
+	capacity := int(math.Ceil(c.opts.loadFactor * float64(total+1) / float64(len(nodes))))
+	if err = c.walkNodes(ctx, dataKey, func(candidate string) (bool, error) {
+		if loads[candidate] < capacity {
+			nodeID = candidate
+			return true, nil
+		}
+		return false, nil
+	}); err != nil {
+		return "", err
+	}
+
+	// 上限不低于平均负载，正常情况下一定存在有余量的节点
+	if nodeID == "" {
+		return "", fmt.Errorf("all nodes reach capacity %d, err: %w", capacity, ErrNoNodeAvailable)
+	}
+	return nodeID, nil
+}
+
+// 查询数据 key 是否登记在真实节点下，以及真实节点登记的数据 key 个数，已经登记时不再统计个数
+// 哈希环同时实现了 DataKeyRecorder 与 RingCounter 时直接查询，否则退化为拉取真实节点全量的数据 key
+func (c *ConsistentHash) nodeLoad(ctx context.Context, nodeID, dataKey string) (recorded bool, load int, err error) {
+	recorder, recordable := c.hashRing.(DataKeyRecorder)
+	counter, countable := c.hashRing.(RingCounter)
+	if recordable && countable {
+		if recorded, err = recorder.DataKeyRecorded(ctx, nodeID, dataKey); err != nil || recorded {
+			return recorded, 0, err
+		}
+		load, err = counter.DataKeyCount(ctx, nodeID)
+		return false, load, err
+	}
+
+	dataKeys, err := c.hashRing.DataKeys(ctx, nodeID)
+	if err != nil {
+		return false, 0, err
+	}
+	_, recorded = dataKeys[dataKey]
+	return recorded, len(dataKeys), nil
+}
+
+// 检索数据所对应的真实节点，不加锁也不登记数据 key
+func (c *ConsistentHash) getNode(ctx context.Context, dataKey string) (string, error) {
+	//输入一个数据的key 根据encryptor计算出其从属与哈希环的位置dataScore
+	nodeID, _, _, err := c.getNodeDetailed(ctx, dataKey)
+	return nodeID, err
+}
+
+// 按照哈希环上的任意位置 score 检索真实节点，返回选中的真实节点以及其所在的虚拟节点数值
+// 与 GetNodeReadOnly 一样不加锁也不登记数据 key，用于排查数据 key 的路由结果
+// 由于不涉及具体的数据 key，虚拟节点上存在多个真实节点时固定返回列表的首个节点
+func (c *ConsistentHash) NodeForScore(ctx context.Context, score int64) (string, int64, error) {
+	nodes, ceilingScore, err := c.ceilingNodes(ctx, score)
+	if err != nil {
+		return "", 0, err
+	}
+	return c.getNodeID(nodes[0]), ceilingScore, nil
+}
+
+// 查询负责哈希环上区间 [fromScore, toScore] 的全部真实节点，fromScore 大于 toScore 时区间跨过环的终点回绕到起点
+// 结果包含位于区间内的虚拟节点，以及区间之后第一个虚拟节点（负责区间的尾部）对应的真实节点，按照顺时针的遍历顺序去重返回
+// 与 NodeForScore 一样不加锁，适用于按区间扫描数据的调用方确定需要访问的真实节点
+func (c *ConsistentHash) NodesInRange(ctx context.Context, fromScore, toScore int64) ([]string, error) {
+	if fromScore < 0 || fromScore >= c.opts.ringSize || toScore < 0 || toScore >= c.opts.ringSize {
+		return nil, fmt.Errorf("invalid score range [%d, %d], ring size: %d", fromScore, toScore, c.opts.ringSize)
+	}
+
+	// 以 fromScore 为起点顺时针计算的距离，用于统一处理回绕的情况
+	distance := func(score int64) int64 {
+		if score >= fromScore {
+			return score - fromScore
+		}
+		return score + c.opts.ringSize - fromScore
+	}
+	rangeLen := distance(toScore)
+
+	var (
+		res      []string
+		selected = make(map[string]struct{})
+		lastDist = int64(-1)
+	)
+	score, err := c.ceilingInclusive(ctx, fromScore)
+	if err != nil {
+		return nil, err
+	}
+	if score == -1 {
+		return nil, c.noNodeErr(ctx)
+	}
+	for {
+		// 距离没有增大，说明已经遍历了一整圈
+		dist := distance(score)
+		if dist <= lastDist {
+			break
+		}
+		lastDist = dist
+
+		rawNodeKeys, err := c.hashRing.Node(ctx, score)
+		if err != nil {
+			return nil, err
+		}
+		if len(rawNodeKeys) == 0 {
+			return nil, fmt.Errorf("empty score, err: %w", ErrNoNodeAvailable)
+		}
+		// 位置冲突时数据归属于列表的首个节点，开启冲突分散后列表中的每个节点都可能承接数据
+		if !c.opts.spreadCollisions {
+			rawNodeKeys = rawNodeKeys[:1]
+		}
+		for _, rawNodeKey := range rawNodeKeys {
+			nodeID := c.getNodeID(rawNodeKey)
+			if _, ok := selected[nodeID]; ok {
+				continue
+			}
+			selected[nodeID] = struct{}{}
+			res = append(res, nodeID)
+		}
+
+		// 已经到达区间的尾部
+		if dist >= rangeLen {
+			break
+		}
+		if score, err = c.ceilingExclusive(ctx, score); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// 哈希环上检索不到虚拟节点时的错误，没有任何真实节点时返回 ErrRingEmpty，否则说明真实节点缺少虚拟节点，返回 ErrNoNodeAvailable
+func (c *ConsistentHash) noNodeErr(ctx context.Context) error {
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return ErrRingEmpty
+	}
+	return fmt.Errorf("virtual nodes missing, err: %w", ErrNoNodeAvailable)
+}
+
+// 查询 score 顺时针往下的第一个虚拟节点数值及其真实节点列表，列表一定不为空
+func (c *ConsistentHash) ceilingNodes(ctx context.Context, score int64) ([]string, int64, error) {
+	// 路由查询优先由只读副本执行，其余流程需要读取最新的哈希环
+	ceiling, node := c.hashRing.Ceiling, c.hashRing.Node
+	if reader, ok := c.hashRing.(ReplicaReader); ok && isLookup(ctx) {
+		ceiling, node = reader.ReplicaCeiling, reader.ReplicaNode
+	}
+
+	// 执行ceiling 找到score对应的下一个虚拟节点数值ceilingScore
+	ceilingScore, err := ceiling(ctx, score)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 倘若未找到目标，则说明没有可用的目标节点，需要区分哈希环为空与真实节点缺少虚拟节点两种情况
+	if ceilingScore == -1 {
+		return nil, 0, c.noNodeErr(ctx)
+	}
+
+	// 查询ceilingScore对应的真实节点列表
+	nodes, err := node(ctx, ceilingScore)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 倘若真实节点列表为空直接返回错误
+	if len(nodes) == 0 {
+		return nil, 0, fmt.Errorf("empty score, err: %w", ErrNoNodeAvailable)
+	}
+	return nodes, ceilingScore, nil
+}
+
+// 为 dataKey 从虚拟节点的真实节点列表中选择一个节点，返回其下标，未开启冲突分散时固定选择首个节点
+// 开启后对 dataKey 与每个虚拟节点 key 的组合计算 fnv 散列，选择散列值最大的节点（rendezvous hashing），结果与列表的顺序无关，
+// 列表中追加节点时只有改为选中新节点的数据 key 会改变归属，删除节点时也只有原本选中该节点的数据 key 会改变归属
+func (c *ConsistentHash) pickIndex(dataKey string, rawNodeKeys []string) int {
+	if !c.opts.spreadCollisions || len(rawNodeKeys) <= 1 {
+		return 0
+	}
+
+	var (
+		index int
+		max   uint64
+	)
+	for i, rawNodeKey := range rawNodeKeys {
+		hasher := fnv.New64a()
+		_, _ = hasher.Write([]byte(dataKey))
+		_, _ = hasher.Write([]byte{0})
+		_, _ = hasher.Write([]byte(rawNodeKey))
+		sum := hasher.Sum64()
+		if i == 0 || sum > max || (sum == max && rawNodeKey < rawNodeKeys[index]) {
+			index, max = i, sum
+		}
+	}
+	return index
+}
+
+// 为数据检索 n 个互不相同的真实节点，用于多副本存储
+// 从数据在哈希环上的位置开始顺时针遍历虚拟节点，跳过已经选中的真实节点，直到凑齐 n 个真实节点或者遍历完整个哈希环
+// 返回结果中的首个节点与 GetNode 的结果一致，数据 key 也只会登记在首个节点下
+func (c *ConsistentHash) GetNodes(ctx context.Context, dataKey string, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid node count: %d", n)
+	}
+
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	defer c.unlock(ctx)
+
+	res := make([]string, 0, n)
+	if err := c.walkNodes(ctx, dataKey, func(nodeID string) (bool, error) {
+		res = append(res, nodeID)
+		return len(res) == n, nil
+	}); err != nil {
+		return nil, err
+	}
+	return c.trackReplicas(ctx, dataKey, res)
+}
+
+// 在 GetNodes 的基础上让副本尽量分布在不同的故障域，zoneKey 为真实节点元数据中表示故障域的字段，需要哈希环实现 NodeMetaStore
+// 顺时针遍历时跳过所在故障域已经有副本的真实节点，故障域的个数不足 n 个时，再按照遍历顺序用被跳过的节点补齐
+// 元数据中没有 zoneKey 字段的真实节点无法判断其故障域，视为独立的故障域
+func (c *ConsistentHash) GetNodesZoneAware(ctx context.Context, dataKey string, n int, zoneKey string) ([]string, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid node count: %d", n)
+	}
+	metaStore, ok := c.hashRing.(NodeMetaStore)
+	if !ok {
+		return nil, errors.New("hash ring does not support node meta")
+	}
+
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	defer c.unlock(ctx)
+
+	var (
+		res       = make([]string, 0, n)
+		skipped   []string
+		usedZones = make(map[string]struct{}, n)
+	)
+	if err := c.walkNodes(ctx, dataKey, func(nodeID string) (bool, error) {
+		meta, err := metaStore.NodeMeta(ctx, nodeID)
+		if err != nil {
+			return false, err
+		}
+		if zone, ok := meta[zoneKey]; ok {
+			if _, used := usedZones[zone]; used {
+				skipped = append(skipped, nodeID)
+				return false, nil
+			}
+			usedZones[zone] = struct{}{}
+		}
+		res = append(res, nodeID)
+		return len(res) == n, nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, nodeID := range skipped {
+		if len(res) == n {
+			break
+		}
+		res = append(res, nodeID)
+	}
+	return c.trackReplicas(ctx, dataKey, res)
+}
+
+// 从数据在哈希环上的位置开始顺时针遍历互不相同的真实节点，visit 返回 true 或者遍历完整个哈希环时结束
+// 首个遍历到的节点与 GetNode 的结果一致
+func (c *ConsistentHash) walkNodes(ctx context.Context, dataKey string, visit func(nodeID string) (bool, error)) error {
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return ErrRingEmpty
+	}
+
+	dataScore := c.getScore(dataKey)
+	score, err := c.ceilingInclusive(ctx, dataScore)
+	if err != nil {
+		return err
+	}
+	if score == -1 {
+		return fmt.Errorf("virtual nodes missing, err: %w", ErrNoNodeAvailable)
+	}
+
+	startScore := score
+	selected := make(map[string]struct{}, len(nodes))
+	for {
+		rawNodeKeys, err := c.hashRing.Node(ctx, score)
+		if err != nil {
+			return err
+		}
+
+		// 首个虚拟节点从 GetNode 选中的节点开始遍历，保证结果的首个节点与 GetNode 一致
+		if score == startScore && len(selected) == 0 {
+			if index := c.pickIndex(dataKey, rawNodeKeys); index > 0 {
+				rawNodeKeys = append(append(make([]string, 0, len(rawNodeKeys)), rawNodeKeys[index:]...), rawNodeKeys[:index]...)
+			}
+		}
+
+		for _, rawNodeKey := range rawNodeKeys {
+			nodeID := c.getNodeID(rawNodeKey)
+			if _, ok := selected[nodeID]; ok {
+				continue
+			}
+			selected[nodeID] = struct{}{}
+			done, err := visit(nodeID)
+			if err != nil {
+				return err
+			}
+			// 全部真实节点都已经遍历过时无需继续
+			if done || len(selected) == len(nodes) {
+				return nil
+			}
+		}
+
+		// 顺时针找到下一个虚拟节点，倘若已经回到起点，说明整个哈希环已经遍历完毕
+		if score, err = c.ceilingExclusive(ctx, score); err != nil {
+			return err
+		}
+		if score == -1 || score == startScore {
+			return nil
+		}
+	}
+}
+
+// 将数据 key 登记在副本列表的首个节点下
+func (c *ConsistentHash) trackReplicas(ctx context.Context, dataKey string, res []string) ([]string, error) {
+	if len(res) == 0 {
+		return nil, fmt.Errorf("empty score, err: %w", ErrNoNodeAvailable)
+	}
+
+	if c.opts.disableDataKeyTracking {
+		return res, nil
+	}
+
+	if err := c.hashRing.AddNodeToDataKeys(ctx, res[0], map[string]struct{}{
+		dataKey: {},
+	}); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// 数据 key 在哈希环上的位置，使用数据 key 的散列函数
+func (c *ConsistentHash) getScore(dataKey string) int64 {
+	return c.hashScore(c.opts.dataKeyEncryptor, dataKey)
+}
+
+// 将原始内容映射到哈希环上的位置，取值范围为 [0, ringSize)
+func (c *ConsistentHash) hashScore(encryptor Encryptor, origin string) int64 {
+	score := encryptor.Encrypt(origin) % c.opts.ringSize
+	if score < 0 {
+		score += c.opts.ringSize
+	}
+	return score
+}
+
+// 返回生效的虚拟节点放大系数，真实节点的虚拟节点个数为权重乘以该系数
+func (c *ConsistentHash) Replicas() int {
+	return c.opts.replicas
+}
+
+// 根据权重计算真实节点的虚拟节点个数，放大系数配置非法时返回 ErrInvalidReplicas
+func (c *ConsistentHash) getReplicas(weight int) (int, error) {
+	if c.opts.replicas < 1 {
+		return 0, fmt.Errorf("replicas: %d, err: %w", c.opts.replicas, ErrInvalidReplicas)
+	}
+	if c.opts.weightScaler == nil {
+		return c.getValidWeight(weight) * c.opts.replicas, nil
+	}
+
+	replicas := int(math.Round(c.opts.weightScaler(c.getValidWeight(weight)) * float64(c.opts.replicas)))
+	if replicas < 1 {
+		replicas = 1
+	}
+	return replicas, nil
+}
+
+func (c *ConsistentHash) getValidWeight(weight int) int {
+	if weight <= 0 {
+		return 1
+	}
+
+	if weight >= 10 {
+		return 10
+	}
+
+	return weight
+}
+
+// 虚拟节点的 key 采用长度前缀编码：{len(nodeID)}:{nodeID}_{index}
+// nodeID 的内容按照长度截取，因此无论其中包含下划线、空格还是其他任意字符，都能被 getNodeID 准确还原
+// 哈希环中存储的始终是该编码，与虚拟节点位置所使用的 NodeKeyFormatter 无关
+func (c *ConsistentHash) getRawNodeKey(nodeID string, index int) string {
+	return defaultNodeKeyFormatter(nodeID, index)
+}
+
+func defaultNodeKeyFormatter(nodeID string, index int) string {
+	return fmt.Sprintf("%d:%s_%d", len(nodeID), nodeID, index)
+}
+
+// 虚拟节点在哈希环上的位置，由 NodeKeyFormatter 生成的 key 经过 encryptor 散列得到
+func (c *ConsistentHash) getVirtualScore(nodeID string, index int) int64 {
+	return c.hashScore(c.encryptor, c.opts.nodeKeyFormatter(nodeID, index))
+}
+
+// 开启 WithCollisionRehash 时虚拟节点加盐重新散列的最大次数
+const maxCollisionSalt = 16
+
+// 虚拟节点第 salt 次加盐重新散列后的位置，salt 为 0 时即为 getVirtualScore
+func (c *ConsistentHash) getSaltedVirtualScore(nodeID string, index, salt int) int64 {
+	if salt == 0 {
+		return c.getVirtualScore(nodeID, index)
+	}
+	return c.hashScore(c.encryptor, fmt.Sprintf("%s#%d", c.opts.nodeKeyFormatter(nodeID, index), salt))
+}
+
+// 虚拟节点可能所在的全部位置，未开启 WithCollisionRehash 时只有 getVirtualScore 一个位置
+func (c *ConsistentHash) virtualScoreCandidates(nodeID string, index int) []int64 {
+	if !c.opts.collisionRehash {
+		return []int64{c.getVirtualScore(nodeID, index)}
+	}
+	candidates := make([]int64, 0, maxCollisionSalt+1)
+	for salt := 0; salt <= maxCollisionSalt; salt++ {
+		candidates = append(candidates, c.getSaltedVirtualScore(nodeID, index, salt))
+	}
+	return candidates
+}
+
+// 为真实节点 nodeID 新增的第 index 个虚拟节点选择位置，pending 为本次已经选好位置但尚未写入哈希环的虚拟节点
+// 开启 WithCollisionRehash 时依次尝试加盐后的位置，跳过已经存在其他真实节点的位置，加盐次数耗尽时退化为原位置
+func (c *ConsistentHash) pickVirtualScore(ctx context.Context, nodeID string, index int, pending map[int64][]string) (int64, error) {
+	candidates := c.virtualScoreCandidates(nodeID, index)
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	for _, score := range candidates {
+		rawNodeKeys, err := c.nodeKeysAt(ctx, score)
+		if err != nil {
+			return 0, err
+		}
+		if !c.occupiedByOthers(nodeID, append(rawNodeKeys, pending[score]...)) {
+			return score, nil
+		}
+	}
+	c.opts.logger.Infof("virtual node %d of %s still collides after %d rehashes", index, nodeID, maxCollisionSalt)
+	return candidates[0], nil
+}
+
+// 查询已经入环的虚拟节点所在的位置，与 pickVirtualScore 相对应，未找到时返回原位置
+func (c *ConsistentHash) locateVirtualScore(ctx context.Context, nodeID string, index int) (int64, error) {
+	candidates := c.virtualScoreCandidates(nodeID, index)
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	rawNodeKey := c.getRawNodeKey(nodeID, index)
+	for _, score := range candidates {
+		rawNodeKeys, err := c.nodeKeysAt(ctx, score)
+		if err != nil {
+			return 0, err
+		}
+		for _, _rawNodeKey := range rawNodeKeys {
+			if _rawNodeKey == rawNodeKey {
+				return score, nil
+			}
+		}
+	}
+	return candidates[0], nil
+}
+
+// 查询哈希环 score 位置上的虚拟节点 key 列表，该位置上没有虚拟节点时返回空
+func (c *ConsistentHash) nodeKeysAt(ctx context.Context, score int64) ([]string, error) {
+	ceilingScore, err := c.ceilingInclusive(ctx, score)
+	if err != nil || ceilingScore != score {
+		return nil, err
+	}
+	return c.hashRing.Node(ctx, score)
+}
+
+// 虚拟节点 key 列表中是否存在属于其他真实节点的虚拟节点
+func (c *ConsistentHash) occupiedByOthers(nodeID string, rawNodeKeys []string) bool {
+	for _, rawNodeKey := range rawNodeKeys {
+		if c.getNodeID(rawNodeKey) != nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConsistentHash) getNodeID(rawNodeKey string) string {
+	nodeID, _, ok := c.parseRawNodeKey(rawNodeKey)
+	if !ok {
+		return rawNodeKey
+	}
+	return nodeID
+}
+
+// getRawNodeKey 的逆操作，从虚拟节点的 key 中解析出真实节点 id 以及虚拟节点的序号
+func (c *ConsistentHash) parseRawNodeKey(rawNodeKey string) (nodeID string, index int, ok bool) {
+	sep := strings.Index(rawNodeKey, ":")
+	if sep <= 0 {
+		return "", 0, false
+	}
+
+	length, err := strconv.Atoi(rawNodeKey[:sep])
+	if err != nil || length < 0 {
+		return "", 0, false
+	}
+
+	// 长度前缀之后依次为 nodeID、下划线以及虚拟节点序号
+	rest := rawNodeKey[sep+1:]
+	if len(rest) < length+2 || rest[length] != '_' {
+		return "", 0, false
+	}
+
+	if index, err = strconv.Atoi(rest[length+1:]); err != nil {
+		return "", 0, false
+	}
+
+	return rest[:length], index, true
 }
@@ -0,0 +1,2098 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+// 从位置 0 开始顺时针遍历整个哈希环，返回全部虚拟节点的位置
+func ringScores(t *testing.T, c *ConsistentHash) []int64 {
+	t.Helper()
+	ctx := context.Background()
+	first, err := c.hashRing.Ceiling(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == -1 {
+		return nil
+	}
+
+	scores := []int64{first}
+	for {
+		next, err := c.hashRing.Ceiling(ctx, c.incrScore(scores[len(scores)-1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next == first {
+			return scores
+		}
+		scores = append(scores, next)
+	}
+}
+
+// 查询一批真实节点下登记的数据 key，返回数据 key 到真实节点的映射
+func recordedDataKeys(t *testing.T, c *ConsistentHash, nodeIDs ...string) map[string]string {
+	t.Helper()
+	recorded := make(map[string]string)
+	for _, nodeID := range nodeIDs {
+		dataKeys, err := c.hashRing.DataKeys(context.Background(), nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for dataKey := range dataKeys {
+			if owner, ok := recorded[dataKey]; ok {
+				t.Errorf("data %s recorded under both %s and %s", dataKey, owner, nodeID)
+			}
+			recorded[dataKey] = nodeID
+		}
+	}
+	return recorded
+}
+
+func Test_RawNodeKey_RoundTrip(t *testing.T) {
+	c := NewConsistentHash(nil, NewMurmurHasher(), nil)
+	nodeIDs := []string{
+		"node",
+		"node_a",
+		"us-east_1",
+		"node_1_2",
+		"_",
+		"node a",
+		" leading and trailing ",
+		"节点_甲",
+		"ノード:1",
+		"5:node_0",
+	}
+
+	for _, nodeID := range nodeIDs {
+		for _, index := range []int{0, 1, 10, 123} {
+			rawNodeKey := c.getRawNodeKey(nodeID, index)
+			gotNodeID, gotIndex, ok := c.parseRawNodeKey(rawNodeKey)
+			if !ok {
+				t.Errorf("parse raw node key %q failed", rawNodeKey)
+				continue
+			}
+			if gotNodeID != nodeID || gotIndex != index {
+				t.Errorf("round trip of (%q, %d) got (%q, %d)", nodeID, index, gotNodeID, gotIndex)
+			}
+			if got := c.getNodeID(rawNodeKey); got != nodeID {
+				t.Errorf("getNodeID(%q) got %q, want %q", rawNodeKey, got, nodeID)
+			}
+		}
+	}
+}
+
+func Test_RawNodeKey_Distinct(t *testing.T) {
+	c := NewConsistentHash(nil, NewMurmurHasher(), nil)
+	// 不同的 (nodeID, index) 组合编码后不能产生相同的 key
+	seen := make(map[string]struct{})
+	for _, nodeID := range []string{"a", "a_1", "a_1_1", "1:a"} {
+		for index := 0; index < 12; index++ {
+			rawNodeKey := c.getRawNodeKey(nodeID, index)
+			if _, ok := seen[rawNodeKey]; ok {
+				t.Errorf("duplicate raw node key %q", rawNodeKey)
+			}
+			seen[rawNodeKey] = struct{}{}
+		}
+	}
+}
+
+func Test_ParseRawNodeKey_Invalid(t *testing.T) {
+	c := NewConsistentHash(nil, NewMurmurHasher(), nil)
+	for _, rawNodeKey := range []string{"", "node_0", ":node_0", "x:node_0", "10:node_0", "4:node0", "4:node_x"} {
+		if _, _, ok := c.parseRawNodeKey(rawNodeKey); ok {
+			t.Errorf("parse invalid raw node key %q should fail", rawNodeKey)
+		}
+	}
+}
+
+func Test_AddNode_RemoveNode_ClearsRing(t *testing.T) {
+	ctx := context.Background()
+	hashRing := memory.NewHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithReplicas(3))
+
+	nodeID := "node_a"
+	if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// 记录添加节点后写入哈希环的虚拟节点位置
+	scores := ringScores(t, consistentHash)
+	if len(scores) != 6 {
+		t.Fatalf("got %d virtual nodes, want 6", len(scores))
+	}
+	for _, score := range scores {
+		nodeIDs, err := hashRing.Node(ctx, score)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := consistentHash.getNodeID(nodeIDs[0]); got != nodeID {
+			t.Errorf("score %d belongs to %q, want %q", score, got, nodeID)
+		}
+	}
+
+	if err := consistentHash.RemoveNode(ctx, nodeID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, score := range scores {
+		if _, err := hashRing.Node(ctx, score); err == nil {
+			t.Errorf("virtual node at score %d still exists after remove", score)
+		}
+	}
+	nodes, err := hashRing.Nodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scores = ringScores(t, consistentHash); len(scores) != 0 || len(nodes) != 0 {
+		t.Errorf("ring not empty after remove, scores: %v, nodes: %v", scores, nodes)
+	}
+}
+
+func Test_AddNode_MigratorPanic(t *testing.T) {
+	ctx := context.Background()
+	hashRing := memory.NewHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		panic("migrate panic")
+	})
+
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 添加新节点触发数据迁移，迁移函数 panic 时应当返回错误而不是终止进程
+	err := consistentHash.AddNode(ctx, "node_b", 1)
+	if err == nil {
+		t.Fatal("add node with panicking migrator should return error")
+	}
+	var migrateErrs MigrateErrors
+	if !errors.As(err, &migrateErrs) || len(migrateErrs) == 0 {
+		t.Errorf("got err %v, want MigrateErrors", err)
+	}
+}
+
+func Test_GetNodes(t *testing.T) {
+	ctx := context.Background()
+	hashRing := memory.NewHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithReplicas(3))
+	for nodeID, weight := range map[string]int{"node_a": 2, "node_b": 1, "node_c": 1} {
+		if err := consistentHash.AddNode(ctx, nodeID, weight); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scores := ringScores(t, consistentHash)
+	for i := 0; i < 50; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		nodes, err := consistentHash.GetNodes(ctx, dataKey, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 2 || nodes[0] == nodes[1] {
+			t.Fatalf("got nodes %v for %s, want 2 distinct nodes", nodes, dataKey)
+		}
+
+		// 首个节点与 GetNode 的结果一致
+		node, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes[0] != node {
+			t.Errorf("got first node %s for %s, want %s", nodes[0], dataKey, node)
+		}
+
+		// 节点顺序与从数据位置开始顺时针遍历哈希环的顺序一致
+		var expected []string
+		start, err := hashRing.Ceiling(ctx, consistentHash.getScore(dataKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var startIndex int
+		for startIndex < len(scores) && scores[startIndex] != start {
+			startIndex++
+		}
+		for j := 0; j < len(scores) && len(expected) < 2; j++ {
+			rawNodeKeys, err := hashRing.Node(ctx, scores[(startIndex+j)%len(scores)])
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rawNodeKey := range rawNodeKeys {
+				nodeID := consistentHash.getNodeID(rawNodeKey)
+				if len(expected) < 2 && (len(expected) == 0 || expected[0] != nodeID) {
+					expected = append(expected, nodeID)
+				}
+			}
+		}
+		if fmt.Sprint(nodes) != fmt.Sprint(expected) {
+			t.Errorf("got nodes %v for %s, want %v", nodes, dataKey, expected)
+		}
+	}
+
+	// 真实节点数量不足时返回全部真实节点
+	nodes, err := consistentHash.GetNodes(ctx, "data_x", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 3 {
+		t.Fatalf("got nodes %v, want all 3 nodes", nodes)
+	}
+	seen := make(map[string]struct{})
+	for _, node := range nodes {
+		if _, ok := seen[node]; ok {
+			t.Errorf("duplicate node %s in %v", node, nodes)
+		}
+		seen[node] = struct{}{}
+	}
+}
+
+func Test_GetNodeReadOnly(t *testing.T) {
+	ctx := context.Background()
+	hashRing := memory.NewHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil)
+	if _, err := consistentHash.GetNodeReadOnly(ctx, "data_a"); err == nil {
+		t.Error("get node on empty ring should return error")
+	}
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		readOnlyNode, err := consistentHash.GetNodeReadOnly(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 只读检索不会登记数据 key
+		if recorded := recordedDataKeys(t, consistentHash, "node_a", "node_b", "node_c"); len(recorded) != i {
+			t.Fatalf("got %d recorded data keys, want %d", len(recorded), i)
+		}
+
+		node, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if readOnlyNode != node {
+			t.Errorf("got read only node %s for %s, want %s", readOnlyNode, dataKey, node)
+		}
+	}
+}
+
+func newBenchmarkConsistentHash(b *testing.B) *ConsistentHash {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	for i := 0; i < 10; i++ {
+		if err := consistentHash.AddNode(ctx, fmt.Sprintf("node_%d", i), 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return consistentHash
+}
+
+func Benchmark_GetNode(b *testing.B) {
+	consistentHash := newBenchmarkConsistentHash(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := context.Background()
+		var i int
+		for pb.Next() {
+			if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i%1000)); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
+
+func Benchmark_GetNodeReadOnly(b *testing.B) {
+	consistentHash := newBenchmarkConsistentHash(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := context.Background()
+		var i int
+		for pb.Next() {
+			if _, err := consistentHash.GetNodeReadOnly(ctx, fmt.Sprintf("data_%d", i%1000)); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
+
+// 统计加锁以及数据 key 登记次数的哈希环
+type countingHashRing struct {
+	HashRing
+	locks             int
+	addNodeToDataKeys int
+}
+
+func (r *countingHashRing) Lock(ctx context.Context, expireSeconds int) error {
+	r.locks++
+	return r.HashRing.Lock(ctx, expireSeconds)
+}
+
+func (r *countingHashRing) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	r.addNodeToDataKeys++
+	return r.HashRing.AddNodeToDataKeys(ctx, nodeID, dataKeys)
+}
+
+func Test_BatchGetNode(t *testing.T) {
+	ctx := context.Background()
+	hashRing := &countingHashRing{HashRing: memory.NewHashRing()}
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil)
+	nodeIDs := []string{"node_a", "node_b", "node_c"}
+	for _, nodeID := range nodeIDs {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataKeys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		dataKeys = append(dataKeys, fmt.Sprintf("data_%d", i))
+	}
+
+	hashRing.locks, hashRing.addNodeToDataKeys = 0, 0
+	res, err := consistentHash.BatchGetNode(ctx, dataKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashRing.locks != 1 || hashRing.addNodeToDataKeys > len(nodeIDs) {
+		t.Errorf("got %d locks and %d data key registrations, want 1 and at most %d", hashRing.locks, hashRing.addNodeToDataKeys, len(nodeIDs))
+	}
+	if len(res) != len(dataKeys) {
+		t.Fatalf("got %d results, want %d", len(res), len(dataKeys))
+	}
+
+	// 每个数据 key 都登记在其选中的节点下，且与 GetNodeReadOnly 的结果一致
+	recorded := recordedDataKeys(t, consistentHash, nodeIDs...)
+	for _, dataKey := range dataKeys {
+		node, err := consistentHash.GetNodeReadOnly(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res[dataKey] != node || recorded[dataKey] != node {
+			t.Errorf("data %s: got %s, recorded under %s, want %s", dataKey, res[dataKey], recorded[dataKey], node)
+		}
+	}
+}
+
+func Benchmark_GetNode_Loop(b *testing.B) {
+	ctx := context.Background()
+	consistentHash := newBenchmarkConsistentHash(b)
+	hashRing := &countingHashRing{HashRing: consistentHash.hashRing}
+	consistentHash.hashRing = hashRing
+	dataKeys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		dataKeys = append(dataKeys, fmt.Sprintf("data_%d", i))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, dataKey := range dataKeys {
+			if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(hashRing.locks)/float64(b.N), "locks/op")
+	b.ReportMetric(float64(hashRing.addNodeToDataKeys)/float64(b.N), "registrations/op")
+}
+
+func Benchmark_BatchGetNode(b *testing.B) {
+	ctx := context.Background()
+	consistentHash := newBenchmarkConsistentHash(b)
+	hashRing := &countingHashRing{HashRing: consistentHash.hashRing}
+	consistentHash.hashRing = hashRing
+	dataKeys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		dataKeys = append(dataKeys, fmt.Sprintf("data_%d", i))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := consistentHash.BatchGetNode(ctx, dataKeys); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(hashRing.locks)/float64(b.N), "locks/op")
+	b.ReportMetric(float64(hashRing.addNodeToDataKeys)/float64(b.N), "registrations/op")
+}
+
+func Test_AddNode_BatchAdd(t *testing.T) {
+	ctx := context.Background()
+	// memory.HashRing 实现了 BatchAdder，countingHashRing 只暴露 HashRing 接口，因此会逐个添加虚拟节点
+	batch := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil, WithRingSize(1000))
+	sequential := NewConsistentHash(&countingHashRing{HashRing: memory.NewHashRing()}, NewMurmurHasher(), nil, WithRingSize(1000))
+	for _, consistentHash := range []*ConsistentHash{batch, sequential} {
+		for nodeID, weight := range map[string]int{"node_a": 3, "node_b": 10, "node_c": 1} {
+			if err := consistentHash.AddNode(ctx, nodeID, weight); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	batchScores, sequentialScores := ringScores(t, batch), ringScores(t, sequential)
+	if fmt.Sprint(batchScores) != fmt.Sprint(sequentialScores) {
+		t.Fatalf("got scores %v with batch add, want %v", batchScores, sequentialScores)
+	}
+	for _, score := range batchScores {
+		batchNodes, err := batch.hashRing.Node(ctx, score)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sequentialNodes, err := sequential.hashRing.Node(ctx, score)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batchNodes) != len(sequentialNodes) {
+			t.Errorf("score %d: got nodes %v with batch add, want %v", score, batchNodes, sequentialNodes)
+		}
+	}
+}
+
+func Test_AddNodeWithReplicas(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+
+	if err := consistentHash.AddNodeWithReplicas(ctx, "node_a", 0); err == nil {
+		t.Error("add node with zero replicas should fail")
+	}
+
+	// 虚拟节点个数不受权重上限 10 * replicas 的限制
+	if err := consistentHash.AddNodeWithReplicas(ctx, "node_a", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := consistentHash.AddNodeWithReplicas(ctx, "node_b", 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := consistentHash.AddNodeWithReplicas(ctx, "node_b", 1); err == nil {
+		t.Error("add repeat node should fail")
+	}
+
+	nodes, err := consistentHash.hashRing.Nodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes["node_a"] != 10 || nodes["node_b"] != 200 {
+		t.Errorf("got replicas %v, want node_a=10 node_b=200", nodes)
+	}
+	if scores := ringScores(t, consistentHash); len(scores) != 210 {
+		t.Errorf("got %d virtual nodes, want 210", len(scores))
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		nodeID, err := consistentHash.GetNodeReadOnly(ctx, fmt.Sprintf("data_%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[nodeID]++
+	}
+	if counts["node_b"] <= counts["node_a"]*3 {
+		t.Errorf("got distribution %v, node_b should take the vast majority of keys", counts)
+	}
+}
+
+func Test_AddNode_RemoveNode_MigratorError(t *testing.T) {
+	ctx := context.Background()
+	const failedKey = "data_7"
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		if _, ok := dataKeys[failedKey]; ok {
+			return fmt.Errorf("migrate %s failed", failedKey)
+		}
+		return nil
+	}
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator)
+
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 仅当 failedKey 需要迁移时迁移函数才会失败，因此只断言失败的迁移任务被准确上报
+	// 失败后同批次尚未执行的任务会以 context.Canceled 上报，不计入失败的任务
+	assertMigrateErr := func(err error) {
+		t.Helper()
+		if err == nil {
+			return
+		}
+		var migrateErrs MigrateErrors
+		if !errors.As(err, &migrateErrs) {
+			t.Fatalf("got err %v, want migrate errors", err)
+		}
+		var failed []error
+		for _, migrateErr := range migrateErrs {
+			if !errors.Is(migrateErr, context.Canceled) {
+				failed = append(failed, migrateErr)
+			}
+		}
+		if len(failed) != 1 {
+			t.Fatalf("got err %v, want exactly one failed migration", err)
+		}
+		if !strings.Contains(failed[0].Error(), failedKey) {
+			t.Errorf("migrate error %q should identify %s", failed[0], failedKey)
+		}
+	}
+
+	owner, err := consistentHash.GetNodeReadOnly(ctx, failedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nodeID := range []string{"node_b", "node_c", "node_d"} {
+		err := consistentHash.AddNode(ctx, nodeID, 1)
+		assertMigrateErr(err)
+		newOwner, _ := consistentHash.GetNodeReadOnly(ctx, failedKey)
+		if (newOwner != owner) != (err != nil) {
+			t.Errorf("owner of %s changed from %s to %s, got err %v", failedKey, owner, newOwner, err)
+		}
+		owner = newOwner
+	}
+
+	// 迁移失败不影响哈希环拓扑的变更
+	nodes, err := consistentHash.hashRing.Nodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 4 {
+		t.Errorf("got nodes %v, want 4 nodes", nodes)
+	}
+
+	err = consistentHash.RemoveNode(ctx, owner)
+	assertMigrateErr(err)
+	if err == nil {
+		t.Errorf("remove owner %s of %s should return the migrate error", owner, failedKey)
+	}
+	if nodes, _ = consistentHash.hashRing.Nodes(ctx); len(nodes) != 3 {
+		t.Errorf("got nodes %v, want 3 nodes after remove", nodes)
+	}
+}
+
+// 在添加指定个数的虚拟节点后取消 ctx，用于模拟请求中途被取消
+type cancelingHashRing struct {
+	HashRing
+	cancel context.CancelFunc
+	after  int
+	adds   int
+}
+
+func (r *cancelingHashRing) Add(ctx context.Context, score int64, nodeID string) error {
+	r.adds++
+	if r.adds == r.after {
+		r.cancel()
+	}
+	return r.HashRing.Add(ctx, score, nodeID)
+}
+
+func Test_AddNode_ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hashRing := &cancelingHashRing{HashRing: memory.NewHashRing(), cancel: cancel, after: 3}
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil)
+
+	err := consistentHash.AddNode(ctx, "node_a", 2)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got err %v, want context.Canceled", err)
+	}
+	if hashRing.adds != 3 {
+		t.Errorf("got %d virtual nodes added, want loop to stop after 3", hashRing.adds)
+	}
+
+	// 取消后哈希环的锁需要已经释放，后续请求可以正常执行
+	if err = consistentHash.AddNode(context.Background(), "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = consistentHash.RemoveNode(ctx, "node_a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got err %v, want context.Canceled", err)
+	}
+}
+
+func Test_UpdateNodeWeight_ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hashRing := &cancelingHashRing{HashRing: memory.NewHashRing(), cancel: func() {}}
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil)
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 调整权重的过程中取消，剩余的虚拟节点不再添加
+	hashRing.adds, hashRing.after, hashRing.cancel = 0, 2, cancel
+	if err := consistentHash.UpdateNodeWeight(ctx, "node_a", 3); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got err %v, want context.Canceled", err)
+	}
+	if hashRing.adds != 2 {
+		t.Errorf("got %d virtual nodes added, want loop to stop after 2", hashRing.adds)
+	}
+
+	// 取消后哈希环的锁需要已经释放
+	if err := consistentHash.AddNode(context.Background(), "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_WithLocking_Disabled(t *testing.T) {
+	ctx := context.Background()
+	hashRing := &countingHashRing{HashRing: memory.NewHashRing()}
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, WithLocking(false))
+
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if hashRing.locks != 0 {
+		t.Errorf("got %d locks with locking disabled, want 0", hashRing.locks)
+	}
+
+	// 默认启用全局锁
+	consistentHash = NewConsistentHash(hashRing, NewMurmurHasher(), migrator)
+	if _, err := consistentHash.GetNode(ctx, "data_0"); err != nil {
+		t.Fatal(err)
+	}
+	if hashRing.locks != 1 {
+		t.Errorf("got %d locks with locking enabled by default, want 1", hashRing.locks)
+	}
+}
+
+func Test_WithNodeKeyFormatter(t *testing.T) {
+	ctx := context.Background()
+	formatters := map[string]NodeKeyFormatter{
+		"dash": func(nodeID string, index int) string {
+			return fmt.Sprintf("%s-%d", nodeID, index)
+		},
+		"hash": func(nodeID string, index int) string {
+			return fmt.Sprintf("%s#%d", nodeID, index)
+		},
+	}
+
+	for name, formatter := range formatters {
+		migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+			return nil
+		}
+		consistentHash := NewConsistentHash(memory.NewHashRing(), NewCRC32Hasher(), migrator,
+			WithNodeKeyFormatter(formatter), WithReplicas(3))
+		nodeIDs := []string{"cache-1", "cache_2"}
+		for _, nodeID := range nodeIDs {
+			if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 虚拟节点的位置由 formatter 生成的 key 决定
+		want := make(map[int64]struct{})
+		for _, nodeID := range nodeIDs {
+			for i := 0; i < 3; i++ {
+				want[int64(crc32.ChecksumIEEE([]byte(formatter(nodeID, i))))] = struct{}{}
+			}
+		}
+		scores := ringScores(t, consistentHash)
+		if len(scores) != len(want) {
+			t.Errorf("%s: got %d virtual nodes, want %d", name, len(scores), len(want))
+		}
+		for _, score := range scores {
+			if _, ok := want[score]; !ok {
+				t.Errorf("%s: unexpected virtual node score %d", name, score)
+			}
+		}
+
+		// 无论 formatter 的格式如何，都能还原出真实节点 id
+		for i := 0; i < 50; i++ {
+			nodeID, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nodeID != "cache-1" && nodeID != "cache_2" {
+				t.Errorf("%s: got node %q, want a physical node id", name, nodeID)
+			}
+		}
+
+		// 删除节点时需要按照相同的 formatter 找到其虚拟节点
+		if err := consistentHash.RemoveNode(ctx, "cache-1"); err != nil {
+			t.Fatal(err)
+		}
+		for _, score := range ringScores(t, consistentHash) {
+			nodes, err := consistentHash.hashRing.Node(ctx, score)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(nodes) != 1 || consistentHash.getNodeID(nodes[0]) != "cache_2" {
+				t.Errorf("%s: got nodes %v at score %d after remove, want only cache_2", name, nodes, score)
+			}
+		}
+	}
+}
+
+// 仅暴露 HashRing 接口，用于验证未实现 NodeChecker 时的退化逻辑
+type plainHashRing struct {
+	HashRing
+}
+
+func Test_NodeExists(t *testing.T) {
+	ctx := context.Background()
+	for name, hashRing := range map[string]HashRing{
+		"node checker": memory.NewHashRing(),
+		"fallback":     plainHashRing{HashRing: memory.NewHashRing()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil)
+			if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+				t.Fatal(err)
+			}
+			for nodeID, want := range map[string]bool{"node_a": true, "node_b": false} {
+				exists, err := consistentHash.NodeExists(ctx, nodeID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if exists != want {
+					t.Errorf("node %s: got exists %t, want %t", nodeID, exists, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_RemoveNode_LastNode(t *testing.T) {
+	ctx := context.Background()
+	dataKeys := []string{"data_a", "data_b", "data_c"}
+
+	newConsistentHash := func(migrator Migrator, opts ...ConsistentHashOption) *ConsistentHash {
+		consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, opts...)
+		if err := consistentHash.AddNode(ctx, "node_a", 2); err != nil {
+			t.Fatal(err)
+		}
+		for _, dataKey := range dataKeys {
+			if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return consistentHash
+	}
+
+	var (
+		migrated map[string]struct{}
+		from, to = "", "unset"
+	)
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, _from, _to string) error {
+		migrated, from, to = dataKeys, _from, _to
+		return nil
+	}
+
+	if err := newConsistentHash(migrator).RemoveNode(ctx, "node_a"); err == nil {
+		t.Error("remove last node should fail by default")
+	}
+
+	consistentHash := newConsistentHash(migrator, WithAllowRemoveLastNode())
+	if err := consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if from != "node_a" || to != "" || len(migrated) != len(dataKeys) {
+		t.Errorf("got migration %v from %q to %q, want all data keys from node_a to empty", migrated, from, to)
+	}
+
+	nodes, err := consistentHash.hashRing.Nodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 0 {
+		t.Errorf("got nodes %v, want empty", nodes)
+	}
+	if scores := ringScores(t, consistentHash); len(scores) != 0 {
+		t.Errorf("got virtual nodes %v, want empty", scores)
+	}
+	remained, err := consistentHash.hashRing.DataKeys(ctx, "node_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remained) != 0 {
+		t.Errorf("got data keys %v, want empty", remained)
+	}
+
+	// 拆除后的哈希环可以重新使用
+	if err = consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if node, err := consistentHash.GetNode(ctx, "data_a"); err != nil || node != "node_b" {
+		t.Errorf("got (%s, %v), want node_b", node, err)
+	}
+}
+
+func Test_Generation(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	assertGeneration := func(want int64) {
+		t.Helper()
+		generation, err := consistentHash.Generation(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if generation != want {
+			t.Errorf("got generation %d, want %d", generation, want)
+		}
+	}
+
+	assertGeneration(0)
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	assertGeneration(1)
+	if err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	assertGeneration(2)
+
+	for i := 0; i < 5; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertGeneration(2)
+
+	// 重复添加失败时拓扑没有变化
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err == nil {
+		t.Fatal("add repeat node should fail")
+	}
+	assertGeneration(2)
+
+	if err := consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	assertGeneration(3)
+
+	// 未实现 GenerationCounter 的哈希环返回错误
+	plain := NewConsistentHash(plainHashRing{HashRing: memory.NewHashRing()}, NewMurmurHasher(), nil)
+	if _, err := plain.Generation(ctx); err == nil {
+		t.Error("generation should fail when hash ring does not support it")
+	}
+}
+
+func Test_WithDataKeyTracking_Disabled(t *testing.T) {
+	ctx := context.Background()
+	var migrations int
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		migrations++
+		return nil
+	}
+	hashRing := &countingHashRing{HashRing: memory.NewHashRing()}
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, WithDataKeyTracking(false))
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	dataKeys := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+			t.Fatal(err)
+		}
+		dataKeys = append(dataKeys, dataKey)
+	}
+	if _, err := consistentHash.BatchGetNode(ctx, dataKeys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consistentHash.GetNodes(ctx, "data_0", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if hashRing.addNodeToDataKeys != 0 {
+		t.Errorf("got %d data key registrations, want 0", hashRing.addNodeToDataKeys)
+	}
+	if migrations != 0 {
+		t.Errorf("got %d migrations, want 0", migrations)
+	}
+	if recorded := recordedDataKeys(t, consistentHash, "node_a", "node_b"); len(recorded) != 0 {
+		t.Errorf("got recorded data keys %v, want empty", recorded)
+	}
+}
+
+func Test_AddDataKey_RemoveDataKey(t *testing.T) {
+	ctx := context.Background()
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, WithDataKeyCache(10))
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if nodeID, err := consistentHash.AddDataKey(ctx, fmt.Sprintf("data_%d", i)); err != nil || nodeID != "node_a" {
+			t.Fatalf("got (%s, %v), want node_a", nodeID, err)
+		}
+	}
+	for i := 0; i < 100; i += 2 {
+		if err := consistentHash.RemoveDataKey(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dataKeys, err := consistentHash.hashRing.DataKeys(ctx, "node_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dataKeys) != 50 {
+		t.Errorf("got %d data keys, want 50", len(dataKeys))
+	}
+	if _, ok := dataKeys["data_0"]; ok {
+		t.Error("data_0 should be removed")
+	}
+
+	// 已删除的数据 key 不再参与数据迁移
+	if err = consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range migrations() {
+		for i := 0; i < 100; i += 2 {
+			if _, ok := migration.DataKeys[fmt.Sprintf("data_%d", i)]; ok {
+				t.Errorf("removed data_%d should not be migrated", i)
+			}
+		}
+	}
+	if recorded := recordedDataKeys(t, consistentHash, "node_a", "node_b"); len(recorded) != 50 {
+		t.Errorf("got %d recorded data keys, want 50", len(recorded))
+	}
+
+	// 删除后重新登记不受缓存影响
+	if _, err = consistentHash.AddDataKey(ctx, "data_1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = consistentHash.RemoveDataKey(ctx, "data_1"); err != nil {
+		t.Fatal(err)
+	}
+	nodeID, err := consistentHash.AddDataKey(ctx, "data_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataKeys, err = consistentHash.hashRing.DataKeys(ctx, nodeID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dataKeys["data_1"]; !ok {
+		t.Errorf("data_1 should be registered under %s again", nodeID)
+	}
+}
+
+func Test_WithReplicas(t *testing.T) {
+	ctx := context.Background()
+	if replicas := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil).Replicas(); replicas != 5 {
+		t.Errorf("got default replicas %d, want 5", replicas)
+	}
+	if replicas := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil, WithReplicas(3)).Replicas(); replicas != 3 {
+		t.Errorf("got replicas %d, want 3", replicas)
+	}
+
+	// 显式配置为 0 时属于配置错误，不能创建出没有虚拟节点的真实节点
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil, WithReplicas(0))
+	if err := consistentHash.AddNode(ctx, "node_a", 1); !errors.Is(err, ErrInvalidReplicas) {
+		t.Fatalf("add node with zero replicas: got %v, want ErrInvalidReplicas", err)
+	}
+	if exists, err := consistentHash.NodeExists(ctx, "node_a"); err != nil || exists {
+		t.Errorf("node_a should not be added, got (%v, %v)", exists, err)
+	}
+	if err := consistentHash.AddNodeWithReplicas(ctx, "node_a", 0); !errors.Is(err, ErrInvalidReplicas) {
+		t.Errorf("add node with zero explicit replicas: got %v, want ErrInvalidReplicas", err)
+	}
+}
+
+func Test_NodeForScore(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	if _, _, err := consistentHash.NodeForScore(ctx, 0); err == nil {
+		t.Error("node for score on empty ring should return error")
+	}
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scores := make(map[int64]struct{})
+	for _, score := range ringScores(t, consistentHash) {
+		scores[score] = struct{}{}
+	}
+	for i := 0; i < 20; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		dataScore := consistentHash.getScore(dataKey)
+		nodeID, ceilingScore, err := consistentHash.NodeForScore(ctx, dataScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := scores[ceilingScore]; !ok {
+			t.Errorf("ceiling score %d of %s is not a virtual node", ceilingScore, dataKey)
+		}
+
+		node, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodeID != node {
+			t.Errorf("got node %s for score %d, want %s", nodeID, dataScore, node)
+		}
+	}
+
+	// 按位置检索不会登记数据 key
+	if recorded := recordedDataKeys(t, consistentHash, "node_a", "node_b", "node_c"); len(recorded) != 20 {
+		t.Errorf("got %d recorded data keys, want 20", len(recorded))
+	}
+}
+
+// 将任意内容都映射到同一位置的散列器，用于构造虚拟节点位置冲突
+type constantEncryptor struct{}
+
+func (constantEncryptor) Encrypt(string) int64 {
+	return 100
+}
+
+func Test_WithCollisionSpreading(t *testing.T) {
+	ctx := context.Background()
+	newCollidingHash := func(opts ...ConsistentHashOption) *ConsistentHash {
+		consistentHash := NewConsistentHash(memory.NewHashRing(), constantEncryptor{}, nil, opts...)
+		for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+			if err := consistentHash.AddNodeWithReplicas(ctx, nodeID, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return consistentHash
+	}
+
+	// 默认固定选择列表的首个节点
+	pinned := newCollidingHash(WithDataKeyTracking(false))
+	spread := newCollidingHash(WithDataKeyTracking(false), WithCollisionSpreading())
+	// 开启数据 key 登记时同样生效
+	tracked := newCollidingHash(WithCollisionSpreading())
+
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		if nodeID, err := pinned.GetNode(ctx, dataKey); err != nil || nodeID != "node_a" {
+			t.Fatalf("got (%s, %v) for %s, want node_a", nodeID, err, dataKey)
+		}
+
+		nodeID, err := spread.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[nodeID]++
+		if trackedNodeID, err := tracked.GetNode(ctx, dataKey); err != nil || trackedNodeID != nodeID {
+			t.Errorf("got (%s, %v) for %s with data key tracking, want %s", trackedNodeID, err, dataKey, nodeID)
+		}
+
+		// 同一个数据 key 的结果保持稳定，且 GetNodes 的首个节点与 GetNode 一致
+		if again, _ := spread.GetNodeReadOnly(ctx, dataKey); again != nodeID {
+			t.Errorf("unstable choice for %s: %s then %s", dataKey, nodeID, again)
+		}
+		nodes, err := spread.GetNodes(ctx, dataKey, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 3 || nodes[0] != nodeID {
+			t.Errorf("got nodes %v for %s, want %s first", nodes, dataKey, nodeID)
+		}
+	}
+
+	if len(counts) != 3 {
+		t.Errorf("data keys should spread across all colliding nodes, got %v", counts)
+	}
+	for nodeID, count := range counts {
+		dataKeys, err := tracked.hashRing.DataKeys(ctx, nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dataKeys) != count {
+			t.Errorf("got %d data keys recorded on %s, want %d", len(dataKeys), nodeID, count)
+		}
+	}
+}
+
+// 开启冲突分散以及数据 key 登记时，节点变更之后每个数据 key 仍然登记在其归属节点上
+func Test_WithCollisionSpreading_Migration(t *testing.T) {
+	ctx := context.Background()
+	migrator, migrations := NewRecordingMigrator()
+	// 哈希环很短，虚拟节点之间大量冲突
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator,
+		WithRingSize(16), WithReplicas(2), WithCollisionSpreading())
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataKeys := make([]string, 0, 200)
+	for i := 0; i < 200; i++ {
+		dataKeys = append(dataKeys, fmt.Sprintf("data_%d", i))
+		if _, err := consistentHash.GetNode(ctx, dataKeys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertPlacement := func(step string) {
+		t.Helper()
+		for _, dataKey := range dataKeys {
+			recordedNode, correctNode, consistent, err := consistentHash.VerifyKeyPlacement(ctx, dataKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !consistent {
+				t.Fatalf("%s: %s recorded on %s, want %s", step, dataKey, recordedNode, correctNode)
+			}
+		}
+	}
+
+	steps := []struct {
+		name string
+		do   func() error
+	}{
+		{"add node_d", func() error { return consistentHash.AddNode(ctx, "node_d", 3) }},
+		{"add node_e and node_f", func() error { return consistentHash.AddNodes(ctx, map[string]int{"node_e": 1, "node_f": 2}) }},
+		{"increase weight of node_a", func() error { return consistentHash.UpdateNodeWeight(ctx, "node_a", 5) }},
+		{"decrease weight of node_d", func() error { return consistentHash.UpdateNodeWeight(ctx, "node_d", 1) }},
+		{"remove node_b", func() error { return consistentHash.RemoveNode(ctx, "node_b") }},
+	}
+	for _, step := range steps {
+		if err := step.do(); err != nil {
+			t.Fatal(err)
+		}
+		assertPlacement(step.name)
+	}
+
+	if len(migrations()) == 0 {
+		t.Error("expect some data keys to be migrated")
+	}
+
+	// 预估结果与实际迁移到新节点的数据 key 一致
+	moved, err := consistentHash.SimulateAddNode(ctx, "node_x", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := len(migrations())
+	if err = consistentHash.AddNode(ctx, "node_x", 2); err != nil {
+		t.Fatal(err)
+	}
+	assertPlacement("add node_x")
+	actual := make(map[string]struct{})
+	for _, migration := range migrations()[before:] {
+		if migration.To != "node_x" {
+			t.Errorf("got migration from %s to %s, want to node_x", migration.From, migration.To)
+		}
+		for dataKey := range migration.DataKeys {
+			actual[dataKey] = struct{}{}
+		}
+	}
+	if !reflect.DeepEqual(moved, actual) {
+		t.Errorf("simulated %d moved data keys, got %d", len(moved), len(actual))
+	}
+}
+
+func Test_ScanDataKeys(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 95; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make(map[string]struct{})
+	var (
+		cursor uint64
+		calls  int
+	)
+	for {
+		keys, next, err := consistentHash.ScanDataKeys(ctx, "node_a", cursor, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) > 10 {
+			t.Fatalf("got %d keys in one page, want at most 10", len(keys))
+		}
+		for _, key := range keys {
+			got[key] = struct{}{}
+		}
+		calls++
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(got) != 95 || calls != 10 {
+		t.Errorf("got %d keys in %d calls, want 95 keys in 10 calls", len(got), calls)
+	}
+
+	if keys, next, err := consistentHash.ScanDataKeys(ctx, "node_x", 0, 0); err != nil || len(keys) != 0 || next != 0 {
+		t.Errorf("scan missing node: got (%v, %d, %v), want empty", keys, next, err)
+	}
+}
+
+type closeableHashRing struct {
+	HashRing
+	closed int
+}
+
+func (c *closeableHashRing) Close() error {
+	c.closed++
+	return nil
+}
+
+func Test_Close(t *testing.T) {
+	ring := &closeableHashRing{HashRing: memory.NewHashRing()}
+	if err := NewConsistentHash(ring, NewMurmurHasher(), nil).Close(); err != nil || ring.closed != 1 {
+		t.Errorf("got (closed %d, %v), want ring closed once", ring.closed, err)
+	}
+
+	// 哈希环未实现 Closeable 时直接返回
+	if err := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil).Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+type pingHashRing struct {
+	HashRing
+	err error
+}
+
+func (p *pingHashRing) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func Test_Ping(t *testing.T) {
+	ctx := context.Background()
+	if err := NewConsistentHash(&pingHashRing{HashRing: memory.NewHashRing()}, NewMurmurHasher(), nil).Ping(ctx); err != nil {
+		t.Errorf("ping healthy ring: %v", err)
+	}
+
+	unreachable := errors.New("unreachable")
+	if err := NewConsistentHash(&pingHashRing{HashRing: memory.NewHashRing(), err: unreachable}, NewMurmurHasher(), nil).Ping(ctx); !errors.Is(err, unreachable) {
+		t.Errorf("ping unreachable ring: got %v, want %v", err, unreachable)
+	}
+
+	// 哈希环未实现 Pinger 时视为可达
+	if err := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil).Ping(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_GetNodeDetailed(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	if _, _, _, err := consistentHash.GetNodeDetailed(ctx, "data"); err == nil {
+		t.Error("get node detailed on empty ring should return error")
+	}
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scores := ringScores(t, consistentHash)
+	for i := 0; i < 50; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		nodeID, dataScore, ceilingScore, err := consistentHash.GetNodeDetailed(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dataScore != consistentHash.getScore(dataKey) {
+			t.Errorf("%s: got data score %d, want %d", dataKey, dataScore, consistentHash.getScore(dataKey))
+		}
+
+		// 选中的虚拟节点是顺时针方向上第一个不小于 dataScore 的节点，不存在时回绕到最小的节点
+		want := scores[0]
+		for _, score := range scores {
+			if score >= dataScore {
+				want = score
+				break
+			}
+		}
+		if ceilingScore != want {
+			t.Errorf("%s: got ceiling score %d, want %d", dataKey, ceilingScore, want)
+		}
+
+		if readOnly, err := consistentHash.GetNodeReadOnly(ctx, dataKey); err != nil || readOnly != nodeID {
+			t.Errorf("%s: got node %s, GetNodeReadOnly returned (%s, %v)", dataKey, nodeID, readOnly, err)
+		}
+	}
+}
+
+func Test_SentinelErrors(t *testing.T) {
+	ctx := context.Background()
+	migrator, _ := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator)
+
+	// 空环
+	_, err := consistentHash.GetNode(ctx, "data")
+	if !errors.Is(err, ErrRingEmpty) {
+		t.Errorf("get node on empty ring: got %v, want ErrRingEmpty", err)
+	}
+	if _, err = consistentHash.GetNodes(ctx, "data", 2); !errors.Is(err, ErrRingEmpty) {
+		t.Errorf("get nodes on empty ring: got %v, want ErrRingEmpty", err)
+	}
+
+	if err = consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = consistentHash.GetNode(ctx, "data"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		do   func() error
+		want error
+	}{
+		{name: "add existing node", do: func() error { return consistentHash.AddNode(ctx, "node_a", 1) }, want: ErrNodeExists},
+		{name: "add nodes with existing node", do: func() error {
+			return consistentHash.AddNodes(ctx, map[string]int{"node_a": 1, "node_b": 1})
+		}, want: ErrNodeExists},
+		{name: "simulate existing node", do: func() error {
+			_, err := consistentHash.SimulateAddNode(ctx, "node_a", 1)
+			return err
+		}, want: ErrNodeExists},
+		{name: "remove missing node", do: func() error { return consistentHash.RemoveNode(ctx, "node_x") }, want: ErrNodeNotFound},
+		{name: "update missing node", do: func() error { return consistentHash.UpdateNodeWeight(ctx, "node_x", 2) }, want: ErrNodeNotFound},
+		{name: "park missing node", do: func() error {
+			_, err := consistentHash.RemoveNodeParkData(ctx, "node_x")
+			return err
+		}, want: ErrNodeNotFound},
+		// 未开启 WithAllowRemoveLastNode 时，最后一个节点的数据没有可以托付的节点
+		{name: "remove last node", do: func() error { return consistentHash.RemoveNode(ctx, "node_a") }, want: ErrNoNodeAvailable},
+	}
+	for _, c := range cases {
+		if err := c.do(); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func Test_GetNode_EmptyRingAndMissingVirtualNodes(t *testing.T) {
+	ctx := context.Background()
+	ring := memory.NewHashRing()
+	consistentHash := NewConsistentHash(ring, NewMurmurHasher(), nil)
+
+	if _, err := consistentHash.GetNode(ctx, "data"); !errors.Is(err, ErrRingEmpty) || errors.Is(err, ErrNoNodeAvailable) {
+		t.Errorf("empty ring: got %v, want ErrRingEmpty", err)
+	}
+
+	// 真实节点已经登记，但是虚拟节点缺失
+	if err := ring.AddNodeToReplica(ctx, "node_a", 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consistentHash.GetNode(ctx, "data"); !errors.Is(err, ErrNoNodeAvailable) || errors.Is(err, ErrRingEmpty) {
+		t.Errorf("ring missing virtual nodes: got %v, want ErrNoNodeAvailable", err)
+	}
+	if _, err := consistentHash.GetNodes(ctx, "data", 2); !errors.Is(err, ErrNoNodeAvailable) {
+		t.Errorf("get nodes on ring missing virtual nodes: got %v, want ErrNoNodeAvailable", err)
+	}
+}
+
+// 按照预设的映射散列的散列器，未预设的内容映射到 0
+type tableEncryptor map[string]int64
+
+func (t tableEncryptor) Encrypt(origin string) int64 {
+	return t[origin]
+}
+
+func Test_NodesInRange(t *testing.T) {
+	ctx := context.Background()
+	// 虚拟节点 key 即为真实节点 id，三个节点分别位于 100、200、300
+	consistentHash := NewConsistentHash(memory.NewHashRing(),
+		tableEncryptor{"node_a": 100, "node_b": 200, "node_c": 300}, nil,
+		WithRingSize(1000), WithNodeKeyFormatter(func(nodeID string, index int) string { return nodeID }))
+	if _, err := consistentHash.NodesInRange(ctx, 0, 10); !errors.Is(err, ErrRingEmpty) {
+		t.Errorf("empty ring: got %v, want ErrRingEmpty", err)
+	}
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNodeWithReplicas(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		from, to int64
+		want     []string
+	}{
+		{from: 150, to: 250, want: []string{"node_b", "node_c"}},
+		{from: 100, to: 100, want: []string{"node_a"}},
+		{from: 101, to: 200, want: []string{"node_b"}},
+		{from: 0, to: 999, want: []string{"node_a", "node_b", "node_c"}},
+		// 区间跨过环的终点
+		{from: 310, to: 320, want: []string{"node_a"}},
+		{from: 350, to: 50, want: []string{"node_a"}},
+		{from: 250, to: 150, want: []string{"node_c", "node_a", "node_b"}},
+		{from: 301, to: 300, want: []string{"node_a", "node_b", "node_c"}},
+	}
+	for _, c := range cases {
+		got, err := consistentHash.NodesInRange(ctx, c.from, c.to)
+		if err != nil {
+			t.Fatalf("range [%d, %d]: %v", c.from, c.to, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("range [%d, %d]: got %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+
+	if _, err := consistentHash.NodesInRange(ctx, -1, 1000); err == nil {
+		t.Error("out of ring range should fail")
+	}
+}
+
+func Test_NodeMeta(t *testing.T) {
+	ctx := context.Background()
+	ring := memory.NewHashRing()
+	consistentHash := NewConsistentHash(ring, NewMurmurHasher(), nil)
+
+	meta := map[string]string{"datacenter": "dc1", "rack": "r1"}
+	if err := consistentHash.AddNodeWithMeta(ctx, "node_a", 1, meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := consistentHash.NodeMeta(ctx, "node_a"); err != nil || !reflect.DeepEqual(got, meta) {
+		t.Errorf("got meta (%v, %v), want %v", got, err, meta)
+	}
+	if got, err := consistentHash.NodeMeta(ctx, "node_b"); err != nil || len(got) != 0 {
+		t.Errorf("node without meta: got (%v, %v), want empty", got, err)
+	}
+
+	// 重复添加不覆盖原有的元数据
+	if err := consistentHash.AddNodeWithMeta(ctx, "node_a", 1, map[string]string{"rack": "r2"}); !errors.Is(err, ErrNodeExists) {
+		t.Errorf("re-add: got %v, want ErrNodeExists", err)
+	}
+	if got, err := consistentHash.NodeMeta(ctx, "node_a"); err != nil || !reflect.DeepEqual(got, meta) {
+		t.Errorf("got meta (%v, %v) after re-add, want %v", got, err, meta)
+	}
+
+	if err := consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consistentHash.NodeMeta(ctx, "node_a"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("removed node: got %v, want ErrNodeNotFound", err)
+	}
+	if got, err := ring.NodeMeta(ctx, "node_a"); err != nil || len(got) != 0 {
+		t.Errorf("got stored meta (%v, %v) after removal, want empty", got, err)
+	}
+
+	if err := NewConsistentHash(plainHashRing{memory.NewHashRing()}, NewMurmurHasher(), nil).AddNodeWithMeta(ctx, "node_a", 1, meta); err == nil {
+		t.Error("ring without NodeMetaStore should fail")
+	}
+}
+
+func Test_GetNodesZoneAware(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	zones := map[string]string{"node_a": "zone_1", "node_b": "zone_1", "node_c": "zone_2", "node_d": "zone_2"}
+	for nodeID, zone := range zones {
+		if err := consistentHash.AddNodeWithMeta(ctx, nodeID, 2, map[string]string{"zone": zone}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		want, err := consistentHash.GetNodeReadOnly(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nodes, err := consistentHash.GetNodesZoneAware(ctx, dataKey, 2, "zone")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 2 || nodes[0] != want {
+			t.Fatalf("%s: got nodes %v, want 2 nodes starting with %s", dataKey, nodes, want)
+		}
+		if zones[nodes[0]] == zones[nodes[1]] {
+			t.Errorf("%s: replicas %v placed in the same zone", dataKey, nodes)
+		}
+
+		// 故障域不足时使用同一故障域的节点补齐
+		nodes, err = consistentHash.GetNodesZoneAware(ctx, dataKey, 5, "zone")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 4 || zones[nodes[0]] == zones[nodes[1]] {
+			t.Errorf("%s: got nodes %v, want all 4 nodes with the first two in different zones", dataKey, nodes)
+		}
+	}
+
+	if _, err := NewConsistentHash(plainHashRing{memory.NewHashRing()}, NewMurmurHasher(), nil).GetNodesZoneAware(ctx, "data", 2, "zone"); err == nil {
+		t.Error("ring without NodeMetaStore should fail")
+	}
+}
+
+func Test_WithBoundedLoad(t *testing.T) {
+	ctx := context.Background()
+	// 所有数据 key 的哈希值都是 0，默认模式下全部落在 node_a 上
+	newConsistentHash := func(migrator Migrator, opts ...ConsistentHashOption) *ConsistentHash {
+		opts = append(opts, WithRingSize(1000), WithNodeKeyFormatter(func(nodeID string, index int) string { return nodeID }))
+		consistentHash := NewConsistentHash(memory.NewHashRing(),
+			tableEncryptor{"node_a": 100, "node_b": 200, "node_c": 300, "node_d": 400}, migrator, opts...)
+		for _, nodeID := range []string{"node_a", "node_b", "node_c", "node_d"} {
+			if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return consistentHash
+	}
+	loads := func(consistentHash *ConsistentHash) map[string]int {
+		res := make(map[string]int)
+		for _, nodeID := range []string{"node_a", "node_b", "node_c", "node_d"} {
+			dataKeys, err := consistentHash.hashRing.DataKeys(ctx, nodeID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res[nodeID] = len(dataKeys)
+		}
+		return res
+	}
+
+	const dataKeyCount = 20
+	capacity := int(math.Ceil(1.25 * dataKeyCount / 4))
+
+	unbounded := newConsistentHash(nil)
+	bounded := newConsistentHash(nil, WithBoundedLoad(1.25))
+	for i := 0; i < dataKeyCount; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		if _, err := unbounded.GetNode(ctx, dataKey); err != nil {
+			t.Fatal(err)
+		}
+		nodeID, err := bounded.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 重复检索返回首次分配的节点
+		if again, err := bounded.GetNode(ctx, dataKey); err != nil || again != nodeID {
+			t.Fatalf("%s: got (%s, %v) on repeated lookup, want %s", dataKey, again, err, nodeID)
+		}
+	}
+
+	if got := loads(unbounded)["node_a"]; got != dataKeyCount {
+		t.Errorf("default mode: node_a holds %d data keys, want %d", got, dataKeyCount)
+	}
+	for nodeID, load := range loads(bounded) {
+		if load > capacity {
+			t.Errorf("bounded mode: %s holds %d data keys, exceeds capacity %d", nodeID, load, capacity)
+		}
+	}
+
+	// 删除节点时，登记在其区间之外的数据 key 迁移到其在哈希环上的归属节点
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := newConsistentHash(migrator, WithBoundedLoad(1.25))
+	for i := 0; i < dataKeyCount; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := loads(consistentHash)
+	if err := consistentHash.RemoveNode(ctx, "node_c"); err != nil {
+		t.Fatal(err)
+	}
+	after := loads(consistentHash)
+	if after["node_c"] != 0 || after["node_a"] != before["node_a"]+before["node_c"] {
+		t.Errorf("remove node_c: loads %v -> %v, want node_c data keys moved to node_a", before, after)
+	}
+	var migrated int
+	for _, migration := range migrations() {
+		if migration.From != "node_c" || migration.To != "node_a" {
+			t.Errorf("unexpected migration %s -> %s", migration.From, migration.To)
+		}
+		migrated += len(migration.DataKeys)
+	}
+	if migrated != before["node_c"] {
+		t.Errorf("migrated %d data keys, want %d", migrated, before["node_c"])
+	}
+}
+
+// 哈希环实现了 DataKeyRecorder 与 RingCounter 时，有界负载模式下检索数据不拉取全量的数据 key
+func Test_WithBoundedLoad_DataKeyRecorder(t *testing.T) {
+	ctx := context.Background()
+	hashRing := memory.NewHashRing()
+	opts := []ConsistentHashOption{WithRingSize(1000), WithNodeKeyFormatter(func(nodeID string, index int) string { return nodeID })}
+	encryptor := tableEncryptor{"node_a": 100, "node_b": 200, "node_c": 300, "node_d": 400}
+	// 添加节点时需要拉取数据 key，因此直接基于内存哈希环完成
+	setup := NewConsistentHash(hashRing, encryptor, nil, opts...)
+	for _, nodeID := range []string{"node_a", "node_b", "node_c", "node_d"} {
+		if err := setup.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bounded := NewConsistentHash(dataKeyRecorderHashRing{HashRing: hashRing}, encryptor, nil, append(opts, WithBoundedLoad(1.25))...)
+
+	const dataKeyCount = 20
+	capacity := int(math.Ceil(1.25 * dataKeyCount / 4))
+	for i := 0; i < dataKeyCount; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		nodeID, err := bounded.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, err := bounded.GetNode(ctx, dataKey); err != nil || again != nodeID {
+			t.Fatalf("%s: got (%s, %v) on repeated lookup, want %s", dataKey, again, err, nodeID)
+		}
+	}
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_c", "node_d"} {
+		if load, err := hashRing.DataKeyCount(ctx, nodeID); err != nil || load > capacity {
+			t.Errorf("%s holds (%d, %v) data keys, exceeds capacity %d", nodeID, load, err, capacity)
+		}
+	}
+}
+
+func Test_WithCollisionRehash(t *testing.T) {
+	ctx := context.Background()
+	// node_a 与 node_b 的虚拟节点均位于 100，node_b 加盐之后位于 500；node_c 的两个虚拟节点均位于 700
+	encryptor := tableEncryptor{"node_a": 100, "node_b": 100, "node_b#1": 500, "node_c": 700, "data_x": 450}
+	newConsistentHash := func(migrator Migrator, opts ...ConsistentHashOption) *ConsistentHash {
+		opts = append(opts, WithRingSize(1000), WithNodeKeyFormatter(func(nodeID string, index int) string { return nodeID }))
+		consistentHash := NewConsistentHash(memory.NewHashRing(), encryptor, migrator, opts...)
+		if err := consistentHash.AddNodeWithReplicas(ctx, "node_a", 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := consistentHash.GetNode(ctx, "data_x"); err != nil {
+			t.Fatal(err)
+		}
+		return consistentHash
+	}
+	virtualNodes := func(consistentHash *ConsistentHash) map[int64][]string {
+		snapshot, err := consistentHash.Snapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := make(map[int64][]string)
+		for _, virtualNode := range snapshot.VirtualNodes {
+			res[virtualNode.Score] = virtualNode.NodeIDs
+		}
+		return res
+	}
+
+	// 默认追加到冲突位置的列表末尾，node_b 不承载任何数据
+	plain := newConsistentHash(nil)
+	if err := plain.AddNodeWithReplicas(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := virtualNodes(plain); !reflect.DeepEqual(got, map[int64][]string{100: {"node_a", "node_b"}}) {
+		t.Errorf("without rehash: got virtual nodes %v", got)
+	}
+
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := newConsistentHash(migrator, WithCollisionRehash())
+	if moved, err := consistentHash.SimulateAddNode(ctx, "node_b", 1); err != nil || !reflect.DeepEqual(moved, map[string]struct{}{"data_x": {}}) {
+		t.Errorf("simulate add node_b: got (%v, %v), want data_x", moved, err)
+	}
+	if err := consistentHash.AddNodeWithReplicas(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	// 同一真实节点的虚拟节点之间的冲突保留在原位置
+	if err := consistentHash.AddNodeWithReplicas(ctx, "node_c", 2); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64][]string{100: {"node_a"}, 500: {"node_b"}, 700: {"node_c", "node_c"}}
+	if got := virtualNodes(consistentHash); !reflect.DeepEqual(got, want) {
+		t.Errorf("with rehash: got virtual nodes %v, want %v", got, want)
+	}
+	if got := migrations(); !reflect.DeepEqual(got, []Migration{{From: "node_a", To: "node_b", DataKeys: map[string]struct{}{"data_x": {}}}}) {
+		t.Errorf("got migrations %+v, want data_x moved to node_b", got)
+	}
+	if nodeID, err := consistentHash.GetNode(ctx, "data_x"); err != nil || nodeID != "node_b" {
+		t.Errorf("data_x: got (%s, %v), want node_b", nodeID, err)
+	}
+	if inconsistencies, err := consistentHash.Validate(ctx); err != nil || len(inconsistencies) != 0 {
+		t.Errorf("validate: got (%v, %v), want consistent", inconsistencies, err)
+	}
+
+	// 删除节点时能够定位到加盐后的虚拟节点
+	if err := consistentHash.RemoveNode(ctx, "node_b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := virtualNodes(consistentHash); !reflect.DeepEqual(got, map[int64][]string{100: {"node_a"}, 700: {"node_c", "node_c"}}) {
+		t.Errorf("after removing node_b: got virtual nodes %v", got)
+	}
+	if nodeID, err := consistentHash.GetNode(ctx, "data_x"); err != nil || nodeID != "node_c" {
+		t.Errorf("data_x after removing node_b: got (%s, %v), want node_c", nodeID, err)
+	}
+}
+
+func Test_WithWeightScaler(t *testing.T) {
+	ctx := context.Background()
+	weights := map[string]int{"node_a": 1, "node_b": 1, "node_c": 1, "node_d": 10}
+	const dataKeyCount = 20000
+
+	variances := make(map[string]float64)
+	for _, tc := range []struct {
+		name     string
+		scaler   WeightScaler
+		replicas map[string]int
+	}{
+		{"default", nil, map[string]int{"node_a": 10, "node_d": 100}},
+		{"linear", LinearWeightScaler, map[string]int{"node_a": 10, "node_d": 100}},
+		{"sqrt", SqrtWeightScaler, map[string]int{"node_a": 10, "node_d": 32}},
+		{"log", LogWeightScaler, map[string]int{"node_a": 10, "node_d": 33}},
+	} {
+		opts := []ConsistentHashOption{WithReplicas(10), WithDataKeyTracking(false)}
+		if tc.scaler != nil {
+			opts = append(opts, WithWeightScaler(tc.scaler))
+		}
+		consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmur128Hasher(), nil, opts...)
+		for nodeID, weight := range weights {
+			if err := consistentHash.AddNode(ctx, nodeID, weight); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		nodes, err := consistentHash.hashRing.Nodes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for nodeID, want := range tc.replicas {
+			if nodes[nodeID] != want {
+				t.Errorf("%s: %s got %d virtual nodes, want %d", tc.name, nodeID, nodes[nodeID], want)
+			}
+		}
+
+		// 各节点实际承载的数据占比与按照虚拟节点个数计算的期望占比之间的方差
+		counts := make(map[string]int)
+		for i := 0; i < dataKeyCount; i++ {
+			nodeID, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts[nodeID]++
+		}
+		var total int
+		for _, replicas := range nodes {
+			total += replicas
+		}
+		var variance float64
+		for nodeID, replicas := range nodes {
+			diff := float64(counts[nodeID])/dataKeyCount - float64(replicas)/float64(total)
+			variance += diff * diff / float64(len(nodes))
+		}
+		t.Logf("%s: virtual nodes %v, data keys %v, variance %.6f", tc.name, nodes, counts, variance)
+		variances[tc.name] = variance
+	}
+
+	// 压缩权重之后低权重节点的虚拟节点占比升高，数据分布更贴近期望占比
+	for _, name := range []string{"sqrt", "log"} {
+		if variances[name] >= variances["linear"] {
+			t.Errorf("%s: variance %.6f, want lower than linear %.6f", name, variances[name], variances["linear"])
+		}
+	}
+}
+
+func Test_SeedNodeDataKeys(t *testing.T) {
+	ctx := context.Background()
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator)
+	for _, nodeID := range []string{"node_a", "node_b"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := consistentHash.SeedNodeDataKeys(ctx, "node_x", map[string]struct{}{"data_0": {}}); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("seed missing node: got %v, want ErrNodeNotFound", err)
+	}
+
+	// 按照哈希环上的归属为每个节点预置数据 key
+	seeds := map[string]map[string]struct{}{"node_a": {}, "node_b": {}}
+	dataKeys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		nodeID, err := consistentHash.GetNodeReadOnly(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeds[nodeID][dataKey] = struct{}{}
+		dataKeys = append(dataKeys, dataKey)
+	}
+	for nodeID, keys := range seeds {
+		if err := consistentHash.SeedNodeDataKeys(ctx, nodeID, keys); err != nil {
+			t.Fatal(err)
+		}
+		recorded, err := consistentHash.hashRing.DataKeys(ctx, nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(recorded, keys) {
+			t.Errorf("%s: got %d data keys, want %d seeded", nodeID, len(recorded), len(keys))
+		}
+	}
+
+	// 预置的数据 key 与 GetNode 登记的一样参与数据迁移
+	if err := consistentHash.AddNode(ctx, "node_c", 1); err != nil {
+		t.Fatal(err)
+	}
+	var migrated int
+	for _, migration := range migrations() {
+		if migration.To != "node_c" {
+			t.Errorf("migrated from %s to %s, want to node_c", migration.From, migration.To)
+		}
+		migrated += len(migration.DataKeys)
+	}
+	if migrated == 0 {
+		t.Error("seeded data keys should be migrated to node_c")
+	}
+	assertPlacement(t, consistentHash, []string{"node_a", "node_b", "node_c"}, dataKeys)
+}
+
+func Test_WithOptimisticGetNode(t *testing.T) {
+	ctx := context.Background()
+	migrator, _ := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, WithOptimisticGetNode())
+	nodeIDs := []string{"node_a", "node_b", "node_c", "node_d"}
+	for _, nodeID := range nodeIDs[:2] {
+		if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 其他调用方持有哈希环的锁时，乐观检索不需要排队等锁
+	if err := consistentHash.hashRing.Lock(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+	if _, err := consistentHash.GetNode(timeoutCtx, "data_locked"); err != nil {
+		t.Errorf("optimistic get node should not wait for the ring lock, err: %v", err)
+	}
+	cancel()
+	if err := consistentHash.hashRing.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 拓扑变更期间并发检索，最终每个数据 key 都只登记在其归属节点下
+	const (
+		workers       = 16
+		keysPerWorker = 200
+	)
+	var (
+		wg   sync.WaitGroup
+		stop = make(chan struct{})
+	)
+	dataKeys := []string{"data_locked"}
+	for w := 0; w < workers; w++ {
+		for i := 0; i < keysPerWorker; i++ {
+			dataKeys = append(dataKeys, fmt.Sprintf("data_%d_%d", w, i))
+		}
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for round := 0; ; round++ {
+				for i := 0; i < keysPerWorker; i++ {
+					if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d_%d", w, i)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		}(w)
+	}
+
+	for _, nodeID := range nodeIDs[2:] {
+		if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := consistentHash.UpdateNodeWeight(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	close(stop)
+	wg.Wait()
+
+	assertPlacement(t, consistentHash, nodeIDs, dataKeys)
+	if recorded := recordedDataKeys(t, consistentHash, nodeIDs...); len(recorded) != len(dataKeys) {
+		t.Errorf("got %d recorded data keys, want %d", len(recorded), len(dataKeys))
+	}
+}
+
+// 只允许通过 AddIfAbsent 添加虚拟节点，并记录未发生追加的次数
+type addIfAbsentHashRing struct {
+	*memory.HashRing
+	t       *testing.T
+	skipped int
+}
+
+func (r *addIfAbsentHashRing) Add(ctx context.Context, score int64, nodeID string) error {
+	r.t.Errorf("add %s at %d without AddIfAbsent", nodeID, score)
+	return r.HashRing.Add(ctx, score, nodeID)
+}
+
+func (r *addIfAbsentHashRing) AddIfAbsent(ctx context.Context, score int64, nodeID string) (bool, error) {
+	added, err := r.HashRing.AddIfAbsent(ctx, score, nodeID)
+	if err == nil && !added {
+		r.skipped++
+	}
+	return added, err
+}
+
+func Test_AddIfAbsent_Idempotent(t *testing.T) {
+	ctx := context.Background()
+	hashRing := &addIfAbsentHashRing{HashRing: memory.NewHashRing(), t: t}
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, WithReplicas(2))
+	for _, nodeID := range []string{"node_a", "node_b"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 模拟并发的调用方已经写入了 node_a 新增的虚拟节点，调整权重时不能重复追加，也不能再次迁移
+	for i := 2; i < 4; i++ {
+		if _, err := hashRing.HashRing.AddIfAbsent(ctx, consistentHash.getVirtualScore("node_a", i), consistentHash.getRawNodeKey("node_a", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := consistentHash.UpdateNodeWeight(ctx, "node_a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if hashRing.skipped != 2 {
+		t.Errorf("got %d skipped adds, want 2", hashRing.skipped)
+	}
+	for i := 0; i < 4; i++ {
+		rawNodeKeys, err := hashRing.Node(ctx, consistentHash.getVirtualScore("node_a", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		for _, rawNodeKey := range rawNodeKeys {
+			if rawNodeKey == consistentHash.getRawNodeKey("node_a", i) {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("virtual node %d of node_a appears %d times, want 1", i, count)
+		}
+	}
+	for _, migration := range migrations() {
+		t.Errorf("unexpected migration %+v for virtual nodes that already existed", migration)
+	}
+}
+
+// 记录 Ceiling、Node 分别由主存储与只读副本执行的次数，副本直接读取同一个哈希环
+type replicaHashRing struct {
+	*memory.HashRing
+	primaryReads int
+	replicaReads int
+}
+
+func (r *replicaHashRing) Ceiling(ctx context.Context, score int64) (int64, error) {
+	r.primaryReads++
+	return r.HashRing.Ceiling(ctx, score)
+}
+
+func (r *replicaHashRing) Node(ctx context.Context, score int64) ([]string, error) {
+	r.primaryReads++
+	return r.HashRing.Node(ctx, score)
+}
+
+func (r *replicaHashRing) ReplicaCeiling(ctx context.Context, score int64) (int64, error) {
+	r.replicaReads++
+	return r.HashRing.Ceiling(ctx, score)
+}
+
+func (r *replicaHashRing) ReplicaNode(ctx context.Context, score int64) ([]string, error) {
+	r.replicaReads++
+	return r.HashRing.Node(ctx, score)
+}
+
+func Test_ReplicaReader(t *testing.T) {
+	ctx := context.Background()
+	hashRing := &replicaHashRing{HashRing: memory.NewHashRing()}
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, WithReplicas(2))
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 路由查询只通过只读副本检索哈希环
+	hashRing.primaryReads, hashRing.replicaReads = 0, 0
+	dataKeys := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		dataKeys = append(dataKeys, dataKey)
+		if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := consistentHash.BatchGetNode(ctx, dataKeys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consistentHash.GetNodeReadOnly(ctx, "data_0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := consistentHash.GetNodeDetailed(ctx, "data_0"); err != nil {
+		t.Fatal(err)
+	}
+	if hashRing.primaryReads != 0 || hashRing.replicaReads == 0 {
+		t.Errorf("lookups: got %d primary reads and %d replica reads, want replica reads only", hashRing.primaryReads, hashRing.replicaReads)
+	}
+
+	// 节点变更与数据迁移需要读取最新的哈希环，不能使用只读副本
+	hashRing.primaryReads, hashRing.replicaReads = 0, 0
+	if err := consistentHash.AddNodes(ctx, map[string]int{"node_b": 1, "node_c": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations()) == 0 {
+		t.Fatal("expect migrations when adding and removing nodes")
+	}
+	if hashRing.replicaReads != 0 || hashRing.primaryReads == 0 {
+		t.Errorf("mutations: got %d primary reads and %d replica reads, want primary reads only", hashRing.primaryReads, hashRing.replicaReads)
+	}
+}
@@ -1,25 +1,106 @@
 package consistent_hash
 
 import (
+	"hash/crc32"
 	"math"
+	"math/bits"
 
 	"github.com/spaolacci/murmur3"
 )
 
+// 哈希环默认的长度
+// redis zset 的 score 为双精度浮点数，只能精确表示 2^53 以内的整数，因此环上的位置不能超过该范围
+const DefaultRingSize int64 = 1 << 53
+
 // 哈希散列器
+// Encrypt 的结果会被 ConsistentHash 按照哈希环长度（参见 WithRingSize）取模后作为环上的位置
 type Encryptor interface {
-	Encrypt(origin string) int32
+	Encrypt(origin string) int64
+}
+
+// 可以按照哈希环的长度调整结果范围的散列器，NewConsistentHash 会将其替换为与 WithRingSize 一致的散列器
+type ringSizeEncryptor interface {
+	withRingSize(ringSize int64) Encryptor
 }
 
+// 基于 murmur3 32 位哈希实现的散列器
+// 哈希值通过乘法移位（multiply-shift）均匀地映射到 [0, ringSize) 的范围内，每个位置命中的概率至多相差一个哈希值
+// 未指定范围时，传入 NewConsistentHash 会使用 WithRingSize 配置的哈希环长度，单独使用时为 [0, DefaultRingSize)
 type MurmurHasher struct {
+	seed uint32
+	// 结果的取值范围 [0, ringSize)，不大于 0 时使用 DefaultRingSize
+	ringSize int64
+	// 沿用对 math.MaxInt32 取模的旧映射方式
+	legacy bool
 }
 
 func NewMurmurHasher() *MurmurHasher {
 	return &MurmurHasher{}
 }
 
-func (m *MurmurHasher) Encrypt(origin string) int32 {
-	hasher := murmur3.New32()
+// 指定种子的 murmur3 散列器，种子不同的散列器会将同一个 key 映射到不同的位置，用于构造相互独立的哈希环
+func NewMurmurHasherWithSeed(seed uint32) *MurmurHasher {
+	return &MurmurHasher{seed: seed}
+}
+
+// 指定结果取值范围的 murmur3 散列器，ringSize 应当与 WithRingSize 保持一致
+func NewMurmurHasherWithRange(ringSize int64) *MurmurHasher {
+	return &MurmurHasher{ringSize: ringSize}
+}
+
+// 沿用旧映射方式的 murmur3 散列器，结果为哈希值对 math.MaxInt32 取模，仅用于兼容由旧版本创建的哈希环
+// 哈希环的长度不能整除 math.MaxInt32 时，ConsistentHash 再次取模会使环上靠前的位置被更多的 key 命中，
+// 例如哈希环的长度为 1.5 * 2^30 时，前三分之一的位置命中的概率是其余位置的两倍
+func NewLegacyMurmurHasher() *MurmurHasher {
+	return &MurmurHasher{legacy: true}
+}
+
+func (m *MurmurHasher) withRingSize(ringSize int64) Encryptor {
+	if m.legacy || m.ringSize > 0 {
+		return m
+	}
+	return &MurmurHasher{seed: m.seed, ringSize: ringSize}
+}
+
+func (m *MurmurHasher) Encrypt(origin string) int64 {
+	hasher := murmur3.New32WithSeed(m.seed)
 	_, _ = hasher.Write([]byte(origin))
-	return int32(hasher.Sum32() % math.MaxInt32)
+	if m.legacy {
+		return int64(hasher.Sum32() % math.MaxInt32)
+	}
+	ringSize := m.ringSize
+	if ringSize <= 0 {
+		ringSize = DefaultRingSize
+	}
+	// 即 sum32 * ringSize / 2^32，每个位置对应的哈希值个数至多相差 1
+	hi, _ := bits.Mul64(uint64(hasher.Sum32())<<32, uint64(ringSize))
+	return int64(hi)
+}
+
+// 基于 murmur3 128 位哈希实现的散列器，结果分布在 [0, DefaultRingSize) 的范围内
+// 相比 MurmurHasher 只有 2^32 个不同的结果，在虚拟节点数量较多时能够大幅降低哈希环上位置冲突的概率
+type Murmur128Hasher struct {
+}
+
+func NewMurmur128Hasher() *Murmur128Hasher {
+	return &Murmur128Hasher{}
+}
+
+func (m *Murmur128Hasher) Encrypt(origin string) int64 {
+	h1, _ := murmur3.Sum128([]byte(origin))
+	return int64(h1 % uint64(DefaultRingSize))
+}
+
+// 基于 crc32（IEEE 多项式）实现的散列器，用于和其他基于 crc32 的一致性哈希实现（如 groupcache/consistent）保持相同的节点位置
+// 环上的位置即为 crc32.ChecksumIEEE 的结果本身，取值范围为 [0, 2^32)，不做任何额外的变换
+// 因此哈希环的长度（参见 WithRingSize）不能小于 2^32，否则取模后的位置会与外部实现不一致，默认的 DefaultRingSize 满足该要求
+type CRC32Hasher struct {
+}
+
+func NewCRC32Hasher() *CRC32Hasher {
+	return &CRC32Hasher{}
+}
+
+func (c *CRC32Hasher) Encrypt(origin string) int64 {
+	return int64(crc32.ChecksumIEEE([]byte(origin)))
 }
@@ -0,0 +1,251 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/pule1234/consistent_hash/memory"
+	"github.com/spaolacci/murmur3"
+)
+
+// 统计一批虚拟节点 key 经过散列后在哈希环上发生位置冲突的次数
+func countCollisions(encryptor Encryptor, n int) int {
+	scores := make(map[int64]struct{}, n)
+	var collisions int
+	for i := 0; i < n; i++ {
+		score := encryptor.Encrypt(fmt.Sprintf("node_%d_%d", i%100, i))
+		if _, ok := scores[score]; ok {
+			collisions++
+			continue
+		}
+		scores[score] = struct{}{}
+	}
+	return collisions
+}
+
+func Test_Murmur128Hasher_Collisions(t *testing.T) {
+	const virtualNodes = 100000
+	narrow := countCollisions(NewMurmurHasher(), virtualNodes)
+	wide := countCollisions(NewMurmur128Hasher(), virtualNodes)
+	t.Logf("collisions with %d virtual nodes, murmur32: %d, murmur128: %d", virtualNodes, narrow, wide)
+	if narrow == 0 {
+		t.Errorf("expect collisions in 31-bit ring space with %d virtual nodes", virtualNodes)
+	}
+	if wide != 0 {
+		t.Errorf("got %d collisions in 53-bit ring space, want 0", wide)
+	}
+}
+
+func Test_Murmur128Hasher_Range(t *testing.T) {
+	hasher := NewMurmur128Hasher()
+	var aboveInt32 bool
+	for i := 0; i < 1000; i++ {
+		score := hasher.Encrypt(fmt.Sprintf("data_%d", i))
+		if score < 0 || score >= DefaultRingSize {
+			t.Fatalf("score %d out of ring range", score)
+		}
+		if score > 1<<31 {
+			aboveInt32 = true
+		}
+		if hasher.Encrypt(fmt.Sprintf("data_%d", i)) != score {
+			t.Fatalf("encrypt result of data_%d is not stable", i)
+		}
+	}
+	if !aboveInt32 {
+		t.Error("murmur128 hasher should fill the space beyond int32")
+	}
+}
+
+func Test_XXH64_Vectors(t *testing.T) {
+	cases := []struct {
+		input string
+		want  uint64
+	}{
+		{input: "", want: 0xef46db3751d8e999},
+		{input: "a", want: 0xd24ec4f1a98c6e5b},
+		{input: "as", want: 0x1c330fb2d66be179},
+		{input: "asd", want: 0x631c37ce72a97393},
+		{input: "asdf", want: 0x415872f599cea71e},
+		{input: "Call me Ishmael. Some years ago--never mind how long precisely-", want: 0x02a2e85470d6fd96},
+	}
+	for _, c := range cases {
+		if got := xxh64(c.input); got != c.want {
+			t.Errorf("xxh64(%q) got %#x, want %#x", c.input, got, c.want)
+		}
+	}
+}
+
+func Test_XXHasher_Range(t *testing.T) {
+	hasher := NewXXHasher()
+	for i := 0; i < 1000; i++ {
+		score := hasher.Encrypt(fmt.Sprintf("data_%d", i))
+		if score < 0 || score >= DefaultRingSize {
+			t.Fatalf("score %d out of ring range", score)
+		}
+	}
+	if allocs := testing.AllocsPerRun(100, func() {
+		hasher.Encrypt("user:10086:session")
+	}); allocs != 0 {
+		t.Errorf("got %v allocs per encrypt, want 0", allocs)
+	}
+}
+
+func Benchmark_MurmurHasher_Encrypt(b *testing.B) {
+	hasher := NewMurmurHasher()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		hasher.Encrypt("user:10086:session")
+	}
+}
+
+func Benchmark_XXHasher_Encrypt(b *testing.B) {
+	hasher := NewXXHasher()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		hasher.Encrypt("user:10086:session")
+	}
+}
+
+func Test_CRC32Hasher_Vectors(t *testing.T) {
+	consistentHash := NewConsistentHash(nil, NewCRC32Hasher(), nil)
+	// 与 crc32.ChecksumIEEE 的标准结果一致，在默认的哈希环长度下位置保持不变
+	cases := []struct {
+		input string
+		want  int64
+	}{
+		{input: "", want: 0},
+		{input: "a", want: 0xe8b7be43},
+		{input: "abc", want: 0x352441c2},
+		{input: "123456789", want: 0xcbf43926},
+		{input: "The quick brown fox jumps over the lazy dog", want: 0x414fa339},
+	}
+	for _, c := range cases {
+		if got := consistentHash.getScore(c.input); got != c.want {
+			t.Errorf("score of %q got %#x, want %#x", c.input, got, c.want)
+		}
+	}
+}
+
+func Test_MurmurHasherWithSeed(t *testing.T) {
+	// 种子为 0 时与 NewMurmurHasher 的结果一致
+	if NewMurmurHasherWithSeed(0).Encrypt("data") != NewMurmurHasher().Encrypt("data") {
+		t.Error("zero seed should match the default murmur hasher")
+	}
+
+	seedA, seedB := NewMurmurHasherWithSeed(1), NewMurmurHasherWithSeed(2)
+	var differ int
+	for i := 0; i < 100; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		if seedA.Encrypt(dataKey) != seedB.Encrypt(dataKey) {
+			differ++
+		}
+		if seedA.Encrypt(dataKey) != NewMurmurHasherWithSeed(1).Encrypt(dataKey) {
+			t.Errorf("same seed should reproduce the score of %s", dataKey)
+		}
+	}
+	if differ != 100 {
+		t.Errorf("got %d of 100 keys with different scores across seeds", differ)
+	}
+}
+
+func Test_WithDataKeyEncryptor(t *testing.T) {
+	ctx := context.Background()
+	dataKeys := make([]string, 0, 200)
+	for i := 0; i < 200; i++ {
+		dataKeys = append(dataKeys, fmt.Sprintf("data_%d", i))
+	}
+
+	migrator, _ := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, WithDataKeyEncryptor(NewXXHasher()))
+	// 虚拟节点的位置仍然由节点的散列函数决定
+	reference := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	for _, c := range []*ConsistentHash{consistentHash, reference} {
+		for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+			if err := c.AddNode(ctx, nodeID, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !reflect.DeepEqual(ringScores(t, consistentHash), ringScores(t, reference)) {
+		t.Fatal("virtual nodes should be placed by the node encryptor")
+	}
+
+	for _, dataKey := range dataKeys {
+		want := consistentHash.hashScore(NewXXHasher(), dataKey)
+		if score := consistentHash.getScore(dataKey); score != want {
+			t.Fatalf("%s: got score %d, want xxhash score %d", dataKey, score, want)
+		}
+		nodeID, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wantNode, _, err := consistentHash.NodeForScore(ctx, want); err != nil || nodeID != wantNode {
+			t.Errorf("%s: got node %s, want (%s, %v)", dataKey, nodeID, wantNode, err)
+		}
+	}
+
+	// 数据迁移的区间计算同样使用数据 key 的散列函数
+	if err := consistentHash.AddNode(ctx, "node_d", 2); err != nil {
+		t.Fatal(err)
+	}
+	assertPlacement(t, consistentHash, []string{"node_a", "node_b", "node_c", "node_d"}, dataKeys)
+	if err := consistentHash.RemoveNode(ctx, "node_b"); err != nil {
+		t.Fatal(err)
+	}
+	assertPlacement(t, consistentHash, []string{"node_a", "node_c", "node_d"}, dataKeys)
+}
+
+func Test_NewMurmurHasherWithRange(t *testing.T) {
+	const (
+		ringSize = 3 << 29
+		buckets  = 10
+		keys     = 100000
+		// 自由度为 9 的卡方分布在 99.9% 置信度下的临界值
+		critical = 27.88
+	)
+	// 将哈希环等分为若干段，统计 key 在各段的分布相对于均匀分布的卡方值
+	chiSquare := func(encryptor Encryptor) float64 {
+		consistentHash := NewConsistentHash(memory.NewHashRing(), encryptor, nil, WithRingSize(ringSize))
+		counts := make([]int, buckets)
+		for i := 0; i < keys; i++ {
+			score := consistentHash.getScore(fmt.Sprintf("data_%d", i))
+			if score < 0 || score >= ringSize {
+				t.Fatalf("score %d out of range [0, %d)", score, ringSize)
+			}
+			counts[score*buckets/ringSize]++
+		}
+		var res float64
+		expected := float64(keys) / buckets
+		for _, count := range counts {
+			res += (float64(count) - expected) * (float64(count) - expected) / expected
+		}
+		return res
+	}
+
+	if got := chiSquare(NewMurmurHasherWithRange(ringSize)); got > critical {
+		t.Errorf("range hasher: chi-square %.2f exceeds %.2f", got, critical)
+	}
+	// 默认的散列器映射到 [0, DefaultRingSize)，再对哈希环长度取模后同样均匀
+	if got := chiSquare(NewMurmurHasher()); got > critical {
+		t.Errorf("default hasher: chi-square %.2f exceeds %.2f", got, critical)
+	}
+	if got := chiSquare(NewMurmurHasherWithSeed(7)); got > critical {
+		t.Errorf("seeded hasher: chi-square %.2f exceeds %.2f", got, critical)
+	}
+	// 对照组：旧的映射方式先对 math.MaxInt32 取模再对哈希环长度取模，环上前三分之一的位置命中概率翻倍
+	if got := chiSquare(NewLegacyMurmurHasher()); got <= critical {
+		t.Errorf("legacy hasher: chi-square %.2f, want the modulo bias to be detected", got)
+	}
+
+	// 未指定范围时使用 DefaultRingSize
+	if NewMurmurHasherWithRange(0).Encrypt("data") != NewMurmurHasher().Encrypt("data") {
+		t.Error("zero range should use the default ring size")
+	}
+	// 旧的映射方式保持不变
+	if got, want := NewLegacyMurmurHasher().Encrypt("data"), int64(murmur3.Sum32([]byte("data"))%math.MaxInt32); got != want {
+		t.Errorf("legacy hasher: got %d, want %d", got, want)
+	}
+}
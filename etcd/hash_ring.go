@@ -0,0 +1,523 @@
+package etcd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+var ErrScoreNotExist = errors.New("score not exist")
+
+// 基于修订号的读改写在并发冲突时的最大重试次数
+const maxTxnRetries = 16
+
+// 单个事务中操作个数的上限，与 etcd 服务端 --max-txn-ops 的默认值一致
+const maxTxnOps = 128
+
+// 哈希环在 etcd 中全部 key 的默认前缀
+const DefaultKeyPrefix = "/consistent_hash/ring"
+
+// 基于 etcd 实现的哈希环
+// 虚拟节点的位置以补齐到 20 位的十进制数作为 key 存储，etcd 按照 key 的字典序排序，因此 Ceiling、Floor 通过一次范围查询即可完成；
+// 真实节点列表、代数通过比较修订号的事务实现并发安全的读改写，数据 key 集合的批量增删以及迁移同样在事务中完成
+// 分布式锁基于 etcd 的 lease 与 concurrency.Mutex 实现，持有锁期间 lease 会自动续期，进程异常退出后 lease 过期自动释放锁
+type EtcdHashRing struct {
+	// 哈希环维度的唯一键
+	key string
+	// 哈希环在 etcd 中全部 key 的前缀
+	keyPrefix string
+	client    *clientv3.Client
+
+	// 当前持有的锁，解锁时需要使用加锁时创建的 session
+	mu      sync.Mutex
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+}
+
+type EtcdHashRingOption func(e *EtcdHashRing)
+
+// 修改哈希环在 etcd 中全部 key 的前缀，默认为 DefaultKeyPrefix
+func WithKeyPrefix(keyPrefix string) EtcdHashRingOption {
+	return func(e *EtcdHashRing) {
+		e.keyPrefix = keyPrefix
+	}
+}
+
+// etcd 客户端的生命周期由使用方管理
+func NewEtcdHashRing(key string, client *clientv3.Client, opts ...EtcdHashRingOption) *EtcdHashRing {
+	e := EtcdHashRing{
+		key:    key,
+		client: client,
+	}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	if e.keyPrefix == "" {
+		e.keyPrefix = DefaultKeyPrefix
+	}
+	return &e
+}
+
+func (e *EtcdHashRing) getLockKey() string {
+	return fmt.Sprintf("%s/%s/lock", e.keyPrefix, e.key)
+}
+
+// 虚拟节点位置的前缀，其下的 key 为补齐位数的虚拟节点数值，value 为真实节点列表的 json 串
+func (e *EtcdHashRing) getScorePrefix() string {
+	return fmt.Sprintf("%s/%s/score/", e.keyPrefix, e.key)
+}
+
+// 虚拟节点数值均为非负数，补齐到 int64 的最大位数后字典序与数值大小一致
+func (e *EtcdHashRing) getScoreKey(score int64) string {
+	return fmt.Sprintf("%s%020d", e.getScorePrefix(), score)
+}
+
+func (e *EtcdHashRing) parseScoreKey(key []byte) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(string(key), e.getScorePrefix()), 10, 64)
+}
+
+func (e *EtcdHashRing) getGenerationKey() string {
+	return fmt.Sprintf("%s/%s/generation", e.keyPrefix, e.key)
+}
+
+func (e *EtcdHashRing) getEventKey() string {
+	return fmt.Sprintf("%s/%s/event", e.keyPrefix, e.key)
+}
+
+func (e *EtcdHashRing) getNodeReplicaPrefix() string {
+	return fmt.Sprintf("%s/%s/node/replica/", e.keyPrefix, e.key)
+}
+
+// 真实节点下的状态数据 key 集合的前缀，nodeID 可能包含 /，因此进行编码，避免不同节点的前缀相互包含
+func (e *EtcdHashRing) getNodeDataPrefix(nodeID string) string {
+	return fmt.Sprintf("%s/%s/node/data_key/%s/", e.keyPrefix, e.key, base64.RawURLEncoding.EncodeToString([]byte(nodeID)))
+}
+
+// 锁住哈希环，为本次加锁创建过期时间为 expireSeconds 秒的 lease，持有锁期间由 session 自动续期
+// 锁已经被占用时不会阻塞，直接返回 concurrency.ErrLocked
+func (e *EtcdHashRing) Lock(ctx context.Context, expireSeconds int) error {
+	lease, err := e.client.Grant(ctx, int64(expireSeconds))
+	if err != nil {
+		return fmt.Errorf("etcd ring lock grant failed, err: %w", err)
+	}
+	// 每次加锁使用独立的 lease，同一个进程内并发加锁时才能互斥
+	session, err := concurrency.NewSession(e.client, concurrency.WithLease(lease.ID))
+	if err != nil {
+		_, _ = e.client.Revoke(context.Background(), lease.ID)
+		return fmt.Errorf("etcd ring lock session failed, err: %w", err)
+	}
+
+	mutex := concurrency.NewMutex(session, e.getLockKey())
+	if err = mutex.TryLock(ctx); err != nil {
+		_ = session.Close()
+		return fmt.Errorf("etcd ring lock failed, err: %w", err)
+	}
+
+	e.mu.Lock()
+	e.session, e.mutex = session, mutex
+	e.mu.Unlock()
+	return nil
+}
+
+// 解锁哈希环，同时撤销加锁时创建的 lease
+func (e *EtcdHashRing) Unlock(ctx context.Context) error {
+	e.mu.Lock()
+	session, mutex := e.session, e.mutex
+	e.session, e.mutex = nil, nil
+	e.mu.Unlock()
+
+	if mutex == nil {
+		return errors.New("etcd ring unlock failed, lock not held")
+	}
+	defer session.Close()
+	if err := mutex.Unlock(ctx); err != nil {
+		return fmt.Errorf("etcd ring unlock failed, err: %w", err)
+	}
+	return nil
+}
+
+// 判断 Lock 返回的错误是否是因为锁已经被其他持有者占用
+func (e *EtcdHashRing) IsLockBusy(err error) bool {
+	return errors.Is(err, concurrency.ErrLocked)
+}
+
+// 基于修订号的读改写，fn 接收当前值（key 不存在时为 nil）并返回新值，返回 nil 时删除 key
+// 写入期间 key 被其他请求修改时事务不会生效，重新读取并重试
+func (e *EtcdHashRing) update(ctx context.Context, key string, fn func(val []byte) ([]byte, error)) error {
+	for i := 0; i < maxTxnRetries; i++ {
+		resp, err := e.client.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		var (
+			oldVal []byte
+			rev    int64
+		)
+		if len(resp.Kvs) > 0 {
+			oldVal, rev = resp.Kvs[0].Value, resp.Kvs[0].ModRevision
+		}
+		newVal, err := fn(oldVal)
+		if err != nil {
+			return err
+		}
+
+		var op clientv3.Op
+		switch {
+		case oldVal == nil && newVal == nil:
+			return nil
+		case newVal == nil:
+			op = clientv3.OpDelete(key)
+		case string(oldVal) == string(newVal):
+			return nil
+		default:
+			op = clientv3.OpPut(key, string(newVal))
+		}
+
+		// key 不存在时 ModRevision 为 0
+		txnResp, err := e.client.Txn(ctx).If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).Then(op).Commit()
+		if err != nil {
+			return err
+		}
+		if txnResp.Succeeded {
+			return nil
+		}
+	}
+	return fmt.Errorf("update %s failed after %d retries", key, maxTxnRetries)
+}
+
+// 真实节点入环. 将一个真实节点 nodeID 追加到 score 对应的虚拟节点中，重复添加同一个真实节点不会产生影响
+func (e *EtcdHashRing) Add(ctx context.Context, score int64, nodeID string) error {
+	if _, err := e.AddIfAbsent(ctx, score, nodeID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 真实节点 nodeID 不在 score 对应的虚拟节点中时将其追加到列表末尾，added 标识是否发生了追加
+func (e *EtcdHashRing) AddIfAbsent(ctx context.Context, score int64, nodeID string) (added bool, err error) {
+	if err = e.update(ctx, e.getScoreKey(score), func(val []byte) ([]byte, error) {
+		added = false
+		var nodeIDs []string
+		if val != nil {
+			if err := json.Unmarshal(val, &nodeIDs); err != nil {
+				return nil, err
+			}
+		}
+		for _, _nodeID := range nodeIDs {
+			if _nodeID == nodeID {
+				return val, nil
+			}
+		}
+		added = true
+		return json.Marshal(append(nodeIDs, nodeID))
+	}); err != nil {
+		return false, fmt.Errorf("etcd ring add failed, err: %w", err)
+	}
+	return added, nil
+}
+
+// 从哈希环对应于 score 的虚拟节点删去真实节点 nodeID，真实节点列表为空时同时删除该虚拟节点
+// 与 redis 哈希环一致，虚拟节点或者真实节点不存在时不做任何操作
+func (e *EtcdHashRing) Rem(ctx context.Context, score int64, nodeID string) error {
+	if err := e.update(ctx, e.getScoreKey(score), func(val []byte) ([]byte, error) {
+		if val == nil {
+			return nil, nil
+		}
+
+		var nodeIDs []string
+		if err := json.Unmarshal(val, &nodeIDs); err != nil {
+			return nil, err
+		}
+
+		newNodeIDs := make([]string, 0, len(nodeIDs))
+		for _, _nodeID := range nodeIDs {
+			if _nodeID != nodeID {
+				newNodeIDs = append(newNodeIDs, _nodeID)
+			}
+		}
+		if len(newNodeIDs) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(newNodeIDs)
+	}); err != nil {
+		return fmt.Errorf("etcd ring rem failed, err: %w", err)
+	}
+	return nil
+}
+
+// 在一个事务中执行 first 与 fallback 两次范围查询，first 未命中时使用 fallback 的结果，哈希环为空时返回 -1
+func (e *EtcdHashRing) seek(ctx context.Context, first, fallback clientv3.Op) (int64, error) {
+	resp, err := e.client.Txn(ctx).Then(first, fallback).Commit()
+	if err != nil {
+		return 0, err
+	}
+	for _, rangeResp := range resp.Responses {
+		if kvs := rangeResp.GetResponseRange().Kvs; len(kvs) > 0 {
+			return e.parseScoreKey(kvs[0].Key)
+		}
+	}
+	return -1, nil
+}
+
+// 从哈希环中获取到 score 顺时针往下的第一个虚拟节点数值
+// 检索 key 大于等于 score 的第一个虚拟节点，未找到时回绕到 score 最小的虚拟节点，在一次请求中完成
+func (e *EtcdHashRing) Ceiling(ctx context.Context, score int64) (int64, error) {
+	prefixEnd := clientv3.GetPrefixRangeEnd(e.getScorePrefix())
+	ceiling, err := e.seek(ctx,
+		clientv3.OpGet(e.getScoreKey(score), clientv3.WithRange(prefixEnd), clientv3.WithLimit(1), clientv3.WithKeysOnly()),
+		clientv3.OpGet(e.getScorePrefix(), clientv3.WithRange(prefixEnd), clientv3.WithLimit(1), clientv3.WithKeysOnly()),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("etcd ring ceiling failed, err: %w", err)
+	}
+	return ceiling, nil
+}
+
+// 从哈希环中获取到 score 逆时针往上的第一个虚拟节点数值
+// 检索 key 小于等于 score 的最后一个虚拟节点，未找到时回绕到 score 最大的虚拟节点，在一次请求中完成
+func (e *EtcdHashRing) Floor(ctx context.Context, score int64) (int64, error) {
+	prefixEnd := clientv3.GetPrefixRangeEnd(e.getScorePrefix())
+	descend := clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend)
+	floor, err := e.seek(ctx,
+		clientv3.OpGet(e.getScorePrefix(), clientv3.WithRange(clientv3.GetPrefixRangeEnd(e.getScoreKey(score))), descend, clientv3.WithLimit(1), clientv3.WithKeysOnly()),
+		clientv3.OpGet(e.getScorePrefix(), clientv3.WithRange(prefixEnd), descend, clientv3.WithLimit(1), clientv3.WithKeysOnly()),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("etcd ring floor failed, err: %w", err)
+	}
+	return floor, nil
+}
+
+func (e *EtcdHashRing) Node(ctx context.Context, score int64) ([]string, error) {
+	resp, err := e.client.Get(ctx, e.getScoreKey(score))
+	if err != nil {
+		return nil, fmt.Errorf("etcd ring node get failed, err: %w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("etcd ring node failed, score: %d, err: %w", score, ErrScoreNotExist)
+	}
+
+	var nodeIDs []string
+	if err = json.Unmarshal(resp.Kvs[0].Value, &nodeIDs); err != nil {
+		return nil, err
+	}
+	return nodeIDs, nil
+}
+
+// 查询哈希环上全部的虚拟节点
+func (e *EtcdHashRing) VirtualNodes(ctx context.Context) (map[int64][]string, error) {
+	resp, err := e.client.Get(ctx, e.getScorePrefix(), clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("etcd ring virtual nodes get failed, err: %w", err)
+	}
+
+	virtualNodes := make(map[int64][]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		score, err := e.parseScoreKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		var nodeIDs []string
+		if err = json.Unmarshal(kv.Value, &nodeIDs); err != nil {
+			return nil, err
+		}
+		virtualNodes[score] = nodeIDs
+	}
+	return virtualNodes, nil
+}
+
+func (e *EtcdHashRing) Nodes(ctx context.Context) (map[string]int, error) {
+	resp, err := e.client.Get(ctx, e.getNodeReplicaPrefix(), clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("etcd ring nodes get failed, err: %w", err)
+	}
+
+	nodes := make(map[string]int, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		replicas, err := strconv.Atoi(string(kv.Value))
+		if err != nil {
+			return nil, err
+		}
+		nodes[strings.TrimPrefix(string(kv.Key), e.getNodeReplicaPrefix())] = replicas
+	}
+	return nodes, nil
+}
+
+// 只统计 key 的个数，无需拉取全量的真实节点
+func (e *EtcdHashRing) NodeExists(ctx context.Context, nodeID string) (bool, error) {
+	resp, err := e.client.Get(ctx, e.getNodeReplicaPrefix()+nodeID, clientv3.WithCountOnly())
+	if err != nil {
+		return false, fmt.Errorf("etcd ring node exists get failed, err: %w", err)
+	}
+	return resp.Count > 0, nil
+}
+
+func (e *EtcdHashRing) AddNodeToReplica(ctx context.Context, nodeID string, replicas int) error {
+	if _, err := e.client.Put(ctx, e.getNodeReplicaPrefix()+nodeID, strconv.Itoa(replicas)); err != nil {
+		return fmt.Errorf("etcd ring add node to replica failed, err: %w", err)
+	}
+	return nil
+}
+
+func (e *EtcdHashRing) DeleteNodeToReplica(ctx context.Context, nodeID string) error {
+	if _, err := e.client.Delete(ctx, e.getNodeReplicaPrefix()+nodeID); err != nil {
+		return fmt.Errorf("etcd ring delete node to replica failed, err: %w", err)
+	}
+	return nil
+}
+
+// 查询哈希环的代数，代数不存在时为 0
+func (e *EtcdHashRing) Generation(ctx context.Context) (int64, error) {
+	resp, err := e.client.Get(ctx, e.getGenerationKey())
+	if err != nil {
+		return 0, fmt.Errorf("etcd ring get generation failed, err: %w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+}
+
+// 递增哈希环的代数，并返回递增后的值
+func (e *EtcdHashRing) IncrGeneration(ctx context.Context) (int64, error) {
+	var generation int64
+	if err := e.update(ctx, e.getGenerationKey(), func(val []byte) ([]byte, error) {
+		generation = 0
+		if val != nil {
+			var err error
+			if generation, err = strconv.ParseInt(string(val), 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		generation++
+		return []byte(strconv.FormatInt(generation, 10)), nil
+	}); err != nil {
+		return 0, fmt.Errorf("etcd ring incr generation failed, err: %w", err)
+	}
+	return generation, nil
+}
+
+// 发布拓扑变更消息，消息写入同一个 key，订阅者通过 watch 接收每一次写入
+func (e *EtcdHashRing) Publish(ctx context.Context, payload string) error {
+	if _, err := e.client.Put(ctx, e.getEventKey(), payload); err != nil {
+		return fmt.Errorf("etcd ring publish failed, err: %w", err)
+	}
+	return nil
+}
+
+// 订阅拓扑变更消息，watch 建立之后才会返回，ctx 结束后关闭返回的 channel
+func (e *EtcdHashRing) Subscribe(ctx context.Context) (<-chan string, error) {
+	watchCh := e.client.Watch(clientv3.WithRequireLeader(ctx), e.getEventKey(), clientv3.WithCreatedNotify())
+	// 第一个响应为 watch 建立的确认
+	created, ok := <-watchCh
+	if !ok {
+		return nil, fmt.Errorf("etcd ring subscribe failed, err: %w", ctx.Err())
+	}
+	if err := created.Err(); err != nil {
+		return nil, fmt.Errorf("etcd ring subscribe failed, err: %w", err)
+	}
+
+	payloads := make(chan string)
+	go func() {
+		defer close(payloads)
+		for resp := range watchCh {
+			if resp.Err() != nil {
+				return
+			}
+			for _, event := range resp.Events {
+				if event.Type != clientv3.EventTypePut {
+					continue
+				}
+				select {
+				case payloads <- string(event.Kv.Value):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return payloads, nil
+}
+
+func (e *EtcdHashRing) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	prefix := e.getNodeDataPrefix(nodeID)
+	resp, err := e.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, fmt.Errorf("etcd ring data keys get failed, err: %w", err)
+	}
+
+	dataKeys := make(map[string]struct{}, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		dataKeys[strings.TrimPrefix(string(kv.Key), prefix)] = struct{}{}
+	}
+	return dataKeys, nil
+}
+
+// 只统计数据 key 对应的 key 的个数，无需拉取真实节点全量的数据 key
+func (e *EtcdHashRing) DataKeyRecorded(ctx context.Context, nodeID, dataKey string) (bool, error) {
+	resp, err := e.client.Get(ctx, e.getNodeDataPrefix(nodeID)+dataKey, clientv3.WithCountOnly())
+	if err != nil {
+		return false, fmt.Errorf("etcd ring data key recorded get failed, err: %w", err)
+	}
+	return resp.Count > 0, nil
+}
+
+// 按照单个事务的操作个数上限分批提交，每一批在一个事务中原子完成
+func (e *EtcdHashRing) commitOps(ctx context.Context, ops []clientv3.Op, batchSize int) error {
+	for start := 0; start < len(ops); start += batchSize {
+		end := start + batchSize
+		if end > len(ops) {
+			end = len(ops)
+		}
+		if _, err := e.client.Txn(ctx).Then(ops[start:end]...).Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EtcdHashRing) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	prefix := e.getNodeDataPrefix(nodeID)
+	ops := make([]clientv3.Op, 0, len(dataKeys))
+	for dataKey := range dataKeys {
+		ops = append(ops, clientv3.OpPut(prefix+dataKey, ""))
+	}
+	if err := e.commitOps(ctx, ops, maxTxnOps); err != nil {
+		return fmt.Errorf("etcd ring add node to data keys failed, err: %w", err)
+	}
+	return nil
+}
+
+func (e *EtcdHashRing) DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	prefix := e.getNodeDataPrefix(nodeID)
+	ops := make([]clientv3.Op, 0, len(dataKeys))
+	for dataKey := range dataKeys {
+		ops = append(ops, clientv3.OpDelete(prefix+dataKey))
+	}
+	if err := e.commitOps(ctx, ops, maxTxnOps); err != nil {
+		return fmt.Errorf("etcd ring delete node to data keys failed, err: %w", err)
+	}
+	return nil
+}
+
+// 将数据 key 从 from 移动到 to，每个数据 key 的删除与添加位于同一个事务中，不会出现数据 key 丢失的中间状态
+func (e *EtcdHashRing) MoveDataKeys(ctx context.Context, from, to string, dataKeys map[string]struct{}) error {
+	fromPrefix, toPrefix := e.getNodeDataPrefix(from), e.getNodeDataPrefix(to)
+	ops := make([]clientv3.Op, 0, 2*len(dataKeys))
+	for dataKey := range dataKeys {
+		ops = append(ops, clientv3.OpDelete(fromPrefix+dataKey), clientv3.OpPut(toPrefix+dataKey, ""))
+	}
+	if err := e.commitOps(ctx, ops, maxTxnOps); err != nil {
+		return fmt.Errorf("etcd ring move data keys failed, err: %w", err)
+	}
+	return nil
+}
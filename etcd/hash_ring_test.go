@@ -0,0 +1,404 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+
+	"github.com/pule1234/consistent_hash"
+	"github.com/pule1234/consistent_hash/redis"
+)
+
+// 全部测试共用一个内嵌的 etcd 服务，每个测试使用不同的哈希环 key 相互隔离
+var testEndpoint string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "consistent_hash_etcd")
+	if err != nil {
+		panic(err)
+	}
+	server, err := startEmbedEtcd(dir)
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	server.Close()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func startEmbedEtcd(dir string) (*embed.Etcd, error) {
+	clientURL, err := freeURL()
+	if err != nil {
+		return nil, err
+	}
+	peerURL, err := freeURL()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{*clientURL}, []url.URL{*clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{*peerURL}, []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		server.Close()
+		return nil, fmt.Errorf("embed etcd not ready")
+	}
+	testEndpoint = clientURL.String()
+	return server, nil
+}
+
+func freeURL() (*url.URL, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	return url.Parse("http://" + listener.Addr().String())
+}
+
+func newTestClient(t *testing.T) *clientv3.Client {
+	t.Helper()
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{testEndpoint}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+func newTestHashRing(t *testing.T, key string) *EtcdHashRing {
+	t.Helper()
+	return NewEtcdHashRing(key, newTestClient(t))
+}
+
+func Test_EtcdHashRing_CeilingFloor(t *testing.T) {
+	ctx := context.Background()
+	ring := newTestHashRing(t, "test_ceiling_floor")
+
+	if score, err := ring.Ceiling(ctx, 1); err != nil || score != -1 {
+		t.Fatalf("ceiling on empty ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+	if score, err := ring.Floor(ctx, 1); err != nil || score != -1 {
+		t.Fatalf("floor on empty ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+
+	for _, score := range []int64{300, 100, 200, 1000} {
+		if err := ring.Add(ctx, score, "node"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 1000 与 100 的字典序相反，用于确认 key 补齐了位数
+	cases := []struct {
+		score   int64
+		ceiling int64
+		floor   int64
+	}{
+		{score: 50, ceiling: 100, floor: 1000},
+		{score: 100, ceiling: 100, floor: 100},
+		{score: 150, ceiling: 200, floor: 100},
+		{score: 300, ceiling: 300, floor: 300},
+		{score: 301, ceiling: 1000, floor: 300},
+		{score: 1001, ceiling: 100, floor: 1000},
+	}
+	for _, c := range cases {
+		if ceiling, err := ring.Ceiling(ctx, c.score); err != nil || ceiling != c.ceiling {
+			t.Errorf("ceiling %d: got (%d, %v), want %d", c.score, ceiling, err, c.ceiling)
+		}
+		if floor, err := ring.Floor(ctx, c.score); err != nil || floor != c.floor {
+			t.Errorf("floor %d: got (%d, %v), want %d", c.score, floor, err, c.floor)
+		}
+	}
+}
+
+func Test_EtcdHashRing_AddRem(t *testing.T) {
+	ctx := context.Background()
+	ring := newTestHashRing(t, "test_add_rem")
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_a"} {
+		if err := ring.Add(ctx, 10, nodeID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nodeIDs, err := ring.Node(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(nodeIDs) != "[node_a node_b]" {
+		t.Fatalf("got node ids %v, want [node_a node_b]", nodeIDs)
+	}
+	if added, err := ring.AddIfAbsent(ctx, 10, "node_b"); err != nil || added {
+		t.Errorf("add existing node: got (%v, %v), want not added", added, err)
+	}
+
+	if err = ring.Rem(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.Rem(ctx, 10, "node_b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 虚拟节点的真实节点列表为空时，需要从环中移除
+	if _, err = ring.Node(ctx, 10); err == nil {
+		t.Error("empty virtual node should be removed from ring")
+	}
+	if score, err := ring.Ceiling(ctx, 0); err != nil || score != -1 {
+		t.Errorf("ceiling on emptied ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+	if err = ring.Rem(ctx, 10, "node_a"); err != nil {
+		t.Errorf("rem on missing score: got %v, want nil", err)
+	}
+}
+
+func Test_EtcdHashRing_Nodes(t *testing.T) {
+	ctx := context.Background()
+	ring := newTestHashRing(t, "test_nodes")
+
+	if err := ring.AddNodeToReplica(ctx, "node/a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.AddNodeToReplica(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.DeleteNodeToReplica(ctx, "node_b"); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := ring.Nodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes["node/a"] != 2 {
+		t.Errorf("got nodes %v, want map[node/a:2]", nodes)
+	}
+	if exists, err := ring.NodeExists(ctx, "node/a"); err != nil || !exists {
+		t.Errorf("node/a exists: got (%v, %v), want true", exists, err)
+	}
+	if exists, err := ring.NodeExists(ctx, "node_b"); err != nil || exists {
+		t.Errorf("node_b exists: got (%v, %v), want false", exists, err)
+	}
+}
+
+func Test_EtcdHashRing_DataKeys(t *testing.T) {
+	ctx := context.Background()
+	ring := newTestHashRing(t, "test_data_keys")
+
+	// 数据 key 的个数超过单个事务的操作个数上限，需要分批提交
+	dataKeys := make(map[string]struct{}, 2*maxTxnOps)
+	for i := 0; i < 2*maxTxnOps; i++ {
+		dataKeys[fmt.Sprintf("data_%d", i)] = struct{}{}
+	}
+	// 节点 id 互为前缀时数据 key 集合不能相互包含
+	if err := ring.AddNodeToDataKeys(ctx, "node", dataKeys); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.AddNodeToDataKeys(ctx, "node/a", map[string]struct{}{"a": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ring.DataKeys(ctx, "node"); err != nil || len(got) != len(dataKeys) {
+		t.Fatalf("got %d data keys (%v), want %d", len(got), err, len(dataKeys))
+	}
+
+	if err := ring.MoveDataKeys(ctx, "node", "node/a", dataKeys); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ring.DataKeys(ctx, "node"); err != nil || len(got) != 0 {
+		t.Errorf("got %d data keys (%v) after move, want 0", len(got), err)
+	}
+	if got, err := ring.DataKeys(ctx, "node/a"); err != nil || len(got) != len(dataKeys)+1 {
+		t.Errorf("got %d data keys (%v) after move, want %d", len(got), err, len(dataKeys)+1)
+	}
+
+	if err := ring.DeleteNodeToDataKeys(ctx, "node/a", dataKeys); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ring.DataKeys(ctx, "node/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["a"]; !ok || len(got) != 1 {
+		t.Errorf("got data keys %v, want [a]", got)
+	}
+	for _, c := range []struct {
+		nodeID, dataKey string
+		want            bool
+	}{
+		{"node/a", "a", true},
+		{"node/a", "data_0", false},
+		{"node", "a", false},
+	} {
+		if recorded, err := ring.DataKeyRecorded(ctx, c.nodeID, c.dataKey); err != nil || recorded != c.want {
+			t.Errorf("data key %s on %s: got (%t, %v), want %t", c.dataKey, c.nodeID, recorded, err, c.want)
+		}
+	}
+}
+
+func Test_EtcdHashRing_Lock(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	ring := NewEtcdHashRing("test_lock", client)
+	other := NewEtcdHashRing("test_lock", client)
+
+	if err := ring.Lock(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Lock(ctx, 10); !other.IsLockBusy(err) {
+		t.Fatalf("lock held by others: got %v, want concurrency.ErrLocked", err)
+	}
+	// 同一个哈希环实例上并发加锁同样需要互斥
+	if err := ring.Lock(ctx, 10); !ring.IsLockBusy(err) {
+		t.Fatalf("lock held by the same ring: got %v, want concurrency.ErrLocked", err)
+	}
+	if err := other.Unlock(ctx); err == nil {
+		t.Error("unlock without holding the lock should fail")
+	}
+	if err := ring.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Lock(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_EtcdHashRing_Generation(t *testing.T) {
+	ctx := context.Background()
+	ring := newTestHashRing(t, "test_generation")
+
+	if generation, err := ring.Generation(ctx); err != nil || generation != 0 {
+		t.Fatalf("got initial generation (%d, %v), want 0", generation, err)
+	}
+	for want := int64(1); want <= 3; want++ {
+		if generation, err := ring.IncrGeneration(ctx); err != nil || generation != want {
+			t.Fatalf("got generation (%d, %v), want %d", generation, err, want)
+		}
+	}
+}
+
+func Test_EtcdHashRing_PublishSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ring := newTestHashRing(t, "test_publish_subscribe")
+
+	payloads, err := ring.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 相同内容的消息同样需要逐条投递
+	for i := 0; i < 2; i++ {
+		if err = ring.Publish(ctx, "payload"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case payload := <-payloads:
+			if payload != "payload" {
+				t.Errorf("got payload %q, want %q", payload, "payload")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("payload not delivered")
+		}
+	}
+
+	cancel()
+	for range payloads {
+	}
+}
+
+// 在 etcd 与 redis 哈希环上执行相同的节点变更，数据 key 的归属以及登记需要保持一致
+func Test_EtcdHashRing_ConsistentHash(t *testing.T) {
+	ctx := context.Background()
+	migrator, _ := consistent_hash.NewRecordingMigrator()
+	etcdRing := newTestHashRing(t, "test_consistent_hash")
+	etcdHash := consistent_hash.NewConsistentHash(etcdRing, consistent_hash.NewMurmurHasher(), migrator)
+	redisRing := redis.NewRedisHashRing("test_consistent_hash", redis.NewClient("tcp", miniredis.RunT(t).Addr(), ""))
+	redisHash := consistent_hash.NewConsistentHash(redisRing, consistent_hash.NewMurmurHasher(), migrator)
+	hashes := []*consistent_hash.ConsistentHash{etcdHash, redisHash}
+
+	assertSameNodes := func() {
+		t.Helper()
+		for i := 0; i < 50; i++ {
+			dataKey := fmt.Sprintf("data_%d", i)
+			got, err := etcdHash.GetNode(ctx, dataKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := redisHash.GetNode(ctx, dataKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("data %s: got node %s, want %s", dataKey, got, want)
+			}
+		}
+
+		gotNodes, err := etcdRing.Nodes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantNodes, err := redisRing.Nodes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(gotNodes) != fmt.Sprint(wantNodes) {
+			t.Errorf("got nodes %v, want %v", gotNodes, wantNodes)
+		}
+	}
+
+	for _, c := range hashes {
+		if err := c.AddNode(ctx, "node_a", 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddNode(ctx, "node_b", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSameNodes()
+
+	for _, c := range hashes {
+		if err := c.AddNode(ctx, "node_c", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSameNodes()
+
+	for _, c := range hashes {
+		if err := c.RemoveNode(ctx, "node_c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSameNodes()
+
+	// 数据迁移后每个数据 key 都登记在其归属的真实节点下
+	for _, c := range hashes {
+		inconsistencies, err := c.Validate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(inconsistencies) != 0 {
+			t.Errorf("got inconsistencies %v", inconsistencies)
+		}
+	}
+}
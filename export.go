@@ -0,0 +1,162 @@
+package consistent_hash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// 导出格式的版本号，格式发生不兼容的变更时递增
+const ringExportVersion = 1
+
+// 哈希环完整状态的导出格式
+type ringExport struct {
+	Version int `json:"version"`
+	// 按照 score 从小到大排列的全部虚拟节点
+	VirtualNodes []exportedVirtualNode `json:"virtual_nodes"`
+	// 真实节点到虚拟节点个数的映射
+	Replicas map[string]int `json:"replicas"`
+	// 真实节点到数据 key 列表的映射
+	DataKeys map[string][]string `json:"data_keys"`
+	// 真实节点的元数据，哈希环未实现 NodeMetaStore 时为空
+	NodeMeta map[string]map[string]string `json:"node_meta,omitempty"`
+}
+
+type exportedVirtualNode struct {
+	Score int64 `json:"score"`
+	// 虚拟节点 key 列表，顺序决定了数据的归属，导入时保持原有顺序
+	RawNodeKeys []string `json:"raw_node_keys"`
+}
+
+// 将哈希环的完整状态（虚拟节点、真实节点的虚拟节点个数、数据 key 以及元数据）导出为 json，用于备份以及在不同存储之间迁移哈希环
+// 执行期间持有哈希环的锁
+func (c *ConsistentHash) Export(ctx context.Context) ([]byte, error) {
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer c.unlock(ctx)
+
+	replicas, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	virtualNodes, err := c.virtualNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	export := ringExport{
+		Version:      ringExportVersion,
+		VirtualNodes: make([]exportedVirtualNode, 0, len(virtualNodes)),
+		Replicas:     replicas,
+		DataKeys:     make(map[string][]string, len(replicas)),
+	}
+	for score, rawNodeKeys := range virtualNodes {
+		export.VirtualNodes = append(export.VirtualNodes, exportedVirtualNode{Score: score, RawNodeKeys: rawNodeKeys})
+	}
+	sort.Slice(export.VirtualNodes, func(i, j int) bool {
+		return export.VirtualNodes[i].Score < export.VirtualNodes[j].Score
+	})
+
+	metaStore, hasMeta := c.hashRing.(NodeMetaStore)
+	if hasMeta {
+		export.NodeMeta = make(map[string]map[string]string)
+	}
+	for nodeID := range replicas {
+		dataKeys, err := c.hashRing.DataKeys(ctx, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(dataKeys))
+		for dataKey := range dataKeys {
+			keys = append(keys, dataKey)
+		}
+		sort.Strings(keys)
+		export.DataKeys[nodeID] = keys
+
+		if !hasMeta {
+			continue
+		}
+		meta, err := metaStore.NodeMeta(ctx, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		if len(meta) > 0 {
+			export.NodeMeta[nodeID] = meta
+		}
+	}
+
+	return json.Marshal(export)
+}
+
+// 将 Export 导出的 json 恢复到哈希环中，哈希环中需要不存在任何真实节点以及虚拟节点，否则返回错误
+// 执行期间持有哈希环的锁，恢复的过程不会执行数据迁移。哈希环未实现 NodeMetaStore 时忽略导出的元数据
+// 导出与导入两侧需要使用相同的 encryptor、WithRingSize 以及 WithNodeKeyFormatter 等配置，否则虚拟节点的位置与配置不一致
+func (c *ConsistentHash) Import(ctx context.Context, data []byte) error {
+	var export ringExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return fmt.Errorf("unmarshal ring export failed, err: %w", err)
+	}
+	if export.Version != ringExportVersion {
+		return fmt.Errorf("unsupported ring export version: %d", export.Version)
+	}
+
+	if err := c.lock(ctx); err != nil {
+		return err
+	}
+	defer c.unlock(ctx)
+	c.invalidateDataKeyCache()
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	score, err := c.ceilingInclusive(ctx, 0)
+	if err != nil {
+		return err
+	}
+	if len(nodes) > 0 || score != -1 {
+		return errors.New("import into a non-empty ring")
+	}
+
+	for nodeID, replicas := range export.Replicas {
+		if err = c.hashRing.AddNodeToReplica(ctx, nodeID, replicas); err != nil {
+			return err
+		}
+	}
+
+	// 逐个添加以保持每个位置上虚拟节点 key 的顺序
+	for _, virtualNode := range export.VirtualNodes {
+		for _, rawNodeKey := range virtualNode.RawNodeKeys {
+			if err = c.hashRing.Add(ctx, virtualNode.Score, rawNodeKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	for nodeID, keys := range export.DataKeys {
+		if len(keys) == 0 {
+			continue
+		}
+		dataKeys := make(map[string]struct{}, len(keys))
+		for _, dataKey := range keys {
+			dataKeys[dataKey] = struct{}{}
+		}
+		if err = c.hashRing.AddNodeToDataKeys(ctx, nodeID, dataKeys); err != nil {
+			return err
+		}
+	}
+
+	if metaStore, ok := c.hashRing.(NodeMetaStore); ok {
+		for nodeID, meta := range export.NodeMeta {
+			if err = metaStore.SetNodeMeta(ctx, nodeID, meta); err != nil {
+				return err
+			}
+		}
+	}
+
+	return c.topologyChanged(ctx, RingEvent{Type: RingEventImported})
+}
@@ -0,0 +1,70 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func Test_Export_Import(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	for i, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNode(ctx, nodeID, i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const dataKeyCount = 200
+	want := make(map[string]string, dataKeyCount)
+	for i := 0; i < dataKeyCount; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		nodeID, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[dataKey] = nodeID
+	}
+
+	data, err := consistentHash.Export(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 清空原有的哈希环
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err = consistentHash.RemoveNode(ctx, nodeID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	imported := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	if err = imported.Import(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+	if err = imported.Import(ctx, data); err == nil {
+		t.Error("import into a non-empty ring: got nil error")
+	}
+
+	for dataKey, nodeID := range want {
+		recordedNode, _, consistent, err := imported.VerifyKeyPlacement(ctx, dataKey)
+		if err != nil || !consistent || recordedNode != nodeID {
+			t.Errorf("%s: recorded on %s (consistent %v, err %v), want %s", dataKey, recordedNode, consistent, err, nodeID)
+		}
+		got, err := imported.GetNode(ctx, dataKey)
+		if err != nil || got != nodeID {
+			t.Errorf("%s: got (%s, %v), want %s", dataKey, got, err, nodeID)
+		}
+	}
+
+	// 重新导出的结果与原始导出一致
+	reexported, err := imported.Export(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reexported) != string(data) {
+		t.Errorf("re-export mismatch:\n%s\n%s", reexported, data)
+	}
+}
@@ -1,12 +1,17 @@
 module github.com/pule1234/consistent_hash
 
-go 1.19
+go 1.20
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.0
 	github.com/demdxx/gocast v1.2.0
 	github.com/gomodule/redigo v1.8.9
 	github.com/spaolacci/murmur3 v1.1.0
 	github.com/xiaoxuxiansheng/redis_lock v0.0.0-20230830022514-0a735ab2dd39
 )
 
-require github.com/pkg/errors v0.9.1 // indirect
+require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/yuin/gopher-lua v0.0.0-20220504180219-658193537a64 // indirect
+)
@@ -2,19 +2,32 @@ package consistent_hash
 
 import "context"
 
+type lookupKey struct{}
+
+// 标记 ctx 来自数据 key 的路由查询，即 GetNode、BatchGetNode、GetNodeReadOnly、GetNodeDetailed
+func withLookup(ctx context.Context) context.Context {
+	return context.WithValue(ctx, lookupKey{}, struct{}{})
+}
+
+func isLookup(ctx context.Context) bool {
+	return ctx.Value(lookupKey{}) != nil
+}
+
 type HashRing interface {
 	// 锁住整个哈希环，在分布式场景下需要使用分布式锁
 	Lock(ctx context.Context, expireSeconds int) error
 	// 解锁哈希环
 	Unlock(ctx context.Context) error
 	// 将一个节点添加到哈希环中, 其中 virtualScore 为虚拟节点在哈希环中的位置，nodeID 为真实节点的 index
-	Add(ctx context.Context, virtualScore int32, nodeID string) error
+	Add(ctx context.Context, virtualScore int64, nodeID string) error
 	//在哈希环中找到virtualScore 顺时针往下的第一个虚拟节点的位置
-	Ceiling(ctx context.Context, virtualScore int32) (int32, error)
+	// 包含 virtualScore 自身，即 virtualScore 上存在虚拟节点时直接返回 virtualScore，超出最大的虚拟节点后回绕到最小的虚拟节点，空环返回 -1
+	Ceiling(ctx context.Context, virtualScore int64) (int64, error)
 	// 在哈希环中好到 virtualScore 逆时针往上的第一个虚拟节点位置
-	Floor(ctx context.Context, virtualScore int32) (int32, error)
+	// 与 Ceiling 一样包含 virtualScore 自身，小于最小的虚拟节点时回绕到最大的虚拟节点，空环返回 -1
+	Floor(ctx context.Context, virtualScore int64) (int64, error)
 	// 在哈希环 virtualScore 位置移除一个真实节点
-	Rem(ctx context.Context, virtualScore int32, nodeID string) error
+	Rem(ctx context.Context, virtualScore int64, nodeID string) error
 	// 查询哈希环中全量的真实节点，返回的结果为 map，其中 key 为真实节点 index，val 为真实节点对应的虚拟节点个数
 	Nodes(ctx context.Context) (map[string]int, error)
 	// 设置一个真实节点对应的虚拟节点个数，同时该操作背后的含义是将一个真实节点添加到一致性哈希模块中
@@ -22,7 +35,7 @@ type HashRing interface {
 	// 删除一个真实节点对应的虚拟节点个数，同时该操作背后的含义是将一个真实节点从一致性哈希模块中删除
 	DeleteNodeToReplica(ctx context.Context, nodeID string) error
 	// 查询哈希环 virtualScore 位置上对应的真实节点列表
-	Node(ctx context.Context, virtualScore int32) ([]string, error)
+	Node(ctx context.Context, virtualScore int64) ([]string, error)
 	// 查询某个真实节点存储的状态数据的key集合
 	DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error)
 	// 将一系列状态数据的 key 添加与某个真实节点建立映射关系
@@ -30,3 +43,123 @@ type HashRing interface {
 	// 将一系列状态数据的key删除与某个真实节点的映射关系
 	DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error
 }
+
+// 可选实现：支持批量添加虚拟节点的哈希环
+// 添加节点时倘若不需要执行数据迁移，虚拟节点之间不存在先后依赖，ConsistentHash 会通过该接口一次性添加全部虚拟节点
+type BatchAdder interface {
+	// 批量将真实节点添加到哈希环中，virtualNodes 为虚拟节点数值到待添加真实节点列表的映射
+	BatchAdd(ctx context.Context, virtualNodes map[int64][]string) error
+}
+
+// 可选实现：支持原子地检查并追加真实节点的哈希环
+// 添加节点时优先使用该接口，重复添加已经存在的虚拟节点时不会再次触发数据迁移
+type AddIfAbsenter interface {
+	// 真实节点 nodeID 不在 score 对应的虚拟节点中时将其追加到列表末尾，added 标识是否发生了追加
+	AddIfAbsent(ctx context.Context, score int64, nodeID string) (added bool, err error)
+}
+
+// 可选实现：支持一次性读取全部虚拟节点的哈希环
+type RingReader interface {
+	// 查询哈希环上全部的虚拟节点，返回虚拟节点数值到真实节点列表的映射
+	VirtualNodes(ctx context.Context) (map[int64][]string, error)
+}
+
+// 可选实现：支持一次性为多个真实节点登记数据 key 的哈希环
+type BatchDataKeysAdder interface {
+	// nodeDataKeys 为真实节点 id 到待登记数据 key 集合的映射
+	BatchAddNodeToDataKeys(ctx context.Context, nodeDataKeys map[string]map[string]struct{}) error
+}
+
+// 可选实现：支持为当前持有的锁续期的哈希环，配合 WithLockWatchDog 使用
+type LockRenewer interface {
+	// 将当前持有的锁的过期时间重置为 expireSeconds 秒
+	RenewLock(ctx context.Context, expireSeconds int) error
+}
+
+// 可选实现：能够识别出锁被其他持有者占用的哈希环，配合 WithLockMaxWait 使用
+// 锁被占用时 ConsistentHash 会在等待窗口内重试加锁，其余的错误（如连接异常）则直接返回
+type LockBusyChecker interface {
+	// 判断 Lock 返回的错误是否是因为锁已经被其他持有者占用
+	IsLockBusy(err error) bool
+}
+
+// 可选实现：支持直接查询单个真实节点是否存在的哈希环，避免拉取全量的真实节点
+type NodeChecker interface {
+	NodeExists(ctx context.Context, nodeID string) (bool, error)
+}
+
+// 可选实现：支持分页遍历真实节点数据 key 的哈希环，避免数据 key 很多时一次性加载到内存
+type DataKeysScanner interface {
+	// 从 cursor 开始遍历，count 为单次返回个数的参考值，返回的 next 为 0 时表示遍历结束
+	ScanDataKeys(ctx context.Context, nodeID string, cursor uint64, count int) ([]string, uint64, error)
+}
+
+// 可选实现：支持直接查询单个数据 key 是否登记在真实节点下的哈希环，避免拉取真实节点全量的数据 key
+type DataKeyRecorder interface {
+	DataKeyRecorded(ctx context.Context, nodeID, dataKey string) (bool, error)
+}
+
+// 可选实现：支持为真实节点存储元数据（如机房、机架、容量）的哈希环，配合 AddNodeWithMeta 使用
+type NodeMetaStore interface {
+	// 覆盖写入真实节点的元数据
+	SetNodeMeta(ctx context.Context, nodeID string, meta map[string]string) error
+	// 查询真实节点的元数据，不存在时返回空 map
+	NodeMeta(ctx context.Context, nodeID string) (map[string]string, error)
+	DeleteNodeMeta(ctx context.Context, nodeID string) error
+}
+
+// 可选实现：维护哈希环代数的哈希环，代数单调递增，用于感知拓扑变更
+type GenerationCounter interface {
+	// 查询当前的代数，从未变更过时为 0
+	Generation(ctx context.Context) (int64, error)
+	// 递增代数，并返回递增后的值
+	IncrGeneration(ctx context.Context) (int64, error)
+}
+
+// 可选实现：支持原子地在两个真实节点之间移动数据 key 的哈希环
+// 数据迁移时优先使用该接口，避免先删除后添加的过程中异常退出导致数据 key 丢失
+type DataKeysMover interface {
+	MoveDataKeys(ctx context.Context, from, to string, dataKeys map[string]struct{}) error
+}
+
+// 可选实现：支持发布与订阅拓扑变更消息的哈希环，配合 ConsistentHash.Watch 使用
+// 消息的内容由 ConsistentHash 负责编解码，哈希环只需要原样投递给全部订阅者
+type RingNotifier interface {
+	Publish(ctx context.Context, payload string) error
+	// 订阅返回前订阅需要已经生效，ctx 结束后关闭返回的 channel
+	Subscribe(ctx context.Context) (<-chan string, error)
+}
+
+// 可选实现：支持由只读副本执行路由查询的哈希环
+// ConsistentHash 只在数据 key 的路由查询中使用该接口，节点变更、数据迁移、校验等流程中的读操作仍然通过 Ceiling、Node 读取最新的数据
+// 副本可能落后于刚刚完成的拓扑变更，此时路由查询会返回变更前的节点
+type ReplicaReader interface {
+	// 与 Ceiling 的语义一致，由只读副本执行
+	ReplicaCeiling(ctx context.Context, virtualScore int64) (int64, error)
+	// 与 Node 的语义一致，由只读副本执行
+	ReplicaNode(ctx context.Context, virtualScore int64) ([]string, error)
+}
+
+// 可选实现：持有连接池等需要释放的资源的哈希环，配合 ConsistentHash.Close 使用
+type Closeable interface {
+	Close() error
+}
+
+// 可选实现：支持检查存储是否可达的哈希环，配合 ConsistentHash.Ping 使用
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// 可选实现：能够直接统计规模的哈希环，配合 ConsistentHash.Stats 使用，避免拉取全量的虚拟节点以及数据 key
+type RingCounter interface {
+	// 哈希环上虚拟节点位置的个数，位置冲突的虚拟节点只计一次
+	VirtualNodeCount(ctx context.Context) (int, error)
+	// 真实节点下登记的数据 key 个数
+	DataKeyCount(ctx context.Context, nodeID string) (int, error)
+}
+
+// 可选实现：持有连接池的哈希环，配合 ConsistentHash.Stats 使用
+type PoolStatsReporter interface {
+	// 返回连接池中活跃（含空闲）以及空闲的连接个数
+	PoolStats() (active, idle int)
+}
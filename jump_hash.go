@@ -0,0 +1,99 @@
+package consistent_hash
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 基于 Google jump consistent hash（https://arxiv.org/abs/1406.2294）的数据分配器，作为哈希环之外的另一种分配策略
+// 与哈希环相比的取舍：
+//  1. 不需要存储虚拟节点，内存占用只有真实节点列表本身，计算复杂度为 O(ln N)，分布也比虚拟节点更加均匀
+//  2. 真实节点只能按照编号 [0, N) 排列的桶进行分配，不支持权重，也没有数据 key 的登记、数据迁移以及分布式锁
+//  3. 只有在尾部增删节点时迁移量才是最小的（约 1/N 的数据），删除中间的节点时需要对桶重新编号，迁移量会变大
+//  4. 节点列表只保存在本地内存中，多个实例之间需要由使用方保证按照相同的顺序增删节点
+//
+// 因此适用于节点按照顺序扩缩容、且希望尽量节省存储的场景，例如分片编号固定的存储集群
+type JumpHash struct {
+	mu        sync.RWMutex
+	encryptor Encryptor
+	// 下标即为真实节点对应的桶编号
+	nodes []string
+}
+
+// encryptor 用于将数据 key 映射为 jump hash 的输入，为空时使用 Murmur128Hasher
+func NewJumpHash(encryptor Encryptor) *JumpHash {
+	if encryptor == nil {
+		encryptor = NewMurmur128Hasher()
+	}
+	return &JumpHash{encryptor: encryptor}
+}
+
+// 将真实节点添加到尾部，占用编号为 N 的新桶
+func (j *JumpHash) AddNode(nodeID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.index(nodeID) >= 0 {
+		return fmt.Errorf("repeat node: %s, err: %w", nodeID, ErrNodeExists)
+	}
+	j.nodes = append(j.nodes, nodeID)
+	return nil
+}
+
+// 删除真实节点，删除尾部节点时只有该节点上的数据发生迁移
+// 删除中间的节点时，尾部的节点会被重新编号到空出来的桶中，因此该节点和尾部节点上的数据都会发生迁移
+func (j *JumpHash) RemoveNode(nodeID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	index := j.index(nodeID)
+	if index < 0 {
+		return fmt.Errorf("invalid node id: %s, err: %w", nodeID, ErrNodeNotFound)
+	}
+
+	last := len(j.nodes) - 1
+	j.nodes[index] = j.nodes[last]
+	j.nodes = j.nodes[:last]
+	return nil
+}
+
+// 检索数据所对应的真实节点
+func (j *JumpHash) GetNode(dataKey string) (string, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if len(j.nodes) == 0 {
+		return "", ErrRingEmpty
+	}
+	return j.nodes[jumpHash(uint64(j.encryptor.Encrypt(dataKey)), len(j.nodes))], nil
+}
+
+// 按照桶编号的顺序返回全部真实节点
+func (j *JumpHash) Nodes() []string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	nodes := make([]string, len(j.nodes))
+	copy(nodes, j.nodes)
+	return nodes
+}
+
+func (j *JumpHash) index(nodeID string) int {
+	for i, node := range j.nodes {
+		if node == nodeID {
+			return i
+		}
+	}
+	return -1
+}
+
+// jump consistent hash 算法，将 key 映射到 [0, buckets) 中的一个桶
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
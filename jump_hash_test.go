@@ -0,0 +1,78 @@
+package consistent_hash
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func Test_JumpHash(t *testing.T) {
+	jumpHash := NewJumpHash(nil)
+	if _, err := jumpHash.GetNode("data"); !errors.Is(err, ErrRingEmpty) {
+		t.Errorf("empty jump hash: got %v, want ErrRingEmpty", err)
+	}
+
+	const dataKeyCount = 10000
+	placement := func() map[string]string {
+		res := make(map[string]string, dataKeyCount)
+		for i := 0; i < dataKeyCount; i++ {
+			dataKey := fmt.Sprintf("data_%d", i)
+			nodeID, err := jumpHash.GetNode(dataKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res[dataKey] = nodeID
+		}
+		return res
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := jumpHash.AddNode(fmt.Sprintf("node_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := jumpHash.AddNode("node_0"); !errors.Is(err, ErrNodeExists) {
+		t.Errorf("repeat node: got %v, want ErrNodeExists", err)
+	}
+
+	// 尾部新增节点时，约 1/N 的数据迁移到新节点，其余数据保持不动
+	before := placement()
+	if err := jumpHash.AddNode("node_10"); err != nil {
+		t.Fatal(err)
+	}
+	after := placement()
+	var moved int
+	for dataKey, nodeID := range after {
+		if nodeID == before[dataKey] {
+			continue
+		}
+		moved++
+		if nodeID != "node_10" {
+			t.Fatalf("%s moved from %s to %s, want node_10", dataKey, before[dataKey], nodeID)
+		}
+	}
+	if ratio := float64(moved) / dataKeyCount; ratio < 0.07 || ratio > 0.11 {
+		t.Errorf("adding the 11th node moved %.3f of data keys, want close to 1/11", ratio)
+	}
+
+	// 删除尾部节点后恢复到之前的分配
+	if err := jumpHash.RemoveNode("node_10"); err != nil {
+		t.Fatal(err)
+	}
+	for dataKey, nodeID := range placement() {
+		if nodeID != before[dataKey] {
+			t.Fatalf("%s: got %s after removing the tail node, want %s", dataKey, nodeID, before[dataKey])
+		}
+	}
+
+	// 删除中间的节点时，尾部节点被重新编号到空出来的桶
+	if err := jumpHash.RemoveNode("node_3"); err != nil {
+		t.Fatal(err)
+	}
+	if nodes := jumpHash.Nodes(); len(nodes) != 9 || nodes[3] != "node_9" {
+		t.Errorf("got nodes %v, want node_9 renumbered to bucket 3", nodes)
+	}
+	if err := jumpHash.RemoveNode("node_3"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("remove missing node: got %v, want ErrNodeNotFound", err)
+	}
+}
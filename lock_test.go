@@ -0,0 +1,124 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func Test_WithLockMaxWait_Contended(t *testing.T) {
+	ctx := context.Background()
+	hashRing := memory.NewHashRing()
+
+	// holder 在数据迁移期间持有锁，直到 release 被关闭
+	locked, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		once.Do(func() { close(locked) })
+		<-release
+		return nil
+	}
+	holder := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, WithReplicas(1))
+	if err := holder.AddNode(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	// 登记足够多的数据 key，保证添加节点 b 时一定有数据需要迁移
+	for i := 0; i < 100; i++ {
+		if _, err := holder.GetNode(ctx, fmt.Sprintf("data_key_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- holder.AddNode(ctx, "b", 1)
+	}()
+	select {
+	case <-locked:
+	case err := <-done:
+		t.Fatalf("expect migration to hold the lock, got err: %v", err)
+	}
+
+	waiter := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithReplicas(1), WithLockMaxWait(100*time.Millisecond))
+	start := time.Now()
+	_, err := waiter.GetNode(ctx, "data_key")
+	if !errors.Is(err, ErrRingLocked) {
+		t.Fatalf("got err: %v, expect: %v", err, ErrRingLocked)
+	}
+	if cost := time.Since(start); cost > time.Second {
+		t.Fatalf("lock wait cost %s, expect about 100ms", cost)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waiter.GetNode(ctx, "data_key"); err != nil {
+		t.Fatalf("expect lock to be acquired after release, got err: %v", err)
+	}
+}
+
+var errMockLockBusy = errors.New("mock lock busy")
+
+// 前 busy 次加锁返回锁被占用的错误，lockErr 非空时每次加锁都返回 lockErr
+type busyHashRing struct {
+	HashRing
+	busy    int
+	lockErr error
+	calls   int
+}
+
+func (r *busyHashRing) Lock(ctx context.Context, expireSeconds int) error {
+	r.calls++
+	if r.lockErr != nil {
+		return r.lockErr
+	}
+	if r.calls <= r.busy {
+		return fmt.Errorf("reply: 0, err: %w", errMockLockBusy)
+	}
+	return r.HashRing.Lock(ctx, expireSeconds)
+}
+
+func (r *busyHashRing) IsLockBusy(err error) bool {
+	return errors.Is(err, errMockLockBusy)
+}
+
+func Test_WithLockMaxWait_Busy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retry until acquired", func(t *testing.T) {
+		hashRing := &busyHashRing{HashRing: memory.NewHashRing(), busy: 2}
+		c := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithLockMaxWait(time.Second))
+		if err := c.AddNode(ctx, "a", 1); err != nil {
+			t.Fatal(err)
+		}
+		if hashRing.calls != 3 {
+			t.Fatalf("got lock calls: %d, expect: 3", hashRing.calls)
+		}
+	})
+
+	t.Run("busy until timeout", func(t *testing.T) {
+		hashRing := &busyHashRing{HashRing: memory.NewHashRing(), busy: 1 << 30}
+		c := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithLockMaxWait(120*time.Millisecond))
+		if err := c.AddNode(ctx, "a", 1); !errors.Is(err, ErrRingLocked) {
+			t.Fatalf("got err: %v, expect: %v", err, ErrRingLocked)
+		}
+	})
+
+	t.Run("connection error", func(t *testing.T) {
+		connErr := errors.New("mock connection refused")
+		hashRing := &busyHashRing{HashRing: memory.NewHashRing(), lockErr: connErr}
+		c := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithLockMaxWait(time.Second))
+		if err := c.AddNode(ctx, "a", 1); !errors.Is(err, connErr) || errors.Is(err, ErrRingLocked) {
+			t.Fatalf("got err: %v, expect: %v", err, connErr)
+		}
+		if hashRing.calls != 1 {
+			t.Fatalf("got lock calls: %d, expect: 1", hashRing.calls)
+		}
+	})
+}
@@ -0,0 +1,17 @@
+package consistent_hash
+
+// 日志打印器，使用方可以通过 WithLogger 注入自定义的实现
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// 默认的日志打印器，不输出任何内容
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, v ...interface{}) {}
+
+func (nopLogger) Infof(format string, v ...interface{}) {}
+
+func (nopLogger) Errorf(format string, v ...interface{}) {}
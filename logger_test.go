@@ -0,0 +1,84 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+// 将日志按照级别记录在内存中，便于断言
+type captureLogger struct {
+	mu     sync.Mutex
+	debugs []string
+	infos  []string
+	errors []string
+}
+
+func (l *captureLogger) Debugf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debugs = append(l.debugs, fmt.Sprintf(format, v...))
+}
+
+func (l *captureLogger) Infof(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.infos = append(l.infos, fmt.Sprintf(format, v...))
+}
+
+func (l *captureLogger) Errorf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, v...))
+}
+
+func Test_WithLogger_MigrateError(t *testing.T) {
+	ctx := context.Background()
+	logger := &captureLogger{}
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return errors.New("target unreachable")
+	}
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, WithLogger(logger))
+
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := consistentHash.AddNode(ctx, "node_b", 1); err == nil {
+		t.Fatal("add node with failing migrator should return error")
+	}
+
+	if len(logger.debugs) == 0 {
+		t.Error("lock acquisition should be logged")
+	}
+	if len(logger.infos) == 0 {
+		t.Error("migrate task dispatch should be logged")
+	}
+	if len(logger.errors) != len(logger.infos) {
+		t.Errorf("got %d error logs for %d migrate tasks", len(logger.errors), len(logger.infos))
+	}
+	for _, msg := range logger.errors {
+		if !strings.Contains(msg, "target unreachable") {
+			t.Errorf("error log %q should contain the migrate error", msg)
+		}
+	}
+}
+
+func Test_WithLogger_Default(t *testing.T) {
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	if consistentHash.opts.logger == nil {
+		t.Fatal("default logger should not be nil")
+	}
+	if err := consistentHash.AddNode(context.Background(), "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+}
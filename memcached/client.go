@@ -0,0 +1,281 @@
+package memcached
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// key 不存在
+	ErrCacheMiss = errors.New("memcached cache miss")
+	// add 时 key 已经存在
+	ErrNotStored = errors.New("memcached not stored")
+	// cas 时 key 已经被其他请求修改
+	ErrCASConflict = errors.New("memcached compare and swap conflict")
+)
+
+const (
+	// 默认最大空闲连接数
+	DefaultMaxIdle = 20
+	// 默认建立连接的超时时间
+	DefaultDialTimeout = 3 * time.Second
+)
+
+// 一条缓存数据，CAS 为 gets 返回的版本号，用于 CompareAndSwap
+type Item struct {
+	Key   string
+	Value []byte
+	CAS   uint64
+}
+
+// 基于 memcached 文本协议实现的客户端，只包含哈希环需要用到的命令
+type Client struct {
+	network string
+	address string
+	// 空闲连接
+	idle chan *conn
+}
+
+type conn struct {
+	net.Conn
+	rw *bufio.ReadWriter
+}
+
+func NewClient(network, address string) *Client {
+	return &Client{
+		network: network,
+		address: address,
+		idle:    make(chan *conn, DefaultMaxIdle),
+	}
+}
+
+func (c *Client) getConn(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: DefaultDialTimeout}
+	netConn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{
+		Conn: netConn,
+		rw:   bufio.NewReadWriter(bufio.NewReader(netConn), bufio.NewWriter(netConn)),
+	}, nil
+}
+
+// 归还连接，发生过网络错误的连接可能残留未读取的响应，需要直接关闭
+func (c *Client) putConn(cn *conn, err error) {
+	if err != nil && !isResponseErr(err) {
+		_ = cn.Close()
+		return
+	}
+	select {
+	case c.idle <- cn:
+	default:
+		_ = cn.Close()
+	}
+}
+
+// 是否是 memcached 正常返回的业务错误，此时连接仍然可以复用
+func isResponseErr(err error) bool {
+	return errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrNotStored) || errors.Is(err, ErrCASConflict)
+}
+
+// 发送一条命令并交由 handle 解析响应
+func (c *Client) do(ctx context.Context, handle func(rw *bufio.ReadWriter) error) (err error) {
+	cn, err := c.getConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { c.putConn(cn, err) }()
+
+	// ctx 没有截止时间时为零值，即不设置读写超时
+	deadline, _ := ctx.Deadline()
+	if err = cn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	return handle(cn.rw)
+}
+
+func readLine(rw *bufio.ReadWriter) (string, error) {
+	line, err := rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", fmt.Errorf("memcached reply error: %s", line)
+	}
+	return line, nil
+}
+
+// 查询 key 对应的数据以及版本号，key 不存在时返回 ErrCacheMiss
+func (c *Client) Gets(ctx context.Context, key string) (*Item, error) {
+	var item *Item
+	err := c.do(ctx, func(rw *bufio.ReadWriter) error {
+		if _, err := fmt.Fprintf(rw, "gets %s\r\n", key); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		for {
+			line, err := readLine(rw)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+
+			// VALUE <key> <flags> <bytes> <cas unique>
+			fields := strings.Fields(line)
+			if len(fields) != 5 || fields[0] != "VALUE" {
+				return fmt.Errorf("memcached gets failed, invalid reply: %s", line)
+			}
+			size, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return fmt.Errorf("memcached gets failed, invalid reply: %s", line)
+			}
+			cas, err := strconv.ParseUint(fields[4], 10, 64)
+			if err != nil {
+				return fmt.Errorf("memcached gets failed, invalid reply: %s", line)
+			}
+
+			buf := make([]byte, size+2)
+			if _, err = io.ReadFull(rw, buf); err != nil {
+				return err
+			}
+			item = &Item{Key: fields[1], Value: buf[:size], CAS: cas}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, ErrCacheMiss
+	}
+	return item, nil
+}
+
+// 执行 set、add、cas 等存储命令，expireSeconds 为 0 时永不过期
+func (c *Client) store(ctx context.Context, cmd string, item *Item, expireSeconds int) error {
+	return c.do(ctx, func(rw *bufio.ReadWriter) error {
+		if cmd == "cas" {
+			_, _ = fmt.Fprintf(rw, "cas %s 0 %d %d %d\r\n", item.Key, expireSeconds, len(item.Value), item.CAS)
+		} else {
+			_, _ = fmt.Fprintf(rw, "%s %s 0 %d %d\r\n", cmd, item.Key, expireSeconds, len(item.Value))
+		}
+		_, _ = rw.Write(item.Value)
+		_, _ = rw.WriteString("\r\n")
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		line, err := readLine(rw)
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "STORED":
+			return nil
+		case "NOT_STORED":
+			return ErrNotStored
+		case "EXISTS":
+			return ErrCASConflict
+		case "NOT_FOUND":
+			return ErrCacheMiss
+		}
+		return fmt.Errorf("memcached %s failed, invalid reply: %s", cmd, line)
+	})
+}
+
+func (c *Client) Set(ctx context.Context, key string, val []byte, expireSeconds int) error {
+	return c.store(ctx, "set", &Item{Key: key, Value: val}, expireSeconds)
+}
+
+// 仅当 key 不存在时写入，key 已经存在时返回 ErrNotStored
+func (c *Client) Add(ctx context.Context, key string, val []byte, expireSeconds int) error {
+	return c.store(ctx, "add", &Item{Key: key, Value: val}, expireSeconds)
+}
+
+// 仅当 key 的版本号与 item.CAS 一致时写入，版本号不一致时返回 ErrCASConflict，key 不存在时返回 ErrCacheMiss
+func (c *Client) CompareAndSwap(ctx context.Context, item *Item, expireSeconds int) error {
+	return c.store(ctx, "cas", item, expireSeconds)
+}
+
+// 执行只返回一行响应的命令
+func (c *Client) simple(ctx context.Context, cmd string) (string, error) {
+	var line string
+	err := c.do(ctx, func(rw *bufio.ReadWriter) error {
+		if _, err := rw.WriteString(cmd + "\r\n"); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		var err error
+		line, err = readLine(rw)
+		return err
+	})
+	return line, err
+}
+
+// 删除 key，key 不存在时返回 ErrCacheMiss
+func (c *Client) Delete(ctx context.Context, key string) error {
+	line, err := c.simple(ctx, "delete "+key)
+	if err != nil {
+		return err
+	}
+	switch line {
+	case "DELETED":
+		return nil
+	case "NOT_FOUND":
+		return ErrCacheMiss
+	}
+	return fmt.Errorf("memcached delete failed, invalid reply: %s", line)
+}
+
+// 将 key 对应的数值加 1 并返回递增后的值，key 不存在时返回 ErrCacheMiss
+func (c *Client) Incr(ctx context.Context, key string) (int64, error) {
+	line, err := c.simple(ctx, "incr "+key+" 1")
+	if err != nil {
+		return 0, err
+	}
+	if line == "NOT_FOUND" {
+		return 0, ErrCacheMiss
+	}
+	val, err := strconv.ParseInt(line, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("memcached incr failed, invalid reply: %s", line)
+	}
+	return val, nil
+}
+
+// 重置 key 的过期时间，key 不存在时返回 ErrCacheMiss
+func (c *Client) Touch(ctx context.Context, key string, expireSeconds int) error {
+	line, err := c.simple(ctx, fmt.Sprintf("touch %s %d", key, expireSeconds))
+	if err != nil {
+		return err
+	}
+	switch line {
+	case "TOUCHED":
+		return nil
+	case "NOT_FOUND":
+		return ErrCacheMiss
+	}
+	return fmt.Errorf("memcached touch failed, invalid reply: %s", line)
+}
@@ -0,0 +1,502 @@
+package memcached
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// 锁已经被其他持有者占用
+var ErrLockAcquiredByOthers = errors.New("memcached ring lock acquired by others")
+
+// 基于 CAS 的读改写在并发冲突时的最大重试次数
+const maxCASRetries = 16
+
+// 基于 memcached 实现的哈希环
+// memcached 不支持有序集合，虚拟节点的位置以有序列表的 json 串存储在同一个 key 中，通过 CAS 保证并发写入的正确性。
+// 因此 Ceiling、Floor 以及虚拟节点的增删都需要读取整个列表，时间与网络开销为 O(n)，n 为虚拟节点个数；
+// 同时受 memcached 单个 value 的大小限制（默认 1MB），只适用于虚拟节点个数在数万以内的哈希环。
+// memcached 中的数据可能被淘汰，生产环境需要为其预留足够的内存
+type MemcachedHashRing struct {
+	// 哈希环维度的唯一键
+	key    string
+	client *Client
+
+	// 当前持有的锁的 token，解锁以及续期时需要校验
+	mu        sync.Mutex
+	lockToken string
+}
+
+func NewMemcachedHashRing(key string, client *Client) *MemcachedHashRing {
+	return &MemcachedHashRing{
+		key:    key,
+		client: client,
+	}
+}
+
+func (m *MemcachedHashRing) getLockKey() string {
+	return fmt.Sprintf("memcached:consistent_hash:ring:lock:%s", m.key)
+}
+
+// 哈希环上全部虚拟节点位置的有序列表
+func (m *MemcachedHashRing) getScoresKey() string {
+	return fmt.Sprintf("memcached:consistent_hash:ring:score:%s", m.key)
+}
+
+// 虚拟节点位置对应的真实节点列表
+func (m *MemcachedHashRing) getScoreNodeKey(score int64) string {
+	return fmt.Sprintf("memcached:consistent_hash:ring:score_node:%s:%d", m.key, score)
+}
+
+func (m *MemcachedHashRing) getGenerationKey() string {
+	return fmt.Sprintf("memcached:consistent_hash:ring:generation:%s", m.key)
+}
+
+func (m *MemcachedHashRing) getNodeReplicaKey() string {
+	return fmt.Sprintf("memcached:consistent_hash:ring:node:replica:%s", m.key)
+}
+
+// 真实节点下的状态数据 key 集合，memcached 的 key 不能包含空白字符，因此对 nodeID 进行编码
+func (m *MemcachedHashRing) getNodeDataKey(nodeID string) string {
+	return fmt.Sprintf("memcached:consistent_hash:ring:node:data_set:%s:%s", m.key, base64.RawURLEncoding.EncodeToString([]byte(nodeID)))
+}
+
+// 锁住哈希环，基于 add 命令实现，达到过期时间后会自动释放锁
+// 锁已经被占用时不会阻塞，直接返回 ErrLockAcquiredByOthers
+func (m *MemcachedHashRing) Lock(ctx context.Context, expireSeconds int) error {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(buf)
+
+	err := m.client.Add(ctx, m.getLockKey(), []byte(token), expireSeconds)
+	if errors.Is(err, ErrNotStored) {
+		return ErrLockAcquiredByOthers
+	}
+	if err != nil {
+		return fmt.Errorf("memcached ring lock failed, err: %w", err)
+	}
+
+	m.mu.Lock()
+	m.lockToken = token
+	m.mu.Unlock()
+	return nil
+}
+
+// 解锁哈希环，只有锁的持有者才能解锁
+// memcached 的 delete 不支持 CAS，校验 token 与删除之间锁恰好过期并被其他持有者获取时，会误删他人的锁
+func (m *MemcachedHashRing) Unlock(ctx context.Context) error {
+	m.mu.Lock()
+	token := m.lockToken
+	m.lockToken = ""
+	m.mu.Unlock()
+
+	if err := m.checkLockOwner(ctx, token); err != nil {
+		return fmt.Errorf("memcached ring unlock failed, err: %w", err)
+	}
+	if err := m.client.Delete(ctx, m.getLockKey()); err != nil {
+		return fmt.Errorf("memcached ring unlock failed, err: %w", err)
+	}
+	return nil
+}
+
+func (m *MemcachedHashRing) checkLockOwner(ctx context.Context, token string) error {
+	if token == "" {
+		return errors.New("lock not held")
+	}
+	item, err := m.client.Gets(ctx, m.getLockKey())
+	if err != nil {
+		return err
+	}
+	if string(item.Value) != token {
+		return errors.New("lock held by others")
+	}
+	return nil
+}
+
+// 判断 Lock 返回的错误是否是因为锁已经被其他持有者占用
+func (m *MemcachedHashRing) IsLockBusy(err error) bool {
+	return errors.Is(err, ErrLockAcquiredByOthers)
+}
+
+// 将当前持有的锁的过期时间重置为 expireSeconds 秒
+func (m *MemcachedHashRing) RenewLock(ctx context.Context, expireSeconds int) error {
+	m.mu.Lock()
+	token := m.lockToken
+	m.mu.Unlock()
+
+	if err := m.checkLockOwner(ctx, token); err != nil {
+		return fmt.Errorf("memcached ring renew lock failed, err: %w", err)
+	}
+	if err := m.client.Touch(ctx, m.getLockKey(), expireSeconds); err != nil {
+		return fmt.Errorf("memcached ring renew lock failed, err: %w", err)
+	}
+	return nil
+}
+
+// 基于 CAS 的读改写，fn 接收当前值（key 不存在时为 nil）并返回新值，返回 nil 时删除 key
+// 写入期间 key 被其他请求修改时重新读取并重试
+func (m *MemcachedHashRing) update(ctx context.Context, key string, fn func(val []byte) ([]byte, error)) error {
+	for i := 0; i < maxCASRetries; i++ {
+		item, err := m.client.Gets(ctx, key)
+		if err != nil && !errors.Is(err, ErrCacheMiss) {
+			return err
+		}
+
+		var oldVal []byte
+		if item != nil {
+			oldVal = item.Value
+		}
+		newVal, err := fn(oldVal)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case item == nil && newVal == nil:
+			return nil
+		case item == nil:
+			err = m.client.Add(ctx, key, newVal, 0)
+		case newVal == nil:
+			err = m.client.Delete(ctx, key)
+		case bytes.Equal(oldVal, newVal):
+			return nil
+		default:
+			err = m.client.CompareAndSwap(ctx, &Item{Key: key, Value: newVal, CAS: item.CAS}, 0)
+		}
+
+		// 并发写入冲突，重新读取后重试
+		if errors.Is(err, ErrNotStored) || errors.Is(err, ErrCASConflict) || errors.Is(err, ErrCacheMiss) {
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("update %s failed after %d retries, err: %w", key, maxCASRetries, ErrCASConflict)
+}
+
+// 读取 json 串并解析到 v 中，key 不存在时保持 v 不变
+func (m *MemcachedHashRing) get(ctx context.Context, key string, v interface{}) error {
+	item, err := m.client.Gets(ctx, key)
+	if errors.Is(err, ErrCacheMiss) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(item.Value, v)
+}
+
+func (m *MemcachedHashRing) scores(ctx context.Context) ([]int64, error) {
+	var scores []int64
+	if err := m.get(ctx, m.getScoresKey(), &scores); err != nil {
+		return nil, fmt.Errorf("memcached ring get scores failed, err: %w", err)
+	}
+	return scores, nil
+}
+
+// 在有序列表中插入或者删除 score
+func (m *MemcachedHashRing) updateScores(ctx context.Context, score int64, add bool) error {
+	return m.update(ctx, m.getScoresKey(), func(val []byte) ([]byte, error) {
+		var scores []int64
+		if val != nil {
+			if err := json.Unmarshal(val, &scores); err != nil {
+				return nil, err
+			}
+		}
+
+		index := sort.Search(len(scores), func(i int) bool { return scores[i] >= score })
+		exists := index < len(scores) && scores[index] == score
+		switch {
+		case add && !exists:
+			scores = append(scores, 0)
+			copy(scores[index+1:], scores[index:])
+			scores[index] = score
+		case !add && exists:
+			scores = append(scores[:index], scores[index+1:]...)
+		}
+
+		if len(scores) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(scores)
+	})
+}
+
+// 真实节点入环. 将一个真实节点 nodeID 添加到 score 对应的虚拟节点中，重复添加同一个真实节点不会产生影响
+func (m *MemcachedHashRing) Add(ctx context.Context, score int64, nodeID string) error {
+	if err := m.update(ctx, m.getScoreNodeKey(score), func(val []byte) ([]byte, error) {
+		var nodeIDs []string
+		if val != nil {
+			if err := json.Unmarshal(val, &nodeIDs); err != nil {
+				return nil, err
+			}
+		}
+		for _, _nodeID := range nodeIDs {
+			if _nodeID == nodeID {
+				return val, nil
+			}
+		}
+		return json.Marshal(append(nodeIDs, nodeID))
+	}); err != nil {
+		return fmt.Errorf("memcached ring add failed, err: %w", err)
+	}
+
+	// 先写入真实节点列表再写入位置，保证 Ceiling 检索到的位置一定存在真实节点列表
+	if err := m.updateScores(ctx, score, true); err != nil {
+		return fmt.Errorf("memcached ring add score failed, err: %w", err)
+	}
+	return nil
+}
+
+// 从哈希环对应于 score 的虚拟节点删去真实节点 nodeID，真实节点列表为空时同时删除该虚拟节点
+func (m *MemcachedHashRing) Rem(ctx context.Context, score int64, nodeID string) error {
+	var empty bool
+	if err := m.update(ctx, m.getScoreNodeKey(score), func(val []byte) ([]byte, error) {
+		if val == nil {
+			return nil, fmt.Errorf("score %d not exist", score)
+		}
+
+		var nodeIDs []string
+		if err := json.Unmarshal(val, &nodeIDs); err != nil {
+			return nil, err
+		}
+
+		newNodeIDs := make([]string, 0, len(nodeIDs))
+		for _, _nodeID := range nodeIDs {
+			if _nodeID != nodeID {
+				newNodeIDs = append(newNodeIDs, _nodeID)
+			}
+		}
+		if empty = len(newNodeIDs) == 0; empty {
+			return nil, nil
+		}
+		return json.Marshal(newNodeIDs)
+	}); err != nil {
+		return fmt.Errorf("memcached ring rem failed, err: %w", err)
+	}
+
+	if !empty {
+		return nil
+	}
+	if err := m.updateScores(ctx, score, false); err != nil {
+		return fmt.Errorf("memcached ring rem score failed, err: %w", err)
+	}
+	return nil
+}
+
+// 从哈希环中获取到 score 顺时针往下的第一个虚拟节点数值，哈希环为空时返回 -1
+func (m *MemcachedHashRing) Ceiling(ctx context.Context, score int64) (int64, error) {
+	scores, err := m.scores(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(scores) == 0 {
+		return -1, nil
+	}
+
+	index := sort.Search(len(scores), func(i int) bool { return scores[i] >= score })
+	// 超出最大的虚拟节点后回绕到环上的第一个虚拟节点
+	if index == len(scores) {
+		return scores[0], nil
+	}
+	return scores[index], nil
+}
+
+// 从哈希环中获取到 score 逆时针往上的第一个虚拟节点数值，哈希环为空时返回 -1
+func (m *MemcachedHashRing) Floor(ctx context.Context, score int64) (int64, error) {
+	scores, err := m.scores(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(scores) == 0 {
+		return -1, nil
+	}
+
+	index := sort.Search(len(scores), func(i int) bool { return scores[i] > score })
+	// 小于最小的虚拟节点时回绕到环上的最后一个虚拟节点
+	if index == 0 {
+		return scores[len(scores)-1], nil
+	}
+	return scores[index-1], nil
+}
+
+func (m *MemcachedHashRing) Node(ctx context.Context, score int64) ([]string, error) {
+	item, err := m.client.Gets(ctx, m.getScoreNodeKey(score))
+	if errors.Is(err, ErrCacheMiss) {
+		return nil, fmt.Errorf("memcached ring node failed, score %d not exist", score)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("memcached ring node failed, err: %w", err)
+	}
+
+	var nodeIDs []string
+	if err = json.Unmarshal(item.Value, &nodeIDs); err != nil {
+		return nil, err
+	}
+	return nodeIDs, nil
+}
+
+func (m *MemcachedHashRing) Nodes(ctx context.Context) (map[string]int, error) {
+	nodes := make(map[string]int)
+	if err := m.get(ctx, m.getNodeReplicaKey(), &nodes); err != nil {
+		return nil, fmt.Errorf("memcached ring nodes failed, err: %w", err)
+	}
+	return nodes, nil
+}
+
+func (m *MemcachedHashRing) NodeExists(ctx context.Context, nodeID string) (bool, error) {
+	nodes, err := m.Nodes(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, ok := nodes[nodeID]
+	return ok, nil
+}
+
+// 在真实节点到虚拟节点个数的映射中设置或者删除 nodeID
+func (m *MemcachedHashRing) updateNodeReplica(ctx context.Context, nodeID string, replicas int, del bool) error {
+	return m.update(ctx, m.getNodeReplicaKey(), func(val []byte) ([]byte, error) {
+		nodes := make(map[string]int)
+		if val != nil {
+			if err := json.Unmarshal(val, &nodes); err != nil {
+				return nil, err
+			}
+		}
+
+		if del {
+			delete(nodes, nodeID)
+		} else {
+			nodes[nodeID] = replicas
+		}
+		if len(nodes) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(nodes)
+	})
+}
+
+func (m *MemcachedHashRing) AddNodeToReplica(ctx context.Context, nodeID string, replicas int) error {
+	if err := m.updateNodeReplica(ctx, nodeID, replicas, false); err != nil {
+		return fmt.Errorf("memcached ring add node to replica failed, err: %w", err)
+	}
+	return nil
+}
+
+func (m *MemcachedHashRing) DeleteNodeToReplica(ctx context.Context, nodeID string) error {
+	if err := m.updateNodeReplica(ctx, nodeID, 0, true); err != nil {
+		return fmt.Errorf("memcached ring delete node to replica failed, err: %w", err)
+	}
+	return nil
+}
+
+// 查询哈希环的代数，代数不存在时为 0
+func (m *MemcachedHashRing) Generation(ctx context.Context) (int64, error) {
+	item, err := m.client.Gets(ctx, m.getGenerationKey())
+	if errors.Is(err, ErrCacheMiss) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("memcached ring get generation failed, err: %w", err)
+	}
+	return strconv.ParseInt(string(item.Value), 10, 64)
+}
+
+// 递增哈希环的代数，memcached 的 incr 不会自动创建 key，因此 key 不存在时先通过 add 初始化
+func (m *MemcachedHashRing) IncrGeneration(ctx context.Context) (int64, error) {
+	for i := 0; i < maxCASRetries; i++ {
+		generation, err := m.client.Incr(ctx, m.getGenerationKey())
+		if err == nil {
+			return generation, nil
+		}
+		if !errors.Is(err, ErrCacheMiss) {
+			return 0, fmt.Errorf("memcached ring incr generation failed, err: %w", err)
+		}
+
+		err = m.client.Add(ctx, m.getGenerationKey(), []byte("1"), 0)
+		if err == nil {
+			return 1, nil
+		}
+		// 其他请求已经完成了初始化，重新执行 incr
+		if !errors.Is(err, ErrNotStored) {
+			return 0, fmt.Errorf("memcached ring incr generation failed, err: %w", err)
+		}
+	}
+	return 0, fmt.Errorf("memcached ring incr generation failed after %d retries", maxCASRetries)
+}
+
+func (m *MemcachedHashRing) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	var rawDataKeys []string
+	if err := m.get(ctx, m.getNodeDataKey(nodeID), &rawDataKeys); err != nil {
+		return nil, fmt.Errorf("memcached ring data keys failed, err: %w", err)
+	}
+
+	dataKeys := make(map[string]struct{}, len(rawDataKeys))
+	for _, dataKey := range rawDataKeys {
+		dataKeys[dataKey] = struct{}{}
+	}
+	return dataKeys, nil
+}
+
+// 在真实节点的状态数据 key 集合中添加或者删除 dataKeys，集合为空时删除对应的 key
+func (m *MemcachedHashRing) updateDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}, add bool) error {
+	return m.update(ctx, m.getNodeDataKey(nodeID), func(val []byte) ([]byte, error) {
+		var rawDataKeys []string
+		if val != nil {
+			if err := json.Unmarshal(val, &rawDataKeys); err != nil {
+				return nil, err
+			}
+		}
+
+		set := make(map[string]struct{}, len(rawDataKeys)+len(dataKeys))
+		for _, dataKey := range rawDataKeys {
+			set[dataKey] = struct{}{}
+		}
+		for dataKey := range dataKeys {
+			if add {
+				set[dataKey] = struct{}{}
+			} else {
+				delete(set, dataKey)
+			}
+		}
+		if len(set) == 0 {
+			return nil, nil
+		}
+
+		newDataKeys := make([]string, 0, len(set))
+		for dataKey := range set {
+			newDataKeys = append(newDataKeys, dataKey)
+		}
+		// 排序后内容不变时 json 串也不变，可以跳过写入
+		sort.Strings(newDataKeys)
+		return json.Marshal(newDataKeys)
+	})
+}
+
+func (m *MemcachedHashRing) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	if len(dataKeys) == 0 {
+		return nil
+	}
+	if err := m.updateDataKeys(ctx, nodeID, dataKeys, true); err != nil {
+		return fmt.Errorf("memcached ring add node to data keys failed, err: %w", err)
+	}
+	return nil
+}
+
+func (m *MemcachedHashRing) DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	if len(dataKeys) == 0 {
+		return nil
+	}
+	if err := m.updateDataKeys(ctx, nodeID, dataKeys, false); err != nil {
+		return fmt.Errorf("memcached ring delete node to data keys failed, err: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package memcached
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/pule1234/consistent_hash"
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func newTestHashRing(t *testing.T, key string) *MemcachedHashRing {
+	t.Helper()
+	return NewMemcachedHashRing(key, NewClient("tcp", newMockMemcachedServer(t).addr()))
+}
+
+func Test_MemcachedHashRing_CeilingFloor(t *testing.T) {
+	ctx := context.Background()
+	ring := newTestHashRing(t, "test_ceiling_floor")
+
+	if score, err := ring.Ceiling(ctx, 1); err != nil || score != -1 {
+		t.Fatalf("ceiling on empty ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+	if score, err := ring.Floor(ctx, 1); err != nil || score != -1 {
+		t.Fatalf("floor on empty ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+
+	for _, score := range []int64{300, 100, 200} {
+		if err := ring.Add(ctx, score, "node"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		score   int64
+		ceiling int64
+		floor   int64
+	}{
+		{score: 50, ceiling: 100, floor: 300},
+		{score: 100, ceiling: 100, floor: 100},
+		{score: 150, ceiling: 200, floor: 100},
+		{score: 300, ceiling: 300, floor: 300},
+		{score: 301, ceiling: 100, floor: 300},
+	}
+	for _, c := range cases {
+		if ceiling, err := ring.Ceiling(ctx, c.score); err != nil || ceiling != c.ceiling {
+			t.Errorf("ceiling %d: got (%d, %v), want %d", c.score, ceiling, err, c.ceiling)
+		}
+		if floor, err := ring.Floor(ctx, c.score); err != nil || floor != c.floor {
+			t.Errorf("floor %d: got (%d, %v), want %d", c.score, floor, err, c.floor)
+		}
+	}
+}
+
+func Test_MemcachedHashRing_AddRem(t *testing.T) {
+	ctx := context.Background()
+	ring := newTestHashRing(t, "test_add_rem")
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_a"} {
+		if err := ring.Add(ctx, 10, nodeID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nodeIDs, err := ring.Node(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(nodeIDs) != "[node_a node_b]" {
+		t.Fatalf("got node ids %v, want [node_a node_b]", nodeIDs)
+	}
+
+	if err = ring.Rem(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.Rem(ctx, 10, "node_b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 虚拟节点的真实节点列表为空时，需要从环中移除
+	if _, err = ring.Node(ctx, 10); err == nil {
+		t.Error("empty virtual node should be removed from ring")
+	}
+	if score, err := ring.Ceiling(ctx, 0); err != nil || score != -1 {
+		t.Errorf("ceiling on emptied ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+	if err = ring.Rem(ctx, 10, "node_a"); err == nil {
+		t.Error("rem on missing score should fail")
+	}
+}
+
+func Test_MemcachedHashRing_DataKeys(t *testing.T) {
+	ctx := context.Background()
+	ring := newTestHashRing(t, "test_data_keys")
+
+	// 节点 id 中的空白字符不能出现在 memcached 的 key 中
+	nodeID := "node a"
+	if err := ring.AddNodeToDataKeys(ctx, nodeID, map[string]struct{}{"a": {}, "b": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.DeleteNodeToDataKeys(ctx, nodeID, map[string]struct{}{"a": {}}); err != nil {
+		t.Fatal(err)
+	}
+	dataKeys, err := ring.DataKeys(ctx, nodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dataKeys["b"]; !ok || len(dataKeys) != 1 {
+		t.Errorf("got data keys %v, want [b]", dataKeys)
+	}
+
+	if err = ring.DeleteNodeToDataKeys(ctx, "node_x", map[string]struct{}{"a": {}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_MemcachedHashRing_Lock(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient("tcp", newMockMemcachedServer(t).addr())
+	ring := NewMemcachedHashRing("test_lock", client)
+	other := NewMemcachedHashRing("test_lock", client)
+
+	if err := ring.Lock(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Lock(ctx, 10); !other.IsLockBusy(err) {
+		t.Fatalf("lock held by others: got %v, want ErrLockAcquiredByOthers", err)
+	}
+	if err := other.Unlock(ctx); err == nil {
+		t.Error("unlock without holding the lock should fail")
+	}
+	if err := ring.RenewLock(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Lock(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MemcachedHashRing_Generation(t *testing.T) {
+	ctx := context.Background()
+	ring := newTestHashRing(t, "test_generation")
+
+	if generation, err := ring.Generation(ctx); err != nil || generation != 0 {
+		t.Fatalf("got initial generation (%d, %v), want 0", generation, err)
+	}
+	for want := int64(1); want <= 3; want++ {
+		if generation, err := ring.IncrGeneration(ctx); err != nil || generation != want {
+			t.Fatalf("got generation (%d, %v), want %d", generation, err, want)
+		}
+	}
+}
+
+// 在 memcached 与内存哈希环上执行相同的节点变更，数据 key 的归属需要保持一致
+func Test_MemcachedHashRing_ConsistentHash(t *testing.T) {
+	ctx := context.Background()
+	migrator, _ := consistent_hash.NewRecordingMigrator()
+	memcachedHash := consistent_hash.NewConsistentHash(newTestHashRing(t, "test_consistent_hash"), consistent_hash.NewMurmurHasher(), migrator)
+	memoryHash := consistent_hash.NewConsistentHash(memory.NewHashRing(), consistent_hash.NewMurmurHasher(), migrator)
+
+	assertSameNodes := func() {
+		t.Helper()
+		for i := 0; i < 50; i++ {
+			dataKey := fmt.Sprintf("data_%d", i)
+			got, err := memcachedHash.GetNode(ctx, dataKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := memoryHash.GetNode(ctx, dataKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("data %s: got node %s, want %s", dataKey, got, want)
+			}
+		}
+	}
+
+	for _, c := range []*consistent_hash.ConsistentHash{memcachedHash, memoryHash} {
+		if err := c.AddNode(ctx, "node_a", 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddNode(ctx, "node_b", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSameNodes()
+
+	for _, c := range []*consistent_hash.ConsistentHash{memcachedHash, memoryHash} {
+		if err := c.AddNode(ctx, "node_c", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSameNodes()
+
+	for _, c := range []*consistent_hash.ConsistentHash{memcachedHash, memoryHash} {
+		if err := c.RemoveNode(ctx, "node_c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSameNodes()
+
+	// 数据迁移后每个数据 key 都登记在其归属的真实节点下
+	inconsistencies, err := memcachedHash.Validate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inconsistencies) != 0 {
+		t.Errorf("got inconsistencies %v", inconsistencies)
+	}
+}
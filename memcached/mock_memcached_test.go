@@ -0,0 +1,139 @@
+package memcached
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 基于内存实现的 memcached 模拟服务，支持哈希环用到的文本协议命令，忽略数据的过期时间
+type mockMemcachedServer struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	items   map[string]*Item
+	nextCAS uint64
+}
+
+func newMockMemcachedServer(t testing.TB) *mockMemcachedServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &mockMemcachedServer{listener: listener, items: make(map[string]*Item)}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *mockMemcachedServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *mockMemcachedServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			return
+		}
+
+		// 存储命令的数据部分位于下一行
+		var data []byte
+		switch args[0] {
+		case "set", "add", "cas":
+			size, _ := strconv.Atoi(args[4])
+			data = make([]byte, size+2)
+			if _, err = io.ReadFull(reader, data); err != nil {
+				return
+			}
+			data = data[:size]
+		}
+
+		if _, err = io.WriteString(conn, s.handle(args, data)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *mockMemcachedServer) handle(args []string, data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store := func(key string) string {
+		s.nextCAS++
+		s.items[key] = &Item{Key: key, Value: data, CAS: s.nextCAS}
+		return "STORED\r\n"
+	}
+
+	switch args[0] {
+	case "gets":
+		var reply string
+		for _, key := range args[1:] {
+			if item, ok := s.items[key]; ok {
+				reply += fmt.Sprintf("VALUE %s 0 %d %d\r\n%s\r\n", key, len(item.Value), item.CAS, item.Value)
+			}
+		}
+		return reply + "END\r\n"
+	case "set":
+		return store(args[1])
+	case "add":
+		if _, ok := s.items[args[1]]; ok {
+			return "NOT_STORED\r\n"
+		}
+		return store(args[1])
+	case "cas":
+		item, ok := s.items[args[1]]
+		if !ok {
+			return "NOT_FOUND\r\n"
+		}
+		if strconv.FormatUint(item.CAS, 10) != args[5] {
+			return "EXISTS\r\n"
+		}
+		return store(args[1])
+	case "delete":
+		if _, ok := s.items[args[1]]; !ok {
+			return "NOT_FOUND\r\n"
+		}
+		delete(s.items, args[1])
+		return "DELETED\r\n"
+	case "incr":
+		item, ok := s.items[args[1]]
+		if !ok {
+			return "NOT_FOUND\r\n"
+		}
+		val, _ := strconv.ParseUint(string(item.Value), 10, 64)
+		delta, _ := strconv.ParseUint(args[2], 10, 64)
+		s.nextCAS++
+		item.Value, item.CAS = []byte(strconv.FormatUint(val+delta, 10)), s.nextCAS
+		return string(item.Value) + "\r\n"
+	case "touch":
+		if _, ok := s.items[args[1]]; !ok {
+			return "NOT_FOUND\r\n"
+		}
+		return "TOUCHED\r\n"
+	}
+	return "ERROR\r\n"
+}
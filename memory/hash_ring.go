@@ -0,0 +1,426 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// 基于进程内存实现的哈希环，适用于单元测试以及单进程部署的场景
+type HashRing struct {
+	// 哈希环维度的全局锁，对应于 redis 实现中的分布式锁，使用容量为 1 的 channel 实现以便支持 ctx 超时
+	lock chan struct{}
+
+	// 保护以下数据结构的并发读写
+	mu sync.RWMutex
+	// 哈希环上全部虚拟节点的位置，按照从小到大的顺序排列
+	scores []int64
+	// 虚拟节点位置到真实节点列表的映射
+	table map[int64][]string
+	// 真实节点到虚拟节点个数的映射
+	nodeReplicas map[string]int
+	// 真实节点到状态数据 key 集合的映射
+	nodeDataKeys map[string]map[string]struct{}
+	// 真实节点的元数据
+	nodeMeta map[string]map[string]string
+	// 哈希环的代数
+	generation int64
+	// 拓扑变更消息的订阅者
+	subscribers map[chan string]struct{}
+}
+
+// 每个订阅者缓冲的消息个数，订阅者消费过慢导致缓冲写满时丢弃新的消息
+const subscriberBufferSize = 64
+
+func NewHashRing() *HashRing {
+	return &HashRing{
+		lock:         make(chan struct{}, 1),
+		table:        make(map[int64][]string),
+		nodeReplicas: make(map[string]int),
+		nodeDataKeys: make(map[string]map[string]struct{}),
+		nodeMeta:     make(map[string]map[string]string),
+		subscribers:  make(map[chan string]struct{}),
+	}
+}
+
+// 锁住哈希环，进程内不存在锁过期的问题，因此忽略 expireSeconds
+// 锁被占用时阻塞等待，直到获取到锁或者 ctx 结束
+func (h *HashRing) Lock(ctx context.Context, expireSeconds int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case h.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *HashRing) Unlock(ctx context.Context) error {
+	select {
+	case <-h.lock:
+		return nil
+	default:
+		return errors.New("memory ring unlock failed, lock not held")
+	}
+}
+
+// 获取 score 在有序列表中的插入位置
+func (h *HashRing) search(score int64) int {
+	return sort.Search(len(h.scores), func(i int) bool {
+		return h.scores[i] >= score
+	})
+}
+
+// 将真实节点 nodeID 追加到 score 对应的虚拟节点中
+func (h *HashRing) Add(ctx context.Context, score int64, nodeID string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.add(score, nodeID)
+	return nil
+}
+
+// 将真实节点 nodeID 追加到 score 对应的虚拟节点中，真实节点已经存在时 added 为 false
+func (h *HashRing) AddIfAbsent(ctx context.Context, score int64, nodeID string) (added bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.add(score, nodeID), nil
+}
+
+// 批量将真实节点添加到对应的虚拟节点中，virtualNodes 为虚拟节点数值到待添加真实节点列表的映射
+func (h *HashRing) BatchAdd(ctx context.Context, virtualNodes map[int64][]string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for score, nodeIDs := range virtualNodes {
+		for _, nodeID := range nodeIDs {
+			h.add(score, nodeID)
+		}
+	}
+	return nil
+}
+
+// 真实节点追加到虚拟节点的列表中，真实节点已经存在时返回 false
+func (h *HashRing) add(score int64, nodeID string) bool {
+	nodeIDs, ok := h.table[score]
+	for _, _nodeID := range nodeIDs {
+		if _nodeID == nodeID {
+			return false
+		}
+	}
+
+	// 倘若 score 对应的虚拟节点不存在，则需要将其插入到有序列表中
+	if !ok {
+		index := h.search(score)
+		h.scores = append(h.scores, 0)
+		copy(h.scores[index+1:], h.scores[index:])
+		h.scores[index] = score
+	}
+
+	h.table[score] = append(nodeIDs, nodeID)
+	return true
+}
+
+// 从 score 对应的虚拟节点中删除真实节点 nodeID，当虚拟节点的真实节点列表为空时，从环中移除该虚拟节点
+func (h *HashRing) Rem(ctx context.Context, score int64, nodeID string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	nodeIDs, ok := h.table[score]
+	if !ok {
+		return fmt.Errorf("memory ring rem failed, score %d not exist", score)
+	}
+
+	index := -1
+	for i := 0; i < len(nodeIDs); i++ {
+		if nodeIDs[i] == nodeID {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return nil
+	}
+
+	newNodeIDs := make([]string, 0, len(nodeIDs)-1)
+	newNodeIDs = append(newNodeIDs, nodeIDs[:index]...)
+	newNodeIDs = append(newNodeIDs, nodeIDs[index+1:]...)
+	if len(newNodeIDs) > 0 {
+		h.table[score] = newNodeIDs
+		return nil
+	}
+
+	delete(h.table, score)
+	scoreIndex := h.search(score)
+	h.scores = append(h.scores[:scoreIndex], h.scores[scoreIndex+1:]...)
+	return nil
+}
+
+// 获取 score 顺时针往下的第一个虚拟节点数值，倘若哈希环为空则返回 -1
+func (h *HashRing) Ceiling(ctx context.Context, score int64) (int64, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.scores) == 0 {
+		return -1, nil
+	}
+
+	index := h.search(score)
+	// 超出最大的虚拟节点后回绕到环上的第一个虚拟节点
+	if index == len(h.scores) {
+		return h.scores[0], nil
+	}
+	return h.scores[index], nil
+}
+
+// 获取 score 逆时针往上的第一个虚拟节点数值，倘若哈希环为空则返回 -1
+func (h *HashRing) Floor(ctx context.Context, score int64) (int64, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.scores) == 0 {
+		return -1, nil
+	}
+
+	index := sort.Search(len(h.scores), func(i int) bool {
+		return h.scores[i] > score
+	})
+	// 小于最小的虚拟节点时回绕到环上的最后一个虚拟节点
+	if index == 0 {
+		return h.scores[len(h.scores)-1], nil
+	}
+	return h.scores[index-1], nil
+}
+
+func (h *HashRing) Node(ctx context.Context, score int64) ([]string, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	nodeIDs, ok := h.table[score]
+	if !ok {
+		return nil, fmt.Errorf("memory ring node failed, score %d not exist", score)
+	}
+
+	return append([]string(nil), nodeIDs...), nil
+}
+
+func (h *HashRing) VirtualNodes(ctx context.Context) (map[int64][]string, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	virtualNodes := make(map[int64][]string, len(h.table))
+	for score, nodeIDs := range h.table {
+		virtualNodes[score] = append([]string(nil), nodeIDs...)
+	}
+	return virtualNodes, nil
+}
+
+// 哈希环上虚拟节点位置的个数
+func (h *HashRing) VirtualNodeCount(ctx context.Context) (int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.scores), nil
+}
+
+func (h *HashRing) Nodes(ctx context.Context) (map[string]int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	nodes := make(map[string]int, len(h.nodeReplicas))
+	for nodeID, replicas := range h.nodeReplicas {
+		nodes[nodeID] = replicas
+	}
+	return nodes, nil
+}
+
+func (h *HashRing) NodeExists(ctx context.Context, nodeID string) (bool, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	_, ok := h.nodeReplicas[nodeID]
+	return ok, nil
+}
+
+func (h *HashRing) Generation(ctx context.Context) (int64, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.generation, nil
+}
+
+func (h *HashRing) IncrGeneration(ctx context.Context) (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.generation++
+	return h.generation, nil
+}
+
+func (h *HashRing) Publish(ctx context.Context, payload string) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for subscriber := range h.subscribers {
+		select {
+		case subscriber <- payload:
+		default:
+		}
+	}
+	return nil
+}
+
+func (h *HashRing) Subscribe(ctx context.Context) (<-chan string, error) {
+	subscriber := make(chan string, subscriberBufferSize)
+	h.mu.Lock()
+	h.subscribers[subscriber] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		delete(h.subscribers, subscriber)
+		h.mu.Unlock()
+		close(subscriber)
+	}()
+	return subscriber, nil
+}
+
+func (h *HashRing) AddNodeToReplica(ctx context.Context, nodeID string, replicas int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nodeReplicas[nodeID] = replicas
+	return nil
+}
+
+func (h *HashRing) DeleteNodeToReplica(ctx context.Context, nodeID string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.nodeReplicas, nodeID)
+	return nil
+}
+
+func (h *HashRing) SetNodeMeta(ctx context.Context, nodeID string, meta map[string]string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	copied := make(map[string]string, len(meta))
+	for k, v := range meta {
+		copied[k] = v
+	}
+	h.nodeMeta[nodeID] = copied
+	return nil
+}
+
+func (h *HashRing) NodeMeta(ctx context.Context, nodeID string) (map[string]string, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	meta := make(map[string]string, len(h.nodeMeta[nodeID]))
+	for k, v := range h.nodeMeta[nodeID] {
+		meta[k] = v
+	}
+	return meta, nil
+}
+
+func (h *HashRing) DeleteNodeMeta(ctx context.Context, nodeID string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.nodeMeta, nodeID)
+	return nil
+}
+
+func (h *HashRing) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	dataKeys := make(map[string]struct{}, len(h.nodeDataKeys[nodeID]))
+	for dataKey := range h.nodeDataKeys[nodeID] {
+		dataKeys[dataKey] = struct{}{}
+	}
+	return dataKeys, nil
+}
+
+func (h *HashRing) DataKeyCount(ctx context.Context, nodeID string) (int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.nodeDataKeys[nodeID]), nil
+}
+
+func (h *HashRing) DataKeyRecorded(ctx context.Context, nodeID, dataKey string) (bool, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	_, ok := h.nodeDataKeys[nodeID][dataKey]
+	return ok, nil
+}
+
+func (h *HashRing) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.addNodeToDataKeys(nodeID, dataKeys)
+	return nil
+}
+
+// 批量为多个真实节点登记数据 key，nodeDataKeys 为真实节点 id 到待登记数据 key 集合的映射
+func (h *HashRing) BatchAddNodeToDataKeys(ctx context.Context, nodeDataKeys map[string]map[string]struct{}) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for nodeID, dataKeys := range nodeDataKeys {
+		h.addNodeToDataKeys(nodeID, dataKeys)
+	}
+	return nil
+}
+
+func (h *HashRing) addNodeToDataKeys(nodeID string, dataKeys map[string]struct{}) {
+	oldDataKeys, ok := h.nodeDataKeys[nodeID]
+	if !ok {
+		oldDataKeys = make(map[string]struct{}, len(dataKeys))
+		h.nodeDataKeys[nodeID] = oldDataKeys
+	}
+
+	for dataKey := range dataKeys {
+		oldDataKeys[dataKey] = struct{}{}
+	}
+}
+
+func (h *HashRing) DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.deleteNodeToDataKeys(nodeID, dataKeys)
+	return nil
+}
+
+// 在同一次加锁中将数据 key 从 from 移动到 to
+func (h *HashRing) MoveDataKeys(ctx context.Context, from, to string, dataKeys map[string]struct{}) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.deleteNodeToDataKeys(from, dataKeys)
+	if len(dataKeys) > 0 {
+		h.addNodeToDataKeys(to, dataKeys)
+	}
+	return nil
+}
+
+func (h *HashRing) deleteNodeToDataKeys(nodeID string, dataKeys map[string]struct{}) {
+	oldDataKeys := h.nodeDataKeys[nodeID]
+	for dataKey := range dataKeys {
+		delete(oldDataKeys, dataKey)
+	}
+
+	if len(oldDataKeys) == 0 {
+		delete(h.nodeDataKeys, nodeID)
+	}
+}
@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_HashRing_CeilingFloor(t *testing.T) {
+	ctx := context.Background()
+	ring := NewHashRing()
+
+	if score, err := ring.Ceiling(ctx, 1); err != nil || score != -1 {
+		t.Fatalf("ceiling on empty ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+	if score, err := ring.Floor(ctx, 1); err != nil || score != -1 {
+		t.Fatalf("floor on empty ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+
+	for _, score := range []int64{300, 100, 200} {
+		if err := ring.Add(ctx, score, "node"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		score   int64
+		ceiling int64
+		floor   int64
+	}{
+		{score: 50, ceiling: 100, floor: 300},
+		{score: 100, ceiling: 100, floor: 100},
+		{score: 150, ceiling: 200, floor: 100},
+		{score: 300, ceiling: 300, floor: 300},
+		{score: 301, ceiling: 100, floor: 300},
+	}
+	for _, c := range cases {
+		ceiling, err := ring.Ceiling(ctx, c.score)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ceiling != c.ceiling {
+			t.Errorf("ceiling %d: got %d, want %d", c.score, ceiling, c.ceiling)
+		}
+		floor, err := ring.Floor(ctx, c.score)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if floor != c.floor {
+			t.Errorf("floor %d: got %d, want %d", c.score, floor, c.floor)
+		}
+	}
+}
+
+func Test_HashRing_AddRem(t *testing.T) {
+	ctx := context.Background()
+	ring := NewHashRing()
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_a"} {
+		if err := ring.Add(ctx, 10, nodeID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nodeIDs, err := ring.Node(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodeIDs) != 2 || nodeIDs[0] != "node_a" || nodeIDs[1] != "node_b" {
+		t.Fatalf("got node ids %v, want [node_a node_b]", nodeIDs)
+	}
+
+	if err = ring.Rem(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if nodeIDs, err = ring.Node(ctx, 10); err != nil || len(nodeIDs) != 1 || nodeIDs[0] != "node_b" {
+		t.Fatalf("got node ids (%v, %v), want [node_b]", nodeIDs, err)
+	}
+
+	// 虚拟节点的真实节点列表为空时，需要从环中移除
+	if err = ring.Rem(ctx, 10, "node_b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ring.Node(ctx, 10); err == nil {
+		t.Error("empty virtual node should be removed from ring")
+	}
+	if score, err := ring.Ceiling(ctx, 0); err != nil || score != -1 {
+		t.Errorf("ceiling on emptied ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+}
+
+func Test_HashRing_AddIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	ring := NewHashRing()
+
+	if added, err := ring.AddIfAbsent(ctx, 10, "node_a"); err != nil || !added {
+		t.Fatalf("first add: got (%v, %v), want added", added, err)
+	}
+	if added, err := ring.AddIfAbsent(ctx, 10, "node_a"); err != nil || added {
+		t.Fatalf("second add: got (%v, %v), want not added", added, err)
+	}
+	if nodeIDs, err := ring.Node(ctx, 10); err != nil || len(nodeIDs) != 1 {
+		t.Errorf("got node ids (%v, %v), want [node_a] without duplicates", nodeIDs, err)
+	}
+}
+
+func Test_HashRing_DataKeys(t *testing.T) {
+	ctx := context.Background()
+	ring := NewHashRing()
+
+	if err := ring.AddNodeToDataKeys(ctx, "node_a", map[string]struct{}{"a": {}, "b": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.DeleteNodeToDataKeys(ctx, "node_a", map[string]struct{}{"a": {}}); err != nil {
+		t.Fatal(err)
+	}
+	dataKeys, err := ring.DataKeys(ctx, "node_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dataKeys["b"]; !ok || len(dataKeys) != 1 {
+		t.Errorf("got data keys %v, want [b]", dataKeys)
+	}
+	for _, c := range []struct {
+		nodeID, dataKey string
+		want            bool
+	}{
+		{"node_a", "a", false},
+		{"node_a", "b", true},
+		{"node_x", "b", false},
+	} {
+		if recorded, err := ring.DataKeyRecorded(ctx, c.nodeID, c.dataKey); err != nil || recorded != c.want {
+			t.Errorf("data key %s on %s: got (%t, %v), want %t", c.dataKey, c.nodeID, recorded, err, c.want)
+		}
+	}
+
+	// 删除不存在的节点的数据不会报错
+	if err = ring.DeleteNodeToDataKeys(ctx, "node_x", map[string]struct{}{"a": {}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_HashRing_BatchAddNodeToDataKeys(t *testing.T) {
+	ctx := context.Background()
+	h := NewHashRing()
+	if err := h.AddNodeToDataKeys(ctx, "node_a", map[string]struct{}{"data_0": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.BatchAddNodeToDataKeys(ctx, map[string]map[string]struct{}{
+		"node_a": {"data_1": {}},
+		"node_b": {"data_2": {}, "data_3": {}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for nodeID, want := range map[string]int{"node_a": 2, "node_b": 2} {
+		dataKeys, err := h.DataKeys(ctx, nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dataKeys) != want {
+			t.Errorf("node %s: got data keys %v, want %d keys", nodeID, dataKeys, want)
+		}
+	}
+}
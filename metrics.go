@@ -0,0 +1,52 @@
+package consistent_hash
+
+import (
+	"context"
+	"time"
+)
+
+// 指标上报中使用的操作名称
+const (
+	OpAddNode          = "add_node"
+	OpRemoveNode       = "remove_node"
+	OpGetNode          = "get_node"
+	OpUpdateNodeWeight = "update_node_weight"
+)
+
+// 指标上报器，使用方可以通过 WithMetrics 注入自定义的实现，基于 prometheus 的实现参见 metrics/prometheus
+type Metrics interface {
+	// 累加数据迁移成功的数据 key 个数
+	AddMigratedKeys(n int)
+	// 记录一次操作的耗时，op 为 OpAddNode、OpRemoveNode、OpGetNode 或者 OpUpdateNodeWeight
+	ObserveLatency(op string, d time.Duration)
+	// 设置哈希环中真实节点的个数
+	SetNodeCount(n int)
+}
+
+// 默认的指标上报器，不上报任何内容
+type nopMetrics struct{}
+
+func (nopMetrics) AddMigratedKeys(n int) {}
+
+func (nopMetrics) ObserveLatency(op string, d time.Duration) {}
+
+func (nopMetrics) SetNodeCount(n int) {}
+
+// 记录从 start 开始的操作耗时，配合 defer 使用
+func (c *ConsistentHash) observeLatency(op string, start time.Time) {
+	c.opts.metrics.ObserveLatency(op, c.opts.clock.Now().Sub(start))
+}
+
+// 节点变更后上报真实节点的个数，未注入指标上报器时不查询哈希环
+func (c *ConsistentHash) reportNodeCount(ctx context.Context) {
+	if _, ok := c.opts.metrics.(nopMetrics); ok {
+		return
+	}
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		c.opts.logger.Errorf("report node count failed, err: %v", err)
+		return
+	}
+	c.opts.metrics.SetNodeCount(len(nodes))
+}
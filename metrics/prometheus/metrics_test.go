@@ -0,0 +1,82 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	consistent_hash "github.com/pule1234/consistent_hash"
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func Test_Metrics(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewPedanticRegistry()
+	metrics, err := Register(reg, "consistent_hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Register(reg, "consistent_hash"); err == nil {
+		t.Error("expect registering the same metrics twice to fail")
+	}
+
+	var (
+		mu    sync.Mutex
+		moved int
+	)
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		moved += len(dataKeys)
+		return nil
+	}
+	consistentHash := consistent_hash.NewConsistentHash(memory.NewHashRing(), consistent_hash.NewMurmurHasher(), migrator,
+		consistent_hash.WithMetrics(metrics))
+	if err = consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err = consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if moved == 0 {
+		t.Fatal("expect some data keys to be migrated")
+	}
+
+	if got := testutil.ToFloat64(metrics.migratedKeys); got != float64(moved) {
+		t.Errorf("got migrated keys %v, want %d", got, moved)
+	}
+	if got := testutil.ToFloat64(metrics.nodeCount); got != 2 {
+		t.Errorf("got node count %v, want 2", got)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]uint64)
+	for _, family := range families {
+		if family.GetName() != "consistent_hash_"+LatencyName {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				counts[label.GetValue()] = metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	want := map[string]uint64{consistent_hash.OpAddNode: 2, consistent_hash.OpGetNode: 100}
+	for op, count := range want {
+		if counts[op] != count {
+			t.Errorf("op %s: got %d latency observations, want %d", op, counts[op], count)
+		}
+	}
+}
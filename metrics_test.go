@@ -0,0 +1,93 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+// 将指标记录在内存中，便于断言
+type fakeMetrics struct {
+	mu           sync.Mutex
+	migratedKeys int
+	latencies    map[string]int
+	nodeCount    int
+}
+
+func (m *fakeMetrics) AddMigratedKeys(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.migratedKeys += n
+}
+
+func (m *fakeMetrics) ObserveLatency(op string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.latencies == nil {
+		m.latencies = make(map[string]int)
+	}
+	m.latencies[op]++
+}
+
+func (m *fakeMetrics) SetNodeCount(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodeCount = n
+}
+
+func Test_WithMetrics(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu    sync.Mutex
+		moved int
+	)
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		moved += len(dataKeys)
+		return nil
+	}
+	metrics := &fakeMetrics{}
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, WithMetrics(metrics))
+
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.nodeCount != 2 {
+		t.Errorf("got node count %d, want 2", metrics.nodeCount)
+	}
+	if err := consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := consistentHash.UpdateNodeWeight(ctx, "node_b", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if moved == 0 {
+		t.Fatal("expect some data keys to be migrated")
+	}
+	if metrics.migratedKeys != moved {
+		t.Errorf("got migrated keys %d, want %d", metrics.migratedKeys, moved)
+	}
+	if metrics.nodeCount != 1 {
+		t.Errorf("got node count %d, want 1", metrics.nodeCount)
+	}
+	want := map[string]int{OpAddNode: 2, OpRemoveNode: 1, OpGetNode: 100, OpUpdateNodeWeight: 1}
+	for op, count := range want {
+		if metrics.latencies[op] != count {
+			t.Errorf("op %s: got %d latency observations, want %d", op, metrics.latencies[op], count)
+		}
+	}
+}
@@ -1,19 +1,53 @@
 package consistent_hash
 
-import "C"
 import (
 	"context"
 	"errors"
-	"math"
+	"fmt"
+	"sync"
 )
 
 // 用户需要注册好闭包函数进来，核心是执行数据迁移操作
+// 开启 WithAllowRemoveLastNode 后删除最后一个真实节点时，to 为空，表示数据已经没有可以托付的节点
 type Migrator func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error
 
+// 一笔数据迁移任务的明细
+type Migration struct {
+	From     string
+	To       string
+	DataKeys map[string]struct{}
+}
+
+// 创建只记录、不实际迁移数据的迁移函数，用于测试以及预发环境校验数据迁移的明细
+// 返回的 migrations 用于获取截至目前记录的全部迁移任务，迁移函数可以被并发调用
+func NewRecordingMigrator() (migrator Migrator, migrations func() []Migration) {
+	var (
+		mu       sync.Mutex
+		recorded []Migration
+	)
+	migrator = func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		_dataKeys := make(map[string]struct{}, len(dataKeys))
+		for dataKey := range dataKeys {
+			_dataKeys[dataKey] = struct{}{}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		recorded = append(recorded, Migration{From: from, To: to, DataKeys: _dataKeys})
+		return nil
+	}
+	migrations = func() []Migration {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Migration(nil), recorded...)
+	}
+	return migrator, migrations
+}
+
 // 在AddNode 添加流程节点中，获取需要执行的数据迁移的任务明细
-func (c *ConsistentHash) migrateIn(ctx context.Context, virtualScore int32, nodeID string) (from, to string, datas map[string]struct{}, _err error) {
-	// 若使用方没有注入迁移函数 ， 则直接返回
-	if c.migrator == nil {
+func (c *ConsistentHash) migrateIn(ctx context.Context, virtualScore int64, nodeID string) (from, to string, datas map[string]struct{}, _err error) {
+	// 若使用方关闭了数据 key 登记 ， 则直接返回
+	if !c.needMigration() {
 		return
 	}
 
@@ -30,7 +64,7 @@ func (c *ConsistentHash) migrateIn(ctx context.Context, virtualScore int32, node
 	}
 
 	// 执行floor操作，获取当前虚拟节点数值virtualScore逆时针往上的第一个虚拟节点数值lastScore
-	lastScore, err := c.hashRing.Floor(ctx, c.decrScore(virtualScore))
+	lastScore, err := c.floorExclusive(ctx, virtualScore)
 	if err != nil {
 		_err = err
 		return
@@ -42,7 +76,7 @@ func (c *ConsistentHash) migrateIn(ctx context.Context, virtualScore int32, node
 	}
 
 	// 执行ceiling操作，获取当前虚拟节点数值virtualScore顺时针往下的第一个虚拟节点数值nextScore
-	nextScore, err := c.hashRing.Ceiling(ctx, c.incrScore(virtualScore))
+	nextScore, err := c.ceilingExclusive(ctx, virtualScore)
 	if err != nil {
 		_err = err
 		return
@@ -60,12 +94,12 @@ func (c *ConsistentHash) migrateIn(ctx context.Context, virtualScore int32, node
 	//  patternTwo: last-cur-0-next
 	patternTwo := nextScore < virtualScore
 	if patternOne {
-		lastScore -= math.MaxInt32
+		lastScore -= c.opts.ringSize
 	}
 
 	if patternTwo {
-		virtualScore -= math.MaxInt32
-		lastScore -= math.MaxInt32
+		virtualScore -= c.opts.ringSize
+		lastScore -= c.opts.ringSize
 	}
 
 	// 获取到nextScore对应的真实节点列表
@@ -82,20 +116,24 @@ func (c *ConsistentHash) migrateIn(ctx context.Context, virtualScore int32, node
 
 	//获取到nextScore首个真实节点对应的状态数据的key列表
 	dataKeys, err := c.hashRing.DataKeys(ctx, c.getNodeID(nextNodes[0]))
+	if err != nil {
+		_err = err
+		return
+	}
 
 	datas = make(map[string]struct{})
 	// 遍历状态数据key列表，将其中满足迁移条件的部分添加到datas中
 	for dataKey := range dataKeys {
 		// 依次将每个状态数据的 key 映射到哈希环上的某个位置
-		dataVirtualScore := c.encryptor.Encrypt(dataKey)
+		dataVirtualScore := c.getScore(dataKey)
 		//  对应于 patternOne，需要将 (last,max] 范围内的数据统一减去哈希环的长度
-		if patternOne && dataVirtualScore > (lastScore+math.MaxInt32) {
-			dataVirtualScore -= math.MaxInt32
+		if patternOne && dataVirtualScore > (lastScore+c.opts.ringSize) {
+			dataVirtualScore -= c.opts.ringSize
 		}
 
 		// 对应于 patternTwo，将数据统一减去哈希环的长度
 		if patternTwo {
-			dataVirtualScore -= math.MaxInt32
+			dataVirtualScore -= c.opts.ringSize
 		}
 
 		//  倘若数据不属于 (lastScore,virtuaslScore] 的范围，则无需迁移
@@ -106,13 +144,12 @@ func (c *ConsistentHash) migrateIn(ctx context.Context, virtualScore int32, node
 		datas[dataKey] = struct{}{}
 	}
 
-	// 从nextScore对应的首个真实节点中删除这部分需要迁移的数据key
-	if err = c.hashRing.DeleteNodeToDataKeys(ctx, c.getNodeID(nextNodes[0]), datas); err != nil {
-		return "", "", nil, err
+	if len(datas) == 0 {
+		return
 	}
 
-	// 将这部分数据添加到nodeID中
-	if err = c.hashRing.AddNodeToDataKeys(ctx, nodeID, datas); err != nil {
+	// 将这部分需要迁移的数据key从nextScore对应的首个真实节点移动到nodeID中
+	if err = c.moveDataKeys(ctx, c.getNodeID(nextNodes[0]), nodeID, datas); err != nil {
 		return "", "", nil, err
 	}
 
@@ -120,10 +157,89 @@ func (c *ConsistentHash) migrateIn(ctx context.Context, virtualScore int32, node
 	return c.getNodeID(nextNodes[0]), nodeID, datas, nil
 }
 
+// 开启冲突分散时，在添加虚拟节点 virtualScore 之后将其区间 (lastScore, virtualScore] 内改为归属于 nodeID 的数据 key 迁入 nodeID
+// 虚拟节点与已有虚拟节点重合时，数据来自同一位置上的其他节点，否则来自后继虚拟节点上的全部节点，因此可能产生多笔迁移任务
+func (c *ConsistentHash) migrateInSpread(ctx context.Context, virtualScore int64, nodeID string) ([]migrateTask, error) {
+	if !c.needMigration() {
+		return nil, nil
+	}
+
+	nodes, err := c.hashRing.Node(ctx, virtualScore)
+	if err != nil {
+		return nil, err
+	}
+
+	lastScore, err := c.floorExclusive(ctx, virtualScore)
+	if err != nil {
+		return nil, err
+	}
+
+	// 添加之前持有该区间数据 key 的节点
+	holders := nodes
+	if len(nodes) <= 1 {
+		nextScore, err := c.ceilingExclusive(ctx, virtualScore)
+		if err != nil {
+			return nil, err
+		}
+		if nextScore == -1 || nextScore == virtualScore {
+			return nil, nil
+		}
+		if holders, err = c.hashRing.Node(ctx, nextScore); err != nil {
+			return nil, err
+		}
+	}
+
+	var tasks []migrateTask
+	visited := make(map[string]struct{}, len(holders))
+	for _, rawNodeKey := range holders {
+		from := c.getNodeID(rawNodeKey)
+		if _, ok := visited[from]; ok || from == nodeID {
+			continue
+		}
+		visited[from] = struct{}{}
+
+		dataKeys, err := c.hashRing.DataKeys(ctx, from)
+		if err != nil {
+			return nil, err
+		}
+		datas := make(map[string]struct{})
+		for dataKey := range dataKeys {
+			if !c.scoreInRange(c.getScore(dataKey), lastScore, virtualScore) {
+				continue
+			}
+			if c.getNodeID(nodes[c.pickIndex(dataKey, nodes)]) != nodeID {
+				continue
+			}
+			datas[dataKey] = struct{}{}
+		}
+		if len(datas) == 0 {
+			continue
+		}
+
+		if err = c.moveDataKeys(ctx, from, nodeID, datas); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, c.newMigrateTask(ctx, datas, from, nodeID))
+	}
+	return tasks, nil
+}
+
+// dataScore 是否位于区间 (lastScore, score]，区间跨过环的终点时回绕到起点
+// lastScore 为 -1 或者与 score 相同时哈希环上只有 score 一个虚拟节点，区间覆盖整个哈希环
+func (c *ConsistentHash) scoreInRange(dataScore, lastScore, score int64) bool {
+	if lastScore == -1 || lastScore == score {
+		return true
+	}
+	if lastScore < score {
+		return dataScore > lastScore && dataScore <= score
+	}
+	return dataScore > lastScore || dataScore <= score
+}
+
 // 获取在删除节点流程中，需要执行数据迁移任务的明细
-func (c *ConsistentHash) migrateOut(ctx context.Context, virtualScore int32, nodeID string) (from, to string, datas map[string]struct{}, err error) {
-	// 没有注入迁函数
-	if c.migrator == nil {
+func (c *ConsistentHash) migrateOut(ctx context.Context, virtualScore int64, nodeID string) (from, to string, datas map[string]struct{}, err error) {
+	// 关闭了数据 key 登记
+	if !c.needMigration() {
 		return
 	}
 
@@ -135,16 +251,12 @@ func (c *ConsistentHash) migrateOut(ctx context.Context, virtualScore int32, nod
 			return
 		}
 
-		if err = c.hashRing.DeleteNodeToDataKeys(ctx, nodeID, datas); err != nil {
-			return
-		}
-		err = c.hashRing.AddNodeToDataKeys(ctx, to, datas)
+		err = c.moveDataKeys(ctx, nodeID, to, datas)
 	}()
 
 	from = nodeID
-	nodes, _err := c.hashRing.Node(ctx, virtualScore)
-	if err != nil {
-		err = _err
+	var nodes []string
+	if nodes, err = c.hashRing.Node(ctx, virtualScore); err != nil {
 		return
 	}
 
@@ -169,9 +281,8 @@ func (c *ConsistentHash) migrateOut(ctx context.Context, virtualScore int32, nod
 	}
 
 	// 查询哈希环中虚拟节点数值virtualScore逆时针往前的第一个虚拟节点数值lastScore
-	lastScore, _err := c.hashRing.Floor(ctx, c.decrScore(virtualScore))
-	if _err != nil {
-		err = _err
+	var lastScore int64
+	if lastScore, err = c.floorExclusive(ctx, virtualScore); err != nil {
 		return
 	}
 
@@ -179,16 +290,21 @@ func (c *ConsistentHash) migrateOut(ctx context.Context, virtualScore int32, nod
 	var onlyScore bool
 	if lastScore == -1 || lastScore == virtualScore {
 		if len(nodes) == 1 {
-			err = errors.New("no other no")
+			err = fmt.Errorf("no other node, err: %w", ErrNoNodeAvailable)
 			return
 		}
 		onlyScore = true
 	}
 
+	// 开启冲突分散时区间内的数据 key 可能归属于同一位置上的不同节点，统一在全部虚拟节点删除之后由 migrateLeftover 迁移
+	if c.opts.spreadCollisions {
+		return
+	}
+
 	// 判断是否是lastScore-00virtualScore-nextScore的组成形式
 	patten := lastScore > virtualScore
 	if patten {
-		lastScore -= math.MaxInt32
+		lastScore -= c.opts.ringSize
 	}
 
 	datas = make(map[string]struct{})
@@ -201,9 +317,9 @@ func (c *ConsistentHash) migrateOut(ctx context.Context, virtualScore int32, nod
 		}
 
 		// 将位置位于 (lastScore, virtualScore] 的数据添加到 datas，需要进行迁移
-		dataScore := c.encryptor.Encrypt(data)
-		if patten && dataScore > lastScore+math.MaxInt32 {
-			dataScore -= math.MaxInt32
+		dataScore := c.getScore(data)
+		if patten && dataScore > lastScore+c.opts.ringSize {
+			dataScore -= c.opts.ringSize
 		}
 		if dataScore <= lastScore || dataScore > virtualScore {
 			continue
@@ -220,19 +336,92 @@ func (c *ConsistentHash) migrateOut(ctx context.Context, virtualScore int32, nod
 
 	// 寻找后继节点
 	if to, err = c.getvaildNextNode(ctx, virtualScore, nodeID, nil); err != nil {
-		err = _err
 		return
 	}
 
 	if to == "" {
-		err = errors.New("no other node")
+		err = fmt.Errorf("no other node, err: %w", ErrNoNodeAvailable)
 	}
 	return
 }
 
+// 在 UpdateNodeWeight 减少虚拟节点的流程中，获取需要执行数据迁移的任务明细
+// 调用前需要已经将 nodeID 从 virtualScore 对应的虚拟节点中删除，此时 virtualScore 顺时针往下的首个真实节点即为区间数据新的归属节点
+// 与 migrateOut 不同，nodeID 仍然存在于哈希环中，因此新的归属节点可能就是 nodeID 自身，此时无需迁移
+func (c *ConsistentHash) migrateShrink(ctx context.Context, virtualScore int64, nodeID string) (from, to string, datas map[string]struct{}, err error) {
+	if !c.needMigration() {
+		return
+	}
+
+	nextScore, err := c.ceilingInclusive(ctx, virtualScore)
+	if err != nil || nextScore == -1 {
+		return
+	}
+
+	nextNodes, err := c.hashRing.Node(ctx, nextScore)
+	if err != nil || len(nextNodes) == 0 {
+		return
+	}
+
+	if to = c.getNodeID(nextNodes[0]); to == nodeID {
+		return "", "", nil, nil
+	}
+
+	lastScore, err := c.floorExclusive(ctx, virtualScore)
+	if err != nil {
+		return
+	}
+
+	// 哈希环上只剩一个虚拟节点时，nodeID 的全部数据都归属于该虚拟节点
+	onlyScore := lastScore == -1 || lastScore == nextScore
+	patten := lastScore > virtualScore
+	if patten {
+		lastScore -= c.opts.ringSize
+	}
+
+	allDatas, err := c.hashRing.DataKeys(ctx, nodeID)
+	if err != nil {
+		return
+	}
+
+	datas = make(map[string]struct{})
+	// 将位置位于 (lastScore, virtualScore] 的数据添加到 datas
+	for data := range allDatas {
+		dataScore := c.getScore(data)
+		if patten && dataScore > lastScore+c.opts.ringSize {
+			dataScore -= c.opts.ringSize
+		}
+		if !onlyScore && (dataScore <= lastScore || dataScore > virtualScore) {
+			continue
+		}
+		datas[data] = struct{}{}
+	}
+
+	if len(datas) == 0 {
+		return
+	}
+
+	if err = c.moveDataKeys(ctx, nodeID, to, datas); err != nil {
+		return
+	}
+	return nodeID, to, datas, nil
+}
+
+// 将数据 key 从 from 移动到 to，哈希环实现了 DataKeysMover 时原子执行，否则先删除后添加
+func (c *ConsistentHash) moveDataKeys(ctx context.Context, from, to string, datas map[string]struct{}) error {
+	if mover, ok := c.hashRing.(DataKeysMover); ok {
+		return mover.MoveDataKeys(ctx, from, to, datas)
+	}
+
+	if err := c.hashRing.DeleteNodeToDataKeys(ctx, from, datas); err != nil {
+		return err
+	}
+	return c.hashRing.AddNodeToDataKeys(ctx, to, datas)
+}
+
 // 寻找后继节点， 一方面需要考虑位置关系，另一方面要考虑后继节点不能和待删除节点是同一个真实节点
-func (c *ConsistentHash) getvaildNextNode(ctx context.Context, score int32, nodeID string, ranged map[int32]struct{}) (string, error) {
-	nextScore, err := c.hashRing.Ceiling(ctx, c.incrScore(score))
+func (c *ConsistentHash) getvaildNextNode(ctx context.Context, score int64, nodeID string, ranged map[int64]struct{}) (string, error) {
+	nextScore, err := c.ceilingExclusive(ctx, score)
 	if err != nil {
 		return "", err
 	}
@@ -267,7 +456,7 @@ func (c *ConsistentHash) getvaildNextNode(ctx context.Context, score int32, node
 	}
 
 	if ranged == nil {
-		ranged = make(map[int32]struct{})
+		ranged = make(map[int64]struct{})
 	}
 	ranged[score] = struct{}{}
 
@@ -275,16 +464,40 @@ func (c *ConsistentHash) getvaildNextNode(ctx context.Context, score int32, node
 	return c.getvaildNextNode(ctx, nextScore, nodeID, ranged)
 }
 
-func (c *ConsistentHash) incrScore(score int32) int32 {
-	if score == math.MaxInt32-1 {
+// 以下四个方法区分检索虚拟节点时是否包含 score 自身，HashRing 的 Ceiling 与 Floor 均包含 score 自身
+// 检索数据 key 的归属节点时，数据恰好落在虚拟节点上即归属于该虚拟节点，因此使用包含自身的版本；
+// 检索虚拟节点的前驱、后继虚拟节点时需要跳过其自身，因此使用不包含自身的版本，在环的边界处会回绕
+
+// score 顺时针往下的第一个虚拟节点，包含 score 自身
+func (c *ConsistentHash) ceilingInclusive(ctx context.Context, score int64) (int64, error) {
+	return c.hashRing.Ceiling(ctx, score)
+}
+
+// score 顺时针往下的第一个虚拟节点，不包含 score 自身，哈希环上只有 score 一个虚拟节点时回绕返回 score
+func (c *ConsistentHash) ceilingExclusive(ctx context.Context, score int64) (int64, error) {
+	return c.hashRing.Ceiling(ctx, c.incrScore(score))
+}
+
+// score 逆时针往上的第一个虚拟节点，包含 score 自身
+func (c *ConsistentHash) floorInclusive(ctx context.Context, score int64) (int64, error) {
+	return c.hashRing.Floor(ctx, score)
+}
+
+// score 逆时针往上的第一个虚拟节点，不包含 score 自身，哈希环上只有 score 一个虚拟节点时回绕返回 score
+func (c *ConsistentHash) floorExclusive(ctx context.Context, score int64) (int64, error) {
+	return c.hashRing.Floor(ctx, c.decrScore(score))
+}
+
+func (c *ConsistentHash) incrScore(score int64) int64 {
+	if score == c.opts.ringSize-1 {
 		return 0
 	}
 	return score + 1
 }
 
-func (c *ConsistentHash) decrScore(score int32) int32 {
+func (c *ConsistentHash) decrScore(score int64) int64 {
 	if score == 0 {
-		return math.MaxInt32 - 1
+		return c.opts.ringSize - 1
 	}
 	return score - 1
 }
@@ -0,0 +1,732 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+// 校验每个数据 key 都登记在其应当归属的真实节点下
+func assertPlacement(t *testing.T, c *ConsistentHash, nodeIDs []string, dataKeys []string) {
+	t.Helper()
+	ctx := context.Background()
+	recorded := recordedDataKeys(t, c, nodeIDs...)
+	for _, dataKey := range dataKeys {
+		// 直接查询哈希环，计算数据应当归属的真实节点
+		score, err := c.hashRing.Ceiling(ctx, c.getScore(dataKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawNodeKeys, err := c.hashRing.Node(ctx, score)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := c.getNodeID(rawNodeKeys[0]); recorded[dataKey] != want {
+			t.Errorf("data %s (score %d) recorded under %q, want %q", dataKey, c.getScore(dataKey), recorded[dataKey], want)
+		}
+	}
+}
+
+func Test_Migration_SmallRingSize(t *testing.T) {
+	ctx := context.Background()
+	const ringSize = 97
+	hashRing := memory.NewHashRing()
+	nodeIDs := []string{"node_a", "node_b", "node_c", "node_d"}
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, WithRingSize(ringSize), WithReplicas(4))
+
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	dataKeys := make([]string, 0, 300)
+	for i := 0; i < 300; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		if score := consistentHash.getScore(dataKey); score < 0 || score >= ringSize {
+			t.Fatalf("score %d of %s out of ring range", score, dataKey)
+		}
+		if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+			t.Fatal(err)
+		}
+		dataKeys = append(dataKeys, dataKey)
+	}
+	assertPlacement(t, consistentHash, nodeIDs, dataKeys)
+
+	// 依次添加节点，每一步都需要把落在新虚拟节点区间内的数据迁移过去，包括跨越环首尾的区间
+	for _, nodeID := range nodeIDs[1:] {
+		if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+		for _, score := range ringScores(t, consistentHash) {
+			if score < 0 || score >= ringSize {
+				t.Fatalf("virtual score %d out of ring range", score)
+			}
+		}
+		assertPlacement(t, consistentHash, nodeIDs, dataKeys)
+	}
+
+	for _, nodeID := range []string{"node_a", "node_c"} {
+		if err := consistentHash.RemoveNode(ctx, nodeID); err != nil {
+			t.Fatal(err)
+		}
+		assertPlacement(t, consistentHash, nodeIDs, dataKeys)
+	}
+}
+
+func Test_Migration_NilMigrator(t *testing.T) {
+	ctx := context.Background()
+	nodeIDs := []string{"node_a", "node_b", "node_c"}
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	if err := consistentHash.AddNode(ctx, "node_a", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	dataKeys := make([]string, 0, 200)
+	for i := 0; i < 200; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+			t.Fatal(err)
+		}
+		dataKeys = append(dataKeys, dataKey)
+	}
+
+	// 未注入迁移函数时，拓扑变更后登记关系同样需要指向新的归属节点
+	for _, nodeID := range nodeIDs[1:] {
+		if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+		assertPlacement(t, consistentHash, nodeIDs, dataKeys)
+	}
+	if err := consistentHash.UpdateNodeWeight(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	assertPlacement(t, consistentHash, nodeIDs, dataKeys)
+	for _, nodeID := range nodeIDs[:2] {
+		if err := consistentHash.RemoveNode(ctx, nodeID); err != nil {
+			t.Fatal(err)
+		}
+		assertPlacement(t, consistentHash, nodeIDs, dataKeys)
+	}
+
+	// 删除最后一个节点时清空其登记的数据 key
+	if err := consistentHash.RemoveNode(ctx, "node_c"); err != nil {
+		t.Fatal(err)
+	}
+	if recorded := recordedDataKeys(t, consistentHash, nodeIDs...); len(recorded) != 0 {
+		t.Errorf("got %d recorded data keys after removing every node, want 0", len(recorded))
+	}
+}
+
+func Test_IncrDecrScore_RingSize(t *testing.T) {
+	consistentHash := NewConsistentHash(nil, NewMurmurHasher(), nil, WithRingSize(10))
+	if got := consistentHash.incrScore(9); got != 0 {
+		t.Errorf("incrScore(9) got %d, want 0", got)
+	}
+	if got := consistentHash.incrScore(3); got != 4 {
+		t.Errorf("incrScore(3) got %d, want 4", got)
+	}
+	if got := consistentHash.decrScore(0); got != 9 {
+		t.Errorf("decrScore(0) got %d, want 9", got)
+	}
+	if got := consistentHash.decrScore(4); got != 3 {
+		t.Errorf("decrScore(4) got %d, want 3", got)
+	}
+}
+
+// 构造一个登记了 1000 个数据 key 的哈希环，并统计迁移函数搬运过的数据 key 数量
+func newMigrationCountingHash(t *testing.T, moved *int) (*ConsistentHash, []string) {
+	t.Helper()
+	ctx := context.Background()
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		*moved += len(dataKeys)
+		return nil
+	}
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, WithRingSize(1<<20))
+	for _, nodeID := range []string{"node_a", "node_b", "node_c", "node_d"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataKeys := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+			t.Fatal(err)
+		}
+		dataKeys = append(dataKeys, dataKey)
+	}
+	*moved = 0
+	return consistentHash, dataKeys
+}
+
+func Test_CeilingFloor_Boundaries(t *testing.T) {
+	ctx := context.Background()
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(memory.NewHashRing(),
+		tableEncryptor{"node_a": 100, "node_b": 200, "node_c": 300, "node_d": 250, "on_b": 200, "after_b": 201, "on_d": 250},
+		migrator, WithRingSize(1000), WithNodeKeyFormatter(func(nodeID string, index int) string { return nodeID }))
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		find  func(ctx context.Context, score int64) (int64, error)
+		score int64
+		want  int64
+	}{
+		{"ceiling inclusive on node", consistentHash.ceilingInclusive, 200, 200},
+		{"ceiling exclusive on node", consistentHash.ceilingExclusive, 200, 300},
+		{"ceiling exclusive wraps", consistentHash.ceilingExclusive, 300, 100},
+		{"ceiling exclusive at ring end", consistentHash.ceilingExclusive, 999, 100},
+		{"floor inclusive on node", consistentHash.floorInclusive, 200, 200},
+		{"floor exclusive on node", consistentHash.floorExclusive, 200, 100},
+		{"floor exclusive wraps", consistentHash.floorExclusive, 100, 300},
+		{"floor exclusive at ring start", consistentHash.floorExclusive, 0, 300},
+	} {
+		if got, err := tc.find(ctx, tc.score); err != nil || got != tc.want {
+			t.Errorf("%s: got (%d, %v), want %d", tc.name, got, err, tc.want)
+		}
+	}
+
+	// 恰好落在虚拟节点上的数据 key 归属于该虚拟节点，越过一个位置则归属于下一个虚拟节点
+	for dataKey, want := range map[string]string{"on_b": "node_b", "after_b": "node_c", "on_d": "node_c"} {
+		if nodeID, err := consistentHash.GetNode(ctx, dataKey); err != nil || nodeID != want {
+			t.Errorf("%s: got (%s, %v), want %s", dataKey, nodeID, err, want)
+		}
+	}
+
+	// 新增的虚拟节点 250 接管区间 (200, 250]，恰好落在 200 上的数据 key 不迁移，落在 250 上的数据 key 迁移
+	if err := consistentHash.AddNode(ctx, "node_d", 1); err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{{From: "node_c", To: "node_d", DataKeys: map[string]struct{}{"after_b": {}, "on_d": {}}}}
+	if got := migrations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got migrations %+v, want %+v", got, want)
+	}
+}
+
+func Test_UpdateNodeWeight(t *testing.T) {
+	ctx := context.Background()
+	nodeIDs := []string{"node_a", "node_b", "node_c", "node_d"}
+
+	var updateMoved int
+	consistentHash, dataKeys := newMigrationCountingHash(t, &updateMoved)
+	if err := consistentHash.UpdateNodeWeight(ctx, "node_e", 3); err == nil {
+		t.Error("update weight of missing node should fail")
+	}
+	if err := consistentHash.UpdateNodeWeight(ctx, "node_a", 3); err != nil {
+		t.Fatal(err)
+	}
+	assertPlacement(t, consistentHash, nodeIDs, dataKeys)
+	if scores := ringScores(t, consistentHash); len(scores) != 45 {
+		t.Errorf("got %d virtual nodes after increase, want 45", len(scores))
+	}
+
+	var rebuildMoved int
+	rebuilt, _ := newMigrationCountingHash(t, &rebuildMoved)
+	if err := rebuilt.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rebuilt.AddNode(ctx, "node_a", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if updateMoved == 0 || updateMoved >= rebuildMoved {
+		t.Errorf("update weight moved %d keys, remove and add moved %d keys", updateMoved, rebuildMoved)
+	}
+
+	// 权重减少时，被删除的虚拟节点区间内的数据需要交给新的归属节点
+	for _, weight := range []int{1, 4, 2} {
+		if err := consistentHash.UpdateNodeWeight(ctx, "node_b", weight); err != nil {
+			t.Fatal(err)
+		}
+		assertPlacement(t, consistentHash, nodeIDs, dataKeys)
+	}
+	nodes, err := consistentHash.hashRing.Nodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes["node_a"] != 15 || nodes["node_b"] != 10 {
+		t.Errorf("got replicas %v, want node_a=15 node_b=10", nodes)
+	}
+}
+
+// 在注入错误后，让 Node 或 Ceiling 返回指定的错误
+type failingHashRing struct {
+	HashRing
+	nodeErr     error
+	ceilingErr  error
+	dataKeysErr error
+}
+
+func (r *failingHashRing) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	if r.dataKeysErr != nil {
+		return nil, r.dataKeysErr
+	}
+	return r.HashRing.DataKeys(ctx, nodeID)
+}
+
+func (r *failingHashRing) Node(ctx context.Context, score int64) ([]string, error) {
+	if r.nodeErr != nil {
+		return nil, r.nodeErr
+	}
+	return r.HashRing.Node(ctx, score)
+}
+
+func (r *failingHashRing) Ceiling(ctx context.Context, score int64) (int64, error) {
+	if r.ceilingErr != nil {
+		return -1, r.ceilingErr
+	}
+	return r.HashRing.Ceiling(ctx, score)
+}
+
+func Test_MigrateOut_Error(t *testing.T) {
+	ctx := context.Background()
+	hashRing := &failingHashRing{HashRing: memory.NewHashRing()}
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, WithReplicas(1))
+	for _, nodeID := range []string{"node_a", "node_b"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 找到一个归属于 node_a 的数据，保证 migrateOut 需要为 node_a 的虚拟节点寻找后继节点
+	for i := 0; ; i++ {
+		nodeID, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodeID == "node_a" {
+			break
+		}
+	}
+	virtualScore := consistentHash.getVirtualScore("node_a", 0)
+
+	nodeErr := errors.New("node failed")
+	hashRing.nodeErr = nodeErr
+	if _, _, _, err := consistentHash.migrateOut(ctx, virtualScore, "node_a"); !errors.Is(err, nodeErr) {
+		t.Errorf("got err %v, want %v", err, nodeErr)
+	}
+	hashRing.nodeErr = nil
+
+	// getvaildNextNode 的错误不能被覆盖
+	ceilingErr := errors.New("ceiling failed")
+	hashRing.ceilingErr = ceilingErr
+	if _, _, _, err := consistentHash.migrateOut(ctx, virtualScore, "node_a"); !errors.Is(err, ceilingErr) {
+		t.Errorf("got err %v, want %v", err, ceilingErr)
+	}
+}
+
+func Test_MigrateIn_DataKeysError(t *testing.T) {
+	ctx := context.Background()
+	hashRing := &failingHashRing{HashRing: memory.NewHashRing()}
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator)
+	for _, nodeID := range []string{"node_a", "node_b"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 读取后继节点的数据 key 失败时不能当作没有数据需要迁移
+	dataKeysErr := errors.New("data keys failed")
+	hashRing.dataKeysErr = dataKeysErr
+	if err := consistentHash.UpdateNodeWeight(ctx, "node_a", 2); !errors.Is(err, dataKeysErr) {
+		t.Errorf("update node weight: got err %v, want %v", err, dataKeysErr)
+	}
+	if err := consistentHash.AddNode(ctx, "node_c", 1); !errors.Is(err, dataKeysErr) {
+		t.Errorf("add node: got err %v, want %v", err, dataKeysErr)
+	}
+	if got := migrations(); len(got) != 0 {
+		t.Errorf("got %d migrations, want none after failed reads", len(got))
+	}
+}
+
+// 校验记录的迁移任务恰好覆盖了归属节点发生变化的数据 key
+func assertMigrations(t *testing.T, migrations []Migration, before, after map[string]string) {
+	t.Helper()
+	moved := make(map[string]Migration)
+	for _, migration := range migrations {
+		for dataKey := range migration.DataKeys {
+			if _, ok := moved[dataKey]; ok {
+				t.Errorf("data %s migrated more than once", dataKey)
+			}
+			moved[dataKey] = migration
+		}
+	}
+	for dataKey, owner := range after {
+		migration, ok := moved[dataKey]
+		if before[dataKey] == owner {
+			if ok {
+				t.Errorf("data %s stays on %s but migrated from %s to %s", dataKey, owner, migration.From, migration.To)
+			}
+			continue
+		}
+		if !ok || migration.From != before[dataKey] || migration.To != owner {
+			t.Errorf("data %s moves from %s to %s, got migration %+v", dataKey, before[dataKey], owner, migration)
+		}
+	}
+}
+
+func Test_NewRecordingMigrator(t *testing.T) {
+	ctx := context.Background()
+	nodeIDs := []string{"node_a", "node_b", "node_c"}
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator)
+	for _, nodeID := range nodeIDs[:2] {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := recordedDataKeys(t, consistentHash, nodeIDs...)
+	if err := consistentHash.AddNode(ctx, "node_c", 1); err != nil {
+		t.Fatal(err)
+	}
+	after := recordedDataKeys(t, consistentHash, nodeIDs...)
+	recorded := migrations()
+	if len(recorded) == 0 {
+		t.Fatal("expect migrations to node_c to be recorded")
+	}
+	assertMigrations(t, recorded, before, after)
+
+	before = after
+	if err := consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	after = recordedDataKeys(t, consistentHash, nodeIDs...)
+	assertMigrations(t, migrations()[len(recorded):], before, after)
+}
+
+func Test_WithMigrationConcurrency(t *testing.T) {
+	ctx := context.Background()
+	const concurrency = 3
+	var (
+		mu               sync.Mutex
+		running, maxSeen int
+		tasks            int
+	)
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		mu.Lock()
+		running++
+		tasks++
+		if running > maxSeen {
+			maxSeen = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, WithMigrationConcurrency(concurrency))
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := consistentHash.AddNodeWithReplicas(ctx, "node_b", 50); err != nil {
+		t.Fatal(err)
+	}
+	if tasks <= concurrency {
+		t.Fatalf("got %d migration tasks, want more than %d", tasks, concurrency)
+	}
+	if maxSeen > concurrency {
+		t.Errorf("got %d concurrent migrations, want at most %d", maxSeen, concurrency)
+	}
+}
+
+func Test_BatchExecuteMigrator_CancelOnError(t *testing.T) {
+	ctx := context.Background()
+	errMigrate := errors.New("mock migrate error")
+	var (
+		mu       sync.Mutex
+		canceled int
+		started  sync.WaitGroup
+	)
+	started.Add(3)
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		started.Done()
+		if from == "node_bad" {
+			// 等其余任务都开始执行后再失败
+			started.Wait()
+			return errMigrate
+		}
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			canceled++
+			mu.Unlock()
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	}
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, WithMigrationConcurrency(3))
+
+	datas := map[string]struct{}{"data_a": {}}
+	err := consistentHash.batchExecuteMigrator(ctx, []migrateTask{
+		consistentHash.newMigrateTask(ctx, datas, "node_a", "node_b"),
+		consistentHash.newMigrateTask(ctx, datas, "node_bad", "node_b"),
+		consistentHash.newMigrateTask(ctx, datas, "node_c", "node_b"),
+		// 并发数已满，失败发生后才会开始执行，不会再调用迁移函数
+		consistentHash.newMigrateTask(ctx, datas, "node_d", "node_b"),
+	})
+	if !errors.Is(err, errMigrate) {
+		t.Fatalf("got err: %v, want: %v", err, errMigrate)
+	}
+	if canceled != 2 {
+		t.Errorf("got %d canceled migrators, want 2", canceled)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got err: %v, want canceled tasks reported", err)
+	}
+}
+
+// 模拟先删除后添加的过程中，添加数据 key 时进程异常退出
+type crashingHashRing struct {
+	*memory.HashRing
+}
+
+func (r crashingHashRing) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	return errors.New("mock crash")
+}
+
+func Test_MoveDataKeys_Atomic(t *testing.T) {
+	ctx := context.Background()
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		return nil
+	}
+	hashRing := memory.NewHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator)
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	dataKeys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+			t.Fatal(err)
+		}
+		dataKeys = append(dataKeys, dataKey)
+	}
+
+	// 迁移过程中单独的 AddNodeToDataKeys 会失败，通过 MoveDataKeys 迁移的数据 key 不受影响
+	consistentHash.hashRing = crashingHashRing{HashRing: hashRing}
+	if err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	recorded := recordedDataKeys(t, consistentHash, "node_a", "node_b")
+	if len(recorded) != len(dataKeys) {
+		t.Errorf("got %d recorded data keys, want %d", len(recorded), len(dataKeys))
+	}
+	assertPlacement(t, consistentHash, []string{"node_a", "node_b"}, dataKeys)
+}
+
+func Test_AddNodes(t *testing.T) {
+	ctx := context.Background()
+	nodeIDs := []string{"node_a", "node_b", "node_c", "node_d", "node_e"}
+	dataKeys := make([]string, 0, 500)
+	for i := 0; i < 500; i++ {
+		dataKeys = append(dataKeys, fmt.Sprintf("data_%d", i))
+	}
+	newHash := func() (*ConsistentHash, func() []Migration) {
+		migrator, migrations := NewRecordingMigrator()
+		consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator)
+		for _, nodeID := range nodeIDs[:2] {
+			if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, dataKey := range dataKeys {
+			if _, err := consistentHash.GetNode(ctx, dataKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return consistentHash, migrations
+	}
+	migratedKeys := func(migrations []Migration) int {
+		var total int
+		for _, migration := range migrations {
+			total += len(migration.DataKeys)
+		}
+		return total
+	}
+
+	sequential, sequentialMigrations := newHash()
+	for _, nodeID := range nodeIDs[2:] {
+		if err := sequential.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bulk, bulkMigrations := newHash()
+	before := recordedDataKeys(t, bulk, nodeIDs...)
+	if err := bulk.AddNodes(ctx, map[string]int{"node_c": 1, "node_d": 1, "node_e": 1}); err != nil {
+		t.Fatal(err)
+	}
+	after := recordedDataKeys(t, bulk, nodeIDs...)
+	assertPlacement(t, bulk, nodeIDs, dataKeys)
+	assertMigrations(t, bulkMigrations(), before, after)
+
+	// 两种方式得到的拓扑一致，批量添加的迁移量不超过逐个添加
+	if fmt.Sprint(ringScores(t, bulk)) != fmt.Sprint(ringScores(t, sequential)) {
+		t.Error("bulk add should produce the same ring as sequential adds")
+	}
+	if bulkKeys, sequentialKeys := migratedKeys(bulkMigrations()), migratedKeys(sequentialMigrations()); bulkKeys > sequentialKeys {
+		t.Errorf("bulk add migrated %d keys, more than %d of sequential adds", bulkKeys, sequentialKeys)
+	}
+
+	if err := bulk.AddNodes(ctx, map[string]int{"node_f": 1, "node_a": 1}); err == nil {
+		t.Error("add nodes containing an existing node should fail")
+	}
+}
+
+func Test_RemoveNodeParkData(t *testing.T) {
+	ctx := context.Background()
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator)
+	for _, nodeID := range []string{"node_a", "node_b"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := make(map[string]struct{})
+	for i := 0; i < 100; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		nodeID, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodeID == "node_a" {
+			want[dataKey] = struct{}{}
+		}
+	}
+
+	parked, err := consistentHash.RemoveNodeParkData(ctx, "node_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parked, want) {
+		t.Errorf("got %d parked keys, want %d", len(parked), len(want))
+	}
+	if len(migrations()) != 0 {
+		t.Errorf("parking data should not migrate, got migrations %v", migrations())
+	}
+
+	// 节点不再参与路由
+	if exist, err := consistentHash.NodeExists(ctx, "node_a"); err != nil || exist {
+		t.Errorf("got node_a exists (%t, %v), want removed", exist, err)
+	}
+	for i := 0; i < 100; i++ {
+		if nodeID, err := consistentHash.GetNodeReadOnly(ctx, fmt.Sprintf("data_%d", i)); err != nil || nodeID != "node_b" {
+			t.Fatalf("got node (%s, %v), want node_b", nodeID, err)
+		}
+	}
+
+	// 数据 key 不再登记在被删除的节点下，但可以从停放标记下查询
+	if dataKeys, err := consistentHash.hashRing.DataKeys(ctx, "node_a"); err != nil || len(dataKeys) != 0 {
+		t.Errorf("got node_a data keys (%v, %v), want empty", dataKeys, err)
+	}
+	if dataKeys, err := consistentHash.ParkedDataKeys(ctx, "node_a"); err != nil || !reflect.DeepEqual(dataKeys, want) {
+		t.Errorf("got %d parked data keys (%v), want %d", len(dataKeys), err, len(want))
+	}
+
+	if _, err = consistentHash.RemoveNodeParkData(ctx, "node_a"); err == nil {
+		t.Error("parking a missing node should fail")
+	}
+}
+
+func Test_EnsureNode(t *testing.T) {
+	ctx := context.Background()
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator)
+	replicas := func(nodeID string) int {
+		t.Helper()
+		nodes, err := consistentHash.hashRing.Nodes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nodes[nodeID]
+	}
+
+	// 新节点
+	if err := consistentHash.EnsureNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := consistentHash.EnsureNode(ctx, "node_b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := replicas("node_b"); got != 2*consistentHash.Replicas() {
+		t.Fatalf("got node_b replicas %d, want %d", got, 2*consistentHash.Replicas())
+	}
+	dataKeys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		dataKeys = append(dataKeys, fmt.Sprintf("data_%d", i))
+		if _, err := consistentHash.GetNode(ctx, dataKeys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	generation, err := consistentHash.Generation(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated := len(migrations())
+
+	// 权重相同的重复添加不修改哈希环
+	if err = consistentHash.EnsureNode(ctx, "node_b", 2); err != nil {
+		t.Fatalf("re-add with same weight: %v", err)
+	}
+	if got, err := consistentHash.Generation(ctx); err != nil || got != generation {
+		t.Errorf("got generation (%d, %v) after same-weight re-add, want %d", got, err, generation)
+	}
+	if len(migrations()) != migrated {
+		t.Error("same-weight re-add should not migrate data")
+	}
+
+	// 权重变化时调整虚拟节点个数
+	if err = consistentHash.EnsureNode(ctx, "node_b", 4); err != nil {
+		t.Fatalf("re-add with changed weight: %v", err)
+	}
+	if got := replicas("node_b"); got != 4*consistentHash.Replicas() {
+		t.Errorf("got node_b replicas %d, want %d", got, 4*consistentHash.Replicas())
+	}
+	assertPlacement(t, consistentHash, []string{"node_a", "node_b"}, dataKeys)
+
+	if err = consistentHash.AddNode(ctx, "node_b", 4); !errors.Is(err, ErrNodeExists) {
+		t.Errorf("add existing node: got %v, want ErrNodeExists", err)
+	}
+}
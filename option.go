@@ -1,21 +1,256 @@
 package consistent_hash
 
+import (
+	"math"
+	"runtime"
+	"time"
+)
+
 type ConsistentHashOptions struct {
 	lockExpireSeconds int
 	replicas          int
+	// 是否通过 WithReplicas 显式配置了放大系数
+	replicasConfigured bool
+	// 哈希环的长度，环上的位置范围为 [0, ringSize)
+	ringSize int64
+	logger   Logger
+	// 是否跳过哈希环的全局锁
+	disableLocking bool
+	// 生成用于计算虚拟节点位置的 key
+	nodeKeyFormatter NodeKeyFormatter
+	// 持有锁期间是否在后台自动为锁续期
+	lockWatchDog bool
+	// 加锁的最长等待时间，为 0 时沿用哈希环 Lock 自身的语义
+	lockMaxWait time.Duration
+	// 是否允许删除哈希环中最后一个真实节点
+	allowRemoveLastNode bool
+	// 数据 key 登记缓存的容量，为 0 时不启用
+	dataKeyCacheSize int
+	metrics          Metrics
+	tracer           Tracer
+	// 有界负载模式的负载系数，为 0 时不启用
+	loadFactor float64
+	// 数据 key 的散列函数，为空时使用虚拟节点的 encryptor
+	dataKeyEncryptor Encryptor
+	// 是否关闭数据 key 的登记
+	disableDataKeyTracking bool
+	// 数据迁移任务的最大并发数
+	migrationConcurrency int
+	// 虚拟节点上存在多个真实节点时，是否按照数据 key 在列表中分散选择
+	spreadCollisions bool
+	// 权重到虚拟节点个数的缩放函数，为空时按照权重线性放大
+	weightScaler WeightScaler
+	// 虚拟节点与其他真实节点的虚拟节点位置冲突时，是否加盐重新散列
+	collisionRehash bool
+	// 单次 ReconcileKeys 至多检查的数据 key 个数
+	reconcileBatchSize int
+	clock              Clock
+	// GetNode 是否先不加锁乐观检索
+	optimisticGetNode bool
 }
 
 type ConsistentHashOption func(opts *ConsistentHashOptions)
 
+// 虚拟节点 key 的生成函数，index 为虚拟节点的序号，生成的 key 经过 encryptor 散列后作为虚拟节点在哈希环上的位置
+type NodeKeyFormatter func(nodeID string, index int) string
+
 func WithLockExpireSeconds(seconds int) ConsistentHashOption {
 	return func(opts *ConsistentHashOptions) {
 		opts.lockExpireSeconds = seconds
 	}
 }
 
+// 设置虚拟节点的放大系数，真实节点的虚拟节点个数为权重乘以 replicas，默认为 5
+// replicas 必须大于等于 1，否则 AddNode 等操作会返回 ErrInvalidReplicas
 func WithReplicas(replicas int) ConsistentHashOption {
 	return func(opts *ConsistentHashOptions) {
 		opts.replicas = replicas
+		opts.replicasConfigured = true
+	}
+}
+
+// 自定义哈希环的长度，encryptor 的结果以及数据迁移时的位置计算都会基于该长度取模
+func WithRingSize(ringSize int64) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.ringSize = ringSize
+	}
+}
+
+// 注入日志打印器，用于输出加锁、数据迁移任务分发以及迁移失败等关键信息
+func WithLogger(logger Logger) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.logger = logger
+	}
+}
+
+// 是否启用哈希环的全局锁，默认启用
+// 仅当哈希环只被单个进程读写时才可以关闭，关闭后 ConsistentHash 不会再调用 HashRing 的 Lock 和 Unlock
+func WithLocking(enabled bool) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.disableLocking = !enabled
+	}
+}
+
+// 自定义虚拟节点 key 的格式，用于和已有集群（如 ketama 的 nodeID-index）的虚拟节点布局保持一致
+// formatter 只影响虚拟节点的位置，哈希环中存储的仍然是可以还原出真实节点 id 的内部编码
+func WithNodeKeyFormatter(formatter NodeKeyFormatter) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.nodeKeyFormatter = formatter
+	}
+}
+
+// 开启锁的看门狗，持有哈希环锁期间每隔 lockExpireSeconds/3 为锁续期一次，避免耗时较长的数据迁移期间锁过期
+// 需要哈希环实现 LockRenewer 接口，否则该配置不生效
+func WithLockWatchDog() ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.lockWatchDog = true
+	}
+}
+
+// 设置加锁的最长等待时间，超过 maxWait 仍未获取到哈希环的锁时返回 ErrRingLocked，便于调用方退避重试
+// 锁被占用时的重试依赖哈希环实现 LockBusyChecker 接口，否则只在 Lock 阻塞超过 maxWait 时返回 ErrRingLocked
+func WithLockMaxWait(maxWait time.Duration) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.lockMaxWait = maxWait
+	}
+}
+
+// 允许通过 RemoveNode 删除哈希环中最后一个真实节点，用于整体拆除哈希环
+// 默认情况下最后一个节点的数据没有后继节点可以托付，删除会失败；开启后其数据 key 会被清空，
+// 倘若注入了迁移函数，全部数据会以 to 为空的形式交给迁移函数处理
+func WithAllowRemoveLastNode() ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.allowRemoveLastNode = true
+	}
+}
+
+// 启用容量为 size 的进程内 LRU 缓存，记录数据 key 上一次登记的真实节点
+// 重复调用 GetNode 检索同一个数据 key 时，倘若结果不变且期间本实例没有执行过节点变更，则跳过数据 key 的重复登记
+// 缓存只感知本实例发起的节点变更，其他进程修改哈希环后，结果不变的数据 key 仍然会跳过登记
+func WithDataKeyCache(size int) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.dataKeyCacheSize = size
+	}
+}
+
+// 注入指标上报器，用于观测数据迁移量、AddNode/RemoveNode/GetNode 的耗时以及真实节点个数
+func WithMetrics(metrics Metrics) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.metrics = metrics
+	}
+}
+
+// 注入链路追踪器，AddNode、RemoveNode 以及 GetNode 会各自开启一个 span
+func WithTracer(tracer Tracer) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.tracer = tracer
+	}
+}
+
+// 是否登记数据 key 与真实节点的映射关系，默认开启
+// 仅做请求路由、不关心数据归属的场景可以关闭，关闭后 GetNode 不再登记数据 key，节点变更时也不会执行数据迁移
+func WithDataKeyTracking(enabled bool) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.disableDataKeyTracking = !enabled
+	}
+}
+
+// 设置数据迁移任务的最大并发数，避免节点变更时产生的大量迁移任务同时压向下游，默认为 GOMAXPROCS
+func WithMigrationConcurrency(n int) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.migrationConcurrency = n
+	}
+}
+
+// 多个真实节点的虚拟节点落在同一位置时，默认总是选择列表的首个节点，开启后按照数据 key 的散列值在列表中选择，同一个数据 key 的结果保持稳定
+// 数据迁移同样按照该规则确定数据 key 的归属节点；删除节点以及减少权重时，节点上的数据 key 会逐个重新检索归属节点，开销与数据 key 个数成正比
+// 数据 key 的归属依赖该配置，因此已经登记过数据 key 的哈希环不能切换该配置，否则需要通过 ReconcileKeys 修正
+func WithCollisionSpreading() ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.spreadCollisions = true
+	}
+}
+
+// 权重到虚拟节点个数的缩放函数，真实节点的虚拟节点个数为 round(scaler(weight) * replicas)，至少为 1
+// weight 为已经限定在 [1,10] 范围内的权重
+type WeightScaler func(weight int) float64
+
+// 线性缩放，权重为 10 的节点的虚拟节点个数是权重为 1 的节点的 10 倍，默认的缩放方式
+func LinearWeightScaler(weight int) float64 {
+	return float64(weight)
+}
+
+// 平方根缩放，权重为 10 的节点的虚拟节点个数约为权重为 1 的节点的 3.2 倍
+func SqrtWeightScaler(weight int) float64 {
+	return math.Sqrt(float64(weight))
+}
+
+// 对数缩放（1 + ln(weight)），权重为 10 的节点的虚拟节点个数约为权重为 1 的节点的 3.3 倍
+func LogWeightScaler(weight int) float64 {
+	return 1 + math.Log(float64(weight))
+}
+
+// 设置权重到虚拟节点个数的缩放函数，默认为 LinearWeightScaler
+// 线性缩放下权重较高的节点会占用大量虚拟节点，放大系数较小时权重较低的节点承载的数据波动较大，
+// 可以通过平方根或者对数缩放压缩节点之间的差距。只影响之后添加或调整权重的节点，已有节点的虚拟节点个数保持不变
+func WithWeightScaler(scaler WeightScaler) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.weightScaler = scaler
+	}
+}
+
+// 虚拟节点与其他真实节点的虚拟节点落在同一位置时，默认追加到该位置真实节点列表的末尾，由于数据只归属于列表的首个节点，
+// 追加的虚拟节点不会承载任何数据。开启后对这类虚拟节点的 key 加盐重新散列，直到找到没有其他真实节点的位置，
+// 同一真实节点的多个虚拟节点之间的冲突不影响数据归属，仍然保留在原位置
+// 加盐后的位置依赖添加时哈希环的状态，因此已经存在虚拟节点的哈希环不能关闭该配置，否则加盐的虚拟节点无法被定位和删除
+func WithCollisionRehash() ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.collisionRehash = true
+	}
+}
+
+// 为数据 key 单独指定散列函数，默认与虚拟节点共用 NewConsistentHash 传入的 encryptor
+// 用于降低数据位置与虚拟节点位置之间的相关性。GetNode 以及数据迁移时的区间计算都会使用该散列函数，
+// 因此已经登记过数据 key 的哈希环不能更换该配置，否则已登记的数据 key 会被迁移到错误的节点
+func WithDataKeyEncryptor(encryptor Encryptor) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.dataKeyEncryptor = encryptor
+	}
+}
+
+// 开启有界负载模式（consistent hashing with bounded loads），单个真实节点登记的数据 key 个数不超过平均值的 loadFactor 倍
+// GetNode 选中的节点已经达到上限时，顺时针寻找下一个仍有余量的节点，已经登记过的数据 key 仍然返回其登记的节点
+// 负载以登记的数据 key 个数衡量，因此需要开启数据 key 登记，且每次 GetNode 都会读取全部真实节点的数据 key，适用于数据 key 规模不大的场景
+// 未超出负载时的结果与 GetNodeReadOnly 一致，超出时 GetNodeReadOnly 仍然返回哈希环上的归属节点
+// loadFactor 需要大于 1，否则该配置不生效
+func WithBoundedLoad(loadFactor float64) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.loadFactor = loadFactor
+	}
+}
+
+// 设置单次 ReconcileKeys 至多检查的数据 key 个数，默认为 1000
+func WithReconcileBatchSize(n int) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.reconcileBatchSize = n
+	}
+}
+
+// 注入时钟，默认使用系统时间，用于在测试中驱动锁看门狗的续期以及加锁重试的等待
+// WithLockMaxWait 的等待窗口依赖 ctx 的超时，仍然使用系统时间
+func WithClock(clock Clock) ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.clock = clock
+	}
+}
+
+// 开启乐观检索，GetNode 不再加锁，而是对比检索前后的哈希环代数以及本实例的加锁次数，发生变化时再加锁重试，
+// 避免并发的 GetNode 在哈希环的锁上排队。每次检索额外读取两次代数，哈希环没有实现 GenerationCounter 时只能感知本实例发起的变更
+// 其他实例正在执行、尚未递增代数的变更无法被感知，期间登记的数据 key 可能停留在原节点，可以通过 ReconcileKeys 修正
+// 有界负载模式下该配置不生效
+func WithOptimisticGetNode() ConsistentHashOption {
+	return func(opts *ConsistentHashOptions) {
+		opts.optimisticGetNode = true
 	}
 }
 
@@ -25,7 +260,40 @@ func repair(opts *ConsistentHashOptions) {
 		opts.lockExpireSeconds = 15
 	}
 
-	if opts.replicas <= 0 {
+	// 未配置时使用默认值，显式配置的非法值保留下来，由依赖放大系数的操作返回配置错误，避免产生没有虚拟节点的真实节点
+	if !opts.replicasConfigured {
 		opts.replicas = 5
 	}
-}
\ No newline at end of file
+
+	if opts.ringSize <= 1 {
+		opts.ringSize = DefaultRingSize
+	}
+
+	if opts.logger == nil {
+		opts.logger = nopLogger{}
+	}
+
+	if opts.migrationConcurrency <= 0 {
+		opts.migrationConcurrency = runtime.GOMAXPROCS(0)
+	}
+
+	if opts.metrics == nil {
+		opts.metrics = nopMetrics{}
+	}
+
+	if opts.nodeKeyFormatter == nil {
+		opts.nodeKeyFormatter = defaultNodeKeyFormatter
+	}
+
+	if opts.loadFactor <= 1 {
+		opts.loadFactor = 0
+	}
+
+	if opts.reconcileBatchSize <= 0 {
+		opts.reconcileBatchSize = 1000
+	}
+
+	if opts.clock == nil {
+		opts.clock = realClock{}
+	}
+}
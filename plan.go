@@ -0,0 +1,98 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// 添加节点的执行计划，由 PrepareAddNode 生成，生成后一直持有哈希环的锁，直到 CommitPlan 或者 AbortPlan
+type AddNodePlan struct {
+	NodeID   string
+	Replicas int
+	// 下标为虚拟节点的序号，与已有虚拟节点重合的位置同样包含在内
+	VirtualScores []int64
+	// 需要从已有节点迁移到新节点的数据，按照迁移起点的节点 id 排序
+	Migrations []Migration
+
+	c        *ConsistentHash
+	mu       sync.Mutex
+	finished bool
+}
+
+// 添加节点的第一阶段，计算添加节点时的虚拟节点位置与数据迁移明细，不修改哈希环也不调用迁移函数
+// 返回的计划持有哈希环的锁，检查之后需要调用 CommitPlan 执行或者 AbortPlan 放弃，否则锁只能等待过期
+// 检查耗时可能超过锁的过期时间时，需要配合 WithLockWatchDog 使用
+func (c *ConsistentHash) PrepareAddNode(ctx context.Context, nodeID string, weight int) (*AddNodePlan, error) {
+	replicas, err := c.getReplicas(weight)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	moved, virtualScores, err := c.planAddNode(ctx, nodeID, replicas)
+	if err != nil {
+		c.unlock(ctx)
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(moved))
+	for from, dataKeys := range moved {
+		migrations = append(migrations, Migration{From: from, To: nodeID, DataKeys: dataKeys})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].From < migrations[j].From
+	})
+
+	return &AddNodePlan{
+		NodeID:        nodeID,
+		Replicas:      replicas,
+		VirtualScores: virtualScores,
+		Migrations:    migrations,
+		c:             c,
+	}, nil
+}
+
+// 添加节点的第二阶段，按照计划添加节点并执行数据迁移，结束后释放哈希环的锁
+// 持有锁期间哈希环不会发生变化，因此实际的虚拟节点位置以及迁移的数据与计划一致
+func (c *ConsistentHash) CommitPlan(ctx context.Context, plan *AddNodePlan) (err error) {
+	if err = c.finishPlan(plan); err != nil {
+		return err
+	}
+
+	defer c.observeLatency(OpAddNode, c.opts.clock.Now())
+	ctx, span := c.startSpan(ctx, OpAddNode, map[string]interface{}{AttrNodeID: plan.NodeID})
+	defer func() { span.end(err, nil) }()
+
+	defer c.unlock(ctx)
+	c.invalidateDataKeyCache()
+	return c.addNodeLocked(ctx, plan.NodeID, plan.Replicas)
+}
+
+// 放弃执行计划并释放哈希环的锁
+func (c *ConsistentHash) AbortPlan(ctx context.Context, plan *AddNodePlan) error {
+	if err := c.finishPlan(plan); err != nil {
+		return err
+	}
+	c.unlock(ctx)
+	return nil
+}
+
+// 将计划标记为已结束，每个计划只能被提交或者放弃一次
+func (c *ConsistentHash) finishPlan(plan *AddNodePlan) error {
+	if plan == nil || plan.c != c {
+		return errors.New("plan is not prepared by this consistent hash")
+	}
+
+	plan.mu.Lock()
+	defer plan.mu.Unlock()
+	if plan.finished {
+		return errors.New("plan already committed or aborted")
+	}
+	plan.finished = true
+	return nil
+}
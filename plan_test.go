@@ -0,0 +1,111 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func Test_PrepareAddNode_CommitPlan(t *testing.T) {
+	ctx := context.Background()
+	nodeIDs := []string{"node_a", "node_b", "node_c"}
+	newRing := func() (*ConsistentHash, func() []Migration) {
+		migrator, migrations := NewRecordingMigrator()
+		consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator)
+		for _, nodeID := range nodeIDs[:2] {
+			if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 200; i++ {
+			if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return consistentHash, migrations
+	}
+
+	planned, migrations := newRing()
+	plan, err := planned.PrepareAddNode(ctx, "node_c", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.VirtualScores) != plan.Replicas || plan.Replicas != 2*planned.Replicas() {
+		t.Errorf("got %d virtual scores for %d replicas", len(plan.VirtualScores), plan.Replicas)
+	}
+	for i, score := range plan.VirtualScores {
+		if want := planned.getVirtualScore("node_c", i); score != want {
+			t.Errorf("virtual score %d: got %d, want %d", i, score, want)
+		}
+	}
+	// 提交之前哈希环保持不变
+	if exists, err := planned.NodeExists(ctx, "node_c"); err != nil || exists {
+		t.Fatalf("got (%v, %v), node_c should not exist before commit", exists, err)
+	}
+	if len(migrations()) != 0 {
+		t.Fatal("prepare should not invoke the migrator")
+	}
+
+	if err = planned.CommitPlan(ctx, plan); err != nil {
+		t.Fatal(err)
+	}
+	if err = planned.CommitPlan(ctx, plan); err == nil {
+		t.Error("commit a plan twice: got nil error")
+	}
+
+	// 实际执行的数据迁移与计划一致
+	migrated := make(map[string]map[string]struct{})
+	for _, migration := range migrations() {
+		if migrated[migration.From] == nil {
+			migrated[migration.From] = make(map[string]struct{})
+		}
+		for dataKey := range migration.DataKeys {
+			migrated[migration.From][dataKey] = struct{}{}
+		}
+	}
+	want := make(map[string]map[string]struct{})
+	for _, migration := range plan.Migrations {
+		want[migration.From] = migration.DataKeys
+	}
+	if !reflect.DeepEqual(migrated, want) {
+		t.Errorf("got migrations %v, want planned %v", migrated, want)
+	}
+
+	// 与直接调用 AddNode 的结果一致
+	direct, _ := newRing()
+	if err = direct.AddNode(ctx, "node_c", 2); err != nil {
+		t.Fatal(err)
+	}
+	plannedNodes, err := planned.virtualNodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directNodes, err := direct.virtualNodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plannedNodes, directNodes) {
+		t.Error("virtual nodes differ from a direct AddNode")
+	}
+	if !reflect.DeepEqual(recordedDataKeys(t, planned, nodeIDs...), recordedDataKeys(t, direct, nodeIDs...)) {
+		t.Error("recorded data keys differ from a direct AddNode")
+	}
+
+	// 放弃计划后释放锁，哈希环不变
+	plan, err = direct.PrepareAddNode(ctx, "node_d", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = direct.AbortPlan(ctx, plan); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := direct.NodeExists(ctx, "node_d"); err != nil || exists {
+		t.Fatalf("got (%v, %v), node_d should not exist after abort", exists, err)
+	}
+	if err = direct.AddNode(ctx, "node_d", 1); err != nil {
+		t.Fatal(err)
+	}
+}
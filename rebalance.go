@@ -0,0 +1,123 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// 预估添加节点时需要迁移的数据，返回会被迁移到新节点的数据 key 集合
+// 该方法只读取哈希环，不会修改哈希环也不会调用迁移函数，用于在真正执行 AddNode 之前评估数据迁移的规模
+func (c *ConsistentHash) SimulateAddNode(ctx context.Context, nodeID string, weight int) (map[string]struct{}, error) {
+	replicas, err := c.getReplicas(weight)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer c.unlock(ctx)
+
+	moved, _, err := c.planAddNode(ctx, nodeID, replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	movedKeys := make(map[string]struct{})
+	for _, dataKeys := range moved {
+		for dataKey := range dataKeys {
+			movedKeys[dataKey] = struct{}{}
+		}
+	}
+	return movedKeys, nil
+}
+
+// 在已经持有哈希环锁的前提下，计算添加节点时每个虚拟节点的位置，以及需要从各个已有节点迁移到新节点的数据 key
+// moved 为迁移起点的节点 id 到数据 key 集合的映射，virtualScores 的下标为虚拟节点的序号，只读取哈希环
+func (c *ConsistentHash) planAddNode(ctx context.Context, nodeID string, replicas int) (moved map[string]map[string]struct{}, virtualScores []int64, err error) {
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := nodes[nodeID]; ok {
+		return nil, nil, fmt.Errorf("repeat node: %s, err: %w", nodeID, ErrNodeExists)
+	}
+
+	virtualNodes, err := c.virtualNodes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scores := make([]int64, 0, len(virtualNodes))
+	for score := range virtualNodes {
+		scores = append(scores, score)
+	}
+
+	// 新节点的虚拟节点与已有虚拟节点重合时，新节点会追加到真实节点列表的末尾，未开启冲突分散时不会承载数据
+	virtualScores = make([]int64, 0, replicas)
+	newScores := make(map[int64]struct{}, replicas)
+	// 新节点追加到已有虚拟节点上的虚拟节点 key
+	collided := make(map[int64][]string)
+	for i := 0; i < replicas; i++ {
+		// 开启 WithCollisionRehash 时与 pickVirtualScore 一致，跳过已经存在其他真实节点的位置
+		score := c.getVirtualScore(nodeID, i)
+		for _, candidate := range c.virtualScoreCandidates(nodeID, i) {
+			if _, ok := virtualNodes[candidate]; !ok {
+				score = candidate
+				break
+			}
+		}
+		virtualScores = append(virtualScores, score)
+		if _, ok := virtualNodes[score]; ok {
+			collided[score] = append(collided[score], c.getRawNodeKey(nodeID, i))
+			continue
+		}
+		if _, ok := newScores[score]; ok {
+			continue
+		}
+		newScores[score] = struct{}{}
+		scores = append(scores, score)
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i] < scores[j]
+	})
+
+	// 在添加新节点后的哈希环上重新检索每个数据 key，归属于新虚拟节点的数据即为需要迁移的数据
+	moved = make(map[string]map[string]struct{})
+	if len(newScores) == 0 && (!c.opts.spreadCollisions || len(collided) == 0) {
+		return moved, virtualScores, nil
+	}
+	for _nodeID := range nodes {
+		dataKeys, err := c.hashRing.DataKeys(ctx, _nodeID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for dataKey := range dataKeys {
+			dataScore := c.getScore(dataKey)
+			index := sort.Search(len(scores), func(i int) bool {
+				return scores[i] >= dataScore
+			})
+			if index == len(scores) {
+				index = 0
+			}
+			if _, ok := newScores[scores[index]]; !ok && !c.pickedAfterCollision(dataKey, virtualNodes[scores[index]], collided[scores[index]], nodeID) {
+				continue
+			}
+			if moved[_nodeID] == nil {
+				moved[_nodeID] = make(map[string]struct{})
+			}
+			moved[_nodeID][dataKey] = struct{}{}
+		}
+	}
+	return moved, virtualScores, nil
+}
+
+// 开启冲突分散时，新节点的虚拟节点 key added 追加到已有的真实节点列表 rawNodeKeys 之后，数据 key 是否会改为选中新节点
+func (c *ConsistentHash) pickedAfterCollision(dataKey string, rawNodeKeys, added []string, nodeID string) bool {
+	if !c.opts.spreadCollisions || len(added) == 0 {
+		return false
+	}
+	rawNodeKeys = append(append(make([]string, 0, len(rawNodeKeys)+len(added)), rawNodeKeys...), added...)
+	return c.getNodeID(rawNodeKeys[c.pickIndex(dataKey, rawNodeKeys)]) == nodeID
+}
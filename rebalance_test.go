@@ -0,0 +1,68 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func Test_SimulateAddNode(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu        sync.Mutex
+		movedKeys = make(map[string]struct{})
+	)
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for dataKey := range dataKeys {
+			movedKeys[dataKey] = struct{}{}
+		}
+		return nil
+	}
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator)
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := consistentHash.SimulateAddNode(ctx, "node_a", 1); err == nil {
+		t.Error("simulate adding an existing node should fail")
+	}
+
+	before, err := consistentHash.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	simulated, err := consistentHash.SimulateAddNode(ctx, "node_d", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := consistentHash.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before.VirtualNodes) != len(after.VirtualNodes) || len(after.Replicas) != 3 {
+		t.Fatal("simulate add node should not modify the ring")
+	}
+
+	if err = consistentHash.AddNode(ctx, "node_d", 3); err != nil {
+		t.Fatal(err)
+	}
+	if len(simulated) == 0 || len(simulated) != len(movedKeys) {
+		t.Fatalf("simulated %d moved keys, actually moved %d keys", len(simulated), len(movedKeys))
+	}
+	for dataKey := range movedKeys {
+		if _, ok := simulated[dataKey]; !ok {
+			t.Errorf("data %s moved but not simulated", dataKey)
+		}
+	}
+}
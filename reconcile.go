@@ -0,0 +1,123 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// 修复登记位置错误的数据 key，用于修复数据迁移中断等原因导致的数据 key 登记位置与哈希环不一致的问题
+// 按照 NodeID 以及数据 key 从小到大的顺序检查每个数据 key 在当前哈希环上的归属节点（参见 VerifyKeyPlacement），
+// 将登记位置错误的数据 key 改登记到正确的节点下，并调用迁移函数迁移数据，未注入迁移函数时只修正登记关系
+// 单次调用至多检查 WithReconcileBatchSize 个数据 key，未检查完时 done 为 false，下一次调用会从上一次结束的位置继续，
+// 完成一轮完整的检查之后 done 为 true，再次调用时重新开始新的一轮。moved 为本次修复的数据 key 个数
+// 检查进度只保存在当前实例的内存中，执行期间持有哈希环的锁
+func (c *ConsistentHash) ReconcileKeys(ctx context.Context) (moved int, done bool, err error) {
+	if c.opts.disableDataKeyTracking {
+		return 0, true, nil
+	}
+	// 有界负载模式下数据 key 本就可能登记在哈希环上归属节点之外的节点
+	if c.boundedLoad() {
+		return 0, false, errors.New("reconcile keys in bounded load mode")
+	}
+
+	if err = c.lock(ctx); err != nil {
+		return 0, false, err
+	}
+	defer c.unlock(ctx)
+	c.invalidateDataKeyCache()
+
+	c.reconcileMu.Lock()
+	defer c.reconcileMu.Unlock()
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	nodeIDs := make([]string, 0, len(nodes))
+	for nodeID := range nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+
+	var (
+		budget = c.opts.reconcileBatchSize
+		// from -> to -> 数据 key
+		misplaced = make(map[string]map[string]map[string]struct{})
+		// 本次检查结束的位置，从上一次结束的真实节点开始，该节点已经被删除时从其后的节点开始
+		nodeIndex = sort.SearchStrings(nodeIDs, c.reconcileNodeID)
+		lastKey   string
+	)
+	if nodeIndex < len(nodeIDs) && nodeIDs[nodeIndex] == c.reconcileNodeID {
+		lastKey = c.reconcileDataKey
+	}
+
+	for ; nodeIndex < len(nodeIDs) && budget > 0; nodeIndex++ {
+		nodeID := nodeIDs[nodeIndex]
+		dataKeys, err := c.hashRing.DataKeys(ctx, nodeID)
+		if err != nil {
+			return 0, false, err
+		}
+		keys := make([]string, 0, len(dataKeys))
+		for dataKey := range dataKeys {
+			if dataKey > lastKey {
+				keys = append(keys, dataKey)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, dataKey := range keys {
+			if budget == 0 {
+				break
+			}
+			budget--
+			lastKey = dataKey
+
+			correctNode, err := c.getNode(ctx, dataKey)
+			if err != nil {
+				return 0, false, err
+			}
+			if correctNode == nodeID {
+				continue
+			}
+			if misplaced[nodeID] == nil {
+				misplaced[nodeID] = make(map[string]map[string]struct{})
+			}
+			if misplaced[nodeID][correctNode] == nil {
+				misplaced[nodeID][correctNode] = make(map[string]struct{})
+			}
+			misplaced[nodeID][correctNode][dataKey] = struct{}{}
+			moved++
+		}
+
+		// 预算恰好耗尽在节点中间时，下一次从该节点的 lastKey 之后继续
+		if budget == 0 && len(keys) > 0 && lastKey != keys[len(keys)-1] {
+			break
+		}
+		lastKey = ""
+	}
+
+	var migrateTasks []migrateTask
+	for from, toDatas := range misplaced {
+		for to, datas := range toDatas {
+			if err = c.moveDataKeys(ctx, from, to, datas); err != nil {
+				return 0, false, err
+			}
+			c.opts.logger.Infof("reconcile %d misplaced data keys from %s to %s", len(datas), from, to)
+			if c.migrator != nil {
+				migrateTasks = append(migrateTasks, c.newMigrateTask(ctx, datas, from, to))
+			}
+		}
+	}
+	if err = c.batchExecuteMigrator(ctx, migrateTasks); err != nil {
+		return 0, false, err
+	}
+
+	// 全部修复成功之后才推进检查进度
+	if nodeIndex >= len(nodeIDs) {
+		c.reconcileNodeID, c.reconcileDataKey = "", ""
+		return moved, true, nil
+	}
+	c.reconcileNodeID, c.reconcileDataKey = nodeIDs[nodeIndex], lastKey
+	return moved, false, nil
+}
@@ -0,0 +1,102 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func Test_ReconcileKeys(t *testing.T) {
+	ctx := context.Background()
+	migrator, migrations := NewRecordingMigrator()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, WithReconcileBatchSize(7))
+	nodeIDs := []string{"node_a", "node_b", "node_c"}
+	for _, nodeID := range nodeIDs {
+		if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const dataKeyCount = 30
+	correct := make(map[string]string, dataKeyCount)
+	for i := 0; i < dataKeyCount; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		nodeID, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		correct[dataKey] = nodeID
+	}
+
+	// 模拟迁移中断，将部分数据 key 登记到错误的节点下
+	var misplaced int
+	for i := 0; i < dataKeyCount; i += 6 {
+		dataKey := fmt.Sprintf("data_%d", i)
+		from := correct[dataKey]
+		to := nodeIDs[0]
+		if from == to {
+			to = nodeIDs[1]
+		}
+		if err := consistentHash.moveDataKeys(ctx, from, to, map[string]struct{}{dataKey: {}}); err != nil {
+			t.Fatal(err)
+		}
+		misplaced++
+	}
+
+	var (
+		moved  int
+		rounds int
+	)
+	for {
+		rounds++
+		_moved, done, err := consistentHash.ReconcileKeys(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		moved += _moved
+		if done {
+			break
+		}
+		if rounds > dataKeyCount {
+			t.Fatal("reconcile keys never finished")
+		}
+	}
+
+	// 每次至多检查 7 个数据 key，30 个数据 key 需要分 5 次完成
+	if rounds != 5 {
+		t.Errorf("got %d rounds, want 5", rounds)
+	}
+	if moved != misplaced {
+		t.Errorf("got %d moved data keys, want %d", moved, misplaced)
+	}
+	for dataKey, nodeID := range correct {
+		recordedNode, _, consistent, err := consistentHash.VerifyKeyPlacement(ctx, dataKey)
+		if err != nil || !consistent || recordedNode != nodeID {
+			t.Errorf("%s: recorded on %s (consistent %v, err %v), want %s", dataKey, recordedNode, consistent, err, nodeID)
+		}
+	}
+
+	var migrated int
+	for _, migration := range migrations() {
+		for dataKey := range migration.DataKeys {
+			if correct[dataKey] != migration.To {
+				t.Errorf("%s migrated to %s, want %s", dataKey, migration.To, correct[dataKey])
+			}
+			migrated++
+		}
+	}
+	if migrated != misplaced {
+		t.Errorf("migrated %d data keys, want %d", migrated, misplaced)
+	}
+
+	// 新的一轮检查没有需要修复的数据 key
+	for done := false; !done; {
+		_moved, _done, err := consistentHash.ReconcileKeys(ctx)
+		if err != nil || _moved != 0 {
+			t.Fatalf("got (%d, %v), want nothing to reconcile", _moved, err)
+		}
+		done = _done
+	}
+}
@@ -8,206 +8,495 @@ import (
 	"github.com/demdxx/gocast"
 	"github.com/gomodule/redigo/redis"
 	"github.com/xiaoxuxiansheng/redis_lock"
+	"sort"
+	"strconv"
+	"sync"
 )
 
 type RedisHashRing struct {
 	// 哈希环维度的唯一键
 	key string
-	// 连接redis的客户端
+	// 哈希环在 redis 中全部 key 的前缀
+	keyPrefix string
+	// 连接redis的客户端，配置了 WithReadReplica 时只有 ReplicaCeiling、ReplicaNode 由从节点执行，其余读命令都发往主节点
 	redisClient *Client
+
+	// 当前持有的锁，锁的 token 与加锁的协程绑定，续期以及解锁时需要使用同一个锁对象
+	mu   sync.Mutex
+	lock *redis_lock.RedisLock
 }
 
-func NewRedisHashRing(key string, redisClient *Client) *RedisHashRing {
-	return &RedisHashRing{
+func NewRedisHashRing(key string, redisClient *Client, opts ...RedisHashRingOption) *RedisHashRing {
+	r := RedisHashRing{
 		key:         key,
 		redisClient: redisClient,
 	}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	if r.keyPrefix == "" {
+		r.keyPrefix = DefaultKeyPrefix
+	}
+	return &r
+}
+
+func (r *RedisHashRing) Ping(ctx context.Context) error {
+	if err := r.redisClient.Ping(ctx); err != nil {
+		return fmt.Errorf("redis ring ping failed, err: %w", err)
+	}
+	return nil
+}
+
+// 关闭哈希环使用的 redis 客户端，多个哈希环共用同一个客户端时会一并受到影响
+func (r *RedisHashRing) Close() error {
+	return r.redisClient.Close()
+}
+
+// 通过 ZCARD 统计哈希环上虚拟节点位置的个数
+func (r *RedisHashRing) VirtualNodeCount(ctx context.Context) (int, error) {
+	ctx = withPrimaryRead(ctx)
+	count, err := r.redisClient.ZCard(ctx, r.getTableKey())
+	if err != nil {
+		return 0, fmt.Errorf("redis ring virtual node count zcard failed, err: %w", err)
+	}
+	return int(count), nil
+}
+
+// 通过 SCARD 统计真实节点登记的数据 key 个数
+func (r *RedisHashRing) DataKeyCount(ctx context.Context, nodeID string) (int, error) {
+	ctx = withPrimaryRead(ctx)
+	count, err := r.redisClient.SCard(ctx, r.getNodeDataKey(nodeID))
+	if err != nil {
+		return 0, fmt.Errorf("redis ring data key count scard failed, err: %w", err)
+	}
+	return int(count), nil
+}
+
+// 哈希环使用的 redis 客户端主节点连接池的统计信息
+func (r *RedisHashRing) PoolStats() (active, idle int) {
+	stats := r.redisClient.PoolStats()
+	return stats.ActiveCount, stats.IdleCount
 }
 
 func (r *RedisHashRing) getLockKey() string {
-	return fmt.Sprintf("redis:consistent_hash:ring:lock:%s", r.key)
+	return fmt.Sprintf("%s:lock:%s", r.keyPrefix, r.key)
 }
 
+// 哈希环上虚拟节点的位置，zset 的成员与分值均为虚拟节点数值，仅用于排序
 func (r *RedisHashRing) getTableKey() string {
-	return fmt.Sprintf("redis:consistent_hash:ring:%s", r.key)
+	return fmt.Sprintf("%s:score:%s", r.keyPrefix, r.key)
+}
+
+// 虚拟节点数值到真实节点列表的映射，hash 的 field 为虚拟节点数值，val 为真实节点列表的 json 串
+func (r *RedisHashRing) getScoreNodeKey() string {
+	return fmt.Sprintf("%s:score_node:%s", r.keyPrefix, r.key)
+}
+
+// 旧版本将真实节点列表的 json 串作为 zset 的成员存储
+func (r *RedisHashRing) getLegacyTableKey() string {
+	return fmt.Sprintf("%s:%s", r.keyPrefix, r.key)
+}
+
+func (r *RedisHashRing) getGenerationKey() string {
+	return fmt.Sprintf("%s:generation:%s", r.keyPrefix, r.key)
+}
+
+// 拓扑变更消息的 pub/sub channel
+func (r *RedisHashRing) getEventChannel() string {
+	return fmt.Sprintf("%s:event:%s", r.keyPrefix, r.key)
+}
+
+// 哈希环底层数据变更消息的 pub/sub channel，用于外部缓存失效等场景
+func (r *RedisHashRing) GetMutationChannel() string {
+	return fmt.Sprintf("%s:mutation:%s", r.keyPrefix, r.key)
+}
+
+// 真实节点元数据的 hash，field 为真实节点 id，val 为元数据序列化后的 json
+func (r *RedisHashRing) getNodeMetaKey() string {
+	return fmt.Sprintf("%s:node:meta:%s", r.keyPrefix, r.key)
 }
 
 func (r *RedisHashRing) getNodeReplicaKey() string {
-	return fmt.Sprintf("redis:consistent_hash:ring:node:replica:%s", r.key)
+	return fmt.Sprintf("%s:node:replica:%s", r.keyPrefix, r.key)
 }
 
+// 真实节点下的状态数据 key 集合，使用 redis set 存储
 func (r *RedisHashRing) getNodeDataKey(nodeID string) string {
-	return fmt.Sprintf("redis:consistent_hash:ring:node:data:%s", nodeID)
+	return fmt.Sprintf("%s:node:data_set:%s", r.keyPrefix, nodeID)
+}
+
+// 旧版本中以 json 字符串形式存储的状态数据 key 集合，仅用于 MigrateLegacyDataKeys
+func (r *RedisHashRing) getLegacyNodeDataKey(nodeID string) string {
+	return fmt.Sprintf("%s:node:data:%s", r.keyPrefix, nodeID)
 }
 
 // 锁住哈希环，支持配置过期时间， 达到过期时间后会自动释放锁
 func (r *RedisHashRing) Lock(ctx context.Context, expireSeconds int) error {
 	lock := redis_lock.NewRedisLock(r.getLockKey(), r.redisClient, redis_lock.WithExpireSeconds(int64(expireSeconds)))
-	return lock.Lock(ctx)
+	if err := lock.Lock(ctx); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.lock = lock
+	r.mu.Unlock()
+	return nil
 }
 
 func (r *RedisHashRing) Unlock(ctx context.Context) error {
-	lock := redis_lock.NewRedisLock(r.getLockKey(), r.redisClient)
+	r.mu.Lock()
+	lock := r.lock
+	r.lock = nil
+	r.mu.Unlock()
+
+	if lock == nil {
+		lock = redis_lock.NewRedisLock(r.getLockKey(), r.redisClient)
+	}
 	return lock.Unlock(ctx)
 }
 
-// 真实节点入环. 将一个真实节点 nodeID 添加到 score 对应的虚拟节点中
-func (r *RedisHashRing) Add(ctx context.Context, score int32, nodeID string) error {
-	// add 操作本质上是要在 score 中追加一个 nodeID
-	//  首先基于 score，从哈希环中取出对应的虚拟节点
-	ScoreEntities, err := r.redisClient.ZRangeByScore(ctx, r.getTableKey(), int64(score), int64(score))
-	if err != nil {
-		return fmt.Errorf("redis ring add failed, err: %w", err)
+// 查询哈希环的代数，代数不存在时为 0
+func (r *RedisHashRing) Generation(ctx context.Context) (int64, error) {
+	ctx = withPrimaryRead(ctx)
+	reply, err := r.redisClient.Get(ctx, r.getGenerationKey())
+	if errors.Is(err, redis.ErrNil) {
+		return 0, nil
 	}
-
-	// 存在多个节点返回错误
-	if len(ScoreEntities) > 1 {
-		return fmt.Errorf("invalid score entity len : %d", len(ScoreEntities))
+	if err != nil {
+		return 0, fmt.Errorf("redis ring get generation failed, err: %w", err)
 	}
+	return gocast.ToInt64(reply), nil
+}
 
-	//先查出来 score 对应的 val，将新增节点 nodeID 追加进去，再添加到 zset 中
-	var nodeIDs []string
-	if len(ScoreEntities) == 1 {
-		if err = json.Unmarshal([]byte(ScoreEntities[0].Val), &nodeIDs); err != nil {
-			return err
-		}
-		for _, _nodeID := range nodeIDs {
-			if _nodeID == nodeID {
-				return nil
-			}
-		}
-
-		if err = r.redisClient.ZRem(ctx, r.getTableKey(), ScoreEntities[0].Score); err != nil {
-			return fmt.Errorf("redis ring zrem failed, err: %w", err)
-		}
+func (r *RedisHashRing) IncrGeneration(ctx context.Context) (int64, error) {
+	generation, err := r.redisClient.Incr(ctx, r.getGenerationKey())
+	if err != nil {
+		return 0, fmt.Errorf("redis ring incr generation failed, err: %w", err)
 	}
+	return generation, nil
+}
 
-	nodeIDs = append(nodeIDs, nodeID)
-	newNodeIDs, _ := json.Marshal(nodeIDs)
-	// 将新的结果添加到虚拟节点score虚拟节点中
-	if err = r.redisClient.ZAdd(ctx, r.getTableKey(), int64(score), string(newNodeIDs)); err != nil {
-		return fmt.Errorf("redis ring zadd failed, err: %w", err)
+// 基于 redis pub/sub 发布拓扑变更消息，消息不会持久化，发布时不在线的订阅者会错过该消息
+func (r *RedisHashRing) Publish(ctx context.Context, payload string) error {
+	if _, err := r.redisClient.Publish(ctx, r.getEventChannel(), payload); err != nil {
+		return fmt.Errorf("redis ring publish failed, err: %w", err)
 	}
 	return nil
 }
 
-// 从哈希环对应于 score 的虚拟节点删去真实节点 nodeID
-func (r *RedisHashRing) Rem(ctx context.Context, score int32, nodeID string) error {
-	//  首先通过 score 检索获取到对应的虚拟节点
-	scoreEntities, err := r.redisClient.ZRangeByScore(ctx, r.getTableKey(), int64(score), int64(score))
+func (r *RedisHashRing) Subscribe(ctx context.Context) (<-chan string, error) {
+	payloads, err := r.redisClient.Subscribe(ctx, r.getEventChannel())
 	if err != nil {
-		return fmt.Errorf("redis ring rem zrange by score failed, err: %w", err)
+		return nil, fmt.Errorf("redis ring subscribe failed, err: %w", err)
 	}
+	return payloads, nil
+}
+
+// 哈希环底层数据变更的类型
+const (
+	MutationAdd                 = "add"
+	MutationRem                 = "rem"
+	MutationAddNodeToReplica    = "add_node_to_replica"
+	MutationDeleteNodeToReplica = "delete_node_to_replica"
+)
+
+// 发布到 GetMutationChannel 的哈希环底层数据变更消息，以 json 串的形式发布
+type RingMutation struct {
+	Op string `json:"op"`
+	// 虚拟节点数值，仅 add 与 rem 有效
+	Score  int64  `json:"score,omitempty"`
+	NodeID string `json:"node_id"`
+	// 该变更生效后哈希环的代数，即发布消息时的代数加一
+	// ConsistentHash 在一次节点变更的全部数据写入完成后才会递增代数，同一次节点变更发布的消息携带相同的代数，
+	// 订阅方观察到 Generation 达到该值时，说明这次节点变更已经完整生效
+	Generation int64 `json:"generation"`
+}
+
+// 供变更脚本拼接使用的 lua 函数，在数据写入之后于同一个脚本中发布 RingMutation，无需额外的网络往返
+// 代数为 generationKey 当前的值加一，score 为空时不包含在消息中。score 与代数直接拼接，避免 cjson 以浮点数编码时丢失精度
+// 发布是尽力而为的：数据已经写入，通过 pcall 忽略发布失败（如 ACL 禁止向该 channel 发布），不能让变更返回错误
+const publishMutationFunc = `
+local function publishMutation(generationKey, channel, op, score, nodeID)
+	local generation = redis.pcall('GET', generationKey)
+	if type(generation) ~= 'string' then
+		generation = '0'
+	end
+	local mutation = '{"op":"' .. op .. '",'
+	if score then
+		mutation = mutation .. '"score":' .. score .. ','
+	end
+	mutation = mutation .. '"node_id":' .. cjson.encode(nodeID) .. ',"generation":' .. (tonumber(generation) + 1) .. '}'
+	redis.pcall('PUBLISH', channel, mutation)
+end
+`
+
+// 判断 Lock 返回的错误是否是因为锁已经被其他持有者占用
+func (r *RedisHashRing) IsLockBusy(err error) bool {
+	return errors.Is(err, redis_lock.ErrLockAcquiredByOthers)
+}
+
+// 将当前持有的锁的过期时间重置为 expireSeconds 秒
+func (r *RedisHashRing) RenewLock(ctx context.Context, expireSeconds int) error {
+	r.mu.Lock()
+	lock := r.lock
+	r.mu.Unlock()
 
-	if len(scoreEntities) != 1 {
-		return fmt.Errorf("redis ring rem failed, invalid score entity len: %d", len(scoreEntities))
+	if lock == nil {
+		return errors.New("redis ring renew lock failed, lock not held")
 	}
+	if err := lock.DelayExpire(ctx, int64(expireSeconds)); err != nil {
+		return fmt.Errorf("redis ring renew lock failed, err: %w", err)
+	}
+	return nil
+}
 
-	var nodeIDs []string
-	if err = json.Unmarshal([]byte(scoreEntities[0].Val), &nodeIDs); err != nil {
-		return err
+// 在 ARGV[1] 位置的虚拟节点上追加真实节点 ARGV[2]，KEYS[1] 为位置 zset，KEYS[2] 为真实节点列表 hash，KEYS[3] 为代数
+// 读取、追加与写回在脚本中原子完成，真实节点已经存在时返回 0，否则返回 1 并向 ARGV[3] 发布变更消息
+const addScript = publishMutationFunc + `
+local raw = redis.call('HGET', KEYS[2], ARGV[1])
+local nodeIDs = {}
+if raw then
+	nodeIDs = cjson.decode(raw)
+	for _, nodeID in ipairs(nodeIDs) do
+		if nodeID == ARGV[2] then
+			return 0
+		end
+	end
+end
+
+table.insert(nodeIDs, ARGV[2])
+redis.call('HSET', KEYS[2], ARGV[1], cjson.encode(nodeIDs))
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[1])
+publishMutation(KEYS[3], ARGV[3], 'add', ARGV[1], ARGV[2])
+return 1
+`
+
+// 从 ARGV[1] 位置的虚拟节点中删除真实节点 ARGV[2]，KEYS 与 ARGV 与 addScript 一致
+// 真实节点列表为空时同时删除该虚拟节点，真实节点不存在时返回 0，否则返回 1 并发布变更消息
+const remScript = publishMutationFunc + `
+local raw = redis.call('HGET', KEYS[2], ARGV[1])
+if not raw then
+	return redis.error_reply('score not exist')
+end
+
+local nodeIDs = cjson.decode(raw)
+local index = 0
+for i, nodeID in ipairs(nodeIDs) do
+	if nodeID == ARGV[2] then
+		index = i
+		break
+	end
+end
+if index == 0 then
+	return 0
+end
+
+table.remove(nodeIDs, index)
+if #nodeIDs == 0 then
+	redis.call('HDEL', KEYS[2], ARGV[1])
+	redis.call('ZREM', KEYS[1], ARGV[1])
+else
+	redis.call('HSET', KEYS[2], ARGV[1], cjson.encode(nodeIDs))
+end
+publishMutation(KEYS[3], ARGV[3], 'rem', ARGV[1], ARGV[2])
+return 1
+`
+
+// 将真实节点 ARGV[1] 的虚拟节点个数设置为 ARGV[2]，KEYS[1] 为虚拟节点个数 hash，KEYS[2] 为代数
+// 个数没有变化时返回 0，否则返回 1 并向 ARGV[3] 发布变更消息
+const addNodeToReplicaScript = publishMutationFunc + `
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	return 0
+end
+
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+publishMutation(KEYS[2], ARGV[3], 'add_node_to_replica', nil, ARGV[1])
+return 1
+`
+
+// 删除真实节点 ARGV[1] 的虚拟节点个数，KEYS 与 ARGV 与 addNodeToReplicaScript 一致
+// 真实节点不存在时返回 0，否则返回 1 并发布变更消息
+const deleteNodeToReplicaScript = publishMutationFunc + `
+if redis.call('HDEL', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+
+publishMutation(KEYS[2], ARGV[2], 'delete_node_to_replica', nil, ARGV[1])
+return 1
+`
+
+// 虚拟节点 ARGV[1] 的真实节点列表为空或者不存在时将其从位置 zset 与真实节点列表 hash 中删除，KEYS 与 addScript 一致
+// 列表非空时返回 0，否则返回 1
+const compactScript = `
+local raw = redis.call('HGET', KEYS[2], ARGV[1])
+if raw and #cjson.decode(raw) > 0 then
+	return 0
+end
+
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[1], ARGV[1])
+return 1
+`
+
+// 真实节点入环. 将一个真实节点 nodeID 添加到 score 对应的虚拟节点中
+// 通过 lua 脚本在一次网络往返中完成，重复添加同一个真实节点不会产生影响，也不会发布变更消息
+func (r *RedisHashRing) Add(ctx context.Context, score int64, nodeID string) error {
+	if _, err := r.redisClient.Eval(ctx, addScript, 3, r.virtualNodeScriptArgs(score, nodeID)); err != nil {
+		return fmt.Errorf("redis ring add failed, err: %w", err)
 	}
+	return nil
+}
 
-	// 获取待删除真实节点nodeID在虚拟节点的真实节点列表的index
-	index := -1
-	for i := 0; i < len(nodeIDs); i++ {
-		if nodeIDs[i] == nodeID {
-			index = i
-			break
-		}
+// addScript 与 remScript 的 KEYS 与 ARGV
+func (r *RedisHashRing) virtualNodeScriptArgs(score int64, nodeID string) []interface{} {
+	return []interface{}{r.getTableKey(), r.getScoreNodeKey(), r.getGenerationKey(), score, nodeID, r.GetMutationChannel()}
+}
+
+// 将真实节点 nodeID 追加到 score 对应的虚拟节点中，检查与追加在 addScript 中原子完成，真实节点已经存在时 added 为 false
+func (r *RedisHashRing) AddIfAbsent(ctx context.Context, score int64, nodeID string) (added bool, err error) {
+	reply, err := redis.Int64(r.redisClient.Eval(ctx, addScript, 3, r.virtualNodeScriptArgs(score, nodeID)))
+	if err != nil {
+		return false, fmt.Errorf("redis ring add if absent failed, err: %w", err)
 	}
+	return reply == 1, nil
+}
 
-	if index == -1 {
+// 批量将真实节点添加到对应的虚拟节点中，virtualNodes 为虚拟节点数值到待添加真实节点列表的映射
+// 基于 pipeline 实现，每个真实节点对应一次 addScript 的执行，整个批次只需要一次网络往返，实际添加的真实节点各自发布一条变更消息
+func (r *RedisHashRing) BatchAdd(ctx context.Context, virtualNodes map[int64][]string) error {
+	if len(virtualNodes) == 0 {
 		return nil
 	}
 
-	// 首先删除对应的score
-	if err = r.redisClient.ZRem(ctx, r.getTableKey(), scoreEntities[0].Score); err != nil {
-		return fmt.Errorf("redis ring rem zrem failed, err: %w", err)
+	pipeline, err := r.redisClient.Pipeline(ctx)
+	if err != nil {
+		return fmt.Errorf("redis ring batch add failed, err: %w", err)
 	}
+	defer pipeline.Close()
 
-	nodeIDs = append(nodeIDs[:index], nodeIDs[index+1:]...)
-	if len(nodeIDs) == 0 {
-		return nil
+	for score, nodeIDs := range virtualNodes {
+		for _, nodeID := range nodeIDs {
+			if err = pipeline.Eval(addScript, 3, r.virtualNodeScriptArgs(score, nodeID)...); err != nil {
+				return fmt.Errorf("redis ring batch add eval failed, err: %w", err)
+			}
+		}
 	}
 
-	// 在 score 对应的虚拟节点中添加删除 nodeID 后的真实节点列表
-	newNodeIDStr, _ := json.Marshal(nodeIDs)
-	if err = r.redisClient.ZAdd(ctx, r.getTableKey(), scoreEntities[0].Score, string(newNodeIDStr)); err != nil {
-		return fmt.Errorf("redis ring rem zadd failed, err: %w", err)
+	replies, err := pipeline.Exec()
+	if err != nil {
+		return fmt.Errorf("redis ring batch add failed, err: %w", err)
 	}
 
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return fmt.Errorf("redis ring batch add failed, err: %w", err)
+		}
+	}
 	return nil
 }
 
-// 从哈希环中获取到 score 顺时针往下的第一个虚拟节点数值
-func (r *RedisHashRing) Ceiling(ctx context.Context, score int32) (int32, error) {
-	// 首先执行ceiling检索到zset中大于等于score且最接近与score的节点
-	scoreEntity, err := r.redisClient.Ceiling(ctx, r.getTableKey(), int64(score))
-	if err != nil && !errors.Is(err, ErrScoreNotExist) {
-		return 0, fmt.Errorf("redis ring ceiling failed, err: %w", err)
+// 从哈希环对应于 score 的虚拟节点删去真实节点 nodeID
+func (r *RedisHashRing) Rem(ctx context.Context, score int64, nodeID string) error {
+	if _, err := r.redisClient.Eval(ctx, remScript, 3, r.virtualNodeScriptArgs(score, nodeID)); err != nil {
+		return fmt.Errorf("redis ring rem failed, err: %w", err)
 	}
+	return nil
+}
 
-	// 倘若找到目标直接返回
-	if scoreEntity != nil {
-		return int32(scoreEntity.Score), nil
-	}
+// 从哈希环中获取到 score 顺时针往下的第一个虚拟节点数值
+func (r *RedisHashRing) Ceiling(ctx context.Context, score int64) (int64, error) {
+	return r.ceiling(withPrimaryRead(ctx), score)
+}
 
-	//  倘若 ceiling 流程未找到目标节点，则通过 first 方法获取到 zset 中 score 最小的节点进行返回
-	if scoreEntity, err = r.redisClient.FirstOrLast(ctx, r.getTableKey(), true); err != nil {
-		return 0, fmt.Errorf("redis ring first failed, err: %w", err)
-	}
+// 与 Ceiling 相同，配置了 WithReadReplica 时由从节点执行，ConsistentHash 只在数据 key 的路由查询中使用
+func (r *RedisHashRing) ReplicaCeiling(ctx context.Context, score int64) (int64, error) {
+	return r.ceiling(ctx, score)
+}
 
-	if scoreEntity != nil {
-		return int32(scoreEntity.Score), nil
+func (r *RedisHashRing) ceiling(ctx context.Context, score int64) (int64, error) {
+	// 检索 zset 中大于等于 score 且最接近于 score 的节点，未找到时回绕到 score 最小的节点，在一次请求中完成
+	// 只有 zset 确实为空时才视为空环返回 -1，其余错误需要原样返回，避免将存储故障误判为空环
+	scoreEntity, err := r.redisClient.CeilingOrFirst(ctx, r.getTableKey(), score)
+	if errors.Is(err, ErrScoreNotExist) {
+		return -1, nil
 	}
-
-	return -1, nil
+	if err != nil {
+		return 0, fmt.Errorf("redis ring ceiling failed, err: %w", err)
+	}
+	return scoreEntity.Score, nil
 }
 
 // 从哈希环中获取到 score 逆时针往上的第一个虚拟节点数值
-func (r *RedisHashRing) Floor(ctx context.Context, score int32) (int32, error) {
+func (r *RedisHashRing) Floor(ctx context.Context, score int64) (int64, error) {
+	ctx = withPrimaryRead(ctx)
 	//从 zset 中获取到小于等于 score 且最接近于 score 的节点
-	scoreEntity, err := r.redisClient.Floor(ctx, r.getTableKey(), int64(score))
+	scoreEntity, err := r.redisClient.Floor(ctx, r.getTableKey(), score)
 	if err != nil && !errors.Is(err, ErrScoreNotExist) {
 		return 0, fmt.Errorf("redis ring floor failed, err: %w", err)
 	}
 
 	if scoreEntity != nil {
-		return int32(scoreEntity.Score), nil
+		return scoreEntity.Score, nil
 	}
 
 	// 2 倘若 floor 流程没找到节点，则通过 last 获取 zset 上 score 值最大的节点
-	if scoreEntity, err = r.redisClient.FirstOrLast(ctx, r.getTableKey(), false); err != nil && !errors.Is(err, ErrScoreNotExist) {
+	scoreEntity, err = r.redisClient.FirstOrLast(ctx, r.getTableKey(), false)
+	if errors.Is(err, ErrScoreNotExist) {
+		return -1, nil
+	}
+	if err != nil {
 		return 0, fmt.Errorf("redis ring last failed, err: %w", err)
 	}
+	return scoreEntity.Score, nil
+}
 
-	if scoreEntity != nil {
-		return int32(scoreEntity.Score), nil
-	}
+func (r *RedisHashRing) Node(ctx context.Context, score int64) ([]string, error) {
+	return r.node(withPrimaryRead(ctx), score)
+}
 
-	return -1, nil
+// 与 Node 相同，配置了 WithReadReplica 时由从节点执行，ConsistentHash 只在数据 key 的路由查询中使用
+func (r *RedisHashRing) ReplicaNode(ctx context.Context, score int64) ([]string, error) {
+	return r.node(ctx, score)
 }
 
-func (r *RedisHashRing) Node(ctx context.Context, score int32) ([]string, error) {
-	scoreEntities, err := r.redisClient.ZRangeByScore(ctx, r.getTableKey(), int64(score), int64(score))
-	if err != nil {
-		return nil, fmt.Errorf("redis ring node zrange by score failed, err: %w", err)
+func (r *RedisHashRing) node(ctx context.Context, score int64) ([]string, error) {
+	raw, err := r.redisClient.HGet(ctx, r.getScoreNodeKey(), strconv.FormatInt(score, 10))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, fmt.Errorf("redis ring node failed, score: %d, err: %w", score, ErrScoreNotExist)
 	}
-
-	if len(scoreEntities) != 1 {
-		return nil, fmt.Errorf("redis ring node failed, invalid len of score entities: %d", len(scoreEntities))
+	if err != nil {
+		return nil, fmt.Errorf("redis ring node hget failed, err: %w", err)
 	}
 
 	var nodeIDs []string
-	if err = json.Unmarshal([]byte(scoreEntities[0].Val), &nodeIDs); err != nil {
+	if err = json.Unmarshal([]byte(raw), &nodeIDs); err != nil {
 		return nil, err
 	}
 
 	return nodeIDs, nil
 }
 
+// 查询哈希环上全部的虚拟节点
+func (r *RedisHashRing) VirtualNodes(ctx context.Context) (map[int64][]string, error) {
+	ctx = withPrimaryRead(ctx)
+	rawData, err := r.redisClient.HGetAll(ctx, r.getScoreNodeKey())
+	if err != nil {
+		return nil, fmt.Errorf("redis ring virtual nodes hgetall failed, err: %w", err)
+	}
+
+	virtualNodes := make(map[int64][]string, len(rawData))
+	for rawScore, raw := range rawData {
+		var nodeIDs []string
+		if err = json.Unmarshal([]byte(raw), &nodeIDs); err != nil {
+			return nil, err
+		}
+		virtualNodes[gocast.ToInt64(rawScore)] = nodeIDs
+	}
+	return virtualNodes, nil
+}
+
 func (r *RedisHashRing) Nodes(ctx context.Context) (map[string]int, error) {
+	ctx = withPrimaryRead(ctx)
 	rawData, err := r.redisClient.HGetAll(ctx, r.getNodeReplicaKey())
 	if err != nil {
 		return nil, fmt.Errorf("redis ring nodes hgetall failed, err: %w", err)
@@ -221,85 +510,301 @@ func (r *RedisHashRing) Nodes(ctx context.Context) (map[string]int, error) {
 	return data, nil
 }
 
+// 通过 HEXISTS 查询真实节点是否存在，无需拉取全量的真实节点
+func (r *RedisHashRing) NodeExists(ctx context.Context, nodeID string) (bool, error) {
+	ctx = withPrimaryRead(ctx)
+	exists, err := r.redisClient.HExists(ctx, r.getNodeReplicaKey(), nodeID)
+	if err != nil {
+		return false, fmt.Errorf("redis ring node exists hexists failed, err: %w", err)
+	}
+	return exists, nil
+}
+
 func (r *RedisHashRing) AddNodeToReplica(ctx context.Context, nodeID string, replicas int) error {
-	if err := r.redisClient.HSet(ctx, r.getNodeReplicaKey(), nodeID, gocast.ToString(replicas)); err != nil {
+	keysAndArgs := []interface{}{r.getNodeReplicaKey(), r.getGenerationKey(), nodeID, gocast.ToString(replicas), r.GetMutationChannel()}
+	if _, err := r.redisClient.Eval(ctx, addNodeToReplicaScript, 2, keysAndArgs); err != nil {
 		return fmt.Errorf("redis ring add node to replica failed, err: %w", err)
 	}
 	return nil
 }
 
 func (r *RedisHashRing) DeleteNodeToReplica(ctx context.Context, nodeID string) error {
-	if err := r.redisClient.HDel(ctx, r.getNodeReplicaKey(), nodeID); err != nil {
+	keysAndArgs := []interface{}{r.getNodeReplicaKey(), r.getGenerationKey(), nodeID, r.GetMutationChannel()}
+	if _, err := r.redisClient.Eval(ctx, deleteNodeToReplicaScript, 2, keysAndArgs); err != nil {
 		return fmt.Errorf("redis ring delete node to replica failed, err: %w", err)
 	}
 	return nil
 }
 
-func (r *RedisHashRing) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
-	resStr, err := r.redisClient.Get(ctx, r.getNodeDataKey(nodeID))
-	if err != nil && !errors.Is(err, redis.ErrNil) {
-		return nil, fmt.Errorf("redis ring dataKeys get failed, err: %w", err)
+func (r *RedisHashRing) SetNodeMeta(ctx context.Context, nodeID string, meta map[string]string) error {
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return err
 	}
+	if err = r.redisClient.HSet(ctx, r.getNodeMetaKey(), nodeID, string(raw)); err != nil {
+		return fmt.Errorf("redis ring set node meta failed, err: %w", err)
+	}
+	return nil
+}
 
-	dataKeys := make(map[string]struct{})
-	if len(resStr) > 0 {
-		if err = json.Unmarshal([]byte(resStr), &dataKeys); err != nil {
-			return nil, err
-		}
+func (r *RedisHashRing) NodeMeta(ctx context.Context, nodeID string) (map[string]string, error) {
+	ctx = withPrimaryRead(ctx)
+	raw, err := r.redisClient.HGet(ctx, r.getNodeMetaKey(), nodeID)
+	if errors.Is(err, redis.ErrNil) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis ring node meta hget failed, err: %w", err)
+	}
+
+	meta := make(map[string]string)
+	if err = json.Unmarshal([]byte(raw), &meta); err != nil {
+		return nil, err
 	}
+	return meta, nil
+}
 
+func (r *RedisHashRing) DeleteNodeMeta(ctx context.Context, nodeID string) error {
+	if err := r.redisClient.HDel(ctx, r.getNodeMetaKey(), nodeID); err != nil {
+		return fmt.Errorf("redis ring delete node meta failed, err: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisHashRing) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	ctx = withPrimaryRead(ctx)
+	members, err := r.redisClient.SMembers(ctx, r.getNodeDataKey(nodeID))
+	if err != nil {
+		return nil, fmt.Errorf("redis ring dataKeys smembers failed, err: %w", err)
+	}
+
+	dataKeys := make(map[string]struct{}, len(members))
+	for _, member := range members {
+		dataKeys[member] = struct{}{}
+	}
 	return dataKeys, nil
 }
 
+// 通过 SISMEMBER 查询单个数据 key，无需拉取真实节点全量的数据 key
+func (r *RedisHashRing) DataKeyRecorded(ctx context.Context, nodeID, dataKey string) (bool, error) {
+	ctx = withPrimaryRead(ctx)
+	recorded, err := r.redisClient.SIsMember(ctx, r.getNodeDataKey(nodeID), dataKey)
+	if err != nil {
+		return false, fmt.Errorf("redis ring data key recorded sismember failed, err: %w", err)
+	}
+	return recorded, nil
+}
+
+// 通过 SSCAN 分页遍历数据 key，同一个 key 可能在多次遍历结果中重复出现，调用方需要自行去重
+func (r *RedisHashRing) ScanDataKeys(ctx context.Context, nodeID string, cursor uint64, count int) ([]string, uint64, error) {
+	ctx = withPrimaryRead(ctx)
+	dataKeys, next, err := r.redisClient.SScan(ctx, r.getNodeDataKey(nodeID), cursor, count)
+	if err != nil {
+		return nil, 0, fmt.Errorf("redis ring scanDataKeys sscan failed, err: %w", err)
+	}
+	return dataKeys, next, nil
+}
+
+// 通过 SADD 追加数据 key，单条命令即可完成，并发调用时不会相互覆盖
 func (r *RedisHashRing) AddNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
-	// 获取这个节点对应的信息
-	resStr, err := r.redisClient.Get(ctx, r.getNodeDataKey(nodeID))
-	if err != nil && !errors.Is(err, redis.ErrNil) {
-		return fmt.Errorf("redis ring addNodeToDataKey get failed, err: %w", err)
+	if len(dataKeys) == 0 {
+		return nil
 	}
 
-	var oldDataKeys map[string]struct{}
-	if len(resStr) > 0 {
-		if err = json.Unmarshal([]byte(resStr), &oldDataKeys); err != nil {
-			return err
+	if err := r.redisClient.SAdd(ctx, r.getNodeDataKey(nodeID), setMembers(dataKeys)...); err != nil {
+		return fmt.Errorf("redis ring addNodeToDataKeys sadd failed, err: %w", err)
+	}
+	return nil
+}
+
+// 批量为多个真实节点登记数据 key，每个真实节点对应一条 SADD 命令，全部命令通过 pipeline 在一次网络往返中完成
+func (r *RedisHashRing) BatchAddNodeToDataKeys(ctx context.Context, nodeDataKeys map[string]map[string]struct{}) error {
+	pipeline, err := r.redisClient.Pipeline(ctx)
+	if err != nil {
+		return fmt.Errorf("redis ring batch addNodeToDataKeys failed, err: %w", err)
+	}
+	defer pipeline.Close()
+
+	for nodeID, dataKeys := range nodeDataKeys {
+		if len(dataKeys) == 0 {
+			continue
+		}
+		if err = pipeline.SAdd(r.getNodeDataKey(nodeID), setMembers(dataKeys)...); err != nil {
+			return fmt.Errorf("redis ring batch addNodeToDataKeys sadd failed, err: %w", err)
 		}
 	}
 
-	if oldDataKeys == nil {
-		oldDataKeys = make(map[string]struct{})
+	replies, err := pipeline.Exec()
+	if err != nil {
+		return fmt.Errorf("redis ring batch addNodeToDataKeys failed, err: %w", err)
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return fmt.Errorf("redis ring batch addNodeToDataKeys sadd failed, err: %w", err)
+		}
 	}
+	return nil
+}
 
+// 通过 SREM 删除数据 key，节点下没有登记过数据时视为空集合
+func (r *RedisHashRing) DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
+	if len(dataKeys) == 0 {
+		return nil
+	}
+
+	if err := r.redisClient.SRem(ctx, r.getNodeDataKey(nodeID), setMembers(dataKeys)...); err != nil {
+		return fmt.Errorf("redis ring deleteNodeToDataKeys srem failed, err: %w", err)
+	}
+	return nil
+}
+
+// 将数据 key 从 KEYS[1] 对应的集合移动到 KEYS[2] 对应的集合，脚本整体原子执行
+const moveDataKeysScript = `
+for i = 1, #ARGV do
+	redis.call('SREM', KEYS[1], ARGV[i])
+	redis.call('SADD', KEYS[2], ARGV[i])
+end
+return #ARGV
+`
+
+// 通过 lua 脚本原子地将数据 key 从 from 移动到 to，不会出现数据 key 同时存在于两个节点或者两个节点都不存在的中间状态
+func (r *RedisHashRing) MoveDataKeys(ctx context.Context, from, to string, dataKeys map[string]struct{}) error {
+	if len(dataKeys) == 0 {
+		return nil
+	}
+
+	keysAndArgs := make([]interface{}, 0, len(dataKeys)+2)
+	keysAndArgs = append(keysAndArgs, r.getNodeDataKey(from), r.getNodeDataKey(to))
+	for dataKey := range dataKeys {
+		keysAndArgs = append(keysAndArgs, dataKey)
+	}
+	if _, err := r.redisClient.Eval(ctx, moveDataKeysScript, 2, keysAndArgs); err != nil {
+		return fmt.Errorf("redis ring move data keys eval failed, err: %w", err)
+	}
+	return nil
+}
+
+func setMembers(dataKeys map[string]struct{}) []string {
+	members := make([]string, 0, len(dataKeys))
 	for dataKey := range dataKeys {
-		oldDataKeys[dataKey] = struct{}{}
+		members = append(members, dataKey)
 	}
+	return members
+}
 
-	dataKeysStr, _ := json.Marshal(oldDataKeys)
-	if err = r.redisClient.Set(ctx, r.getNodeDataKey(nodeID), string(dataKeysStr)); err != nil {
-		return fmt.Errorf("redis ring addNodeToDataKey set failed, err: %w", err)
+// 将旧版本以 json 字符串存储的状态数据 key 迁移到 redis set 中，迁移完成后删除旧数据
+// 升级后需要在哈希环加锁的情况下执行一次，迁移期间不能有旧版本的进程继续写入
+func (r *RedisHashRing) MigrateLegacyDataKeys(ctx context.Context) error {
+	ctx = withPrimaryRead(ctx)
+	nodes, err := r.Nodes(ctx)
+	if err != nil {
+		return err
 	}
 
+	for nodeID := range nodes {
+		resStr, err := r.redisClient.Get(ctx, r.getLegacyNodeDataKey(nodeID))
+		if errors.Is(err, redis.ErrNil) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("redis ring migrate legacy data keys get failed, err: %w", err)
+		}
+
+		var dataKeys map[string]struct{}
+		if err = json.Unmarshal([]byte(resStr), &dataKeys); err != nil {
+			return err
+		}
+
+		if err = r.AddNodeToDataKeys(ctx, nodeID, dataKeys); err != nil {
+			return err
+		}
+
+		if err = r.redisClient.Del(ctx, r.getLegacyNodeDataKey(nodeID)); err != nil {
+			return fmt.Errorf("redis ring migrate legacy data keys del failed, err: %w", err)
+		}
+	}
 	return nil
 }
 
-func (r *RedisHashRing) DeleteNodeToDataKeys(ctx context.Context, nodeID string, dataKeys map[string]struct{}) error {
-	resStr, err := r.redisClient.Get(ctx, r.getNodeDataKey(nodeID))
+// 清理真实节点列表为空的虚拟节点，返回被删除的虚拟节点数值
+// Rem 在删除最后一个真实节点时会一并删除虚拟节点，但倘若清理过程被中断，或者存储由旧版本写入，
+// 位置 zset 中可能残留真实节点列表为空或者不存在的虚拟节点，导致检索到该位置时无法找到真实节点
+// 每个虚拟节点的检查与删除通过 lua 脚本原子完成，不会误删并发添加了真实节点的虚拟节点
+func (r *RedisHashRing) Compact(ctx context.Context) ([]int64, error) {
+	ctx = withPrimaryRead(ctx)
+	scoreEntities, err := r.redisClient.ZRange(ctx, r.getTableKey())
 	if err != nil {
-		return fmt.Errorf("redis ring addNodeToDataKey get failed, err: %w", err)
+		return nil, fmt.Errorf("redis ring compact zrange failed, err: %w", err)
+	}
+	rawNodeIDs, err := r.redisClient.HGetAll(ctx, r.getScoreNodeKey())
+	if err != nil {
+		return nil, fmt.Errorf("redis ring compact hgetall failed, err: %w", err)
 	}
 
-	var oldDataKeys map[string]struct{}
-	if err = json.Unmarshal([]byte(resStr), &oldDataKeys); err != nil {
-		return err
+	isEmpty := func(raw string, ok bool) bool {
+		if !ok {
+			return true
+		}
+		var nodeIDs []string
+		return json.Unmarshal([]byte(raw), &nodeIDs) == nil && len(nodeIDs) == 0
 	}
 
-	for dataKey := range dataKeys {
-		delete(oldDataKeys, dataKey)
+	candidates := make(map[int64]struct{})
+	for _, scoreEntity := range scoreEntities {
+		raw, ok := rawNodeIDs[strconv.FormatInt(scoreEntity.Score, 10)]
+		if isEmpty(raw, ok) {
+			candidates[scoreEntity.Score] = struct{}{}
+		}
+	}
+	// 不在位置 zset 中的空列表同样需要清理
+	for field, raw := range rawNodeIDs {
+		score, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		if isEmpty(raw, true) {
+			candidates[score] = struct{}{}
+		}
+	}
+
+	removed := make([]int64, 0, len(candidates))
+	for score := range candidates {
+		reply, err := redis.Int64(r.redisClient.Eval(ctx, compactScript, 2, []interface{}{r.getTableKey(), r.getScoreNodeKey(), score}))
+		if err != nil {
+			return nil, fmt.Errorf("redis ring compact failed, err: %w", err)
+		}
+		if reply == 1 {
+			removed = append(removed, score)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	return removed, nil
+}
+
+// 将旧版本以 json 串作为 zset 成员存储的虚拟节点迁移到位置 zset 与真实节点列表 hash 中，迁移完成后删除旧数据
+// 与 MigrateLegacyDataKeys 一样，升级后需要在哈希环加锁的情况下执行一次
+func (r *RedisHashRing) MigrateLegacyTable(ctx context.Context) error {
+	ctx = withPrimaryRead(ctx)
+	scoreEntities, err := r.redisClient.ZRange(ctx, r.getLegacyTableKey())
+	if err != nil {
+		return fmt.Errorf("redis ring migrate legacy table zrange failed, err: %w", err)
 	}
 
-	if len(oldDataKeys) == 0 {
-		return r.redisClient.Del(ctx, r.getNodeDataKey(nodeID))
+	virtualNodes := make(map[int64][]string, len(scoreEntities))
+	for _, scoreEntity := range scoreEntities {
+		var nodeIDs []string
+		if err = json.Unmarshal([]byte(scoreEntity.Val), &nodeIDs); err != nil {
+			return err
+		}
+		virtualNodes[scoreEntity.Score] = append(virtualNodes[scoreEntity.Score], nodeIDs...)
 	}
 
-	newDataKeyStr, _ := json.Marshal(oldDataKeys)
-	return r.redisClient.Set(ctx, r.getNodeDataKey(nodeID), string(newDataKeyStr))
+	if err = r.BatchAdd(ctx, virtualNodes); err != nil {
+		return err
+	}
+
+	if err = r.redisClient.Del(ctx, r.getLegacyTableKey()); err != nil {
+		return fmt.Errorf("redis ring migrate legacy table del failed, err: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 基于 RESP 协议的 redis 模拟服务，handler 接收命令参数并返回原始的 RESP 响应，返回空串时断开连接
+type mockRedisServer struct {
+	listener net.Listener
+	handler  func(args []string) string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newMockRedisServer(t testing.TB, handler func(args []string) string) *mockRedisServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &mockRedisServer{listener: listener, handler: handler}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *mockRedisServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *mockRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readMockCommand(reader)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(args, " "))
+		s.mu.Unlock()
+
+		// handler 返回空串时直接断开连接，用于模拟网络故障
+		reply := s.handler(args)
+		if reply == "" {
+			return
+		}
+		if _, err = io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *mockRedisServer) received(command string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, _command := range s.commands {
+		if _command == command {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取一条由多行字符串数组组成的命令
+func readMockCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
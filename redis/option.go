@@ -1,5 +1,10 @@
 package redis
 
+import (
+	"crypto/tls"
+	"time"
+)
+
 const (
 	// 默认连接池超过 10 s 释放连接
 	DefaultIdleTimeoutSeconds = 10
@@ -7,6 +12,14 @@ const (
 	DefaultMaxActive = 100
 	// 默认最大空闲连接数
 	DefaultMaxIdle = 20
+	// 哈希环在 redis 中的 key 的默认前缀
+	DefaultKeyPrefix = "redis:consistent_hash:ring"
+	// 默认建立连接的超时时间
+	DefaultDialTimeout = 5 * time.Second
+	// 默认读取响应的超时时间
+	DefaultReadTimeout = 3 * time.Second
+	// 默认发送命令的超时时间
+	DefaultWriteTimeout = 3 * time.Second
 )
 
 type ClientOptions struct {
@@ -18,6 +31,22 @@ type ClientOptions struct {
 	network  string
 	address  string
 	password string
+	// 非空时使用 TLS 建立连接
+	tlsConfig *tls.Config
+	// 连接建立后通过 SELECT 切换到的数据库
+	database int
+	// 建立连接、读取响应以及发送命令的超时时间
+	dialTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// 非空时只读命令发往该地址的从节点
+	readReplicaAddress string
+
+	// 单条命令的最大执行次数，1 表示不重试
+	retryAttempts int
+	// 首次重试前的等待时间，之后每次重试翻倍
+	retryBackoff time.Duration
 }
 
 type ClientOption func(c *ClientOptions)
@@ -46,6 +75,60 @@ func WithWaitMode() ClientOption {
 	}
 }
 
+// 使用 TLS 连接 redis，开发环境可以通过 cfg.InsecureSkipVerify 跳过证书校验
+func WithTLS(cfg *tls.Config) ClientOption {
+	return func(c *ClientOptions) {
+		c.tlsConfig = cfg
+	}
+}
+
+// 指定 redis 数据库编号，连接池中的每个新连接都会切换到该数据库，用于和同一实例上的其他应用隔离
+func WithDatabase(db int) ClientOption {
+	return func(c *ClientOptions) {
+		c.database = db
+	}
+}
+
+// 将 ZRANGE、HGET、SMEMBERS、GET 等只读命令发往 addr 对应的从节点，写命令以及会写入数据的 lua 脚本仍然发往主节点。
+// 从节点的复制存在延迟，刚写入主节点的数据可能无法立即读到，例如 ZADD 之后立即检索虚拟节点可能得到旧的结果。
+// RedisHashRing 只会将数据 key 路由查询中的 Ceiling、Node 发往从节点，节点变更、数据迁移等流程中的读命令仍然发往主节点
+func WithReadReplica(addr string) ClientOption {
+	return func(c *ClientOptions) {
+		c.readReplicaAddress = addr
+	}
+}
+
+// 命令因连接断开、超时等连接错误失败时重试，最多执行 maxAttempts 次，重试间隔从 backoff 开始指数增长。
+// 连接在 redis 执行完命令后断开时，重试会导致命令被重复执行
+func WithRetry(maxAttempts int, backoff time.Duration) ClientOption {
+	return func(c *ClientOptions) {
+		c.retryAttempts = maxAttempts
+		c.retryBackoff = backoff
+	}
+}
+
+// 建立连接的超时时间，默认为 DefaultDialTimeout
+func WithDialTimeout(timeout time.Duration) ClientOption {
+	return func(c *ClientOptions) {
+		c.dialTimeout = timeout
+	}
+}
+
+// 读取单条命令响应的超时时间，默认为 DefaultReadTimeout，避免 redis 无响应时哈希环的操作（以及其持有的锁）被无限期阻塞
+// 订阅 channel 的连接接收消息时不受该超时时间限制
+func WithReadTimeout(timeout time.Duration) ClientOption {
+	return func(c *ClientOptions) {
+		c.readTimeout = timeout
+	}
+}
+
+// 发送单条命令的超时时间，默认为 DefaultWriteTimeout
+func WithWriteTimeout(timeout time.Duration) ClientOption {
+	return func(c *ClientOptions) {
+		c.writeTimeout = timeout
+	}
+}
+
 func repairClient(c *ClientOptions) {
 	if c.maxIdle < 0 {
 		c.maxIdle = DefaultMaxIdle
@@ -58,4 +141,39 @@ func repairClient(c *ClientOptions) {
 	if c.maxActive < 0 {
 		c.maxActive = DefaultMaxActive
 	}
+
+	if c.database < 0 {
+		c.database = 0
+	}
+
+	if c.retryAttempts < 1 {
+		c.retryAttempts = 1
+	}
+
+	if c.retryBackoff < 0 {
+		c.retryBackoff = 0
+	}
+
+	if c.dialTimeout <= 0 {
+		c.dialTimeout = DefaultDialTimeout
+	}
+
+	if c.readTimeout <= 0 {
+		c.readTimeout = DefaultReadTimeout
+	}
+
+	if c.writeTimeout <= 0 {
+		c.writeTimeout = DefaultWriteTimeout
+	}
+}
+
+type RedisHashRingOption func(r *RedisHashRing)
+
+// 指定哈希环在 redis 中全部 key（锁、虚拟节点、真实节点、数据 key 集合、pub/sub channel 等）的前缀，默认为 DefaultKeyPrefix
+// 用于多个业务共用同一个 redis、或者 redis 按照 key 前缀配置了 ACL 的场景。
+// 真实节点的数据 key 集合只以真实节点 id 区分，因此使用相同前缀的哈希环之间会共享同名真实节点的数据 key，不同前缀之间互不影响
+func WithKeyPrefix(prefix string) RedisHashRingOption {
+	return func(r *RedisHashRing) {
+		r.keyPrefix = prefix
+	}
 }
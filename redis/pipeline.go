@@ -0,0 +1,92 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Pipeline 将多条命令缓存在客户端，在 Exec 时一次性发送给 redis 并依次读取结果，用于减少网络往返次数
+// Pipeline 独占一个连接，使用完毕后需要调用 Close 归还连接
+type Pipeline struct {
+	conn redis.Conn
+	// 已经发送但尚未读取结果的命令数量
+	pending int
+}
+
+func (c *Client) Pipeline(ctx context.Context) (*Pipeline, error) {
+	conn, err := c.GetConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Pipeline{conn: conn}, nil
+}
+
+func (p *Pipeline) send(commandName string, args ...interface{}) error {
+	if err := p.conn.Send(commandName, args...); err != nil {
+		return err
+	}
+	p.pending++
+	return nil
+}
+
+func (p *Pipeline) ZAdd(table string, score int64, value string) error {
+	return p.send("ZADD", table, score, value)
+}
+
+// 结果可以通过 ParseZRangeByScore 解析
+func (p *Pipeline) ZRangeByScore(table string, score1, score2 int64) error {
+	return p.send("ZRANGE", table, score1, score2, "BYSCORE", "WITHSCORES")
+}
+
+func (p *Pipeline) ZRem(table string, score int64) error {
+	return p.send("ZREMRANGEBYSCORE", table, score, score)
+}
+
+func (p *Pipeline) Eval(src string, keyCount int, keysAndArgs ...interface{}) error {
+	return p.send("EVAL", append([]interface{}{src, keyCount}, keysAndArgs...)...)
+}
+
+func (p *Pipeline) SAdd(key string, members ...string) error {
+	return p.send("SADD", redis.Args{}.Add(key).AddFlat(members)...)
+}
+
+// 发送全部缓存的命令，并按照命令的顺序返回每条命令的执行结果
+// 单条命令执行失败时，其结果为 redis.Error 类型，不会中断其他命令结果的读取
+func (p *Pipeline) Exec() ([]interface{}, error) {
+	if err := p.conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, 0, p.pending)
+	for ; p.pending > 0; p.pending-- {
+		reply, err := p.conn.Receive()
+		if _, ok := err.(redis.Error); ok {
+			replies = append(replies, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+func (p *Pipeline) Close() error {
+	return p.conn.Close()
+}
+
+// 解析 Pipeline.ZRangeByScore 命令的执行结果
+func ParseZRangeByScore(reply interface{}) ([]*ScoreEntity, error) {
+	if err, ok := reply.(error); ok {
+		return nil, err
+	}
+
+	raws, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, fmt.Errorf("parse zrange by score reply failed, err: %w", err)
+	}
+	return parseScoreEntities(raws)
+}
@@ -6,16 +6,33 @@ import (
 	"fmt"
 	"github.com/demdxx/gocast"
 	"github.com/gomodule/redigo/redis"
+	"io"
+	"net"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 var ErrScoreNotExist = errors.New("score not exist")
 
+// 客户端已经调用过 Close
+var ErrClientClosed = errors.New("redis client closed")
+
+// 未配置 redis 地址
+var ErrEmptyAddress = errors.New("redis address empty")
+
 // Client Redis客户端
 type Client struct {
 	opts *ClientOptions
 	pool *redis.Pool
+	// 只读从节点的连接池，未配置 WithReadReplica 时为 nil
+	readPool *redis.Pool
+	// 非空时通过 sentinel 获取主节点地址
+	sentinel *sentinel
+	// 连接池由使用方注入时为 true，Close 不会关闭该连接池
+	sharedPool bool
+	closed     atomic.Bool
 }
 
 func NewClient(network, address, password string, opts ...ClientOption) *Client {
@@ -32,10 +49,38 @@ func NewClient(network, address, password string, opts ...ClientOption) *Client
 	}
 	repairClient(c.opts)
 
-	pool := c.getRedisPool()
-	return &Client{
-		pool: pool,
+	// 连接池的 Dial 函数依赖 c.opts，因此需要返回持有完整配置项的 client
+	c.pool = c.getRedisPool()
+	c.readPool = c.getReadPool()
+	return &c
+}
+
+// 使用外部已经创建好的连接池构造客户端，便于与其他组件共享连接池以及其自定义的拨号、监控、熔断等逻辑
+// 连接相关的配置项（地址、密码、连接池大小等）由连接池自身决定，opts 中只有 WithRetry、WithReadReplica 等与连接池无关的配置项生效
+// 连接池的生命周期由使用方管理，Close 不会关闭注入的连接池
+func NewClientWithPool(pool *redis.Pool, opts ...ClientOption) *Client {
+	c := Client{
+		opts:       &ClientOptions{network: "tcp"},
+		pool:       pool,
+		sharedPool: true,
+	}
+
+	for _, opt := range opts {
+		opt(c.opts)
+	}
+	repairClient(c.opts)
+
+	c.readPool = c.getReadPool()
+	return &c
+}
+
+// 与 NewClient 相同，但会预先校验配置项，配置不合法时返回错误
+// NewClient 在地址为空时同样可以构造成功，只是之后的每条命令都会返回 ErrEmptyAddress
+func NewValidatedClient(network, address, password string, opts ...ClientOption) (*Client, error) {
+	if address == "" {
+		return nil, ErrEmptyAddress
 	}
+	return NewClient(network, address, password, opts...), nil
 }
 
 func (c *Client) getRedisPool() *redis.Pool {
@@ -51,28 +96,180 @@ func (c *Client) getRedisPool() *redis.Pool {
 		},
 		MaxActive: c.opts.maxActive,
 		Wait:      c.opts.wait,
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			_, err := c.Do("ping")
+		TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+			// 主从切换后旧主节点的连接需要被丢弃
+			if c.sentinel != nil {
+				return testMasterRole(conn)
+			}
+			_, err := conn.Do("ping")
+			return err
+		},
+	}
+}
+
+// 未配置只读从节点时返回 nil
+func (c *Client) getReadPool() *redis.Pool {
+	if c.opts.readReplicaAddress == "" {
+		return nil
+	}
+	return &redis.Pool{
+		MaxIdle:     c.opts.maxIdle,
+		IdleTimeout: time.Duration(c.opts.idleTimeoutSeconds) * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return c.dial(c.opts.readReplicaAddress)
+		},
+		MaxActive: c.opts.maxActive,
+		Wait:      c.opts.wait,
+		TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+			_, err := conn.Do("ping")
 			return err
 		},
 	}
 }
 
 func (c *Client) GetConn(ctx context.Context) (redis.Conn, error) {
+	if c.closed.Load() {
+		return nil, ErrClientClosed
+	}
 	return c.pool.GetContext(ctx)
 }
 
+// 主节点连接池的统计信息
+func (c *Client) PoolStats() redis.PoolStats {
+	return c.pool.Stats()
+}
+
+// 检查 redis 是否可达，配置了只读从节点时会同时检查从节点
+func (c *Client) Ping(ctx context.Context) error {
+	if _, err := c.do(ctx, "PING"); err != nil {
+		return err
+	}
+	if c.readPool != nil {
+		if _, err := c.doWithPool(ctx, c.readPool, "PING"); err != nil {
+			return fmt.Errorf("read replica ping failed, err: %w", err)
+		}
+	}
+	return nil
+}
+
+// 关闭连接池并释放其中的空闲连接，之后执行的命令都会返回 ErrClientClosed
+// 已经取出的连接（如 Pipeline、Subscribe 的连接）在使用方关闭时释放，通过 NewClientWithPool 注入的连接池不会被关闭
+func (c *Client) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	// 其中一个连接池关闭失败时仍然需要关闭另一个
+	var readErr, err error
+	if c.readPool != nil {
+		readErr = c.readPool.Close()
+	}
+	if !c.sharedPool {
+		err = c.pool.Close()
+	}
+	return errors.Join(readErr, err)
+}
+
+// 在主节点上执行一条命令
+func (c *Client) do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	return c.doWithPool(ctx, c.pool, cmd, args...)
+}
+
+// 执行只读命令，配置了只读从节点时由从节点执行
+func (c *Client) doRead(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	return c.doWithPool(ctx, c.getReadPoolFor(ctx), cmd, args...)
+}
+
+type primaryReadKey struct{}
+
+// 标记 ctx 中的只读命令同样由主节点执行，用于需要读取最新数据、不能容忍从节点复制延迟的场景
+func withPrimaryRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadKey{}, struct{}{})
+}
+
+// 返回执行只读命令的连接池，未配置只读从节点或者 ctx 要求读取主节点时返回主节点的连接池
+func (c *Client) getReadPoolFor(ctx context.Context) *redis.Pool {
+	if c.readPool == nil || ctx.Value(primaryReadKey{}) != nil {
+		return c.pool
+	}
+	return c.readPool
+}
+
+// 从连接池获取连接执行一条命令，连接层面的错误会按照 WithRetry 的配置重试，
+// 每次重试都会重新获取连接。redis 返回的错误回复以及 redis.ErrNil 属于业务结果，不会重试
+func (c *Client) doWithPool(ctx context.Context, pool *redis.Pool, cmd string, args ...interface{}) (interface{}, error) {
+	if c.closed.Load() {
+		return nil, ErrClientClosed
+	}
+	backoff := c.opts.retryBackoff
+	for attempt := 1; ; attempt++ {
+		reply, err := doOnce(ctx, pool, cmd, args...)
+		if err == nil || attempt >= c.opts.retryAttempts || !isConnErr(err) {
+			return reply, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func doOnce(ctx context.Context, pool *redis.Pool, cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.Do(cmd, args...)
+}
+
+// 是否是可以通过重试恢复的连接错误
+func isConnErr(err error) bool {
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) || errors.Is(err, redis.ErrNil) || errors.Is(err, redis.ErrPoolExhausted) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
 func (c *Client) getRedisConn() (redis.Conn, error) {
-	if c.opts.address == "" {
-		panic("Cannot get redis address from config")
+	address := c.opts.address
+	if c.sentinel != nil {
+		masterAddr, err := c.sentinel.masterAddr(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		address = masterAddr
 	}
 
-	var dialOpts []redis.DialOption
+	if address == "" {
+		return nil, ErrEmptyAddress
+	}
+	return c.dial(address)
+}
+
+func (c *Client) dial(address string) (redis.Conn, error) {
+	dialOpts := []redis.DialOption{
+		redis.DialConnectTimeout(c.opts.dialTimeout),
+		redis.DialReadTimeout(c.opts.readTimeout),
+		redis.DialWriteTimeout(c.opts.writeTimeout),
+	}
 	if len(c.opts.password) > 0 {
 		dialOpts = append(dialOpts, redis.DialPassword(c.opts.password))
 	}
+	if c.opts.tlsConfig != nil {
+		dialOpts = append(dialOpts, redis.DialUseTLS(true), redis.DialTLSConfig(c.opts.tlsConfig))
+	}
+	if c.opts.database > 0 {
+		dialOpts = append(dialOpts, redis.DialDatabase(c.opts.database))
+	}
 	conn, err := redis.DialContext(context.Background(),
-		c.opts.network, c.opts.address, dialOpts...)
+		c.opts.network, address, dialOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -81,15 +278,23 @@ func (c *Client) getRedisConn() (redis.Conn, error) {
 }
 
 func (c *Client) ZAdd(ctx context.Context, table string, score int64, value string) error {
-	conn, err := c.pool.GetContext(ctx)
+	_, err := c.do(ctx, "ZADD", table, score, value)
+	return err
+}
 
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
+// 查询有序集合的成员个数
+func (c *Client) ZCard(ctx context.Context, table string) (int64, error) {
+	return redis.Int64(c.doRead(ctx, "ZCARD", table))
+}
 
-	_, err = conn.Do("ZADD", table, score, value)
-	return err
+// 仅当 value 不存在时添加，不会覆盖已有成员的 score，返回实际添加的成员个数
+func (c *Client) ZAddNX(ctx context.Context, table string, score int64, value string) (int64, error) {
+	return redis.Int64(c.do(ctx, "ZADD", table, "NX", score, value))
+}
+
+// 仅当 value 已经存在时更新其 score，不会添加新成员，返回 score 实际发生变化的成员个数
+func (c *Client) ZAddXX(ctx context.Context, table string, score int64, value string) (int64, error) {
+	return redis.Int64(c.do(ctx, "ZADD", table, "XX", "CH", score, value))
 }
 
 type ScoreEntity struct {
@@ -100,18 +305,17 @@ type ScoreEntity struct {
 // ZRangByScore 执行redis zrangebyScore命令
 // 检索出对应于score范围的一系列数据
 func (c *Client) ZRangeByScore(ctx context.Context, table string, score1, score2 int64) ([]*ScoreEntity, error) {
-	conn, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-
 	//WITHSCORES 结果会包含成员机器在环上的位置
-	raws, err := redis.Values(conn.Do("ZRANGE", table, score1, score2, "BYSCORE", "WITHSCORES"))
+	raws, err := redis.Values(c.doRead(ctx, "ZRANGE", table, score1, score2, "BYSCORE", "WITHSCORES"))
 	if err != nil {
 		return nil, err
 	}
 
+	return parseScoreEntities(raws)
+}
+
+// 将 ZRANGE ... WITHSCORES 的返回结果解析为 ScoreEntity 列表
+func parseScoreEntities(raws []interface{}) ([]*ScoreEntity, error) {
 	if len(raws)&1 != 0 {
 		return nil, fmt.Errorf("invalid entity len : %d", len(raws))
 	}
@@ -128,16 +332,20 @@ func (c *Client) ZRangeByScore(ctx context.Context, table string, score1, score2
 	return scoreEntities, nil
 }
 
-// 返回大于等于score的第一个目标
-// 通过将检索的右边界设置为 +inf ，将范围设定为 [score,+∞) ，同时通过将 limit 设置为 1，代表只返回第一笔数据
-func (c *Client) Ceiling(ctx context.Context, table string, score int64) (*ScoreEntity, error) {
-	conn, err := c.pool.GetContext(ctx)
+// 按照 score 从小到大的顺序返回 zset 中的全部数据
+func (c *Client) ZRange(ctx context.Context, table string) ([]*ScoreEntity, error) {
+	raws, err := redis.Values(c.doRead(ctx, "ZRANGE", table, 0, -1, "WITHSCORES"))
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 
-	raws, err := redis.Values(conn.Do("ZANGE", table, score, "+inf", "BYSCORE", "LIMIT", 0, 1, "WITHSCORES"))
+	return parseScoreEntities(raws)
+}
+
+// 返回大于等于score的第一个目标
+// 通过将检索的右边界设置为 +inf ，将范围设定为 [score,+∞) ，同时通过将 limit 设置为 1，代表只返回第一笔数据
+func (c *Client) Ceiling(ctx context.Context, table string, score int64) (*ScoreEntity, error) {
+	raws, err := redis.Values(c.doRead(ctx, "ZRANGE", table, score, "+inf", "BYSCORE", "LIMIT", 0, 1, "WITHSCORES"))
 	if err != nil {
 		return nil, err
 	}
@@ -155,13 +363,38 @@ func (c *Client) Ceiling(ctx context.Context, table string, score int64) (*Score
 // 通过将范围右边界设置为 -inf ，并通过 "REV" 标识实现取反操作，
 // 将检索范围设定为 (-∞,score]，同时通过将 limit 设置为 1
 func (c *Client) Floor(ctx context.Context, table string, score int64) (*ScoreEntity, error) {
-	conn, err := c.pool.GetContext(ctx)
+	raws, err := redis.Values(c.doRead(ctx, "ZRANGE", table, score, "-inf", "REV", "BYSCORE", "LIMIT", 0, 1, "WITHSCORES"))
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 
-	raws, err := redis.Values(conn.Do("ZRANGE", table, score, "-inf", "REV", "BYSCORE", "LIMIT", 0, 1, "WITHSCORES"))
+	if len(raws) != 2 {
+		return nil, fmt.Errorf("invalid len of entity: %d, err: %w", len(raws), ErrScoreNotExist)
+	}
+
+	return &ScoreEntity{
+		Score: gocast.ToInt64(raws[1]),
+		Val:   gocast.ToString(raws[0]),
+	}, nil
+}
+
+const ceilingOrFirstScript = `
+local entity = redis.call('ZRANGE', KEYS[1], ARGV[1], '+inf', 'BYSCORE', 'LIMIT', 0, 1, 'WITHSCORES')
+if #entity == 0 then
+	entity = redis.call('ZRANGE', KEYS[1], '-inf', '+inf', 'BYSCORE', 'LIMIT', 0, 1, 'WITHSCORES')
+end
+return entity
+`
+
+// 检索 zset 中大于等于 score 且最接近 score 的元素，不存在时回绕返回 score 最小的元素，zset 为空时返回 ErrScoreNotExist
+// 通过 lua 脚本在一次请求中完成，相比 Ceiling 未命中后再调用 FirstOrLast 节省一次网络往返
+// 与其他只读命令一样，配置了只读从节点时由从节点执行，此时使用 EVAL_RO（redis 7.0 及以上版本）声明脚本只包含读命令
+func (c *Client) CeilingOrFirst(ctx context.Context, table string, score int64) (*ScoreEntity, error) {
+	pool, cmd := c.getReadPoolFor(ctx), "EVAL"
+	if pool == c.readPool {
+		cmd = "EVAL_RO"
+	}
+	raws, err := redis.Values(c.doWithPool(ctx, pool, cmd, ceilingOrFirstScript, 1, table, score))
 	if err != nil {
 		return nil, err
 	}
@@ -178,17 +411,14 @@ func (c *Client) Floor(ctx context.Context, table string, score int64) (*ScoreEn
 
 // 用于返回zset中最小或者最大的score分值
 func (c *Client) FirstOrLast(ctx context.Context, table string, first bool) (*ScoreEntity, error) {
-	conn, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-
-	var raws []interface{}
+	var (
+		raws []interface{}
+		err  error
+	)
 	if first {
-		raws, err = redis.Values(conn.Do("ZRANGE", table, "-inf", "+inf", "BYSCORE", "LIMIT", 0, 1, "WITHSCORES"))
+		raws, err = redis.Values(c.doRead(ctx, "ZRANGE", table, "-inf", "+inf", "BYSCORE", "LIMIT", 0, 1, "WITHSCORES"))
 	} else {
-		raws, err = redis.Values(conn.Do("ZRANGE", table, "+inf", "-inf", "REV", "BYSCORE", "LIMIT", 0, 1, "WITHSCORES"))
+		raws, err = redis.Values(c.doRead(ctx, "ZRANGE", table, "+inf", "-inf", "REV", "BYSCORE", "LIMIT", 0, 1, "WITHSCORES"))
 	}
 
 	if err != nil {
@@ -206,73 +436,167 @@ func (c *Client) FirstOrLast(ctx context.Context, table string, first bool) (*Sc
 }
 
 func (c *Client) ZRem(ctx context.Context, table string, score int64) error {
-	conn, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return err
-	}
-
-	defer conn.Close()
-	_, err = conn.Do("ZREMRANGEBYSCORE", table, score, score)
+	_, err := c.do(ctx, "ZREMRANGEBYSCORE", table, score, score)
 	return err
 }
 
 func (c *Client) HSet(ctx context.Context, table, key, val string) error {
-	conn, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	_, err = conn.Do("HSet", table, key, val)
+	_, err := c.do(ctx, "HSet", table, key, val)
 	return err
 }
 
 func (c *Client) HGetAll(ctx context.Context, table string) (map[string]string, error) {
-	conn, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
+	return redis.StringMap(c.doRead(ctx, "HGETALL", table))
+}
+
+// 查询哈希表 table 中字段 key 的值，字段不存在时返回 redis.ErrNil
+func (c *Client) HGet(ctx context.Context, table, key string) (string, error) {
+	return redis.String(c.doRead(ctx, "HGET", table, key))
+}
 
-	return redis.StringMap(conn.Do("HGETALL", table))
+// 查询哈希表 table 中是否存在字段 key
+func (c *Client) HExists(ctx context.Context, table, key string) (bool, error) {
+	return redis.Bool(c.doRead(ctx, "HEXISTS", table, key))
 }
 
 func (c *Client) HDel(ctx context.Context, table, key string) error {
-	conn, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	_, err = conn.Do("HDEL", table, key)
+	_, err := c.do(ctx, "HDEL", table, key)
 	return err
 }
 
-func (c *Client) Set(ctx context.Context, key, val string) error {
-	conn, err := c.pool.GetContext(ctx)
+// 向集合中添加成员
+func (c *Client) SAdd(ctx context.Context, key string, members ...string) error {
+	_, err := c.do(ctx, "SADD", redis.Args{}.Add(key).AddFlat(members)...)
+	return err
+}
+
+// 从集合中删除成员
+func (c *Client) SRem(ctx context.Context, key string, members ...string) error {
+	_, err := c.do(ctx, "SREM", redis.Args{}.Add(key).AddFlat(members)...)
+	return err
+}
+
+// 获取集合中的全部成员，集合不存在时返回空列表
+func (c *Client) SMembers(ctx context.Context, key string) ([]string, error) {
+	return redis.Strings(c.doRead(ctx, "SMEMBERS", key))
+}
+
+// 查询 member 是否是集合的成员，集合不存在时返回 false
+func (c *Client) SIsMember(ctx context.Context, key, member string) (bool, error) {
+	return redis.Bool(c.doRead(ctx, "SISMEMBER", key, member))
+}
+
+// 查询集合的成员个数
+func (c *Client) SCard(ctx context.Context, key string) (int64, error) {
+	return redis.Int64(c.doRead(ctx, "SCARD", key))
+}
+
+// 从 cursor 开始遍历集合中的成员，count 为单次返回个数的参考值，返回的 next 为 0 时表示遍历结束
+func (c *Client) SScan(ctx context.Context, key string, cursor uint64, count int) ([]string, uint64, error) {
+	raws, err := redis.Values(c.doRead(ctx, "SSCAN", key, cursor, "COUNT", count))
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	defer conn.Close()
-	_, err = conn.Do("SET", key, val)
+	if len(raws) != 2 {
+		return nil, 0, fmt.Errorf("invalid len of sscan reply: %d", len(raws))
+	}
+
+	next, err := redis.Uint64(raws[0], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	members, err := redis.Strings(raws[1], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return members, next, nil
+}
+
+func (c *Client) Set(ctx context.Context, key, val string) error {
+	_, err := c.do(ctx, "SET", key, val)
 	return err
 }
 
 func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	return redis.String(c.doRead(ctx, "GET", key))
+}
+
+// 将 key 对应的整数值加一，并返回加一后的值
+func (c *Client) Incr(ctx context.Context, key string) (int64, error) {
+	return redis.Int64(c.do(ctx, "INCR", key))
+}
+
+// 向 channel 发布一条消息，返回收到消息的订阅者个数
+func (c *Client) Publish(ctx context.Context, channel, message string) (int64, error) {
+	return redis.Int64(c.do(ctx, "PUBLISH", channel, message))
+}
+
+// 订阅 channel，订阅生效后才会返回，ctx 结束后取消订阅并关闭返回的 channel
+// 订阅期间会独占连接池中的一个连接，退订之后归还给连接池。接收消息时不使用读超时，连接需要实现 redis.ConnWithTimeout
+func (c *Client) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	if c.closed.Load() {
+		return nil, ErrClientClosed
+	}
+	// 与其他命令一样从连接池获取连接，通过 NewClientWithPool 注入的连接池同样适用
 	conn, err := c.pool.GetContext(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer conn.Close()
-	return redis.String(conn.Do("GET", key))
+
+	psc := redis.PubSubConn{Conn: conn}
+	if err = psc.Subscribe(channel); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// 等待订阅确认，保证返回之后发布的消息都能被收到
+	if err, ok := psc.Receive().(error); ok {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// ctx 结束后退订，阻塞中的 Receive 收到退订确认后结束接收消息的协程
+	// 连接只能由接收消息的协程归还给连接池，归还前需要等待退订完成，避免并发使用同一个连接
+	stop, unsubscribed := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(unsubscribed)
+		select {
+		case <-ctx.Done():
+			_ = psc.Unsubscribe()
+		case <-stop:
+		}
+	}()
+
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		defer func() {
+			close(stop)
+			<-unsubscribed
+			_ = conn.Close()
+		}()
+		for {
+			// 订阅期间可能长时间没有消息，接收消息时不使用读超时
+			switch v := psc.ReceiveWithTimeout(0).(type) {
+			case redis.Message:
+				select {
+				case messages <- string(v.Data):
+				case <-ctx.Done():
+					return
+				}
+			case redis.Subscription:
+				if v.Count == 0 {
+					return
+				}
+			case error:
+				return
+			}
+		}
+	}()
+	return messages, nil
 }
 
 func (c *Client) Del(ctx context.Context, key string) error {
-	conn, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	_, err = conn.Do("DEL", key)
+	_, err := c.do(ctx, "DEL", key)
 	return err
 }
 
@@ -282,13 +606,7 @@ func (c *Client) Eval(ctx context.Context, src string, keyCount int, keysAndArgs
 	args[0] = src
 	args[1] = keyCount
 	copy(args[2:], keysAndArgs)
-	conn, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return -1, err
-	}
-	defer conn.Close()
-
-	return conn.Do("EVAL", args...)
+	return c.do(ctx, "EVAL", args...)
 }
 
 func (c *Client) SetNEX(ctx context.Context, key, value string, expireSeconds int64) (int64, error) {
@@ -296,13 +614,7 @@ func (c *Client) SetNEX(ctx context.Context, key, value string, expireSeconds in
 		return -1, errors.New("redis SET keyNX or value can't be empty")
 	}
 
-	conn, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return -1, err
-	}
-	defer conn.Close()
-
-	reply, err := conn.Do("SET", key, value, "EX", expireSeconds, "NX")
+	reply, err := c.do(ctx, "SET", key, value, "EX", expireSeconds, "NX")
 	if err != nil {
 		return -1, err
 	}
@@ -0,0 +1,1750 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	miniredisserver "github.com/alicebob/miniredis/v2/server"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	network  = "tcp"
+	address  = "localhost:6379"
+	password = ""
+)
+
+// 获取连接本地 redis 的客户端，倘若本地 redis 不可用则改为连接 miniredis
+func newTestClient(t testing.TB, opts ...ClientOption) *Client {
+	t.Helper()
+	client := NewClient(network, address, password, opts...)
+	conn, err := client.GetConn(context.Background())
+	if err == nil {
+		defer conn.Close()
+		if _, err = conn.Do("PING"); err == nil {
+			return client
+		}
+	}
+	client, _ = newMiniRedisClient(t, opts...)
+	return client
+}
+
+// 获取连接 miniredis 的客户端，miniredis 支持 EVAL，哈希环使用的 lua 脚本会被真实地执行
+func newMiniRedisClient(t testing.TB, opts ...ClientOption) (*Client, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	return NewClient(network, server.Addr(), password, opts...), server
+}
+
+// 记录 miniredis 收到的命令，lua 脚本中执行的命令同样会被记录
+func recordMiniRedisCommands(server *miniredis.Miniredis) func() []string {
+	var (
+		mu       sync.Mutex
+		commands []string
+	)
+	server.Server().SetPreHook(func(_ *miniredisserver.Peer, cmd string, args ...string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		commands = append(commands, strings.Join(append([]string{cmd}, args...), " "))
+		return false
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), commands...)
+	}
+}
+
+func Test_NewClient_Options(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client := NewClient(network, listener.Addr().String(), password,
+		WithMaxIdle(3), WithMaxActive(7), WithIdleTimeoutSeconds(5), WithWaitMode())
+	if client.opts == nil {
+		t.Fatal("client opts should be retained")
+	}
+	if client.opts.maxIdle != 3 || client.opts.maxActive != 7 || client.opts.idleTimeoutSeconds != 5 || !client.opts.wait {
+		t.Errorf("unexpected client opts: %+v", *client.opts)
+	}
+	if client.opts.address != listener.Addr().String() {
+		t.Errorf("got address %s, want %s", client.opts.address, listener.Addr().String())
+	}
+	if client.pool.MaxIdle != 3 || client.pool.MaxActive != 7 || client.pool.IdleTimeout != 5*time.Second || !client.pool.Wait {
+		t.Errorf("pool not configured by client opts")
+	}
+
+	// 连接池的 Dial 函数需要使用 client 配置的地址建立连接
+	accepted := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		close(accepted)
+	}()
+
+	conn, err := client.pool.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Error("pool dial did not reach the configured address")
+	}
+}
+
+func Test_Client_Ceiling(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	table := "test:consistent_hash:client:ceiling"
+	_ = client.Del(ctx, table)
+	defer func() {
+		_ = client.Del(ctx, table)
+	}()
+
+	for score, val := range map[int64]string{10: "a", 20: "b", 30: "c"} {
+		if err := client.ZAdd(ctx, table, score, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		score     int64
+		wantScore int64
+		wantVal   string
+	}{
+		{score: 5, wantScore: 10, wantVal: "a"},
+		{score: 10, wantScore: 10, wantVal: "a"},
+		{score: 11, wantScore: 20, wantVal: "b"},
+		{score: 30, wantScore: 30, wantVal: "c"},
+	}
+	for _, c := range cases {
+		entity, err := client.Ceiling(ctx, table, c.score)
+		if err != nil {
+			t.Fatalf("ceiling %d: %v", c.score, err)
+		}
+		if entity.Score != c.wantScore || entity.Val != c.wantVal {
+			t.Errorf("ceiling %d: got (%d, %s), want (%d, %s)", c.score, entity.Score, entity.Val, c.wantScore, c.wantVal)
+		}
+	}
+
+	if _, err := client.Ceiling(ctx, table, 31); err == nil {
+		t.Error("ceiling beyond the largest score should return ErrScoreNotExist")
+	}
+}
+
+func Test_RedisHashRing_Ceiling_Wraparound(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	ring := NewRedisHashRing("test_ceiling_wraparound", client)
+	_ = client.Del(ctx, ring.getTableKey())
+	_ = client.Del(ctx, ring.getScoreNodeKey())
+	defer func() {
+		_ = client.Del(ctx, ring.getTableKey())
+		_ = client.Del(ctx, ring.getScoreNodeKey())
+	}()
+
+	if score, err := ring.Ceiling(ctx, 1); err != nil || score != -1 {
+		t.Fatalf("ceiling on empty ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+
+	for _, score := range []int64{100, 200} {
+		if err := ring.Add(ctx, score, "node"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		score int64
+		want  int64
+	}{
+		{score: 50, want: 100},
+		{score: 150, want: 200},
+		{score: 200, want: 200},
+		// 超出最大虚拟节点后需要回绕到环上的第一个节点
+		{score: 201, want: 100},
+	}
+	for _, c := range cases {
+		score, err := ring.Ceiling(ctx, c.score)
+		if err != nil {
+			t.Fatalf("ceiling %d: %v", c.score, err)
+		}
+		if score != c.want {
+			t.Errorf("ceiling %d: got %d, want %d", c.score, score, c.want)
+		}
+	}
+}
+
+// 回绕检索只需要一次网络往返
+func Test_RedisHashRing_Ceiling_SingleRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	// miniredis 会把脚本内部的 redis.call 也计入命令数，因此在客户端一侧统计发出的请求数
+	server := miniredis.RunT(t)
+	var sent int32
+	pool := &redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", server.Addr())
+			return countingConn{Conn: conn, commands: &sent}, err
+		},
+	}
+	defer pool.Close()
+	ring := NewRedisHashRing("test_ceiling_single_round_trip", NewClientWithPool(pool))
+
+	if score, err := ring.Ceiling(ctx, 1); err != nil || score != -1 {
+		t.Fatalf("ceiling on empty ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+	for _, score := range []int64{100, 200} {
+		if err := ring.Add(ctx, score, "node"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	atomic.StoreInt32(&sent, 0)
+	if score, err := ring.Ceiling(ctx, 201); err != nil || score != 100 {
+		t.Fatalf("ceiling 201: got (%d, %v), want 100", score, err)
+	}
+	if score, err := ring.Ceiling(ctx, 150); err != nil || score != 200 {
+		t.Fatalf("ceiling 150: got (%d, %v), want 200", score, err)
+	}
+
+	if got := atomic.LoadInt32(&sent); got != 2 {
+		t.Errorf("got %d commands for 2 ceiling lookups, want 2", got)
+	}
+}
+
+// 统计通过连接发出的命令数，连接池归还连接时以空命令刷新缓冲区，不计入其中
+type countingConn struct {
+	redis.Conn
+	commands *int32
+}
+
+func (c countingConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" {
+		atomic.AddInt32(c.commands, 1)
+	}
+	return c.Conn.Do(cmd, args...)
+}
+
+// 订阅时需要不带读超时地接收消息
+func (c countingConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+func (c countingConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" {
+		atomic.AddInt32(c.commands, 1)
+	}
+	return redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+}
+
+// 对比回绕检索时 Ceiling 与 FirstOrLast 两次请求和 CeilingOrFirst 一次请求的耗时
+func Benchmark_Client_CeilingThenFirst(b *testing.B) {
+	ctx := context.Background()
+	client, table := newBenchmarkWraparoundTable(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Ceiling(ctx, table, 1000); !errors.Is(err, ErrScoreNotExist) {
+			b.Fatal(err)
+		}
+		if _, err := client.FirstOrLast(ctx, table, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_Client_CeilingOrFirst(b *testing.B) {
+	ctx := context.Background()
+	client, table := newBenchmarkWraparoundTable(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.CeilingOrFirst(ctx, table, 1000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 检索的 score 大于 zset 中的所有元素，需要回绕到第一个元素
+func newBenchmarkWraparoundTable(b *testing.B) (*Client, string) {
+	b.Helper()
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(b)
+	table := "benchmark_wraparound"
+	for score := int64(0); score < 100; score++ {
+		if err := client.ZAdd(ctx, table, score, fmt.Sprint(score)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return client, table
+}
+
+func Test_RedisHashRing_BatchAdd(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	ring := NewRedisHashRing("test_batch_add", client)
+	_ = client.Del(ctx, ring.getTableKey())
+	_ = client.Del(ctx, ring.getScoreNodeKey())
+	defer func() {
+		_ = client.Del(ctx, ring.getTableKey())
+		_ = client.Del(ctx, ring.getScoreNodeKey())
+	}()
+
+	if err := ring.Add(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.BatchAdd(ctx, map[int64][]string{
+		10: {"node_a", "node_b"},
+		20: {"node_c"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	nodeIDs, err := ring.Node(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(nodeIDs) != "[node_a node_b]" {
+		t.Errorf("got node ids %v at score 10, want [node_a node_b]", nodeIDs)
+	}
+	if nodeIDs, err = ring.Node(ctx, 20); err != nil || fmt.Sprint(nodeIDs) != "[node_c]" {
+		t.Errorf("got node ids (%v, %v) at score 20, want [node_c]", nodeIDs, err)
+	}
+}
+
+// 对比逐个添加与 pipeline 批量添加 1000 个虚拟节点的耗时
+func Benchmark_RedisHashRing_Add(b *testing.B) {
+	ctx := context.Background()
+	client := newTestClient(b)
+	ring := NewRedisHashRing("benchmark_add", client)
+	defer func() {
+		_ = client.Del(ctx, ring.getTableKey())
+		_ = client.Del(ctx, ring.getScoreNodeKey())
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = client.Del(ctx, ring.getTableKey())
+		_ = client.Del(ctx, ring.getScoreNodeKey())
+		for score := int64(0); score < 1000; score++ {
+			if err := ring.Add(ctx, score, fmt.Sprintf("node_%d", score)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func Benchmark_RedisHashRing_BatchAdd(b *testing.B) {
+	ctx := context.Background()
+	client := newTestClient(b)
+	ring := NewRedisHashRing("benchmark_batch_add", client)
+	defer func() {
+		_ = client.Del(ctx, ring.getTableKey())
+		_ = client.Del(ctx, ring.getScoreNodeKey())
+	}()
+
+	virtualNodes := make(map[int64][]string, 1000)
+	for score := int64(0); score < 1000; score++ {
+		virtualNodes[score] = []string{fmt.Sprintf("node_%d", score)}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = client.Del(ctx, ring.getTableKey())
+		_ = client.Del(ctx, ring.getScoreNodeKey())
+		if err := ring.BatchAdd(ctx, virtualNodes); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 生成仅对 127.0.0.1 有效的自签名证书
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "consistent_hash test"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func Test_NewClient_WithTLS(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// 服务端只负责完成 TLS 握手
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	cases := []struct {
+		name    string
+		cfg     *tls.Config
+		wantErr bool
+	}{
+		{name: "trusted", cfg: &tls.Config{RootCAs: roots}},
+		{name: "skip verify", cfg: &tls.Config{InsecureSkipVerify: true}},
+		{name: "untrusted", cfg: &tls.Config{RootCAs: x509.NewCertPool()}, wantErr: true},
+	}
+	for _, c := range cases {
+		client := NewClient(network, listener.Addr().String(), password, WithTLS(c.cfg))
+		conn, err := client.pool.Dial()
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: got err %v, want err %v", c.name, err, c.wantErr)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func Test_NewClient_WithDatabase(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, WithDatabase(3))
+	defaultClient := newTestClient(t)
+	ring := NewRedisHashRing("test_with_database", client)
+	defer func() {
+		_ = client.Del(ctx, ring.getTableKey())
+		_ = client.Del(ctx, ring.getScoreNodeKey())
+	}()
+
+	if err := ring.Add(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个新建立的连接都需要切换到 3 号数据库
+	for i := 0; i < 3; i++ {
+		conn, err := client.pool.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		exists, err := redis.Bool(conn.Do("EXISTS", ring.getTableKey()))
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Errorf("ring key should exist in db 3")
+		}
+	}
+
+	entities, err := defaultClient.ZRange(ctx, ring.getTableKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 0 {
+		t.Errorf("ring key should be absent from db 0, got %v", entities)
+	}
+}
+
+func Test_NewClient_WithRetry(t *testing.T) {
+	ctx := context.Background()
+	// 第一次 GET 时断开连接，之后正常返回
+	newFlakyServer := func() *mockRedisServer {
+		var gets int32
+		return newMockRedisServer(t, func(args []string) string {
+			switch strings.ToUpper(args[0]) {
+			case "GET":
+				if atomic.AddInt32(&gets, 1) == 1 {
+					return ""
+				}
+				return "$3\r\nval\r\n"
+			case "SET":
+				return "-ERR wrong number of arguments\r\n"
+			}
+			return "+OK\r\n"
+		})
+	}
+
+	server := newFlakyServer()
+	client := NewClient(network, server.addr(), password, WithRetry(3, time.Millisecond))
+	if val, err := client.Get(ctx, "key"); err != nil || val != "val" {
+		t.Fatalf("get with retry: got (%s, %v), want val", val, err)
+	}
+
+	if _, err := NewClient(network, newFlakyServer().addr(), password).Get(ctx, "key"); err == nil {
+		t.Error("get without retry should return the connection error")
+	}
+
+	// redis 返回的错误回复不会重试
+	if err := client.Set(ctx, "key", "val"); err == nil {
+		t.Error("set should return the error reply")
+	}
+	var sets int
+	server.mu.Lock()
+	for _, cmd := range server.commands {
+		if strings.HasPrefix(strings.ToUpper(cmd), "SET") {
+			sets++
+		}
+	}
+	server.mu.Unlock()
+	if sets != 1 {
+		t.Errorf("error reply retried: got %d SET commands, want 1", sets)
+	}
+
+	// 重试等待期间 ctx 结束时立即返回
+	cancelCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	slow := NewClient(network, newFlakyServer().addr(), password, WithRetry(3, time.Minute))
+	if _, err := slow.Get(cancelCtx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context deadline exceeded", err)
+	}
+}
+
+func Test_NewClient_WithReadReplica(t *testing.T) {
+	ctx := context.Background()
+	// 主从节点对 GET 返回不同的值，用于区分命令由哪个节点执行
+	primary := newMockRedisServer(t, func(args []string) string {
+		if strings.ToUpper(args[0]) == "GET" {
+			return "$7\r\nprimary\r\n"
+		}
+		return "+OK\r\n"
+	})
+	replica := newMockRedisServer(t, func(args []string) string {
+		if strings.ToUpper(args[0]) == "GET" {
+			return "$7\r\nreplica\r\n"
+		}
+		return "*0\r\n"
+	})
+	client := NewClient(network, primary.addr(), password, WithReadReplica(replica.addr()))
+
+	if val, err := client.Get(ctx, "key"); err != nil || val != "replica" {
+		t.Errorf("get: got (%s, %v), want replica", val, err)
+	}
+	if _, err := client.ZRangeByScore(ctx, "table", 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Set(ctx, "key", "val"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ZAdd(ctx, "table", 1, "node"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range []string{"GET key", "ZRANGE table 0 10 BYSCORE WITHSCORES"} {
+		if !replica.received(cmd) || primary.received(cmd) {
+			t.Errorf("read command %q should be sent to the replica only", cmd)
+		}
+	}
+	for _, cmd := range []string{"SET key val", "ZADD table 1 node"} {
+		if !primary.received(cmd) || replica.received(cmd) {
+			t.Errorf("write command %q should be sent to the primary only", cmd)
+		}
+	}
+
+	// 未配置从节点时读命令由主节点执行
+	if val, err := NewClient(network, primary.addr(), password).Get(ctx, "key"); err != nil || val != "primary" {
+		t.Errorf("get without replica: got (%s, %v), want primary", val, err)
+	}
+}
+
+func Test_RedisHashRing_WithReadReplica(t *testing.T) {
+	ctx := context.Background()
+	primary := miniredis.RunT(t)
+	// 从节点上只有 score 为 50 的虚拟节点，其余命令返回错误，用于确认只有路由查询发往从节点
+	replica := newMockRedisServer(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			return "+PONG\r\n"
+		case "EVAL_RO":
+			return "*2\r\n$12\r\nnode_replica\r\n$2\r\n50\r\n"
+		case "HGET":
+			return "$16\r\n[\"node_replica\"]\r\n"
+		}
+		return "-ERR read sent to replica\r\n"
+	})
+	ring := NewRedisHashRing("test_read_replica", NewClient(network, primary.Addr(), password, WithReadReplica(replica.addr())))
+	if err := ring.Add(ctx, 10, "node_primary"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.AddNodeToReplica(ctx, "node_primary", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if score, err := ring.ReplicaCeiling(ctx, 0); err != nil || score != 50 {
+		t.Errorf("replica ceiling: got (%d, %v), want 50", score, err)
+	}
+	if nodeIDs, err := ring.ReplicaNode(ctx, 50); err != nil || !reflect.DeepEqual(nodeIDs, []string{"node_replica"}) {
+		t.Errorf("replica node: got (%v, %v), want [node_replica]", nodeIDs, err)
+	}
+
+	// 节点变更、数据迁移等流程使用的读操作都由主节点执行
+	if score, err := ring.Ceiling(ctx, 0); err != nil || score != 10 {
+		t.Errorf("ceiling: got (%d, %v), want 10", score, err)
+	}
+	if nodeIDs, err := ring.Node(ctx, 10); err != nil || !reflect.DeepEqual(nodeIDs, []string{"node_primary"}) {
+		t.Errorf("node: got (%v, %v), want [node_primary]", nodeIDs, err)
+	}
+	if score, err := ring.Floor(ctx, 20); err != nil || score != 10 {
+		t.Errorf("floor: got (%d, %v), want 10", score, err)
+	}
+	if nodes, err := ring.Nodes(ctx); err != nil || nodes["node_primary"] != 1 {
+		t.Errorf("nodes: got (%v, %v), want node_primary", nodes, err)
+	}
+	if _, err := ring.VirtualNodes(ctx); err != nil {
+		t.Error(err)
+	}
+	if _, err := ring.NodeExists(ctx, "node_primary"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ring.DataKeys(ctx, "node_primary"); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := ring.ScanDataKeys(ctx, "node_primary", 0, 10); err != nil {
+		t.Error(err)
+	}
+	if _, err := ring.NodeMeta(ctx, "node_primary"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ring.Generation(ctx); err != nil {
+		t.Error(err)
+	}
+	if _, err := ring.VirtualNodeCount(ctx); err != nil {
+		t.Error(err)
+	}
+	if _, err := ring.DataKeyCount(ctx, "node_primary"); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_Client_Close(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient(network, miniredis.RunT(t).Addr(), password, WithReadReplica(miniredis.RunT(t).Addr()))
+	if err := client.Set(ctx, "key", "val"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := client.pool.Stats(); stats.IdleCount != 0 {
+		t.Errorf("got %d idle connections after close, want 0", stats.IdleCount)
+	}
+	// 主节点与从节点的连接池都需要关闭
+	for _, pool := range []*redis.Pool{client.pool, client.readPool} {
+		if _, err := pool.Get().Do("PING"); err == nil {
+			t.Error("get on closed pool should fail")
+		}
+	}
+
+	if err := client.Set(ctx, "key", "val"); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("set after close: got %v, want ErrClientClosed", err)
+	}
+	if _, err := client.Get(ctx, "key"); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("get after close: got %v, want ErrClientClosed", err)
+	}
+	if _, err := client.Pipeline(ctx); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("pipeline after close: got %v, want ErrClientClosed", err)
+	}
+	if _, err := NewRedisHashRing("test_close", client).Nodes(ctx); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("ring nodes after close: got %v, want ErrClientClosed", err)
+	}
+	// 重复关闭不报错
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_Client_Ping(t *testing.T) {
+	ctx := context.Background()
+	client, server := newMiniRedisClient(t)
+	if err := client.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewRedisHashRing("test_ping", client).Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 从节点不可达时同样返回错误
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := listener.Addr().String()
+	_ = listener.Close()
+	if err = NewClient(network, server.Addr(), password, WithReadReplica(unreachable)).Ping(ctx); err == nil {
+		t.Error("ping with unreachable read replica should fail")
+	}
+
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Ping(ctx); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("ping after close: got %v, want ErrClientClosed", err)
+	}
+}
+
+func Test_NewClientWithPool(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	var dials int32
+	pool := &redis.Pool{
+		MaxIdle: 2,
+		Dial: func() (redis.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return redis.Dial("tcp", server.Addr())
+		},
+	}
+	defer pool.Close()
+
+	client := NewClientWithPool(pool)
+	if err := client.Set(ctx, "key", "val"); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := client.Get(ctx, "key"); err != nil || val != "val" {
+		t.Fatalf("got (%q, %v), want val", val, err)
+	}
+
+	ring := NewRedisHashRing("test_pool", client)
+	if err := ring.Add(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.AddNodeToReplica(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if nodes, err := ring.Nodes(ctx); err != nil || nodes["node_a"] != 1 {
+		t.Fatalf("got (%v, %v), want node_a with 1 replica", nodes, err)
+	}
+	if score, err := ring.Ceiling(ctx, 5); err != nil || score != 10 {
+		t.Fatalf("got (%d, %v), want 10", score, err)
+	}
+	pipeline, err := client.Pipeline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pipeline.Close()
+
+	if val, _ := server.Get("key"); atomic.LoadInt32(&dials) == 0 || val != "val" {
+		t.Errorf("commands did not go through the injected pool, dials %d", atomic.LoadInt32(&dials))
+	}
+
+	// 关闭客户端不会关闭注入的连接池
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Set(ctx, "key", "val"); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("set after close: got %v, want ErrClientClosed", err)
+	}
+	_conn := pool.Get()
+	defer _conn.Close()
+	if _, err = _conn.Do("PING"); err != nil {
+		t.Errorf("injected pool closed by client: %v", err)
+	}
+}
+
+func Test_Client_ZAddNX_ZAddXX(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	scores := func() map[string]int64 {
+		entities, err := client.ZRangeByScore(ctx, "test_zadd", 0, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := make(map[string]int64, len(entities))
+		for _, entity := range entities {
+			res[entity.Val] = entity.Score
+		}
+		return res
+	}
+
+	if added, err := client.ZAddNX(ctx, "test_zadd", 10, "node_a"); err != nil || added != 1 {
+		t.Fatalf("nx add new member: got (%d, %v), want 1", added, err)
+	}
+	// NX 不会覆盖已有成员
+	if added, err := client.ZAddNX(ctx, "test_zadd", 20, "node_a"); err != nil || added != 0 {
+		t.Fatalf("nx add existing member: got (%d, %v), want 0", added, err)
+	}
+	if got := scores(); !reflect.DeepEqual(got, map[string]int64{"node_a": 10}) {
+		t.Errorf("after nx: got %v, want node_a at 10", got)
+	}
+
+	// XX 只更新已有成员
+	if changed, err := client.ZAddXX(ctx, "test_zadd", 30, "node_b"); err != nil || changed != 0 {
+		t.Fatalf("xx add missing member: got (%d, %v), want 0", changed, err)
+	}
+	if changed, err := client.ZAddXX(ctx, "test_zadd", 40, "node_a"); err != nil || changed != 1 {
+		t.Fatalf("xx update existing member: got (%d, %v), want 1", changed, err)
+	}
+	if got := scores(); !reflect.DeepEqual(got, map[string]int64{"node_a": 40}) {
+		t.Errorf("after xx: got %v, want node_a at 40", got)
+	}
+}
+
+func Test_NewClient_ReadTimeout(t *testing.T) {
+	// 只建立连接、从不响应的 redis
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	client := NewClient(network, listener.Addr().String(), "", WithReadTimeout(50*time.Millisecond))
+	defer client.Close()
+	if client.opts.dialTimeout != DefaultDialTimeout || client.opts.writeTimeout != DefaultWriteTimeout {
+		t.Errorf("got dial timeout %v, write timeout %v, want defaults", client.opts.dialTimeout, client.opts.writeTimeout)
+	}
+
+	start := time.Now()
+	_, err = client.Get(context.Background(), "key")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("got %v, want timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("command took %v, want it to fail after the read timeout", elapsed)
+	}
+}
+
+func Test_RedisHashRing_DeleteNodeToDataKeys_Missing(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_delete_missing", client)
+
+	// 节点下从未登记过数据
+	if err := ring.DeleteNodeToDataKeys(ctx, "node_a", map[string]struct{}{"data": {}}); err != nil {
+		t.Fatalf("delete data keys of node without data: %v", err)
+	}
+}
+
+func Test_RedisHashRing_AddNodeToDataKeys_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_data_keys_concurrent", client)
+
+	// 并发追加数据 key，基于 json 读改写的实现会相互覆盖而丢失数据
+	const workers, perWorker = 20, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for i := 0; i < workers; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				if err := ring.AddNodeToDataKeys(ctx, "node_a", map[string]struct{}{
+					fmt.Sprintf("data_%d_%d", i, j): {},
+				}); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	dataKeys, err := ring.DataKeys(ctx, "node_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dataKeys) != workers*perWorker {
+		t.Errorf("got %d data keys, want %d", len(dataKeys), workers*perWorker)
+	}
+
+	if err = ring.DeleteNodeToDataKeys(ctx, "node_a", map[string]struct{}{"data_0_0": {}, "data_1_1": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if dataKeys, err = ring.DataKeys(ctx, "node_a"); err != nil || len(dataKeys) != workers*perWorker-2 {
+		t.Errorf("got (%d, %v) data keys after delete, want %d", len(dataKeys), err, workers*perWorker-2)
+	}
+}
+
+func Test_RedisHashRing_MigrateLegacyDataKeys(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_migrate_legacy", client)
+
+	if err := ring.AddNodeToReplica(ctx, "node_a", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Set(ctx, ring.getLegacyNodeDataKey("node_a"), `{"data_1":{},"data_2":{}}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.AddNodeToDataKeys(ctx, "node_a", map[string]struct{}{"data_3": {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ring.MigrateLegacyDataKeys(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dataKeys, err := ring.DataKeys(ctx, "node_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dataKeys) != 3 {
+		t.Errorf("got data keys %v, want data_1, data_2 and data_3", dataKeys)
+	}
+	if _, err = client.Get(ctx, ring.getLegacyNodeDataKey("node_a")); !errors.Is(err, redis.ErrNil) {
+		t.Errorf("legacy data keys should be deleted, got err %v", err)
+	}
+
+	// 重复执行迁移不会产生影响
+	if err = ring.MigrateLegacyDataKeys(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_RedisHashRing_BatchAddNodeToDataKeys(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_batch_data_keys", client)
+
+	if err := ring.AddNodeToDataKeys(ctx, "node_a", map[string]struct{}{"data_0": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.BatchAddNodeToDataKeys(ctx, map[string]map[string]struct{}{
+		"node_a": {"data_1": {}, "data_2": {}},
+		"node_b": {"data_3": {}},
+		"node_c": {},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for nodeID, want := range map[string]int{"node_a": 3, "node_b": 1, "node_c": 0} {
+		dataKeys, err := ring.DataKeys(ctx, nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dataKeys) != want {
+			t.Errorf("node %s: got data keys %v, want %d keys", nodeID, dataKeys, want)
+		}
+	}
+}
+
+// 对比逐个登记与批量登记 1000 个数据 key 到 3 个真实节点时发送的 redis 命令数量
+func benchmarkRegisterDataKeys(b *testing.B, batch bool) {
+	ctx := context.Background()
+	client, server := newMiniRedisClient(b)
+	ring := NewRedisHashRing("benchmark_data_keys", client)
+
+	nodeDataKeys := make(map[string]map[string]struct{}, 3)
+	for i := 0; i < 1000; i++ {
+		nodeID := fmt.Sprintf("node_%d", i%3)
+		if nodeDataKeys[nodeID] == nil {
+			nodeDataKeys[nodeID] = make(map[string]struct{})
+		}
+		nodeDataKeys[nodeID][fmt.Sprintf("data_%d", i)] = struct{}{}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if batch {
+			if err := ring.BatchAddNodeToDataKeys(ctx, nodeDataKeys); err != nil {
+				b.Fatal(err)
+			}
+			continue
+		}
+		for nodeID, dataKeys := range nodeDataKeys {
+			for dataKey := range dataKeys {
+				if err := ring.AddNodeToDataKeys(ctx, nodeID, map[string]struct{}{dataKey: {}}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(server.CommandCount())/float64(b.N), "cmds/op")
+}
+
+func Benchmark_RedisHashRing_AddNodeToDataKeys(b *testing.B) {
+	benchmarkRegisterDataKeys(b, false)
+}
+
+func Benchmark_RedisHashRing_BatchAddNodeToDataKeys(b *testing.B) {
+	benchmarkRegisterDataKeys(b, true)
+}
+
+func Test_RedisHashRing_NodeExists(t *testing.T) {
+	ctx := context.Background()
+	client, server := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_node_exists", client)
+
+	if err := ring.AddNodeToReplica(ctx, "node_a", 5); err != nil {
+		t.Fatal(err)
+	}
+	commands := recordMiniRedisCommands(server)
+	for nodeID, want := range map[string]bool{"node_a": true, "node_b": false} {
+		exists, err := ring.NodeExists(ctx, nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != want {
+			t.Errorf("node %s: got exists %t, want %t", nodeID, exists, want)
+		}
+	}
+
+	for _, command := range commands() {
+		if strings.HasPrefix(command, "HGETALL") {
+			t.Errorf("node exists should not fetch the whole node map, got command %q", command)
+		}
+	}
+}
+
+func Test_RedisHashRing_DataKeyRecorded(t *testing.T) {
+	ctx := context.Background()
+	client, server := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_data_key_recorded", client)
+
+	if err := ring.AddNodeToDataKeys(ctx, "node_a", map[string]struct{}{"data_1": {}, "data_2": {}}); err != nil {
+		t.Fatal(err)
+	}
+	commands := recordMiniRedisCommands(server)
+	for _, c := range []struct {
+		nodeID, dataKey string
+		want            bool
+	}{
+		{"node_a", "data_1", true},
+		{"node_a", "data_3", false},
+		{"node_b", "data_1", false},
+	} {
+		recorded, err := ring.DataKeyRecorded(ctx, c.nodeID, c.dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recorded != c.want {
+			t.Errorf("data key %s on %s: got recorded %t, want %t", c.dataKey, c.nodeID, recorded, c.want)
+		}
+	}
+
+	for _, command := range commands() {
+		if !strings.HasPrefix(command, "SISMEMBER") {
+			t.Errorf("data key recorded should only check membership, got command %q", command)
+		}
+	}
+}
+
+func Test_Client_HExists(t *testing.T) {
+	ctx := context.Background()
+	client, server := newMiniRedisClient(t)
+	commands := recordMiniRedisCommands(server)
+
+	if err := client.HSet(ctx, "test_hexists", "field_a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		table, key string
+		want       bool
+	}{
+		{"test_hexists", "field_a", true},
+		{"test_hexists", "field_b", false},
+		{"test_hexists_missing", "field_a", false},
+	} {
+		exists, err := client.HExists(ctx, c.table, c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != c.want {
+			t.Errorf("hexists %s %s: got %t, want %t", c.table, c.key, exists, c.want)
+		}
+	}
+	var sent bool
+	for _, command := range commands() {
+		sent = sent || command == "HEXISTS test_hexists field_a"
+	}
+	if !sent {
+		t.Error("expect HEXISTS command to be sent")
+	}
+}
+
+func Test_RedisHashRing_Generation(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_generation", client)
+
+	generation, err := ring.Generation(ctx)
+	if err != nil || generation != 0 {
+		t.Fatalf("got (%d, %v), want 0", generation, err)
+	}
+	for want := int64(1); want <= 3; want++ {
+		if generation, err = ring.IncrGeneration(ctx); err != nil || generation != want {
+			t.Fatalf("got (%d, %v), want %d", generation, err, want)
+		}
+	}
+	if generation, err = ring.Generation(ctx); err != nil || generation != 3 {
+		t.Fatalf("got (%d, %v), want 3", generation, err)
+	}
+}
+
+func Test_RedisHashRing_MoveDataKeys(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_move_data_keys", client)
+
+	if err := ring.AddNodeToDataKeys(ctx, "node_a", map[string]struct{}{"data_1": {}, "data_2": {}, "data_3": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.MoveDataKeys(ctx, "node_a", "node_b", map[string]struct{}{"data_1": {}, "data_2": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.MoveDataKeys(ctx, "node_a", "node_b", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for nodeID, want := range map[string]int{"node_a": 1, "node_b": 2} {
+		dataKeys, err := ring.DataKeys(ctx, nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dataKeys) != want {
+			t.Errorf("node %s: got data keys %v, want %d keys", nodeID, dataKeys, want)
+		}
+	}
+}
+
+func Test_RedisHashRing_Add(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	ring := NewRedisHashRing("test_add", client)
+	_ = client.Del(ctx, ring.getTableKey())
+	_ = client.Del(ctx, ring.getScoreNodeKey())
+	defer func() {
+		_ = client.Del(ctx, ring.getTableKey())
+		_ = client.Del(ctx, ring.getScoreNodeKey())
+	}()
+
+	for _, nodeID := range []string{"node_a", "node_b", "node_a"} {
+		if err := ring.Add(ctx, 10, nodeID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodeIDs, err := ring.Node(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(nodeIDs) != "[node_a node_b]" {
+		t.Errorf("got node ids %v at score 10, want [node_a node_b]", nodeIDs)
+	}
+	entities, err := client.ZRangeByScore(ctx, ring.getTableKey(), 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 {
+		t.Errorf("got %d entities at score 10, want 1", len(entities))
+	}
+}
+
+func Test_RedisHashRing_ScoreNodeTable(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_score_node_table", client)
+
+	if err := ring.BatchAdd(ctx, map[int64][]string{100: {"node_a"}, 200: {"node_b"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, nodeID := range []string{"node_c", "node_a"} {
+		if err := ring.Add(ctx, 100, nodeID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		score       int64
+		ceil, floor int64
+	}{
+		{score: 50, ceil: 100, floor: 200},
+		{score: 100, ceil: 100, floor: 100},
+		{score: 150, ceil: 200, floor: 100},
+		{score: 250, ceil: 100, floor: 200},
+	} {
+		if ceil, err := ring.Ceiling(ctx, c.score); err != nil || ceil != c.ceil {
+			t.Errorf("ceiling %d: got (%d, %v), want %d", c.score, ceil, err, c.ceil)
+		}
+		if floor, err := ring.Floor(ctx, c.score); err != nil || floor != c.floor {
+			t.Errorf("floor %d: got (%d, %v), want %d", c.score, floor, err, c.floor)
+		}
+	}
+
+	nodeIDs, err := ring.Node(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(nodeIDs) != "[node_a node_c]" {
+		t.Errorf("got node ids %v at score 100, want [node_a node_c]", nodeIDs)
+	}
+
+	// 列表清空后虚拟节点需要同时从位置 zset 与真实节点列表 hash 中删除
+	if err = ring.Rem(ctx, 200, "node_b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ring.Node(ctx, 200); !errors.Is(err, ErrScoreNotExist) {
+		t.Errorf("node of removed score: got %v, want ErrScoreNotExist", err)
+	}
+	if ceil, err := ring.Ceiling(ctx, 150); err != nil || ceil != 100 {
+		t.Errorf("ceiling after rem: got (%d, %v), want 100", ceil, err)
+	}
+
+	virtualNodes, err := ring.VirtualNodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(virtualNodes) != "map[100:[node_a node_c]]" {
+		t.Errorf("got virtual nodes %v", virtualNodes)
+	}
+}
+
+func Test_RedisHashRing_MigrateLegacyTable(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_migrate_legacy_table", client)
+
+	if err := client.ZAdd(ctx, ring.getLegacyTableKey(), 100, `["node_a","node_b"]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ZAdd(ctx, ring.getLegacyTableKey(), 200, `["node_c"]`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ring.MigrateLegacyTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	virtualNodes, err := ring.VirtualNodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(virtualNodes) != "map[100:[node_a node_b] 200:[node_c]]" {
+		t.Errorf("got virtual nodes %v", virtualNodes)
+	}
+	if ceil, err := ring.Ceiling(ctx, 150); err != nil || ceil != 200 {
+		t.Errorf("ceiling after migration: got (%d, %v), want 200", ceil, err)
+	}
+	entities, err := client.ZRange(ctx, ring.getLegacyTableKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 0 {
+		t.Errorf("legacy table should be deleted, got %v", entities)
+	}
+}
+
+func Test_RedisHashRing_FloorCeiling_FallbackError(t *testing.T) {
+	ctx := context.Background()
+	// 以具体 score 检索时返回空结果，回退到 FirstOrLast 检索首尾节点时断开连接
+	server := newMockRedisServer(t, func(args []string) string {
+		// ceiling 通过 lua 脚本回退，同样断开连接
+		if strings.ToUpper(args[0]) == "EVAL" {
+			return ""
+		}
+		if strings.ToUpper(args[0]) != "ZRANGE" {
+			return "-ERR unknown command\r\n"
+		}
+		if args[2] == "-inf" || args[2] == "+inf" {
+			return ""
+		}
+		return "*0\r\n"
+	})
+	ring := NewRedisHashRing("test_fallback_error", NewClient(network, server.addr(), password))
+
+	if score, err := ring.Floor(ctx, 10); err == nil {
+		t.Errorf("floor should return the connection error, got score %d", score)
+	}
+	if score, err := ring.Ceiling(ctx, 10); err == nil {
+		t.Errorf("ceiling should return the connection error, got score %d", score)
+	}
+
+	// zset 确实为空时仍然返回 -1
+	emptyClient, _ := newMiniRedisClient(t)
+	empty := NewRedisHashRing("test_fallback_empty", emptyClient)
+	if score, err := empty.Floor(ctx, 10); err != nil || score != -1 {
+		t.Errorf("floor on empty ring: got (%d, %v), want (-1, nil)", score, err)
+	}
+}
+
+func Test_RedisHashRing_PublishSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_publish_subscribe", client)
+
+	payloads, err := ring.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.Publish(ctx, "payload"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-payloads:
+		if payload != "payload" {
+			t.Errorf("got payload %q, want %q", payload, "payload")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("payload not delivered")
+	}
+
+	cancel()
+	for range payloads {
+	}
+}
+
+func Test_Client_SubscribeWithPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := miniredis.RunT(t)
+	pool := &redis.Pool{
+		MaxIdle: 2,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", server.Addr())
+		},
+	}
+	defer pool.Close()
+	// 注入的连接池没有配置地址，订阅同样需要从连接池获取连接
+	client := NewClientWithPool(pool)
+
+	messages, err := client.Subscribe(ctx, "channel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received, err := client.Publish(ctx, "channel", "message"); err != nil || received != 1 {
+		t.Fatalf("publish: got (%d, %v), want 1 subscriber", received, err)
+	}
+	select {
+	case message := <-messages:
+		if message != "message" {
+			t.Errorf("got message %q, want %q", message, "message")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	// ctx 结束后退订并关闭 channel，连接归还给连接池后不再收到消息
+	cancel()
+	for range messages {
+	}
+	if stats := pool.Stats(); stats.ActiveCount != stats.IdleCount {
+		t.Errorf("got %d active and %d idle connections, want the subscribe connection returned", stats.ActiveCount, stats.IdleCount)
+	}
+	if received, err := client.Publish(context.Background(), "channel", "message"); err != nil || received != 0 {
+		t.Errorf("publish after unsubscribe: got (%d, %v), want 0 subscribers", received, err)
+	}
+}
+
+func Test_RedisHashRing_PublishMutation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := miniredis.RunT(t)
+	var sent int32
+	pool := &redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", server.Addr())
+			return countingConn{Conn: conn, commands: &sent}, err
+		},
+	}
+	defer pool.Close()
+	client := NewClientWithPool(pool)
+	ring := NewRedisHashRing("test_publish_mutation", client)
+
+	payloads, err := client.Subscribe(ctx, ring.GetMutationChannel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ring.IncrGeneration(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.AddNodeToReplica(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 变更消息在写入数据的脚本中发布，Add 只需要一次网络往返
+	atomic.StoreInt32(&sent, 0)
+	if err = ring.Add(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&sent); got != 1 {
+		t.Errorf("got %d commands for add, want 1", got)
+	}
+
+	// 没有实际发生变化的操作不发布消息
+	if err = ring.Add(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.AddNodeToReplica(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.Rem(ctx, 10, "node_b"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.DeleteNodeToReplica(ctx, "node_b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ring.BatchAdd(ctx, map[int64][]string{20: {"node_b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ring.IncrGeneration(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.Rem(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.DeleteNodeToReplica(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []RingMutation{
+		{Op: MutationAddNodeToReplica, NodeID: "node_a", Generation: 2},
+		{Op: MutationAdd, Score: 10, NodeID: "node_a", Generation: 2},
+		{Op: MutationAdd, Score: 20, NodeID: "node_b", Generation: 2},
+		{Op: MutationRem, Score: 10, NodeID: "node_a", Generation: 3},
+		{Op: MutationDeleteNodeToReplica, NodeID: "node_a", Generation: 3},
+	} {
+		select {
+		case payload := <-payloads:
+			var mutation RingMutation
+			if err = json.Unmarshal([]byte(payload), &mutation); err != nil {
+				t.Fatal(err)
+			}
+			if mutation != want {
+				t.Errorf("got mutation %+v, want %+v", mutation, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("mutation %+v not delivered", want)
+		}
+	}
+}
+
+func Test_RedisHashRing_PublishMutation_Denied(t *testing.T) {
+	ctx := context.Background()
+	client, server := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_publish_denied", client)
+
+	// 模拟共享 redis 中 ACL 禁止向变更 channel 发布消息
+	server.Server().SetPreHook(func(peer *miniredisserver.Peer, cmd string, args ...string) bool {
+		if cmd != "PUBLISH" {
+			return false
+		}
+		peer.WriteError("NOPERM this user has no permissions to access one of the channels used as arguments")
+		return true
+	})
+
+	if err := ring.AddNodeToReplica(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Add(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if node, err := ring.Node(ctx, 10); err != nil || len(node) != 1 || node[0] != "node_a" {
+		t.Errorf("got node (%v, %v), want node_a", node, err)
+	}
+	if err := ring.Rem(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.DeleteNodeToReplica(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_RedisHashRing_ScanDataKeys(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_scan_data_keys", client)
+
+	dataKeys := make(map[string]struct{}, 250)
+	for i := 0; i < 250; i++ {
+		dataKeys[fmt.Sprintf("data_%d", i)] = struct{}{}
+	}
+	if err := ring.AddNodeToDataKeys(ctx, "node_a", dataKeys); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]struct{})
+	var (
+		cursor uint64
+		calls  int
+	)
+	for {
+		keys, next, err := ring.ScanDataKeys(ctx, "node_a", cursor, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			got[key] = struct{}{}
+		}
+		calls++
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(got) != len(dataKeys) || calls < 3 {
+		t.Errorf("got %d keys in %d calls, want %d keys in at least 3 calls", len(got), calls, len(dataKeys))
+	}
+}
+
+func Test_RedisHashRing_Compact(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_compact", client)
+
+	if err := ring.Add(ctx, 100, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟 Rem 清理中断后残留的虚拟节点：一个没有真实节点列表，一个列表为空，另一个空列表不在位置 zset 中
+	if err := client.ZAdd(ctx, ring.getTableKey(), 300, "300"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ZAdd(ctx, ring.getTableKey(), 400, "400"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.HSet(ctx, ring.getScoreNodeKey(), "400", "[]"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.HSet(ctx, ring.getScoreNodeKey(), "500", "[]"); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := ring.Compact(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(removed) != "[300 400 500]" {
+		t.Errorf("got removed scores %v, want [300 400 500]", removed)
+	}
+
+	// 残留的虚拟节点被删除后，检索会回绕到真实存在的虚拟节点
+	if score, err := ring.Ceiling(ctx, 200); err != nil || score != 100 {
+		t.Errorf("ceiling 200: got (%d, %v), want 100", score, err)
+	}
+	if nodeIDs, err := ring.Node(ctx, 100); err != nil || fmt.Sprint(nodeIDs) != "[node_a]" {
+		t.Errorf("got node ids (%v, %v) at score 100, want [node_a]", nodeIDs, err)
+	}
+	if exists, err := client.HExists(ctx, ring.getScoreNodeKey(), "500"); err != nil || exists {
+		t.Errorf("got empty list exists (%t, %v), want removed", exists, err)
+	}
+
+	if removed, err = ring.Compact(ctx); err != nil || len(removed) != 0 {
+		t.Errorf("compact again: got (%v, %v), want nothing removed", removed, err)
+	}
+}
+
+func Test_RedisHashRing_NodeMeta(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_node_meta", client)
+
+	if meta, err := ring.NodeMeta(ctx, "node_a"); err != nil || len(meta) != 0 {
+		t.Fatalf("missing meta: got (%v, %v), want empty", meta, err)
+	}
+
+	want := map[string]string{"datacenter": "dc1", "capacity": "100"}
+	if err := ring.SetNodeMeta(ctx, "node_a", want); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err := ring.NodeMeta(ctx, "node_a"); err != nil || fmt.Sprint(meta) != fmt.Sprint(want) {
+		t.Errorf("got meta (%v, %v), want %v", meta, err, want)
+	}
+
+	if err := ring.DeleteNodeMeta(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err := ring.NodeMeta(ctx, "node_a"); err != nil || len(meta) != 0 {
+		t.Errorf("deleted meta: got (%v, %v), want empty", meta, err)
+	}
+}
+
+func Test_RedisHashRing_Counts(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t, WithMaxIdle(2))
+	ring := NewRedisHashRing("test_counts", client)
+	for score, nodeID := range map[int64]string{10: "node_a", 20: "node_b", 30: "node_a"} {
+		if err := ring.Add(ctx, score, nodeID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 位置冲突的虚拟节点只计一次
+	if err := ring.Add(ctx, 10, "node_c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.AddNodeToDataKeys(ctx, "node_a", map[string]struct{}{"data_1": {}, "data_2": {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := ring.VirtualNodeCount(ctx); err != nil || count != 3 {
+		t.Errorf("virtual node count: got (%d, %v), want 3", count, err)
+	}
+	if count, err := ring.DataKeyCount(ctx, "node_a"); err != nil || count != 2 {
+		t.Errorf("node_a data key count: got (%d, %v), want 2", count, err)
+	}
+	if count, err := ring.DataKeyCount(ctx, "node_b"); err != nil || count != 0 {
+		t.Errorf("node_b data key count: got (%d, %v), want 0", count, err)
+	}
+	if active, idle := ring.PoolStats(); active == 0 || idle == 0 {
+		t.Errorf("got %d active and %d idle connections, want the pooled connection reported", active, idle)
+	}
+}
+
+func Test_NewRedisHashRing_WithKeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	client, server := newMiniRedisClient(t)
+	ringA := NewRedisHashRing("shared", client, WithKeyPrefix("tenant_a"))
+	ringB := NewRedisHashRing("shared", client, WithKeyPrefix("tenant_b"))
+
+	for _, key := range []string{ringA.getLockKey(), ringA.getTableKey(), ringA.getScoreNodeKey(), ringA.getNodeReplicaKey(), ringA.getNodeDataKey("node_a"), ringA.getEventChannel()} {
+		if !strings.HasPrefix(key, "tenant_a:") {
+			t.Errorf("key %s does not use the custom prefix", key)
+		}
+	}
+	if key := NewRedisHashRing("shared", client).getTableKey(); key != "redis:consistent_hash:ring:score:shared" {
+		t.Errorf("default prefix: got table key %s", key)
+	}
+
+	if err := ringA.AddNodeToReplica(ctx, "node_a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := ringA.Add(ctx, 10, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ringA.AddNodeToDataKeys(ctx, "node_a", map[string]struct{}{"data": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if server.HGet("tenant_a:node:replica:shared", "node_a") != "2" {
+		t.Error("node replica not written under the custom prefix")
+	}
+
+	// 相同 key、不同前缀的哈希环互不影响
+	if nodes, err := ringB.Nodes(ctx); err != nil || len(nodes) != 0 {
+		t.Errorf("ring b nodes: got (%v, %v), want empty", nodes, err)
+	}
+	if score, err := ringB.Ceiling(ctx, 0); err != nil || score != -1 {
+		t.Errorf("ring b ceiling: got (%d, %v), want -1", score, err)
+	}
+	if dataKeys, err := ringB.DataKeys(ctx, "node_a"); err != nil || len(dataKeys) != 0 {
+		t.Errorf("ring b data keys: got (%v, %v), want empty", dataKeys, err)
+	}
+	if nodes, err := ringA.Nodes(ctx); err != nil || nodes["node_a"] != 2 {
+		t.Errorf("ring a nodes: got (%v, %v), want node_a", nodes, err)
+	}
+}
+
+func Test_NewValidatedClient_EmptyAddress(t *testing.T) {
+	if _, err := NewValidatedClient(network, "", password); !errors.Is(err, ErrEmptyAddress) {
+		t.Errorf("got %v, want ErrEmptyAddress", err)
+	}
+
+	client, err := NewValidatedClient(network, miniredis.RunT(t).Addr(), password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Ping(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	// 未校验的客户端在执行命令时返回错误，而不是 panic
+	if err = NewClient(network, "", password).Set(context.Background(), "key", "val"); !errors.Is(err, ErrEmptyAddress) {
+		t.Errorf("command with empty address: got %v, want ErrEmptyAddress", err)
+	}
+}
+
+func Test_RedisHashRing_AddIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newMiniRedisClient(t)
+	ring := NewRedisHashRing("test_add_if_absent", client)
+	for _, step := range []struct {
+		nodeID string
+		want   bool
+	}{
+		{nodeID: "node_a", want: true},
+		{nodeID: "node_a", want: false},
+		{nodeID: "node_b", want: true},
+	} {
+		added, err := ring.AddIfAbsent(ctx, 10, step.nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != step.want {
+			t.Errorf("add %s: got added %v, want %v", step.nodeID, added, step.want)
+		}
+	}
+
+	nodeIDs, err := ring.Node(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(nodeIDs) != "[node_a node_b]" {
+		t.Errorf("got node ids %v at score 10, want [node_a node_b]", nodeIDs)
+	}
+}
@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 连接 sentinel 的超时时间，避免某个 sentinel 不可用时阻塞主节点地址的查询
+const DefaultSentinelDialTimeout = time.Second
+
+// 通过 sentinel 发现 redis 主节点的地址
+type sentinel struct {
+	masterName string
+
+	mu    sync.Mutex
+	addrs []string
+}
+
+// 依次向各个 sentinel 查询主节点地址，查询成功的 sentinel 会被调整到列表首位，后续优先使用
+func (s *sentinel) masterAddr(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lastErr error
+	for i, addr := range s.addrs {
+		masterAddr, err := s.queryMasterAddr(ctx, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		s.addrs[0], s.addrs[i] = s.addrs[i], s.addrs[0]
+		return masterAddr, nil
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no sentinel address")
+	}
+	return "", fmt.Errorf("get master addr of %s from sentinels failed, err: %w", s.masterName, lastErr)
+}
+
+func (s *sentinel) queryMasterAddr(ctx context.Context, addr string) (string, error) {
+	conn, err := redis.DialContext(ctx, "tcp", addr,
+		redis.DialConnectTimeout(DefaultSentinelDialTimeout),
+		redis.DialReadTimeout(DefaultSentinelDialTimeout),
+		redis.DialWriteTimeout(DefaultSentinelDialTimeout))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	res, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", s.masterName))
+	if err != nil {
+		return "", err
+	}
+	if len(res) != 2 {
+		return "", fmt.Errorf("invalid master addr reply: %v", res)
+	}
+	return net.JoinHostPort(res[0], res[1]), nil
+}
+
+// 基于 redis sentinel 的客户端，sentinelAddrs 为 sentinel 节点地址列表，password 为主节点的密码
+// 连接池每次建立新连接时都会向 sentinel 查询当前的主节点地址，从连接池中取出空闲连接时会校验其是否仍为主节点
+// 因此主从切换后，指向旧主节点的连接会被丢弃，并重新连接到新的主节点
+func NewSentinelClient(masterName string, sentinelAddrs []string, password string, opts ...ClientOption) *Client {
+	c := Client{
+		opts: &ClientOptions{
+			network:  "tcp",
+			password: password,
+		},
+		sentinel: &sentinel{
+			masterName: masterName,
+			addrs:      append([]string(nil), sentinelAddrs...),
+		},
+	}
+
+	for _, opt := range opts {
+		opt(c.opts)
+	}
+	repairClient(c.opts)
+
+	c.pool = c.getRedisPool()
+	c.readPool = c.getReadPool()
+	return &c
+}
+
+// 校验连接是否指向主节点
+func testMasterRole(conn redis.Conn) error {
+	values, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return errors.New("empty role reply")
+	}
+
+	role, err := redis.String(values[0], nil)
+	if err != nil {
+		return err
+	}
+	if role != "master" {
+		return fmt.Errorf("connection role is %s, not master", role)
+	}
+	return nil
+}
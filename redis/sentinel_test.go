@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 模拟 redis 主从节点，role 为节点当前的角色
+func newMockRedisNode(t *testing.T, role *string, mu *sync.Mutex) *mockRedisServer {
+	return newMockRedisServer(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "ROLE":
+			mu.Lock()
+			defer mu.Unlock()
+			return fmt.Sprintf("*3\r\n$%d\r\n%s\r\n:0\r\n*0\r\n", len(*role), *role)
+		case "PING":
+			return "+PONG\r\n"
+		default:
+			return "+OK\r\n"
+		}
+	})
+}
+
+func Test_NewSentinelClient(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	roleA, roleB := "master", "slave"
+	nodeA := newMockRedisNode(t, &roleA, &mu)
+	nodeB := newMockRedisNode(t, &roleB, &mu)
+
+	master := nodeA
+	sentinelServer := newMockRedisServer(t, func(args []string) string {
+		if len(args) != 3 || strings.ToUpper(args[0]) != "SENTINEL" || args[2] != "mymaster" {
+			return "*-1\r\n"
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		host, port, _ := net.SplitHostPort(master.addr())
+		return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+	})
+
+	// 首个 sentinel 不可用时需要继续询问下一个 sentinel
+	deadListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := deadListener.Addr().String()
+	deadListener.Close()
+
+	client := NewSentinelClient("mymaster", []string{deadAddr, sentinelServer.addr()}, password)
+	if err = client.Set(ctx, "key", "val_1"); err != nil {
+		t.Fatal(err)
+	}
+	if !nodeA.received("SET key val_1") {
+		t.Error("command should be routed to the master discovered by sentinel")
+	}
+
+	// 模拟主从切换，后续命令需要路由到新的主节点
+	mu.Lock()
+	master, roleA, roleB = nodeB, "slave", "master"
+	mu.Unlock()
+	if err = client.Set(ctx, "key", "val_2"); err != nil {
+		t.Fatal(err)
+	}
+	if !nodeB.received("SET key val_2") || nodeA.received("SET key val_2") {
+		t.Error("command should be routed to the new master after failover")
+	}
+
+	unknown := NewSentinelClient("unknown", []string{sentinelServer.addr()}, password)
+	if err = unknown.Set(ctx, "key", "val"); err == nil {
+		t.Error("set with unknown master name should fail")
+	}
+}
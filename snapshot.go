@@ -0,0 +1,277 @@
+package consistent_hash
+
+import (
+	"context"
+	"sort"
+)
+
+// 哈希环上的一个虚拟节点
+type VirtualNode struct {
+	// 虚拟节点在哈希环上的位置
+	Score int64
+	// 虚拟节点对应的真实节点列表，只有首个真实节点会承载数据
+	NodeIDs []string
+}
+
+// 哈希环的快照，用于调试以及可视化
+type RingSnapshot struct {
+	// 按照 score 从小到大排列的全部虚拟节点
+	VirtualNodes []VirtualNode
+	// 真实节点到虚拟节点个数的映射
+	Replicas map[string]int
+}
+
+// 哈希环以及底层存储的运行时统计信息
+type Stats struct {
+	// 真实节点个数
+	Nodes int
+	// 全部真实节点登记的虚拟节点个数之和
+	Replicas int
+	// 哈希环上虚拟节点位置的个数，位置冲突的虚拟节点只计一次，因此可能小于 Replicas
+	VirtualNodes int
+	// 全部真实节点登记的数据 key 个数之和
+	DataKeys int
+	// 连接池中活跃（含空闲）以及空闲的连接个数，哈希环未实现 PoolStatsReporter 时为 0
+	ActiveConns int
+	IdleConns   int
+}
+
+// 真实节点的概要信息
+type NodeInfo struct {
+	NodeID string
+	// 真实节点对应的虚拟节点个数
+	Replicas int
+}
+
+// 查询全部的真实节点，按照 NodeID 从小到大排列，便于展示以及需要稳定遍历顺序的场景
+func (c *ConsistentHash) ListNodes(ctx context.Context) ([]NodeInfo, error) {
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]NodeInfo, 0, len(nodes))
+	for nodeID, replicas := range nodes {
+		infos = append(infos, NodeInfo{NodeID: nodeID, Replicas: replicas})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].NodeID < infos[j].NodeID
+	})
+	return infos, nil
+}
+
+// 获取哈希环当前的快照，快照的组装过程中会持有哈希环的锁，保证读取到的是一致的视图
+func (c *ConsistentHash) Snapshot(ctx context.Context) (*RingSnapshot, error) {
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	defer c.unlock(ctx)
+
+	replicas, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	virtualNodes, err := c.virtualNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := RingSnapshot{
+		VirtualNodes: make([]VirtualNode, 0, len(virtualNodes)),
+		Replicas:     replicas,
+	}
+	for score, rawNodeKeys := range virtualNodes {
+		nodeIDs := make([]string, 0, len(rawNodeKeys))
+		for _, rawNodeKey := range rawNodeKeys {
+			nodeIDs = append(nodeIDs, c.getNodeID(rawNodeKey))
+		}
+		snapshot.VirtualNodes = append(snapshot.VirtualNodes, VirtualNode{
+			Score:   score,
+			NodeIDs: nodeIDs,
+		})
+	}
+
+	sort.Slice(snapshot.VirtualNodes, func(i, j int) bool {
+		return snapshot.VirtualNodes[i].Score < snapshot.VirtualNodes[j].Score
+	})
+	return &snapshot, nil
+}
+
+// 查询哈希环上全部的虚拟节点，返回虚拟节点数值到虚拟节点 key 列表的映射
+// 倘若哈希环实现了 RingReader 则一次性读取，否则从位置 0 开始通过 Ceiling 顺时针遍历整个哈希环
+func (c *ConsistentHash) virtualNodes(ctx context.Context) (map[int64][]string, error) {
+	if reader, ok := c.hashRing.(RingReader); ok {
+		return reader.VirtualNodes(ctx)
+	}
+
+	virtualNodes := make(map[int64][]string)
+	firstScore, err := c.ceilingInclusive(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	if firstScore == -1 {
+		return virtualNodes, nil
+	}
+
+	score := firstScore
+	for {
+		rawNodeKeys, err := c.hashRing.Node(ctx, score)
+		if err != nil {
+			return nil, err
+		}
+		virtualNodes[score] = rawNodeKeys
+
+		if score, err = c.ceilingExclusive(ctx, score); err != nil {
+			return nil, err
+		}
+		if score == -1 || score == firstScore {
+			return virtualNodes, nil
+		}
+	}
+}
+
+// 统计每个真实节点下登记的数据 key 数量，用于观察数据在真实节点之间的分布是否均衡
+// 返回结果包含全部真实节点，没有数据的节点对应的数量为 0
+func (c *ConsistentHash) LoadDistribution(ctx context.Context) (map[string]int, error) {
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	defer c.unlock(ctx)
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	distribution := make(map[string]int, len(nodes))
+	for nodeID := range nodes {
+		dataKeys, err := c.hashRing.DataKeys(ctx, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		distribution[nodeID] = len(dataKeys)
+	}
+	return distribution, nil
+}
+
+// 查询全部真实节点登记的数据 key，返回真实节点 id 到数据 key 列表的映射，数据 key 按照从小到大排列，没有数据的节点对应空列表
+// 执行期间持有哈希环的锁，数据 key 很多时请使用 WalkDataKeys 分批处理
+func (c *ConsistentHash) AllDataKeys(ctx context.Context) (map[string][]string, error) {
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	defer c.unlock(ctx)
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string][]string, len(nodes))
+	for nodeID := range nodes {
+		dataKeys, err := c.hashRing.DataKeys(ctx, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(dataKeys))
+		for dataKey := range dataKeys {
+			keys = append(keys, dataKey)
+		}
+		sort.Strings(keys)
+		res[nodeID] = keys
+	}
+	return res, nil
+}
+
+// WalkDataKeys 单批回调的数据 key 个数的参考值
+const walkDataKeysBatch = 1000
+
+// 按照 NodeID 从小到大的顺序分批遍历全部真实节点登记的数据 key，每批数据 key 回调一次 fn，fn 返回错误时终止遍历并返回该错误
+// 基于 ScanDataKeys 实现，不加锁，遍历期间数据 key 发生变更时，可能重复回调或者遗漏变更的 key
+func (c *ConsistentHash) WalkDataKeys(ctx context.Context, fn func(nodeID string, dataKeys []string) error) error {
+	nodes, err := c.ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		var cursor uint64
+		for {
+			dataKeys, next, err := c.ScanDataKeys(ctx, node.NodeID, cursor, walkDataKeysBatch)
+			if err != nil {
+				return err
+			}
+			if len(dataKeys) > 0 {
+				if err = fn(node.NodeID, dataKeys); err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+	}
+	return nil
+}
+
+// 查询哈希环的运行时统计信息，不加锁，因此与节点变更并发执行时各项统计之间可能不一致
+// 哈希环实现了 RingCounter 时直接读取计数，否则需要拉取全量的虚拟节点以及数据 key
+func (c *ConsistentHash) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.Nodes = len(nodes)
+	for _, replicas := range nodes {
+		stats.Replicas += replicas
+	}
+
+	virtualNodes, err := c.VirtualNodeCount(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.VirtualNodes = int(virtualNodes)
+
+	counter, ok := c.hashRing.(RingCounter)
+
+	for nodeID := range nodes {
+		var count int
+		if ok {
+			count, err = counter.DataKeyCount(ctx, nodeID)
+		} else {
+			var dataKeys map[string]struct{}
+			dataKeys, err = c.hashRing.DataKeys(ctx, nodeID)
+			count = len(dataKeys)
+		}
+		if err != nil {
+			return Stats{}, err
+		}
+		stats.DataKeys += count
+	}
+
+	if reporter, ok := c.hashRing.(PoolStatsReporter); ok {
+		stats.ActiveConns, stats.IdleConns = reporter.PoolStats()
+	}
+	return stats, nil
+}
+
+// 查询哈希环上虚拟节点位置的个数，位置冲突的虚拟节点只计一次，不加锁
+// 哈希环实现了 RingCounter 时直接读取计数（redis 实现为 ZCARD），否则需要拉取全量的虚拟节点
+func (c *ConsistentHash) VirtualNodeCount(ctx context.Context) (int64, error) {
+	if counter, ok := c.hashRing.(RingCounter); ok {
+		count, err := counter.VirtualNodeCount(ctx)
+		return int64(count), err
+	}
+
+	virtualNodes, err := c.virtualNodes(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(virtualNodes)), nil
+}
@@ -0,0 +1,305 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pule1234/consistent_hash/memory"
+	"github.com/pule1234/consistent_hash/redis"
+)
+
+func Test_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	weights := map[string]int{"node_a": 2, "node_b": 1}
+	// memory.HashRing 实现了 RingReader，countingHashRing 则需要通过 Ceiling 遍历哈希环
+	for _, hashRing := range []HashRing{memory.NewHashRing(), &countingHashRing{HashRing: memory.NewHashRing()}} {
+		consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithReplicas(2))
+		for nodeID, weight := range weights {
+			if err := consistentHash.AddNode(ctx, nodeID, weight); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 期望的快照内容
+		expected := make(map[int64][]string)
+		for nodeID, weight := range weights {
+			for i := 0; i < weight*2; i++ {
+				score := consistentHash.getVirtualScore(nodeID, i)
+				expected[score] = append(expected[score], nodeID)
+			}
+		}
+
+		snapshot, err := consistentHash.Snapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshot.VirtualNodes) != len(expected) {
+			t.Fatalf("got %d virtual nodes, want %d", len(snapshot.VirtualNodes), len(expected))
+		}
+		for i, virtualNode := range snapshot.VirtualNodes {
+			if i > 0 && snapshot.VirtualNodes[i-1].Score >= virtualNode.Score {
+				t.Errorf("virtual nodes not sorted: %d before %d", snapshot.VirtualNodes[i-1].Score, virtualNode.Score)
+			}
+			if fmt.Sprint(virtualNode.NodeIDs) != fmt.Sprint(expected[virtualNode.Score]) {
+				t.Errorf("score %d: got nodes %v, want %v", virtualNode.Score, virtualNode.NodeIDs, expected[virtualNode.Score])
+			}
+		}
+		if len(snapshot.Replicas) != 2 || snapshot.Replicas["node_a"] != 4 || snapshot.Replicas["node_b"] != 2 {
+			t.Errorf("got replicas %v, want map[node_a:4 node_b:2]", snapshot.Replicas)
+		}
+	}
+}
+
+func Test_Snapshot_EmptyRing(t *testing.T) {
+	consistentHash := NewConsistentHash(&countingHashRing{HashRing: memory.NewHashRing()}, NewMurmurHasher(), nil)
+	snapshot, err := consistentHash.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.VirtualNodes) != 0 || len(snapshot.Replicas) != 0 {
+		t.Errorf("got snapshot %+v of empty ring", snapshot)
+	}
+}
+
+func Test_LoadDistribution(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 重复路由同一个数据 key 不会被重复统计
+	expected := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		dataKey := fmt.Sprintf("data_%d", i%150)
+		node, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 150 {
+			expected[node]++
+		}
+	}
+
+	distribution, err := consistentHash.LoadDistribution(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total int
+	for _, count := range distribution {
+		total += count
+	}
+	if total != 150 {
+		t.Errorf("got %d keys in total, want 150", total)
+	}
+	for node, count := range expected {
+		if distribution[node] != count {
+			t.Errorf("node %s: got %d keys, want %d", node, distribution[node], count)
+		}
+	}
+	if len(distribution) != 3 {
+		t.Errorf("got distribution %v, want all 3 nodes", distribution)
+	}
+}
+
+func Test_ListNodes(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil, WithReplicas(2))
+	for i, nodeID := range []string{"node_c", "node_a", "node_d", "node_b"} {
+		if err := consistentHash.AddNode(ctx, nodeID, i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []NodeInfo{{"node_a", 4}, {"node_b", 8}, {"node_c", 2}, {"node_d", 6}}
+	for i := 0; i < 10; i++ {
+		infos, err := consistentHash.ListNodes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(infos, expected) {
+			t.Fatalf("round %d: got nodes %v, want %v", i, infos, expected)
+		}
+	}
+}
+
+// memory.HashRing 之外额外实现了 PoolStatsReporter 的哈希环
+type statsHashRing struct {
+	*memory.HashRing
+}
+
+func (s statsHashRing) PoolStats() (active, idle int) {
+	return 3, 1
+}
+
+func Test_Stats(t *testing.T) {
+	ctx := context.Background()
+	weights := map[string]int{"node_a": 2, "node_b": 1, "node_c": 3}
+	for _, hashRing := range []HashRing{&countingHashRing{HashRing: memory.NewHashRing()}, statsHashRing{memory.NewHashRing()}} {
+		consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithReplicas(2))
+		if stats, err := consistentHash.Stats(ctx); err != nil || stats.Nodes != 0 || stats.VirtualNodes != 0 || stats.DataKeys != 0 {
+			t.Fatalf("empty ring: got (%+v, %v), want no nodes", stats, err)
+		}
+
+		positions := make(map[int64]struct{})
+		for nodeID, weight := range weights {
+			if err := consistentHash.AddNode(ctx, nodeID, weight); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < weight*2; i++ {
+				positions[consistentHash.getVirtualScore(nodeID, i)] = struct{}{}
+			}
+		}
+		for i := 0; i < 20; i++ {
+			if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want := Stats{Nodes: 3, Replicas: 12, VirtualNodes: len(positions), DataKeys: 20}
+		if _, ok := hashRing.(PoolStatsReporter); ok {
+			want.ActiveConns, want.IdleConns = 3, 1
+		}
+		if stats, err := consistentHash.Stats(ctx); err != nil || stats != want {
+			t.Errorf("got (%+v, %v), want %+v", stats, err, want)
+		}
+	}
+}
+
+func Test_Stats_RedisHashRing(t *testing.T) {
+	ctx := context.Background()
+	redisRing := redis.NewRedisHashRing("test_stats", redis.NewClient(network, miniredis.RunT(t).Addr(), password))
+	// redis 实现通过 ZCARD 统计虚拟节点、SCARD 统计数据 key，不需要拉取全量的数据
+	if _, ok := HashRing(redisRing).(RingCounter); !ok {
+		t.Fatal("redis hash ring should implement RingCounter")
+	}
+
+	// 与 memory.HashRing 执行相同的操作，统计结果应当一致
+	var got [2]Stats
+	for i, hashRing := range []HashRing{redisRing, memory.NewHashRing()} {
+		consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithReplicas(2))
+		for nodeID, weight := range map[string]int{"node_a": 2, "node_b": 1, "node_c": 3} {
+			if err := consistentHash.AddNode(ctx, nodeID, weight); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for j := 0; j < 20; j++ {
+			if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := consistentHash.RemoveNode(ctx, "node_b"); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := consistentHash.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats.ActiveConns, stats.IdleConns = 0, 0
+		got[i] = stats
+	}
+
+	if want := (Stats{Nodes: 2, Replicas: 10, VirtualNodes: 10, DataKeys: 20}); got[0] != want {
+		t.Errorf("redis ring: got %+v, want %+v", got[0], want)
+	}
+	if got[0] != got[1] {
+		t.Errorf("redis ring stats %+v differ from memory ring stats %+v", got[0], got[1])
+	}
+}
+
+func Test_VirtualNodeCount(t *testing.T) {
+	ctx := context.Background()
+	// memory.HashRing 实现了 RingCounter，countingHashRing 则需要拉取全量的虚拟节点
+	for _, hashRing := range []HashRing{memory.NewHashRing(), &countingHashRing{HashRing: memory.NewHashRing()}} {
+		consistentHash := NewConsistentHash(hashRing, NewMurmur128Hasher(), nil, WithReplicas(3))
+		if count, err := consistentHash.VirtualNodeCount(ctx); err != nil || count != 0 {
+			t.Fatalf("empty ring: got (%d, %v), want 0", count, err)
+		}
+
+		var want int64
+		for nodeID, weight := range map[string]int{"node_a": 1, "node_b": 2, "node_c": 4} {
+			if err := consistentHash.AddNode(ctx, nodeID, weight); err != nil {
+				t.Fatal(err)
+			}
+			want += int64(weight * 3)
+		}
+		if count, err := consistentHash.VirtualNodeCount(ctx); err != nil || count != want {
+			t.Errorf("got (%d, %v), want %d", count, err, want)
+		}
+
+		if err := consistentHash.RemoveNode(ctx, "node_c"); err != nil {
+			t.Fatal(err)
+		}
+		if count, err := consistentHash.VirtualNodeCount(ctx); err != nil || count != want-12 {
+			t.Errorf("after removing node_c: got (%d, %v), want %d", count, err, want-12)
+		}
+	}
+}
+
+func Test_AllDataKeys(t *testing.T) {
+	ctx := context.Background()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	routed := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		dataKey := fmt.Sprintf("data_%d", i)
+		nodeID, err := consistentHash.GetNode(ctx, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routed[dataKey] = nodeID
+	}
+
+	all, err := consistentHash.AllDataKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("got %d nodes, want 3", len(all))
+	}
+	got := make(map[string]string)
+	for nodeID, dataKeys := range all {
+		for _, dataKey := range dataKeys {
+			got[dataKey] = nodeID
+		}
+	}
+	if !reflect.DeepEqual(got, routed) {
+		t.Errorf("got data keys %v, want %v", got, routed)
+	}
+
+	walked := make(map[string]string)
+	if err = consistentHash.WalkDataKeys(ctx, func(nodeID string, dataKeys []string) error {
+		for _, dataKey := range dataKeys {
+			walked[dataKey] = nodeID
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(walked, routed) {
+		t.Errorf("walked data keys %v, want %v", walked, routed)
+	}
+
+	// 回调返回错误时终止遍历
+	stop := errors.New("stop")
+	var calls int
+	if err = consistentHash.WalkDataKeys(ctx, func(string, []string) error {
+		calls++
+		return stop
+	}); !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("got (%v, %d calls), want stop after the first batch", err, calls)
+	}
+}
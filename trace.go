@@ -0,0 +1,91 @@
+package consistent_hash
+
+import (
+	"context"
+	"time"
+)
+
+// 链路追踪中使用的属性名称以及事件名称
+const (
+	AttrNodeID       = "consistent_hash.node_id"
+	AttrDataKey      = "consistent_hash.data_key"
+	AttrMigratedKeys = "consistent_hash.migrated_keys"
+	AttrLockWait     = "consistent_hash.lock_wait"
+
+	EventLockAcquired = "lock acquired"
+)
+
+// 链路追踪器的最小接口，通过 WithTracer 注入，opentelemetry 的适配见 trace/otel 子 module
+type Tracer interface {
+	// 开启一个 span，返回的 ctx 中需要携带该 span
+	Start(ctx context.Context, spanName string) (context.Context, Span)
+}
+
+// 属性值的类型为 string、int 或者 time.Duration
+type Span interface {
+	SetAttributes(attrs map[string]interface{})
+	AddEvent(name string, attrs map[string]interface{})
+	RecordError(err error)
+	End()
+}
+
+type opSpanKey struct{}
+
+// 一次 ConsistentHash 操作对应的 span，同时统计操作过程中需要迁移的数据 key 个数
+type opSpan struct {
+	span         Span
+	migratedKeys int
+}
+
+// 为一次操作开启 span，未注入链路追踪器时返回 nil
+func (c *ConsistentHash) startSpan(ctx context.Context, op string, attrs map[string]interface{}) (context.Context, *opSpan) {
+	if c.opts.tracer == nil {
+		return ctx, nil
+	}
+
+	ctx, span := c.opts.tracer.Start(ctx, "ConsistentHash."+op)
+	span.SetAttributes(attrs)
+	s := &opSpan{span: span}
+	return context.WithValue(ctx, opSpanKey{}, s), s
+}
+
+func spanFromContext(ctx context.Context) *opSpan {
+	s, _ := ctx.Value(opSpanKey{}).(*opSpan)
+	return s
+}
+
+// 记录加锁的等待时间
+func (s *opSpan) lockAcquired(wait time.Duration) {
+	if s == nil {
+		return
+	}
+	s.span.AddEvent(EventLockAcquired, map[string]interface{}{AttrLockWait: wait})
+}
+
+// 累加需要迁移的数据 key 个数
+func (s *opSpan) addMigratedKeys(n int) {
+	if s == nil {
+		return
+	}
+	s.migratedKeys += n
+}
+
+// 结束 span，attrs 为操作结束时才能确定的属性
+func (s *opSpan) end(err error, attrs map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	if s.migratedKeys > 0 {
+		if attrs == nil {
+			attrs = make(map[string]interface{}, 1)
+		}
+		attrs[AttrMigratedKeys] = s.migratedKeys
+	}
+	if len(attrs) > 0 {
+		s.span.SetAttributes(attrs)
+	}
+	if err != nil {
+		s.span.RecordError(err)
+	}
+	s.span.End()
+}
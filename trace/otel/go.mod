@@ -0,0 +1,20 @@
+module github.com/pule1234/consistent_hash/trace/otel
+
+go 1.19
+
+require (
+	github.com/pule1234/consistent_hash v0.0.0
+	go.opentelemetry.io/otel v1.19.0
+	go.opentelemetry.io/otel/sdk v1.19.0
+	go.opentelemetry.io/otel/trace v1.19.0
+)
+
+require (
+	github.com/go-logr/logr v1.2.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/spaolacci/murmur3 v1.1.0 // indirect
+	go.opentelemetry.io/otel/metric v1.19.0 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+)
+
+replace github.com/pule1234/consistent_hash => ../..
@@ -0,0 +1,76 @@
+// 基于 opentelemetry 实现的 consistent_hash.Tracer，独立为单独的 module，避免根 module 依赖 otel
+package otel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	consistent_hash "github.com/pule1234/consistent_hash"
+)
+
+// 通过全局 TracerProvider 获取 tracer 时使用的 instrumentation 名称
+const InstrumentationName = "github.com/pule1234/consistent_hash"
+
+var _ consistent_hash.Tracer = (*Tracer)(nil)
+
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// tracer 为空时使用全局 TracerProvider 创建的 tracer，通过 consistent_hash.WithTracer 注入
+func NewTracer(tracer trace.Tracer) *Tracer {
+	if tracer == nil {
+		tracer = otel.Tracer(InstrumentationName)
+	}
+	return &Tracer{tracer: tracer}
+}
+
+func (t *Tracer) Start(ctx context.Context, spanName string) (context.Context, consistent_hash.Span) {
+	ctx, span := t.tracer.Start(ctx, spanName)
+	return ctx, &otelSpan{span: span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) SetAttributes(attrs map[string]interface{}) {
+	s.span.SetAttributes(toKeyValues(attrs)...)
+}
+
+func (s *otelSpan) AddEvent(name string, attrs map[string]interface{}) {
+	s.span.AddEvent(name, trace.WithAttributes(toKeyValues(attrs)...))
+}
+
+func (s *otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *otelSpan) End() {
+	s.span.End()
+}
+
+// 属性值的类型为 string、int 或者 time.Duration，其余类型按照 fmt.Sprint 转换为字符串
+func toKeyValues(attrs map[string]interface{}) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for key, value := range attrs {
+		switch v := value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(key, v))
+		case int:
+			kvs = append(kvs, attribute.Int(key, v))
+		case time.Duration:
+			kvs = append(kvs, attribute.String(key, v.String()))
+		default:
+			kvs = append(kvs, attribute.String(key, fmt.Sprint(v)))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,58 @@
+package otel
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	consistent_hash "github.com/pule1234/consistent_hash"
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func Test_Tracer(t *testing.T) {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	consistentHash := consistent_hash.NewConsistentHash(memory.NewHashRing(), consistent_hash.NewMurmurHasher(), nil,
+		consistent_hash.WithTracer(NewTracer(provider.Tracer(InstrumentationName))))
+
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	nodeID, err := consistentHash.GetNode(ctx, "data_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = consistentHash.RemoveNode(ctx, "node_c"); err == nil {
+		t.Fatal("remove missing node should fail")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("got %d ended spans, want 3", len(spans))
+	}
+
+	span := spans[1]
+	if span.Name() != "ConsistentHash.get_node" {
+		t.Errorf("got span %s, want ConsistentHash.get_node", span.Name())
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs[consistent_hash.AttrDataKey].AsString() != "data_a" || attrs[consistent_hash.AttrNodeID].AsString() != nodeID {
+		t.Errorf("got attributes %v, want data key data_a and node %s", span.Attributes(), nodeID)
+	}
+	if events := span.Events(); len(events) != 1 || events[0].Name != consistent_hash.EventLockAcquired ||
+		len(events[0].Attributes) != 1 || events[0].Attributes[0].Key != consistent_hash.AttrLockWait {
+		t.Errorf("got events %v, want lock acquired with lock wait", events)
+	}
+
+	if span = spans[2]; span.Name() != "ConsistentHash.remove_node" || span.Status().Code != codes.Error {
+		t.Errorf("got span %s with status %v, want remove_node with error status", span.Name(), span.Status())
+	}
+}
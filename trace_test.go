@@ -0,0 +1,115 @@
+package consistent_hash
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+type recordedSpan struct {
+	name   string
+	attrs  map[string]interface{}
+	events map[string]map[string]interface{}
+	err    error
+	ended  bool
+}
+
+// 将 span 记录在内存中，便于断言
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *recordingTracer) Start(ctx context.Context, spanName string) (context.Context, Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	span := &recordedSpan{
+		name:   spanName,
+		attrs:  make(map[string]interface{}),
+		events: make(map[string]map[string]interface{}),
+	}
+	r.spans = append(r.spans, span)
+	return ctx, span
+}
+
+func (s *recordedSpan) SetAttributes(attrs map[string]interface{}) {
+	for key, val := range attrs {
+		s.attrs[key] = val
+	}
+}
+
+func (s *recordedSpan) AddEvent(name string, attrs map[string]interface{}) {
+	s.events[name] = attrs
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.ended = true
+}
+
+func Test_WithTracer(t *testing.T) {
+	ctx := context.Background()
+	var migrated int
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		migrated += len(dataKeys)
+		return nil
+	}
+	tracer := &recordingTracer{}
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), migrator, WithTracer(tracer))
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	nodeID, err := consistentHash.GetNode(ctx, "data_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracer.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(tracer.spans))
+	}
+	span := tracer.spans[1]
+	if span.name != "ConsistentHash.get_node" || !span.ended || span.err != nil {
+		t.Errorf("got span %s, ended %t, err %v", span.name, span.ended, span.err)
+	}
+	if span.attrs[AttrDataKey] != "data_a" || span.attrs[AttrNodeID] != nodeID {
+		t.Errorf("got attributes %v, want data key data_a and node %s", span.attrs, nodeID)
+	}
+	if _, ok := span.events[EventLockAcquired][AttrLockWait].(time.Duration); !ok {
+		t.Errorf("got events %v, want lock wait duration", span.events)
+	}
+
+	// 添加节点时记录迁移的数据 key 个数
+	if err = consistentHash.AddNode(ctx, "node_b", 5); err != nil {
+		t.Fatal(err)
+	}
+	if migrated == 0 {
+		t.Fatal("expect data_a to be migrated")
+	}
+	span = tracer.spans[2]
+	if span.attrs[AttrNodeID] != "node_b" {
+		t.Errorf("got attributes %v, want node node_b", span.attrs)
+	}
+	if span.attrs[AttrMigratedKeys] != migrated {
+		t.Errorf("got migrated keys %v, want %d", span.attrs[AttrMigratedKeys], migrated)
+	}
+
+	if err = consistentHash.RemoveNode(ctx, "node_c"); err == nil {
+		t.Fatal("remove missing node should fail")
+	}
+	if span = tracer.spans[3]; span.name != "ConsistentHash.remove_node" || span.err == nil {
+		t.Errorf("got span %s with err %v, want remove_node with error", span.name, span.err)
+	}
+
+	if err = consistentHash.UpdateNodeWeight(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if span = tracer.spans[4]; span.name != "ConsistentHash.update_node_weight" || span.attrs[AttrNodeID] != "node_b" || !span.ended {
+		t.Errorf("got span %s with attributes %v, ended %t, want update_node_weight of node_b", span.name, span.attrs, span.ended)
+	}
+}
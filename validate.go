@@ -0,0 +1,195 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// 哈希环中不一致问题的类型
+type InconsistencyType int
+
+const (
+	// 真实节点登记了虚拟节点个数，但哈希环上缺少其对应的虚拟节点
+	InconsistencyMissingVirtualNode InconsistencyType = iota + 1
+	// 哈希环上的虚拟节点引用了不存在的真实节点，或者其序号超出了真实节点登记的虚拟节点个数
+	InconsistencyOrphanVirtualNode
+)
+
+func (i InconsistencyType) String() string {
+	switch i {
+	case InconsistencyMissingVirtualNode:
+		return "missing virtual node"
+	case InconsistencyOrphanVirtualNode:
+		return "orphan virtual node"
+	}
+	return fmt.Sprintf("unknown inconsistency %d", int(i))
+}
+
+// 哈希环中的一处不一致
+type Inconsistency struct {
+	Type InconsistencyType
+	// 虚拟节点在哈希环上的位置
+	Score int64
+	// 哈希环中存储的虚拟节点 key
+	RawNodeKey string
+	// 虚拟节点所属的真实节点 id
+	NodeID string
+}
+
+// 校验真实节点与虚拟节点个数的映射关系（Nodes）与哈希环上的虚拟节点是否一致，返回全部不一致之处
+// 节点变更过程中发生崩溃等异常时，两者可能出现偏差
+func (c *ConsistentHash) Validate(ctx context.Context) ([]Inconsistency, error) {
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer c.unlock(ctx)
+
+	return c.validate(ctx)
+}
+
+// 修复 Validate 检测出的不一致：补齐缺失的虚拟节点，删除孤立的虚拟节点，返回修复的不一致之处
+// 修复过程只调整哈希环的拓扑，不会执行数据迁移
+func (c *ConsistentHash) Repair(ctx context.Context) ([]Inconsistency, error) {
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer c.unlock(ctx)
+	c.invalidateDataKeyCache()
+
+	inconsistencies, err := c.validate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, inconsistency := range inconsistencies {
+		switch inconsistency.Type {
+		case InconsistencyMissingVirtualNode:
+			err = c.hashRing.Add(ctx, inconsistency.Score, inconsistency.RawNodeKey)
+		case InconsistencyOrphanVirtualNode:
+			err = c.hashRing.Rem(ctx, inconsistency.Score, inconsistency.RawNodeKey)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("repair %s %s at score %d failed, err: %w", inconsistency.Type, inconsistency.RawNodeKey, inconsistency.Score, err)
+		}
+		c.opts.logger.Infof("repaired %s %s at score %d", inconsistency.Type, inconsistency.RawNodeKey, inconsistency.Score)
+	}
+
+	if len(inconsistencies) > 0 {
+		if err = c.topologyChanged(ctx, RingEvent{Type: RingEventRepaired}); err != nil {
+			return nil, err
+		}
+	}
+	return inconsistencies, nil
+}
+
+// score 是否是真实节点 nodeID 第 index 个虚拟节点可能所在的位置
+func (c *ConsistentHash) isVirtualScore(nodeID string, index int, score int64) bool {
+	for _, candidate := range c.virtualScoreCandidates(nodeID, index) {
+		if candidate == score {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConsistentHash) validate(ctx context.Context) ([]Inconsistency, error) {
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	virtualNodes, err := c.virtualNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var inconsistencies []Inconsistency
+	// 哈希环上实际存在的虚拟节点 key 集合
+	actual := make(map[int64]map[string]struct{}, len(virtualNodes))
+	for score, rawNodeKeys := range virtualNodes {
+		actual[score] = make(map[string]struct{}, len(rawNodeKeys))
+		for _, rawNodeKey := range rawNodeKeys {
+			actual[score][rawNodeKey] = struct{}{}
+
+			nodeID, index, ok := c.parseRawNodeKey(rawNodeKey)
+			replicas, exist := nodes[nodeID]
+			if ok && exist && index < replicas && c.isVirtualScore(nodeID, index, score) {
+				continue
+			}
+			if !ok {
+				nodeID = rawNodeKey
+			}
+			inconsistencies = append(inconsistencies, Inconsistency{
+				Type:       InconsistencyOrphanVirtualNode,
+				Score:      score,
+				RawNodeKey: rawNodeKey,
+				NodeID:     nodeID,
+			})
+		}
+	}
+
+	for nodeID, replicas := range nodes {
+		for i := 0; i < replicas; i++ {
+			rawNodeKey := c.getRawNodeKey(nodeID, i)
+			var found bool
+			for _, candidate := range c.virtualScoreCandidates(nodeID, i) {
+				if _, found = actual[candidate][rawNodeKey]; found {
+					break
+				}
+			}
+			if found {
+				continue
+			}
+			score := c.getVirtualScore(nodeID, i)
+			inconsistencies = append(inconsistencies, Inconsistency{
+				Type:       InconsistencyMissingVirtualNode,
+				Score:      score,
+				RawNodeKey: rawNodeKey,
+				NodeID:     nodeID,
+			})
+		}
+	}
+
+	sort.Slice(inconsistencies, func(i, j int) bool {
+		if inconsistencies[i].Score != inconsistencies[j].Score {
+			return inconsistencies[i].Score < inconsistencies[j].Score
+		}
+		return inconsistencies[i].RawNodeKey < inconsistencies[j].RawNodeKey
+	})
+	return inconsistencies, nil
+}
+
+// 校验单个数据 key 的登记位置，用于排查数据分布不均衡等问题
+// recordedNode 为当前登记了该数据 key 的真实节点，没有任何节点登记时为空，有多个节点登记时返回 NodeID 最小的节点
+// correctNode 为按照当前哈希环该数据 key 应当归属的真实节点，两者相同时 consistent 为 true
+// 该方法只读取数据，不加锁也不登记数据 key
+func (c *ConsistentHash) VerifyKeyPlacement(ctx context.Context, dataKey string) (recordedNode, correctNode string, consistent bool, err error) {
+	if correctNode, err = c.getNode(ctx, dataKey); err != nil {
+		return "", "", false, err
+	}
+
+	nodes, err := c.hashRing.Nodes(ctx)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	nodeIDs := make([]string, 0, len(nodes))
+	for nodeID := range nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+
+	for _, nodeID := range nodeIDs {
+		recorded, err := c.dataKeyRecorded(ctx, nodeID, dataKey)
+		if err != nil {
+			return "", "", false, err
+		}
+		if recorded {
+			recordedNode = nodeID
+			break
+		}
+	}
+
+	return recordedNode, correctNode, recordedNode == correctNode, nil
+}
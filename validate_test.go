@@ -0,0 +1,145 @@
+package consistent_hash
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func Test_Validate_Repair(t *testing.T) {
+	ctx := context.Background()
+	hashRing := memory.NewHashRing()
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), nil, WithReplicas(3))
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := consistentHash.AddNode(ctx, nodeID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inconsistencies, err := consistentHash.Validate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inconsistencies) != 0 {
+		t.Fatalf("got inconsistencies %v on a healthy ring", inconsistencies)
+	}
+
+	// 1 node_a 缺失一个虚拟节点
+	missingKey := consistentHash.getRawNodeKey("node_a", 1)
+	missingScore := consistentHash.getVirtualScore("node_a", 1)
+	if err = hashRing.Rem(ctx, missingScore, missingKey); err != nil {
+		t.Fatal(err)
+	}
+	// 2 哈希环上残留了已删除的真实节点 node_d 的虚拟节点
+	ghostKey := consistentHash.getRawNodeKey("node_d", 0)
+	ghostScore := consistentHash.getVirtualScore("node_d", 0)
+	if err = hashRing.Add(ctx, ghostScore, ghostKey); err != nil {
+		t.Fatal(err)
+	}
+	// 3 node_b 的虚拟节点个数被调小，序号超出范围的虚拟节点成为孤立节点
+	if err = hashRing.AddNodeToReplica(ctx, "node_b", 2); err != nil {
+		t.Fatal(err)
+	}
+	staleKey := consistentHash.getRawNodeKey("node_b", 2)
+
+	want := map[string]InconsistencyType{
+		missingKey: InconsistencyMissingVirtualNode,
+		ghostKey:   InconsistencyOrphanVirtualNode,
+		staleKey:   InconsistencyOrphanVirtualNode,
+	}
+	if inconsistencies, err = consistentHash.Validate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(inconsistencies) != len(want) {
+		t.Fatalf("got inconsistencies %v, want %d", inconsistencies, len(want))
+	}
+	for _, inconsistency := range inconsistencies {
+		if want[inconsistency.RawNodeKey] != inconsistency.Type {
+			t.Errorf("got %s for %s, want %s", inconsistency.Type, inconsistency.RawNodeKey, want[inconsistency.RawNodeKey])
+		}
+	}
+
+	repaired, err := consistentHash.Repair(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repaired) != len(want) {
+		t.Errorf("got %d repaired inconsistencies, want %d", len(repaired), len(want))
+	}
+	if inconsistencies, err = consistentHash.Validate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(inconsistencies) != 0 {
+		t.Errorf("got inconsistencies %v after repair", inconsistencies)
+	}
+	if scores := ringScores(t, consistentHash); len(scores) != 8 {
+		t.Errorf("got %d virtual nodes after repair, want 8", len(scores))
+	}
+}
+
+// 拉取全量数据 key 时返回错误的哈希环，用于验证实现了 DataKeyRecorder 时不会退化为遍历全量数据 key
+type dataKeyRecorderHashRing struct {
+	*memory.HashRing
+}
+
+func (dataKeyRecorderHashRing) DataKeys(ctx context.Context, nodeID string) (map[string]struct{}, error) {
+	return nil, errors.New("data keys should not be fetched")
+}
+
+func Test_VerifyKeyPlacement(t *testing.T) {
+	for name, wrap := range map[string]func(hashRing *memory.HashRing) HashRing{
+		"data key recorder": func(hashRing *memory.HashRing) HashRing { return dataKeyRecorderHashRing{HashRing: hashRing} },
+		"fallback":          func(hashRing *memory.HashRing) HashRing { return plainHashRing{HashRing: hashRing} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			testVerifyKeyPlacement(t, wrap)
+		})
+	}
+}
+
+func testVerifyKeyPlacement(t *testing.T, wrap func(hashRing *memory.HashRing) HashRing) {
+	ctx := context.Background()
+	hashRing := memory.NewHashRing()
+	// 添加节点时需要拉取数据 key，因此直接基于内存哈希环完成
+	setup := NewConsistentHash(hashRing, NewMurmurHasher(), nil)
+	for _, nodeID := range []string{"node_a", "node_b", "node_c"} {
+		if err := setup.AddNode(ctx, nodeID, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	consistentHash := NewConsistentHash(wrap(hashRing), NewMurmurHasher(), nil)
+
+	// 未登记的数据 key
+	recordedNode, correctNode, consistent, err := consistentHash.VerifyKeyPlacement(ctx, "data")
+	if err != nil || recordedNode != "" || correctNode == "" || consistent {
+		t.Errorf("untracked data key: got (%q, %q, %v, %v)", recordedNode, correctNode, consistent, err)
+	}
+
+	nodeID, err := consistentHash.GetNode(ctx, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordedNode, correctNode, consistent, err = consistentHash.VerifyKeyPlacement(ctx, "data")
+	if err != nil || recordedNode != nodeID || correctNode != nodeID || !consistent {
+		t.Errorf("tracked data key: got (%q, %q, %v, %v), want (%q, %q, true, nil)", recordedNode, correctNode, consistent, err, nodeID, nodeID)
+	}
+
+	// 将数据 key 改登记到其他节点下
+	other := "node_a"
+	if nodeID == other {
+		other = "node_b"
+	}
+	dataKeys := map[string]struct{}{"data": {}}
+	if err = consistentHash.hashRing.DeleteNodeToDataKeys(ctx, nodeID, dataKeys); err != nil {
+		t.Fatal(err)
+	}
+	if err = consistentHash.hashRing.AddNodeToDataKeys(ctx, other, dataKeys); err != nil {
+		t.Fatal(err)
+	}
+	recordedNode, correctNode, consistent, err = consistentHash.VerifyKeyPlacement(ctx, "data")
+	if err != nil || recordedNode != other || correctNode != nodeID || consistent {
+		t.Errorf("corrupted data key: got (%q, %q, %v, %v), want (%q, %q, false, nil)", recordedNode, correctNode, consistent, err, other, nodeID)
+	}
+}
@@ -0,0 +1,75 @@
+package consistent_hash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// 拓扑变更事件的类型
+type RingEventType string
+
+const (
+	RingEventNodeAdded   RingEventType = "node_added"
+	RingEventNodeRemoved RingEventType = "node_removed"
+	// 真实节点的权重发生了变化
+	RingEventNodeUpdated RingEventType = "node_updated"
+	// Repair 修复了哈希环上的虚拟节点，此时 NodeID 为空
+	RingEventRepaired RingEventType = "repaired"
+	// Import 恢复了整个哈希环，此时 NodeID 为空
+	RingEventImported RingEventType = "imported"
+)
+
+// 哈希环的拓扑变更事件
+type RingEvent struct {
+	Type   RingEventType `json:"type"`
+	NodeID string        `json:"node_id,omitempty"`
+	// 变更后哈希环的代数，哈希环没有实现 GenerationCounter 时为 0
+	Generation int64 `json:"generation"`
+}
+
+// 订阅哈希环的拓扑变更事件，ctx 结束后返回的 channel 会被关闭
+// 需要哈希环实现 RingNotifier 接口，事件的投递是尽力而为的，依赖拓扑的缓存仍然需要结合 Generation 校验
+func (c *ConsistentHash) Watch(ctx context.Context) (<-chan RingEvent, error) {
+	notifier, ok := c.hashRing.(RingNotifier)
+	if !ok {
+		return nil, errors.New("hash ring does not support watch")
+	}
+
+	payloads, err := notifier.Subscribe(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("subscribe ring events failed, err: %w", err)
+	}
+
+	events := make(chan RingEvent)
+	go func() {
+		defer close(events)
+		for payload := range payloads {
+			var event RingEvent
+			if err := json.Unmarshal([]byte(payload), &event); err != nil {
+				c.opts.logger.Errorf("decode ring event %s failed, err: %v", payload, err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// 发布拓扑变更事件，拓扑已经变更完成，因此发布失败时只记录日志
+func (c *ConsistentHash) publish(ctx context.Context, event RingEvent) {
+	notifier, ok := c.hashRing.(RingNotifier)
+	if !ok {
+		return
+	}
+
+	payload, _ := json.Marshal(event)
+	if err := notifier.Publish(ctx, string(payload)); err != nil {
+		c.opts.logger.Errorf("publish ring event %s failed, err: %v", payload, err)
+	}
+}
@@ -0,0 +1,55 @@
+package consistent_hash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+func Test_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	consistentHash := NewConsistentHash(memory.NewHashRing(), NewMurmurHasher(), nil)
+
+	events, err := consistentHash.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = consistentHash.RemoveNode(ctx, "node_a"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RingEvent{
+		{Type: RingEventNodeAdded, NodeID: "node_a", Generation: 1},
+		{Type: RingEventNodeAdded, NodeID: "node_b", Generation: 2},
+		{Type: RingEventNodeRemoved, NodeID: "node_a", Generation: 3},
+	}
+	for _, w := range want {
+		select {
+		case event := <-events:
+			if event != w {
+				t.Errorf("got event %+v, want %+v", event, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %+v not delivered", w)
+		}
+	}
+
+	// ctx 结束后 channel 被关闭
+	cancel()
+	for range events {
+	}
+
+	if _, err = NewConsistentHash(plainHashRing{memory.NewHashRing()}, NewMurmurHasher(), nil).Watch(context.Background()); err == nil {
+		t.Error("watch on hash ring without RingNotifier should fail")
+	}
+}
@@ -0,0 +1,124 @@
+package consistent_hash
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pule1234/consistent_hash/memory"
+)
+
+// 为进程内的锁模拟过期时间，用于验证看门狗的续期
+type leaseHashRing struct {
+	HashRing
+	mu       sync.Mutex
+	expireAt time.Time
+	renewals int
+	// 为空时使用系统时间
+	clock Clock
+	// 不为空时每次续期后写入一次
+	renewed chan struct{}
+}
+
+func (r *leaseHashRing) now() time.Time {
+	if r.clock == nil {
+		return time.Now()
+	}
+	return r.clock.Now()
+}
+
+func (r *leaseHashRing) Lock(ctx context.Context, expireSeconds int) error {
+	if err := r.HashRing.Lock(ctx, expireSeconds); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireAt = r.now().Add(time.Duration(expireSeconds) * time.Second)
+	return nil
+}
+
+func (r *leaseHashRing) Unlock(ctx context.Context) error {
+	r.mu.Lock()
+	r.expireAt = time.Time{}
+	r.mu.Unlock()
+	return r.HashRing.Unlock(ctx)
+}
+
+func (r *leaseHashRing) RenewLock(ctx context.Context, expireSeconds int) error {
+	r.mu.Lock()
+	r.expireAt = r.now().Add(time.Duration(expireSeconds) * time.Second)
+	r.renewals++
+	r.mu.Unlock()
+	if r.renewed != nil {
+		r.renewed <- struct{}{}
+	}
+	return nil
+}
+
+func (r *leaseHashRing) held() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.now().Before(r.expireAt)
+}
+
+// 执行一次耗时超过锁过期时间的数据迁移，返回迁移结束时锁是否仍然被持有
+func runSlowMigration(t *testing.T, hashRing *leaseHashRing, opts ...ConsistentHashOption) bool {
+	t.Helper()
+	ctx := context.Background()
+	var (
+		once sync.Once
+		held bool
+	)
+	migrator := func(ctx context.Context, dataKeys map[string]struct{}, from, to string) error {
+		once.Do(func() {
+			time.Sleep(1500 * time.Millisecond)
+			held = hashRing.held()
+		})
+		return nil
+	}
+	opts = append(opts, WithLockExpireSeconds(1))
+	consistentHash := NewConsistentHash(hashRing, NewMurmurHasher(), migrator, opts...)
+
+	if err := consistentHash.AddNode(ctx, "node_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := consistentHash.GetNode(ctx, fmt.Sprintf("data_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := consistentHash.AddNode(ctx, "node_b", 1); err != nil {
+		t.Fatal(err)
+	}
+	return held
+}
+
+func Test_WithLockWatchDog(t *testing.T) {
+	hashRing := &leaseHashRing{HashRing: memory.NewHashRing()}
+	if !runSlowMigration(t, hashRing, WithLockWatchDog()) {
+		t.Error("lock should be renewed during slow migration")
+	}
+
+	// 释放锁之后看门狗需要停止续期
+	hashRing.mu.Lock()
+	renewals := hashRing.renewals
+	hashRing.mu.Unlock()
+	if renewals == 0 {
+		t.Error("watch dog should renew the lock")
+	}
+	time.Sleep(500 * time.Millisecond)
+	hashRing.mu.Lock()
+	defer hashRing.mu.Unlock()
+	if hashRing.renewals != renewals {
+		t.Errorf("watch dog kept renewing after unlock, renewals %d -> %d", renewals, hashRing.renewals)
+	}
+}
+
+func Test_WithoutLockWatchDog_Expired(t *testing.T) {
+	hashRing := &leaseHashRing{HashRing: memory.NewHashRing()}
+	if runSlowMigration(t, hashRing) {
+		t.Error("lock should expire during slow migration without watch dog")
+	}
+}
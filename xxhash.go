@@ -0,0 +1,94 @@
+package consistent_hash
+
+import "math/bits"
+
+// 基于 xxHash64 实现的散列器，结果分布在 [0, DefaultRingSize) 的范围内
+// 直接在字符串上计算哈希值，不需要创建哈希器对象，也不需要将字符串转换为字节切片，Encrypt 过程中没有内存分配
+type XXHasher struct {
+}
+
+func NewXXHasher() *XXHasher {
+	return &XXHasher{}
+}
+
+func (x *XXHasher) Encrypt(origin string) int64 {
+	return int64(xxh64(origin) % uint64(DefaultRingSize))
+}
+
+// 使用变量而不是常量声明，以便在计算中利用 uint64 的溢出回绕
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// 种子为 0 的 XXH64 算法，与 cespare/xxhash 的 Sum64String 结果一致
+func xxh64(s string) uint64 {
+	n := len(s)
+	var h uint64
+	if n >= 32 {
+		v1 := xxPrime1 + xxPrime2
+		v2 := xxPrime2
+		v3 := uint64(0)
+		v4 := -xxPrime1
+		for ; len(s) >= 32; s = s[32:] {
+			v1 = xxRound(v1, xxU64(s[0:8]))
+			v2 = xxRound(v2, xxU64(s[8:16]))
+			v3 = xxRound(v3, xxU64(s[16:24]))
+			v4 = xxRound(v4, xxU64(s[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+
+	h += uint64(n)
+
+	for ; len(s) >= 8; s = s[8:] {
+		h ^= xxRound(0, xxU64(s[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(s) >= 4 {
+		h ^= uint64(xxU32(s[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		s = s[4:]
+	}
+	for ; len(s) > 0; s = s[1:] {
+		h ^= uint64(s[0]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*xxPrime1 + xxPrime4
+}
+
+func xxU64(s string) uint64 {
+	return uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24 |
+		uint64(s[4])<<32 | uint64(s[5])<<40 | uint64(s[6])<<48 | uint64(s[7])<<56
+}
+
+func xxU32(s string) uint32 {
+	return uint32(s[0]) | uint32(s[1])<<8 | uint32(s[2])<<16 | uint32(s[3])<<24
+}